@@ -14,22 +14,108 @@
 package command
 
 import (
+	"bufio"
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/bufferflies/tinker/pkg/data"
 	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type CloudCommand struct {
-	version   string
-	namespace string
-	config    string
+	version     string
+	namespace   string
+	config      string
+	kubeContext string
+	components  string
+	dataDirs    string
+	backupRoot  string
+	timeout     time.Duration
+	// retry
+	retry           int
+	retryBackoff    time.Duration
+	retryMaxBackoff time.Duration
+	dryRun          bool
+	yes             bool
+	inCluster       bool
+	allExcept       int
+	retain          int
+	minFreeRatio    float64
+	maxBackupSize   string
+	output          string
+	thresholds      string
+	livenessCmds    []string
+	livenessExpects []string
+	stream          bool
+	execTimeout     time.Duration
+	parallel        int
+	qps             float32
+	burst           int
+	selector        string
+	discovery       string
+	placeholder     string
+	allNamespaces   bool
+	nsSelector      string
+	containers      string
+	waitTimeout     time.Duration
+	stopGrace       time.Duration
+	lockTTL         time.Duration
+	logDir          string
+	skipPreflight   bool
+	excludes        []string
+	force           bool
+	debugKey        string
+	debugImage      string
+	componentImages string
+	debugValue      string
+	incremental     bool
+	rolling         bool
+	noStart         bool
+	order           string
+	compress        bool
+	resume          bool
+	pdSingle        bool
+	timestamped     bool
+	at              string
+	snapshot        bool
+	snapshotClass   string
+	upTimeouts      string
+	upload          string
+	progress        time.Duration
+	download        string
+	from            string
+	metricsAddr     string
+	strict          bool
+	pods            []string
+	listComponent   string
+	versionPrefix   string
+	listMetadata    bool
+	listSizes       bool
+	diff            bool
+	diffSummary     bool
+	healthCmds      []string
+	healthTimeout   time.Duration
+	execComponent   string
+	podComponent    string
+	// ctx bounds the operator of the invocation, cancel cancels it on the interrupt.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// operator is created once per invocation and reused by all the steps of the command.
+	operator *data.CloudOperator
 }
 
+// allNamespaces is the input to confirm the operation in all namespaces.
+const allNamespaces = "all-namespaces"
+
 var cloudCmd CloudCommand
 
 func NewCloudCommand() *cobra.Command {
@@ -37,26 +123,347 @@ func NewCloudCommand() *cobra.Command {
 		Use:   "tc",
 		Short: "data back or recovery for tidb controller",
 	}
+	cmd.PersistentPreRunE = func(sub *cobra.Command, args []string) error {
+		// cobra only runs the nearest persistent pre run, so the root's one which configures the logger runs here.
+		if root := sub.Root(); root != cmd && root.PersistentPreRunE != nil {
+			if err := root.PersistentPreRunE(sub, args); err != nil {
+				return err
+			}
+		}
+		if err := cloudCmd.validate(sub, args); err != nil {
+			return err
+		}
+		return cloudCmd.initOperator()
+	}
 	config := filepath.Join(homeDir(), ".kube", "config")
-	cmd.PersistentFlags().StringVarP(&cloudCmd.version, "version", "v", "5.2", "back or restore version")
+	cmd.PersistentFlags().StringVarP(&cloudCmd.version, "version", "v", "5.2", "back or restore version, restore also accepts the versions of components, e.g. tikv=5.1,pd=5.2")
 	cmd.PersistentFlags().StringVarP(&cloudCmd.config, "kube-config", "c", config, "kube config file path")
-	cmd.PersistentFlags().StringVarP(&cloudCmd.namespace, "namespace", "n", "", "kube namespace")
+	cmd.PersistentFlags().StringVarP(&cloudCmd.namespace, "namespace", "n", "", "kube namespaces, e.g. tidb-a,tidb-b, default is the namespace of the context in the kube config")
+	cmd.PersistentFlags().BoolVarP(&cloudCmd.allNamespaces, "all-namespaces", "A", false, "operate the pods in all namespaces")
+	cmd.PersistentFlags().StringVar(&cloudCmd.nsSelector, "namespace-selector", "", "operate the pods in the namespaces matching the label selector, e.g. app=tidb-cluster")
+	cmd.PersistentFlags().StringVar(&cloudCmd.kubeContext, "context", "", "the context in the kube config file to use, default is the current context")
+	cmd.PersistentFlags().BoolVar(&cloudCmd.inCluster, "in-cluster", false, "use the in-cluster config instead of the kube config file")
+	cmd.PersistentFlags().StringVar(&cloudCmd.components, "components", data.DefaultComponents, "components to back or restore, e.g. tikv,pd,tidb")
+	cmd.PersistentFlags().StringVar(&cloudCmd.order, "components-order", "tidb,tikv,pd", "order to stop the components, they are started in the reverse order")
+	cmd.PersistentFlags().StringVar(&cloudCmd.dataDirs, "data-dir", "", "data directory of components, e.g. tikv=/data/tikv,pd=/data/pd, the dirs of multiple volumes are separated by colon, e.g. tikv=/data1:/data2, default is /var/lib/{component}")
+	cmd.PersistentFlags().StringVar(&cloudCmd.backupRoot, "backup-root", "", "directory to store the backups instead of the data directories, e.g. /backup on another volume, every component has its own sub directory, empty means the data directories")
+	cmd.PersistentFlags().DurationVar(&cloudCmd.timeout, "timeout", 0, "timeout of the operation, 0 means no timeout")
+	cmd.PersistentFlags().DurationVar(&cloudCmd.execTimeout, "exec-timeout", 0, "timeout of every exec in pods, it does not kill the command in the pod, so the timeout back, restore and prune are not retried, the others are, 0 means no timeout")
+	cmd.PersistentFlags().IntVar(&cloudCmd.retry, "retry", data.MaxRetry, "max times to exec command in pods")
+	cmd.PersistentFlags().DurationVar(&cloudCmd.retryBackoff, "retry-backoff", data.RetryBackoff, "backoff before the first retry, it doubles every retry")
+	cmd.PersistentFlags().DurationVar(&cloudCmd.retryMaxBackoff, "retry-max-backoff", data.RetryBackoff, "max backoff between retries")
+	cmd.PersistentFlags().BoolVar(&cloudCmd.dryRun, "dry-run", false, "print the commands without executing them")
+	cmd.PersistentFlags().BoolVarP(&cloudCmd.yes, "yes", "y", false, "skip the confirmation of destructive operations")
+	cmd.PersistentFlags().StringVarP(&cloudCmd.output, "output", "o", OutputTable, "output format, one of table|wide|json|yaml, wide shows the sizes of the backup versions in list")
+	cmd.PersistentFlags().StringVar(&cloudCmd.thresholds, "process-threshold", "", fmt.Sprintf("field count threshold of PID 1 in ps to decide the component is running, e.g. tikv=8,pd=8, default is %d", data.ParamLen))
+	cmd.PersistentFlags().StringArrayVar(&cloudCmd.livenessCmds, "liveness-cmd", nil, "command to check the component process is running instead of counting the fields of PID 1 in ps, it can be repeated, e.g. tikv='pgrep tikv-server', the process is running if the output isn't empty")
+	cmd.PersistentFlags().StringArrayVar(&cloudCmd.livenessExpects, "liveness-expect", nil, "extended regular expression which the output of --liveness-cmd matches if the process is running, it can be repeated, e.g. tikv='^[0-9]+'")
+	cmd.PersistentFlags().BoolVar(&cloudCmd.stream, "stream", false, "log the output of back and restore commands as it arrives")
+	cmd.PersistentFlags().StringVar(&cloudCmd.logDir, "log-dir", "", "directory to save the exec output of back and restore of every pod as <pod>-<operation>-<version>.log, empty means not saving")
+	cmd.PersistentFlags().IntVar(&cloudCmd.parallel, "parallel", data.DefaultParallel, "max number of concurrent execs in pods, 0 means no limit")
+	cmd.PersistentFlags().Float32Var(&cloudCmd.qps, "qps", data.DefaultQPS, "max average queries per second to the API server, including the execs in pods")
+	cmd.PersistentFlags().IntVar(&cloudCmd.burst, "burst", data.DefaultBurst, "max burst of the queries to the API server, it should not be less than --qps")
+	cmd.PersistentFlags().StringVar(&cloudCmd.selector, "selector-template", data.DefaultSelectorTemplate, "label selector template to discover the pods, %s is replaced by the component name")
+	cmd.PersistentFlags().StringVar(&cloudCmd.discovery, "discovery", data.DiscoveryLabel, "how to discover the pods, label: by the label selector, statefulset: the pods owned by the stateful sets matched by the label selector")
+	cmd.PersistentFlags().StringVar(&cloudCmd.placeholder, "placeholder-file", data.DefaultPlaceholderFile, "file which reserves the disk space in the data directories, it is never backed up or restored")
+	cmd.PersistentFlags().StringVar(&cloudCmd.containers, "container", "", "container of components to exec in, e.g. tikv=db,pd=pd, default is resolved from the pod spec")
+	cmd.PersistentFlags().StringVar(&cloudCmd.upTimeouts, "up-timeout", data.DefaultUpTimeouts, "time to wait for every component process up after starting, e.g. tikv=20m,pd=1m, the component without timeout is checked once")
+	cmd.PersistentFlags().DurationVar(&cloudCmd.waitTimeout, "wait-timeout", 10*time.Minute, "timeout to wait for the pods to be ready after starting, 0 means not waiting")
+	cmd.PersistentFlags().DurationVar(&cloudCmd.stopGrace, "stop-grace-period", data.StopGracePeriod, "time to wait for the process to exit after the stop signal before force deleting the pod and stopping the recreated one, 0 means not waiting")
+	cmd.PersistentFlags().DurationVar(&cloudCmd.lockTTL, "lock-ttl", data.DefaultLockTTL, "lock the namespaces during back and restore, the lock left by the crashed operation expires after it, 0 means not locking")
+	cmd.PersistentFlags().BoolVar(&cloudCmd.skipPreflight, "skip-preflight", false, "skip checking the permissions before back and restore")
+	cmd.PersistentFlags().StringVar(&cloudCmd.debugKey, "debug-annotation-key", data.DebugLabel, "annotation key which puts the pod into debug mode")
+	cmd.PersistentFlags().StringVar(&cloudCmd.debugImage, "debug-container", "", "exec the commands in an ephemeral container of the image which shares the process namespace and the volumes of the target container, e.g. busybox for the distroless pods, empty means exec in the target container")
+	cmd.PersistentFlags().StringVar(&cloudCmd.componentImages, "component-image", "", "debug container image of components which overrides --debug-container, e.g. tikv=busybox,pd=alpine:3.18, the preflight tool check execs in it too")
+	cmd.PersistentFlags().StringVar(&cloudCmd.debugValue, "debug-annotation-value", data.DebugValue, "annotation value which puts the pod into debug mode")
+	cmd.PersistentFlags().BoolVar(&cloudCmd.strict, "strict", false, "fail if any pod is not running, or has no backup to restore, instead of skipping it, a restore which skipped pods fails as partial anyway")
+	cmd.PersistentFlags().StringVar(&cloudCmd.metricsAddr, "metrics-addr", "", "address to serve the prometheus metrics at /metrics, e.g. :9090, empty means not serving")
+	cmd.PersistentFlags().BoolVar(&cloudCmd.compress, "compress", false, "back up to or restore from <version>.tar.gz instead of the <version>.bat directory")
+	cmd.PersistentFlags().BoolVar(&cloudCmd.snapshot, "snapshot", false, "back up the persistent volume claims of the data directories to or restore them from CSI volume snapshots <claim>-<version> instead of copying the files in the pods")
+	cmd.PersistentFlags().StringVar(&cloudCmd.snapshotClass, "snapshot-class", "", "volume snapshot class of --snapshot, default is the default class of the CSI driver")
 	cmd.AddCommand(cloudCmd.stopCmd())
 	cmd.AddCommand(cloudCmd.startCmd())
 	cmd.AddCommand(cloudCmd.backCmd())
 	cmd.AddCommand(cloudCmd.restoreCmd())
 	cmd.AddCommand(cloudCmd.listCmd())
+	cmd.AddCommand(cloudCmd.catalogCmd())
 	cmd.AddCommand(cloudCmd.checkCmd())
 	cmd.AddCommand(cloudCmd.removeCmd())
+	cmd.AddCommand(cloudCmd.pruneCmd())
+	cmd.AddCommand(cloudCmd.verifyCmd())
+	cmd.AddCommand(cloudCmd.statusCmd())
+	cmd.AddCommand(cloudCmd.execCmd())
+	cmd.AddCommand(cloudCmd.preflightCmd())
 	return cmd
 }
 
+// validate checks the flags before running any sub command.
+func (c *CloudCommand) validate(_ *cobra.Command, _ []string) error {
+	if _, err := data.ParseComponents(c.components); err != nil {
+		return err
+	}
+	if _, err := data.ParseComponentsOrder(c.order); err != nil {
+		return err
+	}
+	if _, err := data.ParseDataDirs(c.dataDirs); err != nil {
+		return err
+	}
+	if err := data.ValidateBackupRoot(c.backupRoot); err != nil {
+		return err
+	}
+	if _, err := data.ParseProcessThresholds(c.thresholds); err != nil {
+		return err
+	}
+	if _, err := data.ParseLiveness(c.livenessCmds, c.livenessExpects); err != nil {
+		return err
+	}
+	if _, err := data.ParseUpTimeouts(c.upTimeouts); err != nil {
+		return err
+	}
+	if _, err := data.ParseContainers(c.containers); err != nil {
+		return err
+	}
+	if err := data.ValidateImage(c.debugImage); err != nil {
+		return err
+	}
+	if _, err := data.ParseComponentImages(c.componentImages); err != nil {
+		return err
+	}
+	if err := validateOutput(c.output); err != nil {
+		return err
+	}
+	if err := data.ValidateSelectorTemplate(c.selector); err != nil {
+		return err
+	}
+	if err := data.ValidateDiscovery(c.discovery); err != nil {
+		return err
+	}
+	if err := data.ValidatePlaceholderFile(c.placeholder); err != nil {
+		return err
+	}
+	if c.retry <= 0 {
+		return fmt.Errorf("retry should be positive: %d", c.retry)
+	}
+	if c.inCluster && len(c.kubeContext) > 0 {
+		return errors.New("--context and --in-cluster can't be used together")
+	}
+	if c.allNamespaces && len(c.namespace) > 0 {
+		return errors.New("--namespace and --all-namespaces can't be used together")
+	}
+	if err := data.ValidateNamespaceSelector(c.nsSelector); err != nil {
+		return err
+	}
+	if len(c.nsSelector) > 0 && (c.allNamespaces || len(c.namespace) > 0) {
+		return errors.New("--namespace-selector can't be used with --namespace or --all-namespaces")
+	}
+	if !c.allNamespaces && len(c.nsSelector) == 0 && len(c.namespace) == 0 {
+		namespace, err := c.contextNamespace()
+		if err != nil {
+			return err
+		}
+		c.namespace = namespace
+	}
+	if len(c.debugKey) == 0 {
+		return errors.New("debug annotation key should not be empty")
+	}
+	if c.parallel < 0 {
+		return fmt.Errorf("parallel should not be negative: %d", c.parallel)
+	}
+	if err := c.clientRate().Validate(); err != nil {
+		return err
+	}
+	if len(c.metricsAddr) > 0 && metricsServer == nil {
+		server, err := startMetricsServer(c.metricsAddr)
+		if err != nil {
+			return fmt.Errorf("start metrics server failed: %w", err)
+		}
+		metricsServer = server
+	}
+	return nil
+}
+
+// contextNamespace returns the namespace of the context in the kube config,
+// it is used if neither --namespace nor --all-namespaces is set.
+func (c *CloudCommand) contextNamespace() (string, error) {
+	var namespace string
+	if !c.inCluster {
+		var err error
+		if namespace, err = data.ContextNamespace(c.config, c.kubeContext); err != nil {
+			return "", err
+		}
+	}
+	if len(namespace) == 0 {
+		return "", errors.New("namespace is not set, please use --namespace or --all-namespaces, or set the namespace of the current context in the kube config")
+	}
+	return namespace, nil
+}
+
+// newContext creates a context which will be cancelled after the timeout or the deadline of the invocation.
+func (c *CloudCommand) newContext() (context.Context, context.CancelFunc) {
+	if c.timeout > 0 {
+		return context.WithTimeout(invocation.ctx, c.timeout)
+	}
+	return context.WithCancel(invocation.ctx)
+}
+
+// notifyInterrupt cancels the operation on SIGINT or SIGTERM, so the workflow can start the stopped components
+// before exiting. The second signal exits immediately. It returns the function to stop handling the signals.
+func notifyInterrupt(cmd *cobra.Command, cancel context.CancelFunc) func() {
+	sigs := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-sigs:
+			// the default handler exits on the next signal.
+			signal.Stop(sigs)
+			cmd.PrintErrf("received %s, it will cancel the operation and start all the components, interrupt again to exit immediately\n", sig)
+			cancel()
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
+
+// initOperator creates the operator of the invocation with the context of the invocation,
+// it replaces the operator of the previous invocation.
+func (c *CloudCommand) initOperator() error {
+	ctx, cancel := c.newContext()
+	co, err := c.newCloudOperator(ctx)
+	if err != nil {
+		cancel()
+		return err
+	}
+	c.ctx, c.cancel, c.operator = ctx, cancel, co
+	return nil
+}
+
+// cloudOperator returns the operator of the invocation, it is reused by all the steps of the command,
+// so the config and the clientset are built once. It is created at the first call if it's not created before.
+func (c *CloudCommand) cloudOperator() (*data.CloudOperator, error) {
+	if c.operator == nil {
+		if err := c.initOperator(); err != nil {
+			return nil, err
+		}
+	}
+	return c.operator, nil
+}
+
+// clientRate returns the rate of the requests to the API server.
+func (c *CloudCommand) clientRate() data.ClientRate {
+	return data.ClientRate{QPS: c.qps, Burst: c.burst}
+}
+
+// newCloudOperator creates a cloud operator with the flags applied.
+func (c *CloudCommand) newCloudOperator(ctx context.Context) (*data.CloudOperator, error) {
+	components, err := data.ParseComponents(c.components)
+	if err != nil {
+		return nil, err
+	}
+	order, err := data.ParseComponentsOrder(c.order)
+	if err != nil {
+		return nil, err
+	}
+	dataDirs, err := data.ParseDataDirs(c.dataDirs)
+	if err != nil {
+		return nil, err
+	}
+	config := c.config
+	if c.inCluster {
+		config = ""
+	}
+	thresholds, err := data.ParseProcessThresholds(c.thresholds)
+	if err != nil {
+		return nil, err
+	}
+	liveness, err := data.ParseLiveness(c.livenessCmds, c.livenessExpects)
+	if err != nil {
+		return nil, err
+	}
+	containers, err := data.ParseContainers(c.containers)
+	if err != nil {
+		return nil, err
+	}
+	upTimeouts, err := data.ParseUpTimeouts(c.upTimeouts)
+	if err != nil {
+		return nil, err
+	}
+	images, err := data.ParseComponentImages(c.componentImages)
+	if err != nil {
+		return nil, err
+	}
+	co, err := data.NewCloudOperator(c.namespace, config, c.kubeContext, c.clientRate(), ctx)
+	if err != nil {
+		return nil, err
+	}
+	co.Namespaces = data.ParseNamespaces(c.namespace)
+	if c.allNamespaces {
+		co.Namespaces = []string{metav1.NamespaceAll}
+	}
+	if len(c.nsSelector) > 0 {
+		if err := co.SelectNamespaces(c.nsSelector); err != nil {
+			return nil, err
+		}
+	}
+	co.Components = components
+	co.StopOrder = order
+	co.SelectorTemplate = c.selector
+	co.Discovery = c.discovery
+	co.PlaceholderFile = c.placeholder
+	co.DataDirs = dataDirs
+	co.BackupRoot = c.backupRoot
+	co.Containers = containers
+	co.ProcessThresholds = thresholds
+	co.Liveness = liveness
+	co.UpTimeouts = upTimeouts
+	co.RetryCount = c.retry
+	co.RetryBackoff = c.retryBackoff
+	co.RetryMaxBackoff = c.retryMaxBackoff
+	co.ExecTimeout = c.execTimeout
+	co.WaitTimeout = c.waitTimeout
+	co.StopGracePeriod = c.stopGrace
+	co.LockTTL = c.lockTTL
+	co.Compress = c.compress
+	co.DebugKey = c.debugKey
+	co.DebugImage = c.debugImage
+	co.ComponentImages = images
+	co.DebugValue = c.debugValue
+	co.DryRun = c.dryRun
+	co.Stream = c.stream
+	co.LogDir = c.logDir
+	co.SkipPreflight = c.skipPreflight
+	co.Parallel = c.parallel
+	co.Strict = c.strict
+	co.Pods = c.pods
+	if c.snapshot {
+		backend, err := data.NewSnapshotBackend(co, c.snapshotClass)
+		if err != nil {
+			return nil, err
+		}
+		co.Backend = backend
+	}
+	return co, nil
+}
+
 func (c *CloudCommand) removeCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "remove",
 		Short: "remove backup version",
-		Run:   c.removeVersion,
+		RunE:  c.removeVersion,
+	}
+	return cmd
+}
+
+func (c *CloudCommand) pruneCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "prune backup versions except the running one",
+		RunE:  c.prune,
 	}
+	cmd.Flags().IntVar(&c.allExcept, "all-except", 0, "prune all versions except the newest N, it ignores --version if set")
 	return cmd
 }
 
@@ -66,6 +473,7 @@ func (c *CloudCommand) stopCmd() *cobra.Command {
 		Short: "stop component",
 		RunE:  c.stop,
 	}
+	c.addPodFilterFlags(cmd, "stop")
 	return cmd
 }
 
@@ -75,9 +483,16 @@ func (c *CloudCommand) startCmd() *cobra.Command {
 		Short: "start component",
 		RunE:  c.start,
 	}
+	c.addPodFilterFlags(cmd, "start")
 	return cmd
 }
 
+// addPodFilterFlags adds the flags to narrow the pods stopped or started by the command.
+func (c *CloudCommand) addPodFilterFlags(cmd *cobra.Command, operation string) {
+	cmd.Flags().StringVar(&c.podComponent, "component", "", fmt.Sprintf("only %s the pods of the components, e.g. tikv,pd, default is all the components", operation))
+	cmd.Flags().StringSliceVar(&c.pods, "pod", nil, fmt.Sprintf("only %s the pods, it can be repeated, e.g. tikv-0 or tidb-a/tikv-0", operation))
+}
+
 func (c *CloudCommand) checkCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "check",
@@ -87,12 +502,65 @@ func (c *CloudCommand) checkCmd() *cobra.Command {
 	return cmd
 }
 
+func (c *CloudCommand) preflightCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "preflight",
+		Short: "check the permissions and the tools in the containers which back and restore need",
+		RunE:  c.preflight,
+	}
+	return cmd
+}
+
+func (c *CloudCommand) preflight(cmd *cobra.Command, _ []string) error {
+	co, err := c.cloudOperator()
+	if err != nil {
+		return err
+	}
+	defer co.CleanupDebugContainers()
+	checks, err := co.CheckPermissions()
+	if err != nil {
+		return err
+	}
+	tools, err := co.CheckTools("")
+	if err != nil {
+		return err
+	}
+	rst := preflightResult{Permissions: checks, Tools: tools}
+	if err := render(cmd.OutOrStdout(), c.output, rst, preflightTable(rst)); err != nil {
+		return err
+	}
+	for _, check := range checks {
+		if !check.Allowed {
+			return data.WithCategory(data.CategoryPreflight, errors.New("preflight failed, some permissions are missing"))
+		}
+	}
+	for _, tool := range tools {
+		if len(tool.Missing) > 0 || len(tool.Error) > 0 {
+			return data.WithCategory(data.CategoryPreflight, errors.New("preflight failed, some tools are missing in the containers"))
+		}
+	}
+	return nil
+}
+
 func (c *CloudCommand) backCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "back",
 		Short: "back data",
-		Run:   c.back,
+		RunE:  c.back,
 	}
+	cmd.Flags().IntVar(&c.retain, "retain", 0, "keep the newest N backup versions after backing up, 0 means keeping all")
+	cmd.Flags().BoolVar(&c.incremental, "incremental", false, "hard link the unchanged files to the previous backup version by rsync instead of copying them")
+	cmd.Flags().BoolVar(&c.rolling, "rolling", false, "stop, back up and start the pods one by one to keep the cluster serving")
+	cmd.Flags().BoolVar(&c.noStart, "no-start", false, "leave the components stopped after backing up, run tc start to start them")
+	cmd.Flags().DurationVar(&c.progress, "progress-interval", data.DefaultProgressInterval, "interval to log the backup progress of every pod, 0 means not logging")
+	cmd.Flags().StringSliceVar(&c.pods, "pod", nil, "only back up the pods, it can be repeated, e.g. tikv-0 or tidb-a/tikv-0")
+	cmd.Flags().StringVar(&c.upload, "upload", "", "upload the backups to the object storage after backing up, e.g. s3://bucket/prefix?endpoint=http://minio:9000")
+	cmd.Flags().Float64Var(&c.minFreeRatio, "min-free-ratio", data.MinFreeRatio, "min ratio of the free space in the file system after backing up")
+	cmd.Flags().StringVar(&c.maxBackupSize, "max-backup-size", "", "skip the pods whose files to back up are larger than it, or fail with --strict, e.g. 200G, empty means no limit")
+	cmd.Flags().BoolVar(&c.resume, "resume", false, "continue the interrupted backup of the version whose lock is left, the pods backed up since it started are skipped")
+	cmd.Flags().BoolVar(&c.timestamped, "timestamped", false, "name the backup <version>-<unixtime>, so the backups of the same version taken at different times are kept, it can't be used with --upload or --snapshot")
+	cmd.Flags().BoolVar(&c.pdSingle, "pd-single", false, "back up only one pd pod, the leader if it's found by the pd api, otherwise the first pod by name, restore refuses to restore pd from it")
+	cmd.Flags().StringArrayVar(&c.excludes, "exclude", nil, "extended regular expression of the files in the data directory to exclude from the backup, it can be repeated, e.g. raftdb_tmp or '^last_.*\\.toml$'")
 	return cmd
 }
 
@@ -102,6 +570,10 @@ func (c *CloudCommand) listCmd() *cobra.Command {
 		Short: "list version",
 		RunE:  c.listE,
 	}
+	cmd.Flags().StringVar(&c.listComponent, "component", "", "only list the pods of the components, e.g. tikv,pd, default is all the components")
+	cmd.Flags().StringVar(&c.versionPrefix, "version-prefix", "", "only list the versions having the prefix or matching the glob pattern, e.g. 5. or 5.*")
+	cmd.Flags().BoolVar(&c.listMetadata, "metadata", false, "read the metadata of the backups and show the time they were taken")
+	cmd.Flags().BoolVar(&c.listSizes, "sizes", false, "measure the sizes of the backups by du, it is implied by -o wide")
 	return cmd
 }
 
@@ -110,144 +582,541 @@ func (c *CloudCommand) listE(cmd *cobra.Command, _ []string) error {
 	if err != nil {
 		return err
 	}
-	cmd.Printf("version list:%v\n", rst)
-	return nil
+	return render(cmd.OutOrStdout(), c.output, rst, versionsTable(rst))
 }
 
-func (c *CloudCommand) list(_ *cobra.Command, _ []string) (map[string][]string, error) {
-	co := data.NewCloudOperator(c.namespace, c.config, context.Background())
-	if co == nil {
-		return nil, errors.New("init k8s client failed")
+func (c *CloudCommand) list(_ *cobra.Command, _ []string) ([]data.BackupInfo, error) {
+	filter, err := data.ParseListFilter(c.listComponent, c.versionPrefix)
+	if err != nil {
+		return nil, err
 	}
-	return co.List()
+	filter.Metadata = c.listMetadata
+	// measuring the sizes takes long, so they are only measured if they are shown.
+	filter.Sizes = c.listSizes || c.output == OutputWide
+	co, err := c.cloudOperator()
+	if err != nil {
+		return nil, err
+	}
+	defer co.CleanupDebugContainers()
+	return co.List(filter)
+}
+
+func (c *CloudCommand) catalogCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "catalog",
+		Short: "show which pods have every backup version, the version is only safe to restore if all the pods of the component have it",
+		RunE:  c.catalog,
+	}
+	cmd.Flags().StringVar(&c.listComponent, "component", "", "only show the versions of the components, e.g. tikv,pd, default is all the components")
+	cmd.Flags().StringVar(&c.versionPrefix, "version-prefix", "", "only show the versions having the prefix or matching the glob pattern, e.g. 5. or 5.*")
+	return cmd
+}
+
+func (c *CloudCommand) catalog(cmd *cobra.Command, _ []string) error {
+	filter, err := data.ParseListFilter(c.listComponent, c.versionPrefix)
+	if err != nil {
+		return err
+	}
+	co, err := c.cloudOperator()
+	if err != nil {
+		return err
+	}
+	defer co.CleanupDebugContainers()
+	entries, err := co.Catalog(filter)
+	if err != nil {
+		return err
+	}
+	return render(cmd.OutOrStdout(), c.output, entries, catalogTable(entries))
+}
+
+func (c *CloudCommand) statusCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "show the phase, debug mode, process state and backup versions of every pod",
+		RunE:  c.status,
+	}
+	return cmd
+}
+
+func (c *CloudCommand) status(cmd *cobra.Command, _ []string) error {
+	co, err := c.cloudOperator()
+	if err != nil {
+		return err
+	}
+	defer co.CleanupDebugContainers()
+	status, err := co.Status()
+	if err != nil {
+		return err
+	}
+	return render(cmd.OutOrStdout(), c.output, status, statusTable(status))
 }
 
 func (c *CloudCommand) stop(cmd *cobra.Command, _ []string) error {
-	co := data.NewCloudOperator(c.namespace, c.config, context.Background())
-	if co == nil {
-		cmd.Println("init k8s client failed \n")
-		return nil
+	filter, err := data.ParsePodFilter(c.podComponent, c.pods)
+	if err != nil {
+		return err
 	}
-	if err := co.Stop(); err != nil {
-		cmd.Printf("stop cloud operator failed:%v \n", err)
-		return nil
+	co, err := c.cloudOperator()
+	if err != nil {
+		return err
+	}
+	defer co.CleanupDebugContainers()
+	err = co.StopPods(filter)
+	printSkipped(cmd, co)
+	if err != nil {
+		return fmt.Errorf("stop cloud operator failed:%v", err)
 	}
 	return nil
 }
 
+// printSkipped prints the pods which were skipped because they were not running.
+func printSkipped(cmd *cobra.Command, co *data.CloudOperator) {
+	for _, pod := range co.Skipped() {
+		if len(pod.Reason) > 0 {
+			cmd.Printf("%s skipped pod %s of %s, %s\n", pod.Operation, pod.Pod, pod.Component, pod.Reason)
+			continue
+		}
+		cmd.Printf("%s skipped pod %s of %s, it is %s\n", pod.Operation, pod.Pod, pod.Component, pod.Phase)
+	}
+}
+
 func (c *CloudCommand) start(cmd *cobra.Command, _ []string) error {
-	co := data.NewCloudOperator(c.namespace, c.config, context.Background())
-	if co == nil {
-		cmd.Println("init k8s client failed")
-		return nil
+	filter, err := data.ParsePodFilter(c.podComponent, c.pods)
+	if err != nil {
+		return err
 	}
-	if err := co.Start(); err != nil {
-		cmd.Printf("stop cloud operator failed:%v \n", err)
+	co, err := c.cloudOperator()
+	if err != nil {
 		return err
 	}
-	time.Sleep(time.Minute)
-	for i := 0; i < 5; i++ {
-		if err := c.check(cmd, nil); err == nil {
-			return nil
-		}
-		cmd.Println("waiting for pods start")
-		time.Sleep(time.Second * 10)
+	defer co.CleanupDebugContainers()
+	if err := co.StartPods(filter); err != nil {
+		return fmt.Errorf("start cloud operator failed:%v", err)
 	}
-	cmd.Println("pods check exceed timeout")
-	return nil
+	// the other pods of the components may be left stopped on purpose, StartPods has waited for the started pods ready.
+	if len(filter.Pods) > 0 {
+		cmd.Printf("started pods %s\n", strings.Join(filter.Pods, ","))
+		return nil
+	}
+	// every component is waited for until it's up or its own up timeout elapses.
+	return c.renderCheck(cmd, co.WaitUp(filter))
 }
 
 func (c *CloudCommand) check(cmd *cobra.Command, _ []string) error {
-	co := data.NewCloudOperator(c.namespace, c.config, context.Background())
-	if co == nil {
-		return errors.New("init k8s client failed")
+	co, err := c.cloudOperator()
+	if err != nil {
+		return err
+	}
+	defer co.CleanupDebugContainers()
+	return c.renderCheck(cmd, co.Check())
+}
+
+// renderCheck prints the health of the components, it returns the error of the components which are not up.
+func (c *CloudCommand) renderCheck(cmd *cobra.Command, results []data.StatusResult) error {
+	if err := render(cmd.OutOrStdout(), c.output, results, checkTable(results)); err != nil {
+		return err
+	}
+	if err := data.CheckError(results); err != nil {
+		return err
 	}
-	if !co.Check() {
-		return errors.New("check failed")
+	if isTable(c.output) {
+		cmd.Printf("check success \n")
 	}
-	cmd.Printf("check success \n")
 	return nil
 }
 
-func (c *CloudCommand) back(cmd *cobra.Command, _ []string) {
-	ctx := context.Background()
-	t := time.Now()
-	cmd.Println("it will try to stop all component")
-	if err := c.stop(cmd, nil); err != nil {
-		cmd.Printf("stop cloud operator failed:%v", err)
-		return
+func (c *CloudCommand) back(cmd *cobra.Command, _ []string) error {
+	if err := data.ValidateVersion(c.version); err != nil {
+		return err
 	}
-	cmd.Printf("it has stopped component, costs:%f s \n", time.Since(t).Seconds())
-	time.Sleep(time.Second * 20)
-	cmd.Println("it will back data，it can not interrupt, please wait")
-	co := data.NewCloudOperator(c.namespace, c.config, ctx)
-	if co == nil {
-		cmd.Println("init k8s client failed")
-		return
+	if c.incremental && c.compress {
+		return errors.New("--incremental can't be used with --compress")
 	}
-	if err := co.Back(c.version); err != nil {
-		cmd.Printf("back to %s failed:%v", c.version, err)
-		return
+	if c.noStart && c.rolling {
+		return errors.New("--no-start can't be used with --rolling")
 	}
-	cmd.Printf("it restores component already, costs:%f s \n", time.Since(t).Seconds())
-	if err := c.start(cmd, nil); err != nil {
-		cmd.Printf("pods start error:%v", err)
+	if c.resume && c.compress {
+		return errors.New("--resume can't be used with --compress")
 	}
-	cmd.Println("it finished all")
-	return
+	if c.resume && c.upload != "" {
+		return errors.New("--resume can't be used with --upload")
+	}
+	if c.timestamped && (c.upload != "" || c.snapshot) {
+		return errors.New("--timestamped only works with the backups in the pods, it can't be used with --upload or --snapshot")
+	}
+	if err := data.ValidateExcludes(c.excludes); err != nil {
+		return err
+	}
+	maxBackupSize, err := data.ParseMaxBackupSize(c.maxBackupSize)
+	if err != nil {
+		return err
+	}
+	var uploader data.Uploader
+	if c.upload != "" {
+		if uploader, err = data.ParseUploader(c.upload); err != nil {
+			return err
+		}
+	}
+	if err := c.confirm(cmd, "back"); err != nil {
+		return err
+	}
+	co, err := c.cloudOperator()
+	if err != nil {
+		return err
+	}
+	defer co.CleanupDebugContainers()
+	co.Retain = c.retain
+	co.Incremental = c.incremental
+	co.Upload = uploader
+	co.ProgressInterval = c.progress
+	co.MinFreeRatio = c.minFreeRatio
+	co.MaxBackupSize = maxBackupSize
+	co.Excludes = c.excludes
+	co.Resume = c.resume
+	co.Timestamped = c.timestamped
+	co.PDSingle = c.pdSingle
+	co.NoStart = c.noStart
+	co.Notify = func(msg string) {
+		cmd.Println(msg)
+	}
+	defer notifyInterrupt(cmd, c.cancel)()
+	var results []data.OperationResult
+	if c.rolling {
+		results, err = co.RollingBackupWorkflow(c.ctx, c.version)
+	} else {
+		results, err = co.BackupWorkflow(c.ctx, c.version)
+	}
+	printSkipped(cmd, co)
+	return renderResults(cmd, c.output, results, err)
 }
 
 func (c *CloudCommand) restoreCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "restore",
 		Short: "restore data",
-		Run:   c.restore,
+		RunE:  c.restore,
 	}
+	cmd.Flags().StringSliceVar(&c.pods, "pod", nil, "only restore the pods, it can be repeated, e.g. tikv-0 or tidb-a/tikv-0")
+	cmd.Flags().StringVar(&c.download, "download", "", "download the backups from the object storage before restoring, e.g. s3://bucket/prefix?endpoint=http://minio:9000")
+	cmd.Flags().StringVar(&c.from, "from", "", "download the backup artifact of every pod before restoring, <namespace>, <pod> and <version> are replaced, e.g. s3://bucket/prefix/<pod>/<version>.tar.gz")
+	cmd.Flags().BoolVar(&c.timestamped, "timestamped", false, "restore the latest timestamped backup <version>-<unixtime> of the version, or the plain backup if there is none, it can't be used with --download, --from or --snapshot")
+	cmd.Flags().StringVar(&c.at, "at", "", "restore the latest timestamped backup of the version taken at or before the time, unix time or RFC3339, e.g. 2021-12-01T00:00:00Z, it implies --timestamped")
+	cmd.Flags().BoolVar(&c.noStart, "no-start", false, "leave the components stopped after restoring, run tc start to start them")
+	cmd.Flags().BoolVar(&c.force, "force", false, "restore even if the component process is still running in the pods, e.g. stopping them failed, the backup version is still checked")
+	cmd.Flags().BoolVar(&c.diff, "diff", false, "only print the files which restore would change, add or remove without modifying anything")
+	cmd.Flags().BoolVar(&c.diffSummary, "diff-summary", false, "like --diff but only print the count of files per pod")
+	cmd.Flags().StringArrayVar(&c.healthCmds, "health-cmd", nil, "check the restored component by the command in its running pods after start, it can be repeated, e.g. tikv='curl -sf http://127.0.0.1:20180/status'")
+	cmd.Flags().DurationVar(&c.healthTimeout, "health-timeout", data.DefaultHealthTimeout, "wait the health commands to succeed for it, restore fails after it, 0 means checking once")
 	return cmd
 }
 
-func (c *CloudCommand) restore(cmd *cobra.Command, _ []string) {
-	ctx := context.Background()
-	t := time.Now()
-	cmd.Println("it will try to stop all component")
-	if err := c.stop(cmd, nil); err != nil {
-		cmd.Printf("stop cloud operator failed:%v \n", err)
-		return
+func (c *CloudCommand) restore(cmd *cobra.Command, _ []string) error {
+	if _, err := data.ParseComponentVersions(c.version); err != nil {
+		return err
 	}
-	cmd.Printf("it has stopped component, costs:%f s \n", time.Since(t).Seconds())
-	time.Sleep(time.Second * 20)
-	cmd.Println("it will restore data，it can not interrupt, please wait")
-	co := data.NewCloudOperator(c.namespace, c.config, ctx)
-	if co == nil {
-		cmd.Println("init k8s client failed")
-		return
+	healthCmds, err := data.ParseHealthCommands(c.healthCmds)
+	if err != nil {
+		return err
 	}
-	if err := co.Restore(c.version); err != nil {
-		cmd.Printf("restore from %s failed:%v\n", c.version, err)
-		return
+	if c.download != "" && c.from != "" {
+		return errors.New("--download can't be used with --from")
 	}
-	cmd.Printf("it restores component already, costs:%f s \n", time.Since(t).Seconds())
-	if err := c.start(cmd, nil); err != nil {
-		cmd.Printf("pods start error:%v", err)
+	if c.timestamped && (c.download != "" || c.from != "" || c.snapshot) {
+		return errors.New("--timestamped only works with the backups in the pods, it can't be used with --download, --from or --snapshot")
 	}
-	cmd.Println("it finished all")
-	return
+	var at time.Time
+	if c.at != "" {
+		if at, err = data.ParseAt(c.at); err != nil {
+			return err
+		}
+		if c.download != "" || c.from != "" || c.snapshot {
+			return errors.New("--at only selects the backups in the pods, it can't be used with --download, --from or --snapshot")
+		}
+	}
+	var downloader data.Downloader
+	switch {
+	case c.download != "":
+		if downloader, err = data.ParseUploader(c.download); err != nil {
+			return err
+		}
+	case c.from != "":
+		if downloader, err = data.ParseArtifact(c.from); err != nil {
+			return err
+		}
+	}
+	if c.diff || c.diffSummary {
+		return c.restoreDiff(cmd)
+	}
+	if c.noStart && len(healthCmds) > 0 {
+		return errors.New("--no-start can't be used with --health-cmd")
+	}
+	// the warning is printed even if the confirmation is skipped by --yes.
+	if c.force {
+		cmd.PrintErrln("WARNING: --force restores without checking the component process is stopped, the data may be corrupted if it is still writing")
+	}
+	if err := c.confirm(cmd, "restore"); err != nil {
+		return err
+	}
+	co, err := c.cloudOperator()
+	if err != nil {
+		return err
+	}
+	defer co.CleanupDebugContainers()
+	co.Download = downloader
+	co.Timestamped = c.timestamped || !at.IsZero()
+	co.At = at
+	co.Force = c.force
+	co.NoStart = c.noStart
+	if len(healthCmds) > 0 {
+		co.PostRestoreHook = &data.HealthCheckHook{Commands: healthCmds, Timeout: c.healthTimeout}
+	}
+	co.Notify = func(msg string) {
+		cmd.Println(msg)
+	}
+	defer notifyInterrupt(cmd, c.cancel)()
+	results, err := co.RestoreWorkflow(c.ctx, c.version)
+	printSkipped(cmd, co)
+	return renderResults(cmd, c.output, results, err)
 }
 
-func (c *CloudCommand) removeVersion(cmd *cobra.Command, _ []string) {
-	ctx := context.Background()
-	cmd.Println("it will restore data，it can not interrupt, please wait")
-	co := data.NewCloudOperator(c.namespace, c.config, ctx)
-	if co == nil {
-		cmd.Println("init k8s client failed")
-		return
+// renderResults renders the results of the pods if there are any, the error of the operation is returned as it is.
+func renderResults(cmd *cobra.Command, output string, results []data.OperationResult, err error) error {
+	if len(results) > 0 {
+		if rerr := render(cmd.OutOrStdout(), output, results, resultsTable(results)); rerr != nil && err == nil {
+			return rerr
+		}
+	}
+	return err
+}
+
+// restoreDiff prints what restore would change in every pod, nothing is stopped or modified.
+func (c *CloudCommand) restoreDiff(cmd *cobra.Command) error {
+	co, err := c.cloudOperator()
+	if err != nil {
+		return err
+	}
+	defer co.CleanupDebugContainers()
+	results, err := co.Diff(c.version)
+	if err != nil {
+		return err
+	}
+	if c.diffSummary {
+		summaries := diffSummaries(results)
+		return render(cmd.OutOrStdout(), c.output, summaries, diffSummaryTable(summaries))
 	}
+	return render(cmd.OutOrStdout(), c.output, results, diffTable(results))
+}
+
+func (c *CloudCommand) removeVersion(cmd *cobra.Command, _ []string) error {
+	if err := data.ValidateVersion(c.version); err != nil {
+		return err
+	}
+	cmd.Println("it will remove data，it can not interrupt, please wait")
+	co, err := c.cloudOperator()
+	if err != nil {
+		return err
+	}
+	defer co.CleanupDebugContainers()
 	if err := co.Remove(c.version); err != nil {
-		cmd.Printf("restore from %s failed:%v\n", c.version, err)
-		return
+		return fmt.Errorf("remove %s failed:%v", c.version, err)
 	}
 	cmd.Println("it finished all")
-	return
+	return nil
+}
+
+// isTerminal returns true if the stdin is a terminal.
+var isTerminal = func() bool {
+	stat, err := os.Stdin.Stat()
+	return err == nil && (stat.Mode()&os.ModeCharDevice) != 0
+}
+
+// confirm asks the user to type the namespace before the destructive operation.
+// It will be skipped if --yes or --dry-run is set.
+func (c *CloudCommand) confirm(cmd *cobra.Command, operation string) error {
+	if c.yes || c.dryRun {
+		return nil
+	}
+	if !isTerminal() {
+		return errors.New("stdin is not a terminal, please use --yes to skip the confirmation")
+	}
+	cmd.Printf("it will stop all components and %s data, namespace:%s, version:%s, components:%s\n", operation, c.confirmNamespace(), c.version, c.components)
+	return c.readNamespace(cmd, operation)
+}
+
+// confirmPrune returns the confirmation of prune, it shows the space reclaimed in every pod and asks the user to type the namespace.
+// It returns nil if --yes or --dry-run is set.
+func (c *CloudCommand) confirmPrune(cmd *cobra.Command) (func(targets []data.PruneTarget) error, error) {
+	if c.yes || c.dryRun {
+		return nil, nil
+	}
+	if !isTerminal() {
+		return nil, errors.New("stdin is not a terminal, please use --yes to skip the confirmation")
+	}
+	return func(targets []data.PruneTarget) error {
+		if err := render(cmd.OutOrStdout(), OutputTable, targets, pruneTable(targets)); err != nil {
+			return err
+		}
+		var total int64
+		for i := range targets {
+			total += targets[i].Size
+		}
+		cmd.Printf("it will prune the backups and reclaim %s, namespace:%s\n", formatSize(total), c.confirmNamespace())
+		return c.readNamespace(cmd, "prune")
+	}, nil
+}
+
+// confirmNamespace returns the namespace which should be typed to confirm.
+func (c *CloudCommand) confirmNamespace() string {
+	if c.allNamespaces {
+		return allNamespaces
+	}
+	// the namespaces are known after listing, so the selector is typed.
+	if len(c.nsSelector) > 0 {
+		return c.nsSelector
+	}
+	return c.namespace
+}
+
+// readNamespace reads the namespace typed by the user, the operation is cancelled if it doesn't match.
+func (c *CloudCommand) readNamespace(cmd *cobra.Command, operation string) error {
+	namespace := c.confirmNamespace()
+	cmd.Printf("please type the namespace to continue:")
+	input, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if strings.TrimSpace(input) != namespace {
+		return fmt.Errorf("%s is cancelled, the input doesn't match namespace %s", operation, namespace)
+	}
+	return nil
+}
+
+func (c *CloudCommand) prune(cmd *cobra.Command, _ []string) error {
+	// --version is ignored if --all-except is set.
+	if c.allExcept <= 0 {
+		if err := data.ValidateVersion(c.version); err != nil {
+			return err
+		}
+	}
+	confirm, err := c.confirmPrune(cmd)
+	if err != nil {
+		return err
+	}
+	co, err := c.cloudOperator()
+	if err != nil {
+		return err
+	}
+	defer co.CleanupDebugContainers()
+	co.ConfirmPrune = confirm
+	if c.allExcept > 0 {
+		if err := co.PruneAllExcept(c.allExcept); err != nil {
+			return fmt.Errorf("prune all except newest %d failed:%v", c.allExcept, err)
+		}
+	} else if err := co.Prune(c.version); err != nil {
+		return fmt.Errorf("prune %s failed:%v", c.version, err)
+	}
+	cmd.Println("it finished all")
+	return nil
+}
+
+func (c *CloudCommand) verifyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "verify the live data matches the backup version",
+		RunE:  c.verify,
+	}
+	return cmd
+}
+
+func (c *CloudCommand) verify(cmd *cobra.Command, _ []string) error {
+	co, err := c.cloudOperator()
+	if err != nil {
+		return err
+	}
+	defer co.CleanupDebugContainers()
+	results, err := co.Verify(c.version)
+	if err != nil {
+		return err
+	}
+	// nothing is executed, so there is no checksum to compare.
+	if co.DryRun {
+		return nil
+	}
+	if err := render(cmd.OutOrStdout(), c.output, results, verifyTable(results)); err != nil {
+		return err
+	}
+	var mismatches int
+	for i := range results {
+		if !results[i].Match() {
+			mismatches++
+		}
+	}
+	if mismatches > 0 {
+		return fmt.Errorf("verify %s failed, %d of %d pods mismatch", c.version, mismatches, len(results))
+	}
+	cmd.Printf("verify %s passed, %d pods match\n", c.version, len(results))
+	return nil
+}
+
+func (c *CloudCommand) execCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "exec --component <component> -- <command>...",
+		Short: "exec the command in the pods of the component and print the output grouped by pod, the local stdin is sent to every pod if it's not a terminal",
+		Args: func(_ *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("no command to exec, e.g. tc exec --component tikv -- ls /var/lib/tikv")
+			}
+			return nil
+		},
+		RunE: c.exec,
+	}
+	cmd.Flags().StringSliceVar(&c.pods, "pod", nil, "only exec in the pods, it can be repeated, e.g. tikv-0 or tidb-a/tikv-0")
+	cmd.Flags().StringVar(&c.execComponent, "component", "", "the component of the pods, e.g. tikv")
+	_ = cmd.MarkFlagRequired("component")
+	return cmd
+}
+
+func (c *CloudCommand) exec(cmd *cobra.Command, args []string) error {
+	var input []byte
+	if stdin := execStdin(cmd); stdin != nil {
+		var err error
+		if input, err = io.ReadAll(stdin); err != nil {
+			return err
+		}
+	}
+	co, err := c.cloudOperator()
+	if err != nil {
+		return err
+	}
+	defer co.CleanupDebugContainers()
+	results, err := co.ExecPods(c.execComponent, args, input)
+	if err != nil {
+		return err
+	}
+	printSkipped(cmd, co)
+	if isTable(c.output) {
+		writeExecResults(cmd.OutOrStdout(), results)
+	} else if err := render(cmd.OutOrStdout(), c.output, results, nil); err != nil {
+		return err
+	}
+	var failed int
+	for i := range results {
+		if len(results[i].Error) > 0 {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("exec failed in %d of %d pods", failed, len(results))
+	}
+	return nil
+}
+
+// execStdin returns the stdin to send to the command, the terminal isn't sent.
+func execStdin(cmd *cobra.Command) io.Reader {
+	if isTerminal() {
+		return nil
+	}
+	return cmd.InOrStdin()
 }
 
 func homeDir() string {
@@ -0,0 +1,391 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/bufferflies/tinker/pkg/data"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfirm(t *testing.T) {
+	defer func(fn func() bool) {
+		isTerminal = fn
+	}(isTerminal)
+
+	testCases := []struct {
+		yes      bool
+		terminal bool
+		input    string
+		hasErr   bool
+	}{
+		{
+			terminal: true,
+			input:    "tidb-cluster\n",
+		},
+		{
+			terminal: true,
+			input:    "default\n",
+			hasErr:   true,
+		},
+		{
+			terminal: false,
+			input:    "tidb-cluster\n",
+			hasErr:   true,
+		},
+		{
+			yes:      true,
+			terminal: false,
+		},
+	}
+	for _, ca := range testCases {
+		c := &CloudCommand{namespace: "tidb-cluster", version: "5.2", yes: ca.yes}
+		isTerminal = func() bool {
+			return ca.terminal
+		}
+		cmd := &cobra.Command{}
+		cmd.SetIn(strings.NewReader(ca.input))
+		cmd.SetOut(new(bytes.Buffer))
+		err := c.confirm(cmd, "restore")
+		if ca.hasErr {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+}
+
+func TestConfirmNamespaceSelector(t *testing.T) {
+	defer func(fn func() bool) {
+		isTerminal = fn
+	}(isTerminal)
+	isTerminal = func() bool {
+		return true
+	}
+
+	// the selector is typed instead of the namespaces.
+	c := &CloudCommand{nsSelector: "app=tidb-cluster", version: "5.2"}
+	for input, ok := range map[string]bool{"app=tidb-cluster\n": true, "default\n": false} {
+		cmd := &cobra.Command{}
+		cmd.SetIn(strings.NewReader(input))
+		cmd.SetOut(new(bytes.Buffer))
+		assert.Equal(t, ok, c.confirm(cmd, "restore") == nil, input)
+	}
+}
+
+func TestForceRestoreConfirm(t *testing.T) {
+	defer func(fn func() bool) {
+		isTerminal = fn
+	}(isTerminal)
+	isTerminal = func() bool {
+		return true
+	}
+
+	// force warns and still asks for the confirmation.
+	c := &CloudCommand{namespace: "tidb-cluster", version: "5.2", force: true}
+	cmd := &cobra.Command{}
+	cmd.SetIn(strings.NewReader("default\n"))
+	cmd.SetOut(new(bytes.Buffer))
+	errOut := new(bytes.Buffer)
+	cmd.SetErr(errOut)
+	assert.EqualError(t, c.restore(cmd, nil), "restore is cancelled, the input doesn't match namespace tidb-cluster")
+	assert.Contains(t, errOut.String(), "WARNING: --force restores without checking")
+}
+
+func TestExecStdin(t *testing.T) {
+	defer func(fn func() bool) {
+		isTerminal = fn
+	}(isTerminal)
+
+	cmd := &cobra.Command{}
+	input := strings.NewReader("[server]\n")
+	cmd.SetIn(input)
+	isTerminal = func() bool {
+		return false
+	}
+	assert.Equal(t, input, execStdin(cmd))
+	isTerminal = func() bool {
+		return true
+	}
+	assert.Nil(t, execStdin(cmd))
+}
+
+func TestConfirmPrune(t *testing.T) {
+	defer func(fn func() bool) {
+		isTerminal = fn
+	}(isTerminal)
+	isTerminal = func() bool {
+		return true
+	}
+	targets := []data.PruneTarget{{Pod: "tikv-0", Component: "tikv", Versions: []string{"5.1"}, Size: 2048}}
+
+	c := &CloudCommand{namespace: "tidb-cluster"}
+	cmd := &cobra.Command{}
+	out := new(bytes.Buffer)
+	cmd.SetOut(out)
+	cmd.SetIn(strings.NewReader("tidb-cluster\n"))
+	confirm, err := c.confirmPrune(cmd)
+	assert.NoError(t, err)
+	assert.NoError(t, confirm(targets))
+	assert.Contains(t, out.String(), "tikv-0  tikv       5.1       2.0MiB")
+	assert.Contains(t, out.String(), "reclaim 2.0MiB")
+
+	cmd.SetIn(strings.NewReader("default\n"))
+	confirm, err = c.confirmPrune(cmd)
+	assert.NoError(t, err)
+	assert.Error(t, confirm(targets))
+
+	// it doesn't confirm with --yes.
+	c.yes = true
+	confirm, err = c.confirmPrune(cmd)
+	assert.NoError(t, err)
+	assert.Nil(t, confirm)
+
+	c.yes = false
+	isTerminal = func() bool {
+		return false
+	}
+	_, err = c.confirmPrune(cmd)
+	assert.Error(t, err)
+}
+
+func TestContextNamespace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tinker")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	conf := filepath.Join(dir, "config")
+	kubeConfig := `apiVersion: v1
+kind: Config
+contexts:
+- context:
+    cluster: test
+    namespace: tidb-cluster
+  name: test
+- context:
+    cluster: test
+    namespace: tidb-staging
+  name: staging
+current-context: test
+`
+	assert.NoError(t, ioutil.WriteFile(conf, []byte(kubeConfig), 0600))
+
+	c := &CloudCommand{config: conf}
+	namespace, err := c.contextNamespace()
+	assert.NoError(t, err)
+	assert.Equal(t, "tidb-cluster", namespace)
+
+	c = &CloudCommand{config: conf, kubeContext: "staging"}
+	namespace, err = c.contextNamespace()
+	assert.NoError(t, err)
+	assert.Equal(t, "tidb-staging", namespace)
+	c = &CloudCommand{config: conf, kubeContext: "prod"}
+	_, err = c.contextNamespace()
+	assert.Error(t, err)
+
+	// the kube config is not used in cluster.
+	c = &CloudCommand{config: conf, inCluster: true}
+	_, err = c.contextNamespace()
+	assert.Error(t, err)
+
+	c = &CloudCommand{config: filepath.Join(dir, "not-exist")}
+	_, err = c.contextNamespace()
+	assert.Error(t, err)
+}
+
+func TestNotifyInterrupt(t *testing.T) {
+	cmd := &cobra.Command{}
+	errOut := &bytes.Buffer{}
+	cmd.SetErr(errOut)
+
+	// the context is cancelled on the signal.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stop := notifyInterrupt(cmd, cancel)
+	defer stop()
+	assert.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGINT))
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the context is not cancelled after the interrupt")
+	}
+	assert.Contains(t, errOut.String(), "it will cancel the operation and start all the components")
+
+	// nothing is cancelled after it stops handling the signals.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	notifyInterrupt(cmd, cancel)()
+	assert.NoError(t, ctx.Err())
+}
+
+// TestInterruptProcess sends SIGINT to a child process, it should run the work after the cancellation
+// instead of being killed by the signal.
+func TestInterruptProcess(t *testing.T) {
+	if os.Getenv("TINKER_INTERRUPT_HELPER") == "1" {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer notifyInterrupt(&cobra.Command{}, cancel)()
+		fmt.Println("ready")
+		<-ctx.Done()
+		// the workflow starts the components after the cancellation.
+		time.Sleep(100 * time.Millisecond)
+		fmt.Println("started all the components")
+		os.Exit(0)
+	}
+	child := exec.Command(os.Args[0], "-test.run=^TestInterruptProcess$")
+	child.Env = append(os.Environ(), "TINKER_INTERRUPT_HELPER=1")
+	stdout, err := child.StdoutPipe()
+	assert.NoError(t, err)
+	assert.NoError(t, child.Start())
+	reader := bufio.NewReader(stdout)
+	line, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "ready\n", line)
+
+	assert.NoError(t, child.Process.Signal(os.Interrupt))
+	rest, err := ioutil.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.NoError(t, child.Wait())
+	assert.Contains(t, string(rest), "started all the components")
+}
+
+func TestDeadline(t *testing.T) {
+	defer StopDeadline()
+	c := &CloudCommand{}
+
+	// the operations are bounded by the deadline of the invocation.
+	deadline := &Deadline{Timeout: time.Hour}
+	assert.NoError(t, deadline.Start(context.Background()))
+	ctx, cancel := c.newContext()
+	defer cancel()
+	at, ok := ctx.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), at, time.Minute)
+
+	// the shorter timeout of the operation wins.
+	c.timeout = time.Minute
+	ctx, cancel = c.newContext()
+	defer cancel()
+	at, _ = ctx.Deadline()
+	assert.WithinDuration(t, time.Now().Add(time.Minute), at, 10*time.Second)
+
+	// the operation is cancelled once the deadline is exceeded.
+	c.timeout = 0
+	deadline.Timeout = 10 * time.Millisecond
+	assert.NoError(t, deadline.Start(context.Background()))
+	ctx, cancel = c.newContext()
+	defer cancel()
+	select {
+	case <-ctx.Done():
+		assert.ErrorIs(t, ctx.Err(), context.DeadlineExceeded)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the context is not cancelled after the deadline")
+	}
+
+	// no deadline after the invocation stopped or without the deadline.
+	StopDeadline()
+	ctx, cancel = c.newContext()
+	defer cancel()
+	_, ok = ctx.Deadline()
+	assert.False(t, ok)
+	deadline.Timeout = 0
+	assert.NoError(t, deadline.Start(context.Background()))
+	ctx, cancel = c.newContext()
+	defer cancel()
+	_, ok = ctx.Deadline()
+	assert.False(t, ok)
+
+	deadline.Timeout = -time.Second
+	assert.Error(t, deadline.Start(context.Background()))
+}
+
+func TestCloudOperatorReused(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tinker")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	conf := filepath.Join(dir, "config")
+	kubeConfig := `apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    server: https://127.0.0.1:6443
+  name: test
+contexts:
+- context:
+    cluster: test
+    user: test
+  name: test
+current-context: test
+users:
+- name: test
+  user:
+    token: test
+`
+	assert.NoError(t, ioutil.WriteFile(conf, []byte(kubeConfig), 0600))
+	c := &CloudCommand{
+		config:     conf,
+		namespace:  "tidb-cluster",
+		components: data.DefaultComponents,
+		order:      "tidb,tikv,pd",
+		upTimeouts: data.DefaultUpTimeouts,
+		retry:      1,
+		qps:        data.DefaultQPS,
+		burst:      data.DefaultBurst,
+	}
+
+	// all the steps of the invocation share the operator.
+	co, err := c.cloudOperator()
+	assert.NoError(t, err)
+	reused, err := c.cloudOperator()
+	assert.NoError(t, err)
+	assert.Same(t, co, reused)
+	assert.NoError(t, c.ctx.Err())
+
+	// the next invocation creates its own operator.
+	assert.NoError(t, c.initOperator())
+	assert.NotSame(t, co, c.operator)
+
+	c.components = "tiflash"
+	c.operator = nil
+	_, err = c.cloudOperator()
+	assert.Error(t, err)
+	assert.Nil(t, c.operator)
+}
+
+func TestClientRateFlags(t *testing.T) {
+	cmd := NewCloudCommand()
+	assert.NoError(t, cmd.PersistentFlags().Parse([]string{"--qps", "200", "--burst", "400"}))
+	assert.Equal(t, data.ClientRate{QPS: 200, Burst: 400}, cloudCmd.clientRate())
+	assert.NoError(t, cloudCmd.clientRate().Validate())
+
+	// the defaults are used without the flags.
+	cmd = NewCloudCommand()
+	assert.NoError(t, cmd.PersistentFlags().Parse(nil))
+	assert.Equal(t, data.DefaultClientRate, cloudCmd.clientRate())
+
+	cmd = NewCloudCommand()
+	assert.NoError(t, cmd.PersistentFlags().Parse([]string{"--qps", "200", "--burst", "100"}))
+	assert.Error(t, cloudCmd.clientRate().Validate())
+}
@@ -0,0 +1,64 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// invocation is the context of the running root command, it is context.Background if no deadline started.
+// All the operations of the command derive their contexts from it.
+var invocation = struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}{ctx: context.Background(), cancel: func() {}}
+
+// Deadline bounds the whole invocation of the root command, e.g. stop, wait, back and start of the back workflow.
+type Deadline struct {
+	Timeout time.Duration
+}
+
+// AddFlags adds the deadline flag to the persistent flags of the command.
+func (d *Deadline) AddFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().DurationVar(&d.Timeout, "deadline", 0, "deadline of the whole command from its start, the workflows still try to start the stopped components after it, 0 means no deadline")
+}
+
+// Start bounds the operations run after it by the deadline, the parent is the context of the root command.
+// It should be called once before the sub command runs.
+func (d *Deadline) Start(parent context.Context) error {
+	if d.Timeout < 0 {
+		return fmt.Errorf("invalid deadline %s, it should not be negative", d.Timeout)
+	}
+	if parent == nil {
+		parent = context.Background()
+	}
+	StopDeadline()
+	if d.Timeout > 0 {
+		invocation.ctx, invocation.cancel = context.WithTimeout(parent, d.Timeout)
+	} else {
+		invocation.ctx, invocation.cancel = context.WithCancel(parent)
+	}
+	return nil
+}
+
+// StopDeadline releases the context of the invocation.
+// It should be called after the root command exits.
+func StopDeadline() {
+	invocation.cancel()
+	invocation.ctx, invocation.cancel = context.Background(), func() {}
+}
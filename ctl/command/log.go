@@ -0,0 +1,67 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap/zapcore"
+)
+
+// Log formats.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// LogConfig is the logger config of the command line.
+type LogConfig struct {
+	Level  string
+	Format string
+	// Out is the writer of the logs, it is separated from the user-facing output of the commands.
+	Out io.Writer
+}
+
+// AddFlags adds the log flags to the persistent flags of the command.
+func (l *LogConfig) AddFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVar(&l.Level, "log-level", "info", "log level, one of debug|info|warn|error, debug logs the full commands and their output")
+	cmd.PersistentFlags().StringVar(&l.Format, "log-format", LogFormatText, "log format, one of text|json")
+}
+
+// Init replaces the global logger by the config, it returns the func to restore the previous logger.
+func (l *LogConfig) Init() (func(), error) {
+	switch l.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		return nil, fmt.Errorf("unknown log level: %s, it should be one of debug|info|warn|error", l.Level)
+	}
+	switch l.Format {
+	case LogFormatText, LogFormatJSON:
+	default:
+		return nil, fmt.Errorf("unknown log format: %s, it should be one of %s|%s", l.Format, LogFormatText, LogFormatJSON)
+	}
+	out := l.Out
+	if out == nil {
+		out = os.Stderr
+	}
+	logger, props, err := log.InitLoggerWithWriteSyncer(&log.Config{Level: l.Level, Format: l.Format}, zapcore.Lock(zapcore.AddSync(out)))
+	if err != nil {
+		return nil, err
+	}
+	return log.ReplaceGlobals(logger, props), nil
+}
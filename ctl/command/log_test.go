@@ -0,0 +1,61 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pingcap/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogConfig(t *testing.T) {
+	testCases := []struct {
+		level  string
+		format string
+		hasErr bool
+	}{
+		{level: "debug", format: LogFormatText},
+		{level: "warn", format: LogFormatJSON},
+		{level: "trace", format: LogFormatText, hasErr: true},
+		{level: "info", format: "xml", hasErr: true},
+	}
+	for _, ca := range testCases {
+		restore, err := (&LogConfig{Level: ca.level, Format: ca.format, Out: new(bytes.Buffer)}).Init()
+		if ca.hasErr {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		restore()
+	}
+}
+
+func TestLogLevelAndFormat(t *testing.T) {
+	out := new(bytes.Buffer)
+	restore, err := (&LogConfig{Level: "info", Format: LogFormatJSON, Out: out}).Init()
+	assert.NoError(t, err)
+	defer restore()
+
+	log.Debug("exec finished")
+	log.Info("backup finished")
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 1)
+	entry := make(map[string]interface{})
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "backup finished", entry["message"])
+}
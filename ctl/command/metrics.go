@@ -0,0 +1,66 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/bufferflies/tinker/pkg/data"
+	"github.com/pingcap/log"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+// metricsShutdownTimeout bounds the wait for the in-flight scrapes when the metrics server shuts down.
+const metricsShutdownTimeout = 5 * time.Second
+
+// metricsServer is the running metrics server, it is nil if --metrics-addr is not set.
+var metricsServer *http.Server
+
+// startMetricsServer serves the tinker metrics at /metrics of the address.
+// It returns error if the address can't be listened.
+func startMetricsServer(addr string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(data.Registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error("metrics server stopped", zap.String("addr", addr), zap.Error(err))
+		}
+	}()
+	log.Info("metrics server started", zap.String("addr", listener.Addr().String()))
+	return server, nil
+}
+
+// StopMetricsServer shuts down the metrics server if it's running.
+// It should be called after the root command exits.
+func StopMetricsServer() {
+	if metricsServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), metricsShutdownTimeout)
+	defer cancel()
+	if err := metricsServer.Shutdown(ctx); err != nil {
+		log.Warn("shutdown metrics server failed", zap.Error(err))
+	}
+	metricsServer = nil
+}
@@ -0,0 +1,330 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bufferflies/tinker/pkg/data"
+	"sigs.k8s.io/yaml"
+)
+
+// Output formats.
+const (
+	OutputTable = "table"
+	// OutputWide is the table with more details, e.g. the sizes of the backup versions.
+	OutputWide = "wide"
+	OutputJSON = "json"
+	OutputYAML = "yaml"
+)
+
+// validateOutput checks the output format is supported.
+func validateOutput(output string) error {
+	switch output {
+	case OutputTable, OutputWide, OutputJSON, OutputYAML:
+		return nil
+	}
+	return fmt.Errorf("unknown output format: %s, it should be one of %s|%s|%s|%s", output, OutputTable, OutputWide, OutputJSON, OutputYAML)
+}
+
+// isTable returns true if the output format is a table, the details of the wide table are decided by the command.
+func isTable(output string) bool {
+	return output == OutputTable || output == OutputWide
+}
+
+// render writes v to w in the output format, table writes the table format of both table and wide.
+func render(w io.Writer, output string, v interface{}, table func(w io.Writer)) error {
+	switch output {
+	case OutputJSON:
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	case OutputYAML:
+		b, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
+
+// versionsTable writes the versions of pods in aligned columns.
+// The version which has metadata is followed by the time it was taken, and the measured one is followed by its size,
+// e.g. 5.2(2021-12-01 08:00:00, 1.5GiB).
+func versionsTable(infos []data.BackupInfo) func(w io.Writer) {
+	return func(w io.Writer) {
+		fmt.Fprintln(w, "POD\tCOMPONENT\tVERSIONS")
+		for i := range infos {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", infos[i].Pod, infos[i].Component, strings.Join(versionsWithDetails(&infos[i]), ","))
+		}
+	}
+}
+
+// versionsWithDetails returns the versions followed by the time in their metadata and their sizes.
+func versionsWithDetails(info *data.BackupInfo) []string {
+	if len(info.Metadata) == 0 && len(info.Sizes) == 0 {
+		return info.Versions
+	}
+	timestamps := make(map[string]string, len(info.Metadata))
+	for _, meta := range info.Metadata {
+		timestamps[meta.Version] = meta.Timestamp.Format("2006-01-02 15:04:05")
+	}
+	versions := make([]string, 0, len(info.Versions))
+	for _, version := range info.Versions {
+		var details []string
+		if ts, ok := timestamps[version]; ok {
+			details = append(details, ts)
+		}
+		if size, ok := info.Sizes[version]; ok {
+			details = append(details, formatSize(size))
+		}
+		if len(details) > 0 {
+			version = fmt.Sprintf("%s(%s)", version, strings.Join(details, ", "))
+		}
+		versions = append(versions, version)
+	}
+	return versions
+}
+
+// catalogTable writes the versions of the components in aligned columns, the partial version lists the pods missing it.
+func catalogTable(entries []data.CatalogEntry) func(w io.Writer) {
+	return func(w io.Writer) {
+		fmt.Fprintln(w, "COMPONENT\tVERSION\tPODS\tSTATUS\tMISSING")
+		for _, entry := range entries {
+			status := "complete"
+			if !entry.Complete {
+				status = "partial"
+			}
+			total := len(entry.Pods) + len(entry.Missing)
+			fmt.Fprintf(w, "%s\t%s\t%d/%d\t%s\t%s\n", entry.Component, entry.Version, len(entry.Pods), total, status, strings.Join(entry.Missing, ","))
+		}
+	}
+}
+
+// checkTable writes the health of the components in aligned columns.
+func checkTable(results []data.StatusResult) func(w io.Writer) {
+	return func(w io.Writer) {
+		fmt.Fprintln(w, "COMPONENT\tSTATUS\tRUNNING\tERROR")
+		for _, rst := range results {
+			status := "down"
+			if rst.Up {
+				status = "up"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d/%d\t%s\n", rst.Component, status, rst.Running, rst.Pods, rst.Error)
+		}
+	}
+}
+
+// resultsTable writes the result of back or restore of every pod, the size is only known after backing up.
+func resultsTable(results []data.OperationResult) func(w io.Writer) {
+	return func(w io.Writer) {
+		fmt.Fprintln(w, "POD\tCOMPONENT\tDURATION\tSIZE\tERROR")
+		for _, rst := range results {
+			size := "-"
+			if rst.Bytes > 0 {
+				size = formatSize(rst.Bytes / 1024)
+			}
+			var errMsg string
+			if rst.Err != nil {
+				errMsg = rst.Err.Error()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", rst.Pod, rst.Component, rst.Duration.Round(time.Millisecond), size, errMsg)
+		}
+	}
+}
+
+// preflightResult is the permissions and the tools which back and restore need.
+type preflightResult struct {
+	Permissions []data.PermissionCheck `json:"permissions"`
+	Tools       []data.ToolCheck       `json:"tools"`
+}
+
+// preflightTable writes the permission checks and the tool checks as two tables.
+func preflightTable(rst preflightResult) func(w io.Writer) {
+	return func(w io.Writer) {
+		permissionsTable(rst.Permissions)(w)
+		fmt.Fprintln(w)
+		toolsTable(rst.Tools)(w)
+	}
+}
+
+// toolsTable writes the missing tools of every pod in aligned columns.
+func toolsTable(checks []data.ToolCheck) func(w io.Writer) {
+	return func(w io.Writer) {
+		fmt.Fprintln(w, "POD\tCOMPONENT\tMISSING\tERROR")
+		for _, check := range checks {
+			missing := strings.Join(check.Missing, ",")
+			if len(check.Missing) == 0 && len(check.Error) == 0 {
+				missing = "none"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", check.Pod, check.Component, missing, check.Error)
+		}
+	}
+}
+
+// permissionsTable writes the permission checks in aligned columns.
+func permissionsTable(checks []data.PermissionCheck) func(w io.Writer) {
+	return func(w io.Writer) {
+		fmt.Fprintln(w, "NAMESPACE\tPERMISSION\tALLOWED\tREASON")
+		for _, check := range checks {
+			namespace := check.Namespace
+			if len(namespace) == 0 {
+				namespace = "*"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%t\t%s\n", namespace, check.Permission, check.Allowed, check.Reason)
+		}
+	}
+}
+
+// pruneTable writes the backups to be pruned and their sizes in aligned columns.
+func pruneTable(targets []data.PruneTarget) func(w io.Writer) {
+	return func(w io.Writer) {
+		fmt.Fprintln(w, "POD\tCOMPONENT\tVERSIONS\tSIZE")
+		for i := range targets {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", targets[i].Pod, targets[i].Component, strings.Join(targets[i].Versions, ","), formatSize(targets[i].Size))
+		}
+	}
+}
+
+// formatSize formats the size in KB with the largest binary unit, e.g. 1536 => 1.5MiB.
+func formatSize(kb int64) string {
+	if kb < 1024 {
+		return fmt.Sprintf("%dKiB", kb)
+	}
+	size := float64(kb)
+	for _, unit := range []string{"MiB", "GiB"} {
+		size /= 1024
+		if size < 1024 {
+			return fmt.Sprintf("%.1f%s", size, unit)
+		}
+	}
+	return fmt.Sprintf("%.1fTiB", size/1024)
+}
+
+// verifyTable writes the verify results in aligned columns.
+func verifyTable(results []data.VerifyResult) func(w io.Writer) {
+	return func(w io.Writer) {
+		fmt.Fprintln(w, "POD\tCOMPONENT\tRESULT\tDETAIL")
+		for i := range results {
+			rst := &results[i]
+			switch {
+			case len(rst.Error) > 0:
+				fmt.Fprintf(w, "%s\t%s\tFAIL\t%s\n", rst.Pod, rst.Component, rst.Error)
+			case rst.Match():
+				fmt.Fprintf(w, "%s\t%s\tPASS\t%s\n", rst.Pod, rst.Component, rst.Live)
+			default:
+				fmt.Fprintf(w, "%s\t%s\tFAIL\tlive %s != backup %s\n", rst.Pod, rst.Component, rst.Live, rst.Backup)
+			}
+		}
+	}
+}
+
+// diffTable writes every file which restore would change in aligned columns.
+func diffTable(results []data.DiffResult) func(w io.Writer) {
+	return func(w io.Writer) {
+		fmt.Fprintln(w, "POD\tCOMPONENT\tCHANGE\tFILE")
+		for i := range results {
+			rst := &results[i]
+			if len(rst.Error) > 0 {
+				fmt.Fprintf(w, "%s\t%s\tERROR\t%s\n", rst.Pod, rst.Component, rst.Error)
+				continue
+			}
+			for _, change := range []struct {
+				name  string
+				files []string
+			}{{"changed", rst.Changed}, {"added", rst.Added}, {"removed", rst.Removed}} {
+				for _, f := range change.files {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", rst.Pod, rst.Component, change.name, f)
+				}
+			}
+		}
+	}
+}
+
+// diffSummary is the count of files which restore would change in one pod.
+type diffSummary struct {
+	Pod       string `json:"pod"`
+	Component string `json:"component"`
+	Changed   int    `json:"changed"`
+	Added     int    `json:"added"`
+	Removed   int    `json:"removed"`
+	Error     string `json:"error,omitempty"`
+}
+
+func diffSummaries(results []data.DiffResult) []diffSummary {
+	summaries := make([]diffSummary, 0, len(results))
+	for i := range results {
+		rst := &results[i]
+		summaries = append(summaries, diffSummary{
+			Pod:       rst.Pod,
+			Component: rst.Component,
+			Changed:   len(rst.Changed),
+			Added:     len(rst.Added),
+			Removed:   len(rst.Removed),
+			Error:     rst.Error,
+		})
+	}
+	return summaries
+}
+
+// diffSummaryTable writes the count of files which restore would change in aligned columns.
+func diffSummaryTable(summaries []diffSummary) func(w io.Writer) {
+	return func(w io.Writer) {
+		fmt.Fprintln(w, "POD\tCOMPONENT\tCHANGED\tADDED\tREMOVED\tERROR")
+		for i := range summaries {
+			s := &summaries[i]
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\n", s.Pod, s.Component, s.Changed, s.Added, s.Removed, s.Error)
+		}
+	}
+}
+
+// writeExecResults writes the output of every pod under its header, the output isn't aligned as a table.
+func writeExecResults(w io.Writer, results []data.ExecResult) {
+	for i := range results {
+		rst := &results[i]
+		fmt.Fprintf(w, "==> %s <==\n", rst.Pod)
+		if len(rst.Error) > 0 {
+			fmt.Fprintf(w, "error: %s\n", rst.Error)
+			continue
+		}
+		output := strings.ReplaceAll(rst.Output, "\r\n", "\n")
+		if len(output) > 0 && !strings.HasSuffix(output, "\n") {
+			output += "\n"
+		}
+		fmt.Fprint(w, output)
+	}
+}
+
+// statusTable writes the status of pods in aligned columns.
+func statusTable(status []data.PodStatus) func(w io.Writer) {
+	return func(w io.Writer) {
+		fmt.Fprintln(w, "POD\tCOMPONENT\tPHASE\tDEBUG\tPROCESS\tVERSIONS\tERROR")
+		for i := range status {
+			st := &status[i]
+			fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\t%s\t%s\n", st.Pod, st.Component, st.Phase, st.Debug, st.Process, strings.Join(st.Versions, ","), st.Error)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bufferflies/tinker/pkg/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderVersions(t *testing.T) {
+	versions := []data.BackupInfo{
+		{Component: "tikv", Pod: "tikv-0", Versions: []string{"5.1", "5.2"}},
+		{Component: "pd", Pod: "pd-0", Versions: []string{}},
+	}
+	testCases := []struct {
+		output string
+		expect string
+	}{
+		{
+			output: OutputJSON,
+			expect: "[\n  {\n    \"component\": \"tikv\",\n    \"pod\": \"tikv-0\",\n    \"versions\": [\n      \"5.1\",\n      \"5.2\"\n    ]\n  },\n  {\n    \"component\": \"pd\",\n    \"pod\": \"pd-0\",\n    \"versions\": []\n  }\n]\n",
+		},
+		{
+			output: OutputYAML,
+			expect: "- component: tikv\n  pod: tikv-0\n  versions:\n  - \"5.1\"\n  - \"5.2\"\n- component: pd\n  pod: pd-0\n  versions: []\n",
+		},
+		{
+			output: OutputTable,
+			expect: "POD     COMPONENT  VERSIONS\ntikv-0  tikv       5.1,5.2\npd-0    pd         \n",
+		},
+	}
+	for _, ca := range testCases {
+		out := new(bytes.Buffer)
+		assert.NoError(t, render(out, ca.output, versions, versionsTable(versions)))
+		assert.Equal(t, ca.expect, out.String())
+	}
+	assert.Error(t, validateOutput("xml"))
+}
+
+func TestRenderVersionsWithMetadata(t *testing.T) {
+	versions := []data.BackupInfo{{
+		Component: "tikv",
+		Pod:       "tikv-0",
+		Versions:  []string{"5.1", "5.2"},
+		Metadata:  []data.BackupMetadata{{Version: "5.2", Component: "tikv", Timestamp: time.Date(2021, 12, 1, 8, 0, 0, 0, time.UTC)}},
+	}}
+	out := new(bytes.Buffer)
+	assert.NoError(t, render(out, OutputTable, versions, versionsTable(versions)))
+	assert.Equal(t, "POD     COMPONENT  VERSIONS\ntikv-0  tikv       5.1,5.2(2021-12-01 08:00:00)\n", out.String())
+}
+
+func TestRenderVersionsWide(t *testing.T) {
+	versions := []data.BackupInfo{
+		{
+			Component: "tikv",
+			Pod:       "tikv-0",
+			Versions:  []string{"5.1", "5.2"},
+			Metadata:  []data.BackupMetadata{{Version: "5.2", Component: "tikv", Timestamp: time.Date(2021, 12, 1, 8, 0, 0, 0, time.UTC)}},
+			Sizes:     map[string]int64{"5.1": 512, "5.2": 3 * 1024 * 1024 / 2},
+		},
+		{Component: "pd", Pod: "pd-0", Versions: []string{"5.1"}, Sizes: map[string]int64{}},
+	}
+	out := new(bytes.Buffer)
+	assert.NoError(t, validateOutput(OutputWide))
+	assert.NoError(t, render(out, OutputWide, versions, versionsTable(versions)))
+	assert.Equal(t, "POD     COMPONENT  VERSIONS\ntikv-0  tikv       5.1(512KiB),5.2(2021-12-01 08:00:00, 1.5GiB)\npd-0    pd         5.1\n", out.String())
+}
+
+func TestRenderPruneTargets(t *testing.T) {
+	targets := []data.PruneTarget{
+		{Pod: "tikv-0", Component: "tikv", Versions: []string{"5.1", "4.0"}, Size: 3 * 1024 * 1024 / 2},
+		{Pod: "pd-0", Component: "pd", Versions: []string{"5.1"}, Size: 512},
+	}
+	out := new(bytes.Buffer)
+	assert.NoError(t, render(out, OutputTable, targets, pruneTable(targets)))
+	assert.Equal(t, "POD     COMPONENT  VERSIONS  SIZE\ntikv-0  tikv       5.1,4.0   1.5GiB\npd-0    pd         5.1       512KiB\n", out.String())
+}
+
+func TestRenderResults(t *testing.T) {
+	results := []data.OperationResult{
+		{Pod: "tikv-0", Component: "tikv", Duration: 1500 * time.Millisecond, Bytes: 3 * 1024 * 1024},
+		{Pod: "pd-0", Component: "pd", Duration: time.Second, Err: errors.New("exec failed")},
+	}
+	out := new(bytes.Buffer)
+	assert.NoError(t, render(out, OutputTable, results, resultsTable(results)))
+	assert.Equal(t, "POD     COMPONENT  DURATION  SIZE    ERROR\ntikv-0  tikv       1.5s      3.0MiB  \npd-0    pd         1s        -       exec failed\n", out.String())
+	out.Reset()
+	assert.NoError(t, render(out, OutputJSON, results[1:], resultsTable(results[1:])))
+	assert.Equal(t, "[\n  {\n    \"pod\": \"pd-0\",\n    \"component\": \"pd\",\n    \"duration\": 1000000000,\n    \"bytes\": 0,\n    \"error\": \"exec failed\"\n  }\n]\n", out.String())
+}
+
+func TestRenderDiff(t *testing.T) {
+	results := []data.DiffResult{
+		{Pod: "tikv-0", Component: "tikv", Changed: []string{"/var/lib/tikv/db/1.sst"}, Added: []string{"/var/lib/tikv/db/2.sst"}, Removed: []string{"/var/lib/tikv/db/3.sst"}},
+		{Pod: "pd-0", Component: "pd", Error: "exec failed"},
+	}
+	out := new(bytes.Buffer)
+	assert.NoError(t, render(out, OutputTable, results, diffTable(results)))
+	assert.Equal(t, "POD     COMPONENT  CHANGE   FILE\n"+
+		"tikv-0  tikv       changed  /var/lib/tikv/db/1.sst\n"+
+		"tikv-0  tikv       added    /var/lib/tikv/db/2.sst\n"+
+		"tikv-0  tikv       removed  /var/lib/tikv/db/3.sst\n"+
+		"pd-0    pd         ERROR    exec failed\n", out.String())
+
+	summaries := diffSummaries(results)
+	out.Reset()
+	assert.NoError(t, render(out, OutputTable, summaries, diffSummaryTable(summaries)))
+	assert.Equal(t, "POD     COMPONENT  CHANGED  ADDED  REMOVED  ERROR\n"+
+		"tikv-0  tikv       1        1      1        \n"+
+		"pd-0    pd         0        0      0        exec failed\n", out.String())
+}
+
+func TestRenderCatalog(t *testing.T) {
+	entries := []data.CatalogEntry{
+		{Component: "tikv", Version: "5.2", Pods: []string{"tikv-0", "tikv-1"}, Complete: true},
+		{Component: "tikv", Version: "5.1", Pods: []string{"tikv-0"}, Missing: []string{"tikv-1"}},
+	}
+	out := new(bytes.Buffer)
+	assert.NoError(t, render(out, OutputTable, entries, catalogTable(entries)))
+	assert.Equal(t, "COMPONENT  VERSION  PODS  STATUS    MISSING\n"+
+		"tikv       5.2      2/2   complete  \n"+
+		"tikv       5.1      1/2   partial   tikv-1\n", out.String())
+}
+
+func TestRenderCheck(t *testing.T) {
+	results := []data.StatusResult{
+		{Component: "pd", Up: true, Pods: 3, Running: 3},
+		{Component: "tikv", Up: false, Pods: 3, Running: 2, Error: "1 pods failed: tikv-2: process is not running"},
+	}
+	out := new(bytes.Buffer)
+	assert.NoError(t, render(out, OutputTable, results, checkTable(results)))
+	assert.Equal(t, "COMPONENT  STATUS  RUNNING  ERROR\n"+
+		"pd         up      3/3      \n"+
+		"tikv       down    2/3      1 pods failed: tikv-2: process is not running\n", out.String())
+}
+
+func TestRenderPermissions(t *testing.T) {
+	checks := []data.PermissionCheck{
+		{Namespace: "tidb", Permission: "list pods", Allowed: true},
+		{Namespace: "", Permission: "create pods/exec", Allowed: false, Reason: "RBAC: access denied"},
+	}
+	out := new(bytes.Buffer)
+	assert.NoError(t, render(out, OutputTable, checks, permissionsTable(checks)))
+	assert.Equal(t, "NAMESPACE  PERMISSION        ALLOWED  REASON\n"+
+		"tidb       list pods         true     \n"+
+		"*          create pods/exec  false    RBAC: access denied\n", out.String())
+}
+
+func TestRenderPreflight(t *testing.T) {
+	rst := preflightResult{
+		Permissions: []data.PermissionCheck{{Namespace: "tidb", Permission: "list pods", Allowed: true}},
+		Tools: []data.ToolCheck{
+			{Pod: "pd-0", Component: "pd"},
+			{Pod: "tikv-0", Component: "tikv", Missing: []string{"grep", "ps"}},
+			{Pod: "tikv-1", Component: "tikv", Error: "exec failed"},
+		},
+	}
+	out := new(bytes.Buffer)
+	assert.NoError(t, render(out, OutputTable, rst, preflightTable(rst)))
+	// the empty line ends the columns of the first table, so the tables are aligned separately.
+	assert.Equal(t, "NAMESPACE  PERMISSION  ALLOWED  REASON\n"+
+		"tidb       list pods   true     \n"+
+		"\n"+
+		"POD     COMPONENT  MISSING  ERROR\n"+
+		"pd-0    pd         none     \n"+
+		"tikv-0  tikv       grep,ps  \n"+
+		"tikv-1  tikv                exec failed\n", out.String())
+}
+
+func TestWriteExecResults(t *testing.T) {
+	results := []data.ExecResult{
+		{Pod: "tikv-0", Component: "tikv", Output: "5.1.bat\r\n5.2.bat\r\n"},
+		{Pod: "tikv-1", Component: "tikv", Output: "no newline"},
+		{Pod: "tikv-2", Component: "tikv", Error: "exec in pod tikv-2 failed: command exited with code 2"},
+	}
+	out := new(bytes.Buffer)
+	writeExecResults(out, results)
+	assert.Equal(t, "==> tikv-0 <==\n5.1.bat\n5.2.bat\n"+
+		"==> tikv-1 <==\nno newline\n"+
+		"==> tikv-2 <==\nerror: exec in pod tikv-2 failed: command exited with code 2\n", out.String())
+}
+
+func TestFormatSize(t *testing.T) {
+	testCases := []struct {
+		kb     int64
+		expect string
+	}{
+		{0, "0KiB"},
+		{1023, "1023KiB"},
+		{1536, "1.5MiB"},
+		{10 * 1024 * 1024, "10.0GiB"},
+		{2 * 1024 * 1024 * 1024, "2.0TiB"},
+	}
+	for _, ca := range testCases {
+		assert.Equal(t, ca.expect, formatSize(ca.kb))
+	}
+}
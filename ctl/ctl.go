@@ -14,25 +14,109 @@
 package ctl
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/bufferflies/tinker/ctl/command"
+	"github.com/bufferflies/tinker/pkg/data"
 
 	"github.com/spf13/cobra"
 )
 
+// The build information is injected by -ldflags, e.g.
+// -X github.com/bufferflies/tinker/ctl.Version=v0.1.0 -X github.com/bufferflies/tinker/ctl.GitCommit=abc1234
+var (
+	Version   = "None"
+	GitCommit = "None"
+	BuildTime = "None"
+)
+
+// versionInfo returns the build information of the tool.
+func versionInfo() string {
+	return fmt.Sprintf("Version: %s\nGit Commit: %s\nBuild Time: %s\n", Version, GitCommit, BuildTime)
+}
+
+func newVersionCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "print the version, git commit and build time",
+		Run: func(cmd *cobra.Command, _ []string) {
+			cmd.Print(versionInfo())
+		},
+	}
+}
+
 func init() {
 	cobra.EnablePrefixMatching = true
 }
 
+// RootName is the name of the root command, it is the binary name too.
+const RootName = "tinker"
+
+// legacyRootName is the old name of the root command, it is kept hidden for compatibility.
+const legacyRootName = "regression"
+
+// trimRootName removes the root command name from the head of the arguments, e.g. the piped arguments
+// `tinker cloud back` or `regression cloud back`, so both names invoke the same root.
+// It only matches the whole names, they are removed before the prefix matching of the sub commands.
+func trimRootName(args []string) []string {
+	if len(args) > 0 && (args[0] == RootName || args[0] == legacyRootName) {
+		return args[1:]
+	}
+	return args
+}
+
+// The exit codes of the failure categories, so the pipelines can branch on the kind of the failure.
+const (
+	ExitGeneric   = 1
+	ExitPreflight = 2
+	ExitPartial   = 3
+	ExitTimeout   = 4
+)
+
+// exitCode returns the exit code of the error by its category, 0 means no error.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	switch data.Category(err) {
+	case data.CategoryPreflight:
+		return ExitPreflight
+	case data.CategoryPartial:
+		return ExitPartial
+	case data.CategoryTimeout:
+		return ExitTimeout
+	default:
+		return ExitGeneric
+	}
+}
+
 // GetRootCmd is exposed for integration tests. But it can be embedded into another suite, too.
 func GetRootCmd() *cobra.Command {
 	rootCmd := &cobra.Command{
-		Use:   "regression",
+		Use:   RootName,
 		Short: "tools for regression test",
+		// it enables the --version flag.
+		Version: Version,
+	}
+	rootCmd.SetVersionTemplate(versionInfo())
+	// the backups record the version of the tool which took them.
+	data.ToolVersion = Version
+	logConfig := &command.LogConfig{}
+	logConfig.AddFlags(rootCmd)
+	deadline := &command.Deadline{}
+	deadline.AddFlags(rootCmd)
+	// it configures the logger and starts the deadline before any command runs.
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, _ []string) error {
+		if _, err := logConfig.Init(); err != nil {
+			return err
+		}
+		return deadline.Start(cmd.Context())
 	}
 	rootCmd.AddCommand(command.NewCloudCommand())
+	rootCmd.AddCommand(newVersionCmd())
 	rootCmd.SilenceErrors = true
+	rootCmd.SilenceUsage = true
 	return rootCmd
 }
 
@@ -40,12 +124,16 @@ func GetRootCmd() *cobra.Command {
 func MainStart(args []string) {
 	rootCmd := GetRootCmd()
 
+	args = trimRootName(args)
 	rootCmd.SetArgs(args)
 	rootCmd.ParseFlags(args)
 	rootCmd.SetOutput(os.Stdout)
 
-	if err := rootCmd.Execute(); err != nil {
+	err := rootCmd.Execute()
+	command.StopMetricsServer()
+	command.StopDeadline()
+	if err != nil {
 		rootCmd.Println(err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
@@ -0,0 +1,90 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ctl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/bufferflies/tinker/pkg/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersion(t *testing.T) {
+	Version, GitCommit, BuildTime = "v0.1.0", "abc1234", "2021-12-01 08:00:00"
+	defer func() {
+		Version, GitCommit, BuildTime = "None", "None", "None"
+	}()
+	expect := "Version: v0.1.0\nGit Commit: abc1234\nBuild Time: 2021-12-01 08:00:00\n"
+	for _, args := range [][]string{{"version"}, {"--version"}} {
+		rootCmd := GetRootCmd()
+		out := new(bytes.Buffer)
+		rootCmd.SetOut(out)
+		rootCmd.SetArgs(args)
+		assert.NoError(t, rootCmd.Execute(), args)
+		assert.Equal(t, expect, out.String(), args)
+	}
+}
+
+func TestRootName(t *testing.T) {
+	testCases := []struct {
+		args   []string
+		expect []string
+	}{
+		{[]string{"tinker", "version"}, []string{"version"}},
+		{[]string{"regression", "version"}, []string{"version"}},
+		{[]string{"version"}, []string{"version"}},
+		// only the whole names are removed, the prefix is left to the sub commands.
+		{[]string{"reg", "version"}, []string{"reg", "version"}},
+		{[]string{"tc", "tinker"}, []string{"tc", "tinker"}},
+		{nil, nil},
+	}
+	for _, ca := range testCases {
+		assert.Equal(t, ca.expect, trimRootName(ca.args), ca.args)
+	}
+
+	// both names invoke the same root.
+	var outputs []string
+	for _, name := range []string{RootName, legacyRootName} {
+		rootCmd := GetRootCmd()
+		out := new(bytes.Buffer)
+		rootCmd.SetOut(out)
+		rootCmd.SetArgs(trimRootName([]string{name, "tc", "--help"}))
+		assert.NoError(t, rootCmd.Execute(), name)
+		outputs = append(outputs, out.String())
+	}
+	assert.Equal(t, outputs[0], outputs[1])
+	assert.Contains(t, outputs[0], "tinker tc [command]")
+	assert.NotContains(t, outputs[0], "regression")
+}
+
+func TestExitCode(t *testing.T) {
+	testCases := []struct {
+		err  error
+		code int
+	}{
+		{nil, 0},
+		{errors.New("restore is cancelled"), ExitGeneric},
+		{data.WithCategory(data.CategoryPreflight, errors.New("preflight failed, missing permissions: create pods/exec")), ExitPreflight},
+		{fmt.Errorf("stop cloud operator failed: %w", data.WithCategory(data.CategoryPartial, errors.New("1 pods failed: tikv-0: exec failed"))), ExitPartial},
+		{data.WithCategory(data.CategoryTimeout, errors.New("1 pods failed: tikv-0: exec in pod tikv-0 is cancelled")), ExitTimeout},
+		{fmt.Errorf("list pods failed: %w", context.DeadlineExceeded), ExitTimeout},
+	}
+	for _, ca := range testCases {
+		assert.Equal(t, ca.code, exitCode(ca.err), "%v", ca.err)
+	}
+}
@@ -4,10 +4,12 @@ go 1.16
 
 require (
 	github.com/pingcap/log v0.0.0-20210906054005-afc726e70354
+	github.com/prometheus/client_golang v1.10.0
 	github.com/spf13/cobra v1.2.1
 	github.com/stretchr/testify v1.7.0
 	go.uber.org/zap v1.19.0
 	k8s.io/api v0.22.4
 	k8s.io/apimachinery v0.22.4
 	k8s.io/client-go v0.22.4
+	sigs.k8s.io/yaml v1.2.0
 )
@@ -17,34 +17,18 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"os/signal"
 	"strings"
-	"syscall"
 
 	"github.com/bufferflies/tinker/ctl"
 )
 
 func main() {
-	sc := make(chan os.Signal, 1)
-	signal.Notify(sc,
-		syscall.SIGHUP,
-		syscall.SIGINT,
-		syscall.SIGTERM,
-		syscall.SIGQUIT)
-
-	go func() {
-		sig := <-sc
-		fmt.Printf("\nGot signal [%v] to exit.\n", sig)
-		switch sig {
-		case syscall.SIGTERM:
-			os.Exit(0)
-		default:
-			os.Exit(1)
-		}
-	}()
+	// SIGINT and SIGTERM are not handled here, the cloud commands cancel the operation on them to start the
+	// stopped components before exiting, an exit here would leave the cluster stopped.
 	var input []string
 	stat, _ := os.Stdin.Stat()
-	if (stat.Mode() & os.ModeCharDevice) == 0 {
+	// the piped stdin is the arguments, except the command after -- which reads the stdin itself, e.g. tc exec.
+	if (stat.Mode()&os.ModeCharDevice) == 0 && !hasCommand(os.Args[1:]) {
 		b, err := io.ReadAll(os.Stdin)
 		if err != nil {
 			fmt.Println(err)
@@ -54,3 +38,13 @@ func main() {
 	}
 	ctl.MainStart(append(os.Args[1:], input...))
 }
+
+// hasCommand returns true if the arguments have the command to exec after --.
+func hasCommand(args []string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			return true
+		}
+	}
+	return false
+}
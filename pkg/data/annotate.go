@@ -0,0 +1,43 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// annotationPatch returns the merge patch which only sets the annotation, nil value removes it.
+func annotationPatch(key string, value *string) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]*string{key: value},
+		},
+	})
+}
+
+// patchAnnotation sets the annotation of the pod by a merge patch, nil value removes it.
+// It doesn't overwrite the other fields modified concurrently, e.g. by the operator,
+// the patch has no resource version, so it never conflicts.
+func (c *CloudOperator) patchAnnotation(pod *corev1.Pod, key string, value *string) error {
+	patch, err := annotationPatch(key, value)
+	if err != nil {
+		return err
+	}
+	_, err = c.client.CoreV1().Pods(pod.Namespace).Patch(c.ctx, pod.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
@@ -0,0 +1,63 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestAnnotationPatch(t *testing.T) {
+	value := "debug"
+	patch, err := annotationPatch("runmode", &value)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"metadata":{"annotations":{"runmode":"debug"}}}`, string(patch))
+	patch, err = annotationPatch("runmode", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"metadata":{"annotations":{"runmode":null}}}`, string(patch))
+}
+
+func TestPatchAnnotation(t *testing.T) {
+	pod := newTestPod("tikv-0", TiKV, corev1.PodPending)
+	pod.Annotations = map[string]string{"app": "tikv"}
+	client := fake.NewSimpleClientset(pod)
+	co := newTestCloudOperator(context.Background(), client, nil)
+	get := func() map[string]string {
+		pod, err := client.CoreV1().Pods(metav1.NamespaceDefault).Get(context.Background(), "tikv-0", metav1.GetOptions{})
+		assert.NoError(t, err)
+		return pod.Annotations
+	}
+
+	assert.NoError(t, co.Stop())
+	assert.Equal(t, map[string]string{"app": "tikv", DebugLabel: DebugValue}, get())
+
+	// the annotation changed by others after listing is kept.
+	client.PrependReactor("list", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, &corev1.PodList{Items: []corev1.Pod{*pod}}, nil
+	})
+	stale := pod.DeepCopy()
+	stale.Annotations = map[string]string{"app": "tikv", "operator": "synced", DebugLabel: DebugValue}
+	_, err := client.CoreV1().Pods(metav1.NamespaceDefault).Update(context.Background(), stale, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	pod.Annotations[DebugLabel] = DebugValue
+	assert.NoError(t, co.Start())
+	assert.Equal(t, map[string]string{"app": "tikv", "operator": "synced"}, get())
+}
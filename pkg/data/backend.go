@@ -0,0 +1,121 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Backend stores the backups of the pods, the pods are stopped before backing up or restoring them.
+type Backend interface {
+	// Validate checks the options of the operator work with the backend, it is called before stopping any pod.
+	Validate(c *CloudOperator) error
+	// Prepare checks the pods of the component can be backed up, it is called before backing up any pod.
+	Prepare(c *CloudOperator, cp component, pods []corev1.Pod) error
+	// Back backs up the pods of the targets as the version, it returns the result of every pod.
+	Back(c *CloudOperator, version string, targets []componentPods) ([]OperationResult, error)
+	// HasBackup returns true if the pod has the backup of the version.
+	HasBackup(c *CloudOperator, pod *corev1.Pod, cp component, version string) (bool, error)
+	// Restore restores the pods of the targets from the backups of the versions, it returns the result of every pod.
+	Restore(c *CloudOperator, versions ComponentVersions, targets []componentPods) ([]OperationResult, error)
+	// Permissions returns the permissions which the backend needs besides the pod permissions.
+	Permissions() []Permission
+}
+
+// CopyBackend copies the data directory into the backup directory in the pods, it is the default backend.
+type CopyBackend struct{}
+
+// Validate implements Backend interface, all the options work with the copies except the timestamped transfers,
+// the backup root is checked.
+func (CopyBackend) Validate(c *CloudOperator) error {
+	if err := c.validateTimestamped(); err != nil {
+		return err
+	}
+	return c.validateBackupRoot()
+}
+
+// Prepare implements Backend interface, it checks the free space for the copies.
+func (CopyBackend) Prepare(c *CloudOperator, cp component, pods []corev1.Pod) error {
+	return c.checkDiskSpace(cp, pods)
+}
+
+// Back implements Backend interface, the size of the backup is measured after backing up.
+func (CopyBackend) Back(c *CloudOperator, version string, targets []componentPods) ([]OperationResult, error) {
+	return c.execPods("backup", ComponentVersions{all: version}, targets, func(pod *corev1.Pod, cp component) (string, error) {
+		return c.backCmd(pod, cp, version)
+	}, c.backupProgress(version), func(cp component) string {
+		root := c.backupRoot(cp)
+		if c.Compress {
+			return fmt.Sprintf("du -sk %s", backupArchive(root, version))
+		}
+		return cp.BackupSizeExecCmd(root, version)
+	}, nil)
+}
+
+// HasBackup implements Backend interface.
+func (CopyBackend) HasBackup(c *CloudOperator, pod *corev1.Pod, cp component, version string) (bool, error) {
+	versions, err := c.listVersions(pod, cp)
+	if err != nil {
+		return false, err
+	}
+	return AnyOf(versions, func(i int) bool {
+		return versions[i] == version
+	}), nil
+}
+
+// Restore implements Backend interface.
+func (CopyBackend) Restore(c *CloudOperator, versions ComponentVersions, targets []componentPods) ([]OperationResult, error) {
+	return c.execPods("restore", versions, targets, func(pod *corev1.Pod, cp component) (string, error) {
+		version, _ := versions.Of(cp)
+		dir, root := cp.BataDir(c.DataDirs), c.backupRoot(cp)
+		extraDirs := c.extraDataDirs(cp)
+		if len(extraDirs) > 0 && (c.Compress || c.Download != nil) {
+			return "", fmt.Errorf("%s has multiple data dirs, it can't be restored from a compressed backup", cp)
+		}
+		if c.Download != nil {
+			// the downloaded backup is a compressed backup.
+			download := cp.DownloadExecCmd(root, version, c.Download, c.Download.Key(pod, version))
+			if root != dir {
+				// the root may not exist if nothing is backed up in the pod.
+				download = fmt.Sprintf("mkdir -p %s && %s", root, download)
+			}
+			// the restore script is grouped, otherwise the stale archive is restored after the download failed.
+			return fmt.Sprintf("%s && { %s; }", download, cp.compressedRestoreExecCmd(dir, root, version, c.basePattern())), nil
+		}
+		if c.Compress {
+			return cp.compressedRestoreExecCmd(dir, root, version, c.basePattern()), nil
+		}
+		return cp.restoreExecCmd(dir, root, version, c.basePattern(), extraDirs...), nil
+	}, nil, nil, func(cp component) (string, func(string) error) {
+		// cp exits 0 even if the backup is empty, so the restored files are counted.
+		version, _ := versions.Of(cp)
+		dir, root := cp.BataDir(c.DataDirs), c.backupRoot(cp)
+		return cp.restoreCheckExecCmd(dir, root, version, c.basePattern(), c.extraDataDirs(cp)...), checkRestored
+	})
+}
+
+// Permissions implements Backend interface, it only execs in the pods.
+func (CopyBackend) Permissions() []Permission {
+	return nil
+}
+
+// backend returns the backend of the operator, it is CopyBackend if Backend is nil.
+func (c *CloudOperator) backend() Backend {
+	if c.Backend == nil {
+		return CopyBackend{}
+	}
+	return c.Backend
+}
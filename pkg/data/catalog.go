@@ -0,0 +1,113 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"sort"
+)
+
+// CatalogEntry is one backup version of a component across all the pods of the component.
+type CatalogEntry struct {
+	Component string `json:"component"`
+	Version   string `json:"version"`
+	// Pods are the pods which have the version.
+	Pods []string `json:"pods"`
+	// Missing are the pods which don't have the version, the version can't be fully restored if any.
+	Missing []string `json:"missing,omitempty"`
+	// Complete is true if all the pods of the component have the version, so it is safe to restore.
+	Complete bool `json:"complete"`
+}
+
+// Catalog returns the backup versions of every component across the whole cluster.
+// The result is ordered by the components, and then by the versions from newest to oldest,
+// the versions which are not numeric are the last.
+func (c *CloudOperator) Catalog(filter ListFilter) ([]CatalogEntry, error) {
+	return catalog(c.List, filter)
+}
+
+// catalog builds the catalog from the versions listed by list.
+// All the pods are listed regardless of the version pattern, so the pods without the version are known.
+func catalog(list func(filter ListFilter) ([]BackupInfo, error), filter ListFilter) ([]CatalogEntry, error) {
+	infos, err := list(ListFilter{Components: filter.Components})
+	if err != nil {
+		return nil, err
+	}
+	rst := make([]CatalogEntry, 0)
+	for _, entry := range buildCatalog(infos) {
+		if len(filter.Version) == 0 || filter.matchVersion(entry.Version) {
+			rst = append(rst, entry)
+		}
+	}
+	return rst, nil
+}
+
+// buildCatalog groups the versions of the pods by component and version.
+// The components keep the order of infos.
+func buildCatalog(infos []BackupInfo) []CatalogEntry {
+	var components []string
+	// K: component V: the pods of the component
+	pods := make(map[string][]string)
+	// K: component V: K: version V: the pods having the version
+	versions := make(map[string]map[string][]string)
+	for _, info := range infos {
+		if _, ok := pods[info.Component]; !ok {
+			components = append(components, info.Component)
+			versions[info.Component] = make(map[string][]string)
+		}
+		pods[info.Component] = append(pods[info.Component], info.Pod)
+		for _, version := range info.Versions {
+			versions[info.Component][version] = append(versions[info.Component][version], info.Pod)
+		}
+	}
+	var rst []CatalogEntry
+	for _, cp := range components {
+		names := make([]string, 0, len(versions[cp]))
+		for version := range versions[cp] {
+			names = append(names, version)
+		}
+		for _, version := range orderVersions(names) {
+			having := make(map[string]bool, len(versions[cp][version]))
+			for _, pod := range versions[cp][version] {
+				having[pod] = true
+			}
+			entry := CatalogEntry{Component: cp, Version: version}
+			for _, pod := range pods[cp] {
+				if having[pod] {
+					entry.Pods = append(entry.Pods, pod)
+				} else {
+					entry.Missing = append(entry.Missing, pod)
+				}
+			}
+			sort.Strings(entry.Pods)
+			sort.Strings(entry.Missing)
+			entry.Complete = len(entry.Missing) == 0
+			rst = append(rst, entry)
+		}
+	}
+	return rst
+}
+
+// orderVersions returns the numeric versions from newest to oldest followed by the other versions in lexical order.
+func orderVersions(versions []string) []string {
+	rst := sortVersions(versions)
+	var others []string
+	for _, version := range versions {
+		base, _, _ := splitTimestamp(version)
+		if _, ok := parseVersion(base); !ok {
+			others = append(others, version)
+		}
+	}
+	sort.Strings(others)
+	return append(rst, others...)
+}
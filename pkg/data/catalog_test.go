@@ -0,0 +1,78 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCatalog(t *testing.T) {
+	infos := []BackupInfo{
+		{Component: "tikv", Pod: "tikv-1", Versions: []string{"5.10", "5.2", "nightly"}},
+		{Component: "tikv", Pod: "tikv-0", Versions: []string{"5.2", "5.10"}},
+		{Component: "tikv", Pod: "tikv-2", Versions: []string{"5.2"}},
+		{Component: "pd", Pod: "pd-0", Versions: []string{"5.1"}},
+		{Component: "tidb", Pod: "tidb-0"},
+	}
+	var listed []ListFilter
+	list := func(filter ListFilter) ([]BackupInfo, error) {
+		listed = append(listed, filter)
+		var rst []BackupInfo
+		for _, info := range infos {
+			if len(filter.Components) == 0 || AnyOf(filter.Components, func(i int) bool { return filter.Components[i].String() == info.Component }) {
+				rst = append(rst, info)
+			}
+		}
+		return rst, nil
+	}
+	testCases := []struct {
+		filter ListFilter
+		expect []CatalogEntry
+	}{
+		{
+			expect: []CatalogEntry{
+				{Component: "tikv", Version: "5.10", Pods: []string{"tikv-0", "tikv-1"}, Missing: []string{"tikv-2"}},
+				{Component: "tikv", Version: "5.2", Pods: []string{"tikv-0", "tikv-1", "tikv-2"}, Complete: true},
+				{Component: "tikv", Version: "nightly", Pods: []string{"tikv-1"}, Missing: []string{"tikv-0", "tikv-2"}},
+				{Component: "pd", Version: "5.1", Pods: []string{"pd-0"}, Complete: true},
+			},
+		},
+		{
+			filter: ListFilter{Components: []component{TiKV}, Version: "5.1*"},
+			expect: []CatalogEntry{
+				{Component: "tikv", Version: "5.10", Pods: []string{"tikv-0", "tikv-1"}, Missing: []string{"tikv-2"}},
+			},
+		},
+		{
+			filter: ListFilter{Version: "6."},
+			expect: []CatalogEntry{},
+		},
+	}
+	for _, ca := range testCases {
+		listed = nil
+		entries, err := catalog(list, ca.filter)
+		assert.NoError(t, err)
+		assert.Equal(t, ca.expect, entries, ca.filter)
+		// the version pattern is not passed to list, otherwise the pods without the version are omitted.
+		assert.Equal(t, []ListFilter{{Components: ca.filter.Components}}, listed)
+	}
+
+	_, err := catalog(func(ListFilter) ([]BackupInfo, error) {
+		return nil, errors.New("list pods failed")
+	}, ListFilter{})
+	assert.EqualError(t, err, "list pods failed")
+}
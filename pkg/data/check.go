@@ -0,0 +1,189 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DefaultUpTimeouts is the default time to wait for the components up after starting them,
+// PD is up soon but TiKV may take long to open RocksDB.
+const DefaultUpTimeouts = "pd=2m,tikv=10m,tidb=3m"
+
+// ParseUpTimeouts parses the time to wait for the components up, e.g. tikv=20m,pd=1m.
+func ParseUpTimeouts(s string) (map[component]time.Duration, error) {
+	values, err := parseComponentValues(s)
+	if err != nil {
+		return nil, err
+	}
+	timeouts := make(map[component]time.Duration, len(values))
+	for cp, value := range values {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			return nil, fmt.Errorf("invalid up timeout of %s: %s", cp, value)
+		}
+		timeouts[cp] = timeout
+	}
+	return timeouts, nil
+}
+
+// StatusResult is the health of a component.
+type StatusResult struct {
+	Component string `json:"component"`
+	// Up is true if the component process is running in all the pods.
+	Up bool `json:"up"`
+	// Pods is the count of the pods of the component.
+	Pods int `json:"pods"`
+	// Running is the count of the pods whose component process is running.
+	Running int    `json:"running"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Check checks the component process is running in all the pods, the results are in the start order.
+// Every component is checked even if some failed, it stops checking once the context is done.
+func (c *CloudOperator) Check() []StatusResult {
+	components := c.startOrder()
+	results := make([]StatusResult, 0, len(components))
+	for _, cp := range components {
+		rst := c.checkComponent(cp)
+		if !rst.Up {
+			log.Info("check failed", zap.String("component", cp.String()), zap.String("error", rst.Error))
+		}
+		results = append(results, rst)
+	}
+	return results
+}
+
+// WaitUp checks the components kept by the filter until they are up or their own UpTimeouts elapse,
+// so the slow component doesn't fail the fast ones. The components are polled concurrently with
+// the jittered intervals, the results are in the start order.
+func (c *CloudOperator) WaitUp(filter PodFilter) []StatusResult {
+	components := filter.filterComponents(c.startOrder())
+	results := make([]StatusResult, len(components))
+	tasks := make([]func(), 0, len(components))
+	for i, cp := range components {
+		i, cp := i, cp
+		tasks = append(tasks, func() {
+			results[i] = c.waitComponentUp(cp)
+		})
+	}
+	parallel(0, tasks)
+	return results
+}
+
+// waitComponentUp checks the component until it is up or its up timeout elapses, it checks once without timeout.
+func (c *CloudOperator) waitComponentUp(cp component) StatusResult {
+	timeout := c.UpTimeouts[cp]
+	if timeout <= 0 {
+		return c.checkComponent(cp)
+	}
+	var rst StatusResult
+	err := backoffWait(c.ctx, func() (bool, error) {
+		rst = c.checkComponent(cp)
+		if !rst.Up {
+			log.Info("waiting for component up", zap.String("component", cp.String()), zap.String("error", rst.Error))
+		}
+		return rst.Up, nil
+	}, WaitInterval, timeout, func(d time.Duration) <-chan time.Time {
+		return c.after(withJitter(d))
+	})
+	switch {
+	case errors.Is(err, errWaitTimeout):
+		rst.Error = fmt.Sprintf("not up after %s: %s", timeout, rst.Error)
+	case err != nil && !rst.Up:
+		rst.Error = fmt.Sprintf("wait up is cancelled: %v", err)
+	}
+	return rst
+}
+
+// Healthy returns true if all the components are up.
+func (c *CloudOperator) Healthy() bool {
+	return AllUp(c.Check())
+}
+
+// AllUp returns true if all the components of the results are up.
+func AllUp(results []StatusResult) bool {
+	return AllOf(results, func(i int) bool {
+		return results[i].Up
+	})
+}
+
+// CheckError returns the error of the components which are not up, it returns nil if all of them are up.
+func CheckError(results []StatusResult) error {
+	var msgs []string
+	for _, rst := range results {
+		if !rst.Up {
+			msgs = append(msgs, fmt.Sprintf("%s: %s", rst.Component, rst.Error))
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("check failed: %s", strings.Join(msgs, "; "))
+}
+
+// checkComponent checks the component process is running in all its pods.
+func (c *CloudOperator) checkComponent(cp component) StatusResult {
+	rst := StatusResult{Component: cp.String()}
+	// the components are not stopped in dry run mode, so it can't check the status.
+	if c.DryRun {
+		rst.Up = true
+		return rst
+	}
+	pods, err := c.discoverPods(cp)
+	if err != nil {
+		rst.Error = err.Error()
+		return rst
+	}
+	rst.Pods = len(pods.Items)
+	errs := newPodErrors()
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if err := c.ctx.Err(); err != nil {
+			rst.Error = fmt.Sprintf("check is cancelled: %v", err)
+			return rst
+		}
+		running, err := c.podProcessRunning(pod, cp)
+		if err != nil {
+			errs.add(c.podKey(pod), err)
+			continue
+		}
+		if !running {
+			errs.add(c.podKey(pod), errors.New("process is not running"))
+			continue
+		}
+		rst.Running++
+	}
+	if err := errs.err(); err != nil {
+		rst.Error = err.Error()
+		return rst
+	}
+	rst.Up = true
+	return rst
+}
+
+// podProcessRunning returns true if the component process is running in the pod, the pod should be running.
+func (c *CloudOperator) podProcessRunning(pod *corev1.Pod, cp component) (bool, error) {
+	if pod.Status.Phase != corev1.PodRunning {
+		return false, fmt.Errorf("pod is %s", pod.Status.Phase)
+	}
+	return c.processRunning(pod, cp)
+}
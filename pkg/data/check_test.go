@@ -0,0 +1,150 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCheck(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		newTestPod("tidb-0", TiDB, corev1.PodRunning),
+		newTestPod("tikv-0", TiKV, corev1.PodRunning),
+		newTestPod("tikv-1", TiKV, corev1.PodRunning),
+		newTestPod("pd-0", PD, corev1.PodRunning),
+		newTestPod("pd-1", PD, corev1.PodPending),
+	)
+	executor := newFakeExecutor(func(podName string, _ []string) (string, error) {
+		switch podName {
+		case "tikv-1":
+			// PID 1 is the debug process without arguments.
+			return "UID\r\n1\r\n", nil
+		case "tidb-0":
+			return "", &ExitError{Code: 127}
+		}
+		return "UID\r\n12\r\n", nil
+	})
+	co := newTestCloudOperator(context.Background(), client, executor)
+	co.RetryCount = 1
+	results := co.Check()
+	// the components are checked in the start order.
+	assert.Equal(t, []StatusResult{
+		{Component: "pd", Pods: 2, Running: 1, Error: "1 pods failed: pd-1: pod is Pending"},
+		{Component: "tikv", Pods: 2, Running: 1, Error: "1 pods failed: tikv-1: process is not running"},
+		{Component: "tidb", Pods: 1, Error: "1 pods failed: tidb-0: exec in pod tidb-0 failed: command exited with code 127"},
+	}, results)
+	assert.False(t, AllUp(results))
+	assert.False(t, co.Healthy())
+	assert.EqualError(t, CheckError(results), "check failed: pd: 1 pods failed: pd-1: pod is Pending; "+
+		"tikv: 1 pods failed: tikv-1: process is not running; tidb: 1 pods failed: tidb-0: exec in pod tidb-0 failed: command exited with code 127")
+
+	// the healthy components are up, the component without pods is up too.
+	client = fake.NewSimpleClientset(
+		newTestPod("tikv-0", TiKV, corev1.PodRunning),
+		newTestPod("pd-0", PD, corev1.PodRunning),
+	)
+	co.client = client
+	results = co.Check()
+	assert.Equal(t, []StatusResult{
+		{Component: "pd", Up: true, Pods: 1, Running: 1},
+		{Component: "tikv", Up: true, Pods: 1, Running: 1},
+		{Component: "tidb", Up: true},
+	}, results)
+	assert.NoError(t, CheckError(results))
+	assert.True(t, co.Healthy())
+}
+
+func TestCheckCancel(t *testing.T) {
+	client := fake.NewSimpleClientset(newTestPod("tikv-0", TiKV, corev1.PodRunning))
+	executor := newFakeExecutor(func(string, []string) (string, error) {
+		return "", errors.New("unexpected exec")
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	co := newTestCloudOperator(ctx, client, executor)
+	co.StopOrder = []component{TiKV}
+	assert.Equal(t, []StatusResult{{Component: "tikv", Pods: 1, Error: "check is cancelled: context canceled"}}, co.Check())
+	assert.Empty(t, executor.calls)
+}
+
+func TestWaitUp(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		newTestPod("pd-0", PD, corev1.PodRunning),
+		newTestPod("tikv-0", TiKV, corev1.PodRunning),
+		newTestPod("tikv-1", TiKV, corev1.PodRunning),
+		newTestPod("tidb-0", TiDB, corev1.PodRunning),
+	)
+	// pd is up at once, the tikv pods are up after opening RocksDB, tidb never comes up.
+	upAfter := map[string]int{"pd-0": 1, "tikv-0": 3, "tikv-1": 5, "tidb-0": -1}
+	var mu sync.Mutex
+	checks := make(map[string]int)
+	executor := newFakeExecutor(func(podName string, _ []string) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		checks[podName]++
+		if n := upAfter[podName]; n > 0 && checks[podName] >= n {
+			return "UID\r\n12\r\n", nil
+		}
+		return "UID\r\n1\r\n", nil
+	})
+	var intervals []time.Duration
+	co := newTestCloudOperator(context.Background(), client, executor)
+	co.after = func(d time.Duration) <-chan time.Time {
+		mu.Lock()
+		intervals = append(intervals, d)
+		mu.Unlock()
+		return time.After(time.Millisecond)
+	}
+	co.UpTimeouts = map[component]time.Duration{TiKV: time.Hour, TiDB: 50 * time.Millisecond}
+
+	results := co.WaitUp(PodFilter{})
+	// every component is polled until its own deadline, the slow tikv is up.
+	assert.Equal(t, []StatusResult{
+		{Component: "pd", Up: true, Pods: 1, Running: 1},
+		{Component: "tikv", Up: true, Pods: 2, Running: 2},
+		{Component: "tidb", Pods: 1, Error: "not up after 50ms: 1 pods failed: tidb-0: process is not running"},
+	}, results)
+	assert.EqualError(t, CheckError(results), "check failed: tidb: not up after 50ms: 1 pods failed: tidb-0: process is not running")
+	// pd without timeout is checked once.
+	assert.Equal(t, 1, checks["pd-0"])
+	assert.Equal(t, 5, checks["tikv-1"])
+	assert.Greater(t, checks["tidb-0"], 1)
+	for _, interval := range intervals {
+		assert.GreaterOrEqual(t, int64(interval), int64(WaitInterval))
+	}
+
+	// only the filtered components are waited for.
+	checks = make(map[string]int)
+	results = co.WaitUp(PodFilter{Components: []component{PD, TiKV}})
+	assert.Len(t, results, 2)
+	assert.True(t, AllUp(results))
+	assert.Zero(t, checks["tidb-0"])
+
+	// the wait is cancelled with the operation.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	co.ctx = ctx
+	co.UpTimeouts = map[component]time.Duration{TiDB: time.Hour}
+	results = co.WaitUp(PodFilter{Components: []component{TiDB}})
+	assert.False(t, results[0].Up)
+	assert.Equal(t, "wait up is cancelled: context canceled", results[0].Error)
+}
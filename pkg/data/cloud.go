@@ -18,7 +18,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -28,6 +33,7 @@ import (
 	"go.uber.org/zap"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -48,30 +54,465 @@ var componentToName = map[component]string{
 	TiKV: "tikv",
 }
 
+var nameToComponent = map[string]component{
+	"tidb": TiDB,
+	"pd":   PD,
+	"tikv": TiKV,
+}
+
+// dataDirSeparator separates the data directories of a component, e.g. /data1:/data2.
+const dataDirSeparator = ":"
+
+// DefaultStopOrder is the default order to stop the components, they are started in the reverse order.
+var DefaultStopOrder = []component{TiDB, TiKV, PD}
+
+// DefaultComponents is the default component set of back and restore.
+const DefaultComponents = "tikv,pd"
+
 const (
 	BaseDir  = "/var/lib/"
 	ParamLen = 8
-	MaxRetry = 5
+	// ProcessFieldsCmd prints the field count of every line of ps -ef, it only needs ps and the sh builtins.
+	// The globbing is disabled, so the * in the arguments is not expanded.
+	ProcessFieldsCmd = "ps -ef | (set -f;while read -r line; do set -- $line; echo $#; done)"
+	MaxRetry         = 5
+	// RetryBackoff is the default backoff before retrying exec.
+	RetryBackoff = time.Minute
+	// DefaultSelectorTemplate is the default label selector template of the component pods.
+	DefaultSelectorTemplate = "app.kubernetes.io/component=%s"
+	// DefaultPlaceholderFile is the default file which reserves the disk space in the data directories,
+	// it is never backed up or restored.
+	DefaultPlaceholderFile = "space_placeholder_file"
+	// StopGracePeriod is the default time to wait for the process to exit after the stop signal.
+	StopGracePeriod = time.Minute
+	// WaitInterval is the interval to poll the pods status when waiting for them.
+	WaitInterval = 5 * time.Second
+	// MaxWaitInterval caps the interval which doubles after every poll when waiting for the pods.
+	MaxWaitInterval = 30 * time.Second
+	// DefaultParallel is the default max number of the concurrent execs in pods.
+	DefaultParallel = 4
+	// MinFreeRatio is the default min ratio of the free space after backing up.
+	MinFreeRatio = 0.05
+	// retryJitter is the max ratio of the random jitter added to the backoff.
+	retryJitter = 0.1
+	// BackupSuffix is the suffix of the backup directory, e.g. 5.2.bat.
+	BackupSuffix = ".bat"
+	// ArchiveSuffix is the suffix of the compressed backup, e.g. 5.2.tar.gz.
+	ArchiveSuffix = ".tar.gz"
+	// restoringSuffix is the suffix of the temporary directory which the backup is restored into before swapping in.
+	restoringSuffix = ".restoring"
+	// rollbackSuffix is the suffix of the directory which keeps the cleared files until the restore succeeds.
+	rollbackSuffix = ".rollback"
 	// DebugLabel is the label for debug.
 	DebugLabel = "runmode"
 	DebugValue = "debug"
 )
 
+// buildConfig builds the k8s config from the kube config file, it falls back to the in-cluster config
+// if conf is empty or the file doesn't exist.
+// The kubeContext is the context in the kube config file to use, empty means the current context.
+func buildConfig(conf, kubeContext string) (*rest.Config, error) {
+	if len(conf) > 0 {
+		_, err := os.Stat(conf)
+		if err == nil {
+			return clientConfig(conf, kubeContext).ClientConfig()
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		if len(kubeContext) > 0 {
+			return nil, fmt.Errorf("kube config file %q doesn't exist, the context %q can't be used", conf, kubeContext)
+		}
+		log.Warn("kube config file doesn't exist, it will use in-cluster config", zap.String("kube-config", conf))
+	} else if len(kubeContext) > 0 {
+		return nil, fmt.Errorf("no kube config file, the context %q can't be used", kubeContext)
+	}
+	// creates the in-cluster config
+	return rest.InClusterConfig()
+}
+
+// clientConfig loads the kube config file with the context overridden if it's not empty.
+func clientConfig(conf, kubeContext string) clientcmd.ClientConfig {
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: conf},
+		&clientcmd.ConfigOverrides{CurrentContext: kubeContext})
+}
+
+// ContextNamespace returns the namespace of the context in the kube config file, empty context means the current context.
+// It returns empty if the file doesn't exist or the context has no namespace.
+// It returns error if the context is specified but not found.
+func ContextNamespace(conf, kubeContext string) (string, error) {
+	if len(conf) == 0 {
+		return "", nil
+	}
+	config, err := clientcmd.LoadFromFile(conf)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("load kube config from %q failed: %w", conf, err)
+	}
+	name := config.CurrentContext
+	if len(kubeContext) > 0 {
+		name = kubeContext
+	}
+	current, ok := config.Contexts[name]
+	if !ok {
+		if len(kubeContext) > 0 {
+			return "", fmt.Errorf("context %q not found in kube config %q", kubeContext, conf)
+		}
+		return "", nil
+	}
+	return current.Namespace, nil
+}
+
+// ParseComponents parses a comma-separated component list, e.g. tikv,pd,tidb.
+func ParseComponents(s string) ([]component, error) {
+	var components []component
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if len(name) == 0 {
+			continue
+		}
+		cp, ok := nameToComponent[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown component: %s", name)
+		}
+		components = append(components, cp)
+	}
+	if len(components) == 0 {
+		return nil, errors.New("no component specified")
+	}
+	return components, nil
+}
+
+// ParseComponentsOrder parses the order to stop the components, e.g. tidb,tikv,pd.
+// It should be a permutation of all the known components.
+func ParseComponentsOrder(s string) ([]component, error) {
+	components, err := ParseComponents(s)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[component]bool, len(components))
+	for _, cp := range components {
+		if seen[cp] {
+			return nil, fmt.Errorf("duplicated component in order: %s", cp)
+		}
+		seen[cp] = true
+	}
+	if len(components) != len(componentToName) {
+		return nil, fmt.Errorf("order %q should contain all the components, e.g. %s", s, componentNames(DefaultStopOrder))
+	}
+	return components, nil
+}
+
+// componentNames joins the names of the components by comma.
+func componentNames(components []component) string {
+	names := make([]string, 0, len(components))
+	for _, cp := range components {
+		names = append(names, cp.String())
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseNamespaces parses a comma-separated namespace list, e.g. tidb-a,tidb-b.
+// It returns metav1.NamespaceAll if no namespace specified.
+func ParseNamespaces(s string) []string {
+	var namespaces []string
+	seen := make(map[string]bool)
+	for _, namespace := range strings.Split(s, ",") {
+		namespace = strings.TrimSpace(namespace)
+		if len(namespace) == 0 || seen[namespace] {
+			continue
+		}
+		seen[namespace] = true
+		namespaces = append(namespaces, namespace)
+	}
+	if len(namespaces) == 0 {
+		return []string{metav1.NamespaceAll}
+	}
+	return namespaces
+}
+
 // String implements fmt.Stringer interface.
 func (c component) String() string {
 	return componentToName[c]
 }
 
-// BataDir returns the data directory of the component.
-func (c component) BataDir() string {
-	return BaseDir + c.String()
+// parseComponentValues parses the values of components, e.g. tikv=a,pd=b.
+func parseComponentValues(s string) (map[component]string, error) {
+	values := make(map[component]string)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		cp, value, err := parseComponentValue(item)
+		if err != nil {
+			return nil, err
+		}
+		values[cp] = value
+	}
+	return values, nil
+}
+
+// parseComponentValue parses the item component=value.
+func parseComponentValue(item string) (component, string, error) {
+	kv := strings.SplitN(item, "=", 2)
+	if len(kv) != 2 {
+		return 0, "", fmt.Errorf("invalid item: %s, it should be component=value", item)
+	}
+	cp, ok := nameToComponent[strings.ToLower(strings.TrimSpace(kv[0]))]
+	if !ok {
+		return 0, "", fmt.Errorf("unknown component: %s", kv[0])
+	}
+	return cp, strings.TrimSpace(kv[1]), nil
+}
+
+// ParseDataDirs parses the data directories of components, e.g. tikv=/data/tikv,pd=/data/pd.
+// The component with multiple data volumes has the directories separated by colon, e.g. tikv=/data1:/data2.
+func ParseDataDirs(s string) (map[component]string, error) {
+	dataDirs, err := parseComponentValues(s)
+	if err != nil {
+		return nil, err
+	}
+	for cp, dirs := range dataDirs {
+		seen := make(map[string]bool)
+		for _, dir := range strings.Split(dirs, dataDirSeparator) {
+			if !strings.HasPrefix(dir, "/") {
+				return nil, fmt.Errorf("data dir of %s should be an absolute path: %s", cp, dir)
+			}
+			if err := validateShellSafe(fmt.Sprintf("data dir of %s", cp), dir); err != nil {
+				return nil, err
+			}
+			subdir := dataSubdir(dir)
+			if len(subdir) == 0 {
+				return nil, fmt.Errorf("data dir of %s should not be the root directory", cp)
+			}
+			if seen[subdir] {
+				return nil, fmt.Errorf("data dirs of %s are duplicated: %s", cp, dirs)
+			}
+			seen[subdir] = true
+		}
+	}
+	return dataDirs, nil
+}
+
+// ParseProcessThresholds parses the process thresholds of components, e.g. tikv=8,pd=8.
+func ParseProcessThresholds(s string) (map[component]int, error) {
+	values, err := parseComponentValues(s)
+	if err != nil {
+		return nil, err
+	}
+	thresholds := make(map[component]int, len(values))
+	for cp, value := range values {
+		threshold, err := strconv.Atoi(value)
+		if err != nil || threshold < 0 {
+			return nil, fmt.Errorf("invalid process threshold of %s: %s", cp, value)
+		}
+		thresholds[cp] = threshold
+	}
+	return thresholds, nil
+}
+
+// ValidateSelectorTemplate checks the label selector template contains exactly one %s and no other verbs.
+func ValidateSelectorTemplate(template string) error {
+	if strings.Count(template, "%s") != 1 || strings.Count(template, "%") != 1 {
+		return fmt.Errorf("selector template should contain exactly one %%s: %q", template)
+	}
+	return nil
+}
+
+// BataDir returns the data directory of the component, it is the first one if the component has multiple data directories.
+// The backups are stored in it.
+func (c component) BataDir(dataDirs map[component]string) string {
+	return c.BataDirs(dataDirs)[0]
+}
+
+// BataDirs returns all the data directories of the component.
+// It will use the directories in dataDirs if specified, otherwise /var/lib/{component}.
+func (c component) BataDirs(dataDirs map[component]string) []string {
+	dir, ok := dataDirs[c]
+	if !ok || len(dir) == 0 {
+		return []string{BaseDir + c.String()}
+	}
+	var dirs []string
+	for _, dir := range strings.Split(dir, dataDirSeparator) {
+		dirs = append(dirs, strings.TrimSuffix(dir, "/"))
+	}
+	return dirs
+}
+
+// dataSubdir returns the sub directory of the backup which the data directory is backed up into
+// if the component has multiple data directories, e.g. /data1/tikv => data1_tikv.
+func dataSubdir(dir string) string {
+	return strings.ReplaceAll(strings.Trim(dir, "/"), "/", "_")
+}
+
+// backupDir returns the backup directory of the version in the data directory.
+func backupDir(dir, version string) string {
+	return fmt.Sprintf("%s/%s%s", dir, version, BackupSuffix)
+}
+
+// ValidateBackupRoot checks the backup root is an absolute directory which can be used in the shell commands,
+// empty means the backups are stored in the data directories.
+func ValidateBackupRoot(root string) error {
+	if root == "" {
+		return nil
+	}
+	if !path.IsAbs(root) || path.Clean(root) == "/" {
+		return fmt.Errorf("invalid backup root %q, it should be an absolute directory other than /", root)
+	}
+	return validateShellSafe("backup root", root)
+}
+
+// backupRoot returns the directory which the backups of the component are stored in,
+// it is the sub directory named after the component in BackupRoot, or the data directory if BackupRoot is empty.
+func (c *CloudOperator) backupRoot(cp component) string {
+	if c.BackupRoot == "" {
+		return cp.BataDir(c.DataDirs)
+	}
+	return path.Join(c.BackupRoot, cp.String())
+}
+
+// validateBackupRoot checks the backup root of every component is out of its data directories and vice versa,
+// otherwise backing up would copy the backups and restoring would move them aside.
+func (c *CloudOperator) validateBackupRoot() error {
+	if err := ValidateBackupRoot(c.BackupRoot); err != nil || c.BackupRoot == "" {
+		return err
+	}
+	within := func(dir, parent string) bool {
+		return dir == parent || strings.HasPrefix(dir, parent+"/")
+	}
+	for _, cp := range c.Components {
+		root := c.backupRoot(cp)
+		for _, dir := range cp.BataDirs(c.DataDirs) {
+			if within(root, dir) || within(dir, root) {
+				return fmt.Errorf("backup root %s of %s overlaps its data directory %s", root, cp, dir)
+			}
+		}
+	}
+	return nil
+}
+
+// backupArchive returns the compressed backup of the version.
+func backupArchive(dir, version string) string {
+	return fmt.Sprintf("%s/%s%s", dir, version, ArchiveSuffix)
+}
+
+// backupPattern is the grep pattern to exclude backup directories, compressed backups,
+// the temporary directories of restoring, DefaultPlaceholderFile and the metadata of backups.
+var backupPattern = patternWith(DefaultPlaceholderFile)
+
+// patternWith returns the grep pattern like backupPattern, but the placeholder file is excluded instead of DefaultPlaceholderFile.
+func patternWith(placeholder string) string {
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%s", strings.TrimPrefix(BackupSuffix, "."), strings.TrimPrefix(ArchiveSuffix, "."),
+		strings.TrimPrefix(restoringSuffix, "."), strings.TrimPrefix(rollbackSuffix, "."), placeholder, strings.TrimPrefix(metadataFile, "."))
+}
+
+// placeholderPattern is the valid name of the placeholder file, it's safe in the grep patterns and the generated shell.
+var placeholderPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// ValidatePlaceholderFile checks the placeholder file is a plain file name.
+func ValidatePlaceholderFile(name string) error {
+	if !placeholderPattern.MatchString(name) {
+		return fmt.Errorf("invalid placeholder file %q, it should only contain letters, digits, _, . and -", name)
+	}
+	return nil
+}
+
+// placeholderFile returns the placeholder file of the operator, it is DefaultPlaceholderFile if PlaceholderFile is empty.
+func (c *CloudOperator) placeholderFile() string {
+	if c.PlaceholderFile == "" {
+		return DefaultPlaceholderFile
+	}
+	return c.PlaceholderFile
+}
+
+// basePattern returns the grep pattern of the files which are never backed up or restored by the operator.
+func (c *CloudOperator) basePattern() string {
+	return patternWith(c.placeholderFile())
+}
+
+// ValidateExcludes checks the patterns to exclude from backups are valid extended regular expressions
+// and they don't break the generated shell.
+// The pattern is single quoted in the script which is written by a double quoted echo, so the quotes,
+// backticks and spaces are not allowed, $ is only allowed as the end anchor, and the backslash is only
+// allowed to escape the characters which are not special in double quotes, e.g. \.
+func ValidateExcludes(excludes []string) error {
+	for _, exclude := range excludes {
+		if len(exclude) == 0 {
+			return errors.New("exclude should not be empty")
+		}
+		for i := 0; i < len(exclude); i++ {
+			ch := exclude[i]
+			var next byte
+			if i+1 < len(exclude) {
+				next = exclude[i+1]
+			}
+			switch {
+			case strings.IndexByte("'\"`", ch) >= 0 || ch <= ' ':
+				return fmt.Errorf("invalid exclude %q, it should not contain quotes, backticks or spaces", exclude)
+			case ch == '$' && next != 0 && next != '|':
+				return fmt.Errorf("invalid exclude %q, $ is only allowed as the end anchor", exclude)
+			case ch == '\\' && strings.IndexByte("$`\"\\", next) >= 0:
+				return fmt.Errorf("invalid exclude %q, the backslash should not escape $, backticks, quotes or backslashes", exclude)
+			}
+		}
+		if _, err := regexp.Compile(exclude); err != nil {
+			return fmt.Errorf("invalid exclude %q: %w", exclude, err)
+		}
+	}
+	return nil
+}
+
+// excludePattern returns the grep pattern to exclude the files from backups,
+// the excludes are combined with the base pattern which is always excluded, e.g. backupPattern.
+func excludePattern(base string, excludes []string) string {
+	if len(excludes) == 0 {
+		return base
+	}
+	return base + "|" + strings.Join(excludes, "|")
+}
+
+// versionPattern is the grep pattern to match backup directories and compressed backups.
+var versionPattern = fmt.Sprintf("%s$|%s$", BackupSuffix, ArchiveSuffix)
+
+// parseVersions parses the output of `ls {dir}|grep -E versionPattern` to versions.
+// The version is listed once even if it has both the directory and the compressed backup.
+func parseVersions(output string) []string {
+	versions := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, version := range splitLines(output) {
+		if len(version) == 0 {
+			continue
+		}
+		version = strings.TrimSuffix(strings.TrimSuffix(version, BackupSuffix), ArchiveSuffix)
+		if !seen[version] {
+			seen[version] = true
+			versions = append(versions, version)
+		}
+	}
+	return versions
 }
 
 // BackExecCmd backups cmd to the component's data directory.
-// The format of directory is: version.back (e.g. 5.1.back).
-func (c component) BackExecCmd(version string) string {
-	dir := c.BataDir()
-	backDir := fmt.Sprintf("%s/%s.bat", dir, version)
+// The format of directory is: version.bat (e.g. 5.1.bat).
+// If the component has extra data directories, every data directory is backed up into its own sub directory
+// of the backup, see dataSubdir.
+func (c component) BackExecCmd(dir, version string, extraDirs ...string) string {
+	return c.BackExecCmdTo(dir, dir, version, extraDirs...)
+}
+
+// BackExecCmdTo is the same as BackExecCmd, but the backup directory is in the root instead of the data directory,
+// e.g. /backup/tikv/5.1.bat.
+func (c component) BackExecCmdTo(dir, root, version string, extraDirs ...string) string {
+	return c.backExecCmd(dir, root, version, backupPattern, extraDirs...)
+}
+
+// backExecCmd is the same as BackExecCmdTo, but the files matching the pattern are excluded.
+func (c component) backExecCmd(dir, root, version, pattern string, extraDirs ...string) string {
+	backDir := backupDir(root, version)
 	shFile := fmt.Sprintf("%s/back_%s.sh", dir, version)
 
 	// normal cmd: cp -rf `ls -A |grep -vE "back|space_placeholder_file"` /usr/local/bin/tidb /var/lib/tidb/5.1.back
@@ -79,434 +520,1559 @@ func (c component) BackExecCmd(version string) string {
 	steps := []string{
 		fmt.Sprintf("rm -rf %s", backDir),
 		fmt.Sprintf("mkdir -p %s", backDir),
-		fmt.Sprintf("cd %s;/bin/cp -rf \\`ls -A | grep -vE 'bat|space_placeholder_file'\\` %s -v", dir, backDir),
+	}
+	if len(extraDirs) == 0 {
+		steps = append(steps, fmt.Sprintf("cd %s;/bin/cp -rf \\`ls -A | grep -vE '%s'\\` %s -v", dir, pattern, backDir))
+	}
+	for _, d := range dataDirsOf(dir, extraDirs) {
+		subDir := fmt.Sprintf("%s/%s", backDir, dataSubdir(d))
+		steps = append(steps,
+			fmt.Sprintf("mkdir -p %s", subDir),
+			fmt.Sprintf("cd %s;/bin/cp -rf \\`ls -A | grep -vE '%s'\\` %s -v || exit 1", d, pattern, subDir),
+		)
 	}
 	cmd := strings.Join(steps, ";")
 	return fmt.Sprintf("echo \"%s\" > %s;sh %s", cmd, shFile, shFile)
 }
 
-func (c component) RemoveExecCmd(version string) string {
-	dir := BaseDir + c.String()
-	backDir := fmt.Sprintf("%s/%s.bat", dir, version)
-	return fmt.Sprintf("rm -rf %s", backDir)
+// dataDirsOf returns all the data directories if there are extra data directories, otherwise it returns nil.
+func dataDirsOf(dir string, extraDirs []string) []string {
+	if len(extraDirs) == 0 {
+		return nil
+	}
+	return append([]string{dir}, extraDirs...)
+}
+
+// IncrementalBackExecCmd backups cmd to the component's data directory based on the previous version.
+// The unchanged files are hard links to the previous backup instead of copies, so they don't take more space.
+// It is the same as BackExecCmd if there is no previous version.
+func (c component) IncrementalBackExecCmd(dir, version, prevVersion string) string {
+	return c.incrementalBackExecCmd(dir, dir, version, prevVersion, backupPattern)
+}
+
+// incrementalBackExecCmd is the same as IncrementalBackExecCmd, but the backups are in the root
+// and the files matching the pattern are excluded.
+func (c component) incrementalBackExecCmd(dir, root, version, prevVersion, pattern string) string {
+	if len(prevVersion) == 0 {
+		return c.backExecCmd(dir, root, version, pattern)
+	}
+	backDir := backupDir(root, version)
+	prevDir := backupDir(root, prevVersion)
+	shFile := fmt.Sprintf("%s/back_%s.sh", dir, version)
+	// rsync writes the changed files to new inodes, so the previous backup will not be modified.
+	steps := []string{
+		fmt.Sprintf("rm -rf %s", backDir),
+		fmt.Sprintf("mkdir -p %s", backDir),
+		fmt.Sprintf("cd %s;rsync -a --link-dest=%s \\`ls -A | grep -vE '%s'\\` %s -v", dir, prevDir, pattern, backDir),
+	}
+	cmd := strings.Join(steps, ";")
+	return fmt.Sprintf("echo \"%s\" > %s;sh %s", cmd, shFile, shFile)
+}
+
+// RemoveExecCmd removes the backup directory and the compressed backup of the version.
+func (c component) RemoveExecCmd(dir, version string) string {
+	return fmt.Sprintf("rm -rf %s %s", backupDir(dir, version), backupArchive(dir, version))
 }
 
 // RestoreExecCmd restores cmd from the component's data directory.
-func (c component) RestoreExecCmd(version string) string {
-	dir := BaseDir + c.String()
+// The backup is copied into a temporary directory first, the live files are untouched if the copy fails.
+// Then the live files are moved aside and the restored files are swapped in, the moved files are
+// moved back if the swap fails, so the pod is never left without data.
+// If the component has extra data directories, every data directory is restored from its own sub directory
+// of the backup, all of them are copied before swapping, then they are swapped one by one. The moved files of
+// all the directories are kept until every directory is swapped, so all of them are rolled back if any swap fails.
+func (c component) RestoreExecCmd(dir, version string, extraDirs ...string) string {
+	return c.RestoreExecCmdFrom(dir, dir, version, extraDirs...)
+}
+
+// RestoreExecCmdFrom is the same as RestoreExecCmd, but the backup directory is in the root instead of
+// the data directory, see BackExecCmdTo.
+func (c component) RestoreExecCmdFrom(dir, root, version string, extraDirs ...string) string {
+	return c.restoreExecCmd(dir, root, version, backupPattern, extraDirs...)
+}
+
+// restoreExecCmd is the same as RestoreExecCmdFrom, but the live files matching the pattern are kept.
+func (c component) restoreExecCmd(dir, root, version, pattern string, extraDirs ...string) string {
+	shFile := fmt.Sprintf("%s/restore_%s.sh", dir, version)
+	dirs := dataDirsOf(dir, extraDirs)
+	if len(dirs) == 0 {
+		tmpDir := restoringDir(dir, version)
+		steps := []string{
+			fmt.Sprintf("cd %s;rm -rf %s %s", dir, tmpDir, rollbackDir(dir, version)),
+			fmt.Sprintf("/bin/cp -rf %s %s -v || exit 1", backupDir(root, version), tmpDir),
+			// the metadata belongs to the backup, it isn't restored.
+			fmt.Sprintf("rm -f %s/%s", tmpDir, metadataFile),
+		}
+		steps = append(steps, swapRestoredSteps(dir, version, pattern)...)
+		cmd := strings.Join(steps, ";")
+		return fmt.Sprintf("echo \"%s\" > %s;sh %s", cmd, shFile, shFile)
+	}
+	var steps []string
+	for _, d := range dirs {
+		steps = append(steps,
+			fmt.Sprintf("cd %s;rm -rf %s %s", d, restoringDir(d, version), rollbackDir(d, version)),
+			fmt.Sprintf("/bin/cp -rf %s/%s %s -v || exit 1", backupDir(root, version), dataSubdir(d), restoringDir(d, version)),
+		)
+	}
+	for i, d := range dirs {
+		tmpDir, rbDir := restoringDir(d, version), rollbackDir(d, version)
+		// the swapped directories are rolled back in the reverse order.
+		rollbacks := make([]string, 0, i+1)
+		for j := i; j >= 0; j-- {
+			rollbacks = append(rollbacks, rollbackStep(dirs[j], version, pattern))
+		}
+		steps = append(steps,
+			fmt.Sprintf("cd %s", d),
+			fmt.Sprintf("mkdir -p %s", rbDir),
+			fmt.Sprintf("if ls -A | grep -vE '%s' | xargs -r mv -t %s && cd %s && ls -A | xargs -r mv -t %s", pattern, rbDir, tmpDir, d),
+			"then :",
+			fmt.Sprintf("else %s;exit 1", strings.Join(rollbacks, ";")),
+			"fi",
+		)
+	}
+	for _, d := range dirs {
+		steps = append(steps, fmt.Sprintf("rm -rf %s %s", restoringDir(d, version), rollbackDir(d, version)))
+	}
+	cmd := strings.Join(steps, ";")
+	return fmt.Sprintf("echo \"%s\" > %s;sh %s", cmd, shFile, shFile)
+}
+
+// restoringDir returns the temporary directory which the version is restored into.
+func restoringDir(dir, version string) string {
+	return fmt.Sprintf("%s/%s%s", dir, version, restoringSuffix)
+}
+
+// rollbackDir returns the directory which keeps the live files during restoring the version.
+func rollbackDir(dir, version string) string {
+	return fmt.Sprintf("%s/%s%s", dir, version, rollbackSuffix)
+}
+
+// swapRestoredSteps moves the live files into the rollback directory and the restored files into the data directory.
+// It removes the rollback directory only if all the files are swapped in, otherwise it rolls back and exits with 1.
+// The live files matching the pattern are kept.
+func swapRestoredSteps(dir, version, pattern string) []string {
+	tmpDir, rbDir := restoringDir(dir, version), rollbackDir(dir, version)
+	return []string{
+		fmt.Sprintf("mkdir -p %s", rbDir),
+		fmt.Sprintf("if ls -A | grep -vE '%s' | xargs -r mv -t %s && cd %s && ls -A | xargs -r mv -t %s", pattern, rbDir, tmpDir, dir),
+		fmt.Sprintf("then cd %s;rm -rf %s %s", dir, tmpDir, rbDir),
+		fmt.Sprintf("else %s;exit 1", rollbackStep(dir, version, pattern)),
+		"fi",
+	}
+}
+
+// rollbackStep removes the files swapped into the data directory and moves the live files back from the rollback directory.
+func rollbackStep(dir, version, pattern string) string {
+	return fmt.Sprintf("cd %s;ls -A | grep -vE '%s' | xargs -r rm -rf;cd %s && ls -A | xargs -r mv -t %s;cd %s;rm -rf %s",
+		dir, pattern, rollbackDir(dir, version), dir, dir, restoringDir(dir, version))
+}
+
+// RestoreCheckExecCmd counts the files restored to the data directories and the files of the backup,
+// the output is the two counts separated by a space, see checkRestored.
+// The backup count is 0 if the backup isn't a directory, e.g. a compressed backup.
+func (c component) RestoreCheckExecCmd(dir, root, version string, extraDirs ...string) string {
+	return c.restoreCheckExecCmd(dir, root, version, backupPattern, extraDirs...)
+}
+
+// restoreCheckExecCmd is the same as RestoreCheckExecCmd, but the live files matching the pattern aren't counted.
+func (c component) restoreCheckExecCmd(dir, root, version, pattern string, extraDirs ...string) string {
+	dirs := dataDirsOf(dir, extraDirs)
+	if len(dirs) == 0 {
+		dirs = []string{dir}
+	}
+	return fmt.Sprintf("restored=$(for d in %s; do cd $d && files=$(ls -A | grep -vE '%s') && find $files -type f; done | wc -l);"+
+		"backup=$(find %s -type f ! -name %s 2>/dev/null | wc -l);echo $restored $backup",
+		strings.Join(dirs, " "), pattern, backupDir(root, version), metadataFile)
+}
+
+// checkRestored parses the output of RestoreCheckExecCmd, it fails if no file is restored
+// or fewer files are restored than the backup has.
+func checkRestored(output string) error {
+	fields := strings.Fields(output)
+	if len(fields) != 2 {
+		return fmt.Errorf("unexpected output of the restore check: %q", output)
+	}
+	restored, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return fmt.Errorf("unexpected output of the restore check: %q", output)
+	}
+	backup, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return fmt.Errorf("unexpected output of the restore check: %q", output)
+	}
+	if restored == 0 {
+		return errors.New("no file is restored, the data directory is empty")
+	}
+	if restored < backup {
+		return fmt.Errorf("%d files are restored, but the backup has %d files", restored, backup)
+	}
+	return nil
+}
+
+// CompressedBackExecCmd backups cmd to the compressed backup in the component's data directory.
+// The format of the compressed backup is: version.tar.gz (e.g. 5.1.tar.gz).
+func (c component) CompressedBackExecCmd(dir, version string) string {
+	return c.compressedBackExecCmd(dir, dir, version, backupPattern)
+}
+
+// compressedBackExecCmd is the same as CompressedBackExecCmd, but the compressed backup is in the root
+// and the files matching the pattern are excluded.
+func (c component) compressedBackExecCmd(dir, root, version, pattern string) string {
+	archive := backupArchive(root, version)
+	shFile := fmt.Sprintf("%s/back_%s.sh", dir, version)
+	steps := []string{
+		fmt.Sprintf("rm -f %s", archive),
+		fmt.Sprintf("cd %s;tar czf %s \\`ls -A | grep -vE '%s'\\` -v", dir, archive, pattern),
+	}
+	if root != dir {
+		// the root may not exist before the first backup.
+		steps = append([]string{fmt.Sprintf("mkdir -p %s", root)}, steps...)
+	}
+	cmd := strings.Join(steps, ";")
+	return fmt.Sprintf("echo \"%s\" > %s;sh %s", cmd, shFile, shFile)
+}
+
+// CompressedRestoreExecCmd restores cmd from the compressed backup in the component's data directory.
+// It extracts the backup into a temporary directory and swaps it in like RestoreExecCmd.
+func (c component) CompressedRestoreExecCmd(dir, version string) string {
+	return c.compressedRestoreExecCmd(dir, dir, version, backupPattern)
+}
+
+// compressedRestoreExecCmd is the same as CompressedRestoreExecCmd, but the compressed backup is in the root
+// and the live files matching the pattern are kept.
+func (c component) compressedRestoreExecCmd(dir, root, version, pattern string) string {
 	shFile := fmt.Sprintf("%s/restore_%s.sh", dir, version)
-	backDir := fmt.Sprintf("%s/%s.bat", dir, version)
+	archive := backupArchive(root, version)
+	tmpDir := restoringDir(dir, version)
 	steps := []string{
-		fmt.Sprintf("cd %s;rm -rf \\`ls -A | grep -vE 'bat|space_placeholder_file' \\` -v", dir),
-		fmt.Sprintf("/bin/cp -rf %s/* %s -v", backDir, dir),
+		fmt.Sprintf("cd %s;rm -rf %s %s", dir, tmpDir, rollbackDir(dir, version)),
+		fmt.Sprintf("mkdir -p %s", tmpDir),
+		fmt.Sprintf("tar xzf %s -C %s -v || exit 1", archive, tmpDir),
 	}
+	steps = append(steps, swapRestoredSteps(dir, version, pattern)...)
 	cmd := strings.Join(steps, ";")
 	return fmt.Sprintf("echo \"%s\" > %s;sh %s", cmd, shFile, shFile)
 }
 
+// componentPods is the pods of the component.
+type componentPods struct {
+	component component
+	pods      []corev1.Pod
+}
+
 // CloudOperator is the interface for cloud operator.
 type CloudOperator struct {
-	client    *kubernetes.Clientset
-	config    *rest.Config
-	namespace string
-	ctx       context.Context
+	client   kubernetes.Interface
+	config   *rest.Config
+	executor executor
+	ctx      context.Context
+	// Namespaces is the namespaces to operate, metav1.NamespaceAll means all the namespaces.
+	Namespaces []string
+	// Components is the component set which will be backed up or restored.
+	Components []component
+	// SelectorTemplate is the label selector template to discover the pods, %s is replaced by the component name.
+	SelectorTemplate string
+	// PlaceholderFile is the file which reserves the disk space in the data directories, it is never backed up or restored.
+	// Empty means DefaultPlaceholderFile.
+	PlaceholderFile string
+	// Discovery is the way to discover the pods, DiscoveryLabel or DiscoveryStatefulSet, empty means DiscoveryLabel.
+	Discovery string
+	// Containers overrides the container of the component to exec in, it is resolved from the pod spec by default.
+	Containers map[component]string
+	// DataDirs overrides the data directory of the component.
+	DataDirs map[component]string
+	// BackupRoot is the directory which the backups are stored in instead of the data directories,
+	// every component has its own sub directory, e.g. /backup/tikv/5.2.bat. It is usually another volume
+	// mounted in the pods, so the backups don't take the space of the live data. Empty means the data directories.
+	BackupRoot string
+	// RetryCount is the max times to exec a command.
+	RetryCount int
+	// RetryBackoff is the backoff before the first retry, it doubles every retry.
+	RetryBackoff time.Duration
+	// RetryMaxBackoff caps the backoff.
+	RetryMaxBackoff time.Duration
+	// after waits for the duration to elapse, it is time.After but can be injected in tests.
+	after func(time.Duration) <-chan time.Time
+	// ProcessThresholds overrides the process threshold of the component, the default is ParamLen.
+	// See Liveness for details.
+	ProcessThresholds map[component]int
+	// Liveness overrides the command to check the component process is running and the predicate on its output,
+	// the missing component counts the fields of PID 1 in ps, see Liveness for details.
+	Liveness map[component]Liveness
+	// MinFreeRatio is the min ratio of the free space in the file system after backing up.
+	MinFreeRatio float64
+	// MaxBackupSize is the max size in KB of the files to back up in a pod, the pod which exceeds it is skipped,
+	// it fails the backup in strict mode instead, 0 means no limit.
+	MaxBackupSize int64
+	// Parallel is the max number of the concurrent execs in pods, it is shared by all the components, 0 means no limit.
+	Parallel int
+	// semOnce creates sem once, sem bounds the concurrent execs by Parallel.
+	semOnce sync.Once
+	sem     chan struct{}
+	// Retain is the number of the newest backup versions to keep after backing up, 0 means keeping all.
+	Retain int
+	// ConfirmPrune is called with the backups to be pruned before pruning them, pruning is cancelled if it returns error.
+	// nil means pruning without confirmation.
+	ConfirmPrune func(targets []PruneTarget) error
+	// ExecTimeout bounds every exec attempt, 0 means no timeout. It doesn't kill the command in the pod,
+	// so the timeout attempts of back, restore and prune aren't retried, the others will be retried.
+	ExecTimeout time.Duration
+	// Stream logs the output of the long-running commands as it arrives.
+	Stream bool
+	// LogDir is the directory to save the exec output of back and restore of every pod, empty means not saving.
+	LogDir string
+	// SkipPreflight skips checking the permissions before back and restore.
+	SkipPreflight bool
+	// Excludes are the extended regular expressions of the files to exclude from backups,
+	// they are matched against the names in the data directories, see ValidateExcludes.
+	Excludes []string
+	// Force restores even if the component process is still running in the pods, e.g. stopping them failed.
+	// The backup version is still checked.
+	Force bool
+	// ProgressInterval is the interval to log the backup progress of every pod, 0 means not logging.
+	// The progress is not available for the compressed backups.
+	ProgressInterval time.Duration
+	// StopGracePeriod is the time to wait for the process to exit after the stop signal,
+	// the pod will be force deleted and the recreated pod is stopped again after it, 0 means not waiting.
+	StopGracePeriod time.Duration
+	// Incremental backs up based on the previous backup version, the unchanged files are hard links.
+	Incremental bool
+	// Compress stores the backups as compressed tarballs instead of directories.
+	Compress bool
+	// Backend stores the backups, nil means CopyBackend which copies the data in the pods.
+	Backend Backend
+	// Resume continues the interrupted backup of the same version, the pods already backed up are skipped.
+	// It takes over the lock left by the interrupted backup and fails without it, see resumeTargets for details.
+	Resume bool
+	// Upload uploads every backup to the object storage after backing up, nil means not uploading.
+	Upload Uploader
+	// PostRestoreHook checks the cluster after RestoreWorkflow restored it and started all the components,
+	// its error fails the workflow, nil means no check.
+	PostRestoreHook PostRestoreHook
+	// Download downloads the backup from the object storage before restoring, nil means restoring from the pods.
+	Download Downloader
+	// DebugImage execs the commands in an ephemeral container of the image attached to the target container,
+	// e.g. the image with the shell tools for the distroless pods, empty means exec in the target container.
+	DebugImage string
+	// ComponentImages overrides DebugImage of the components, K: component V: image, e.g. the image with
+	// the tools of the component, the component not in it uses DebugImage.
+	ComponentImages map[component]string
+	// debugs records the debug containers injected into the pods.
+	debugs debugContainers
+	// DebugKey and DebugValue is the annotation which puts the pod into debug mode,
+	// PID 1 will not start the component process in debug mode.
+	DebugKey   string
+	DebugValue string
+	// UpTimeouts bounds the wait for the component process up after starting, K: component V: timeout,
+	// every component is polled until it's up or its own timeout elapses, the missing component is checked once.
+	UpTimeouts map[component]time.Duration
+	// WaitTimeout bounds the wait for the pods to be ready after starting, 0 means not waiting.
+	// It also bounds the wait for the processes to stop before backing up or restoring.
+	WaitTimeout time.Duration
+	// StopOrder is the order to stop the components, they are started in the reverse order, nil means DefaultStopOrder.
+	// Back and restore also run the components in this order.
+	StopOrder []component
+	// Pods restricts back and restore to the pods, the pod is the name or namespace/name, empty means all the pods.
+	Pods []string
+	// Strict fails the operation if any pod is not running, or has no backup to restore, instead of skipping it.
+	// Restore returns a CategoryPartial error after restoring the others if it skipped any pod without Strict.
+	Strict bool
+	// Timestamped names the backups <version>-<unixtime>, so the backups of the same version taken at different
+	// times are kept, and restore restores the latest timestamped backup of the version, see selectVersion.
+	Timestamped bool
+	// At selects the latest timestamped backup taken at or before it to restore, zero means the latest one.
+	At time.Time
+	// PDSingle backs up only one PD pod of every namespace, the leader if it's known, otherwise the first pod by name.
+	// Restore refuses to restore PD from it, since a single member is overwritten by the others,
+	// PD has to be recovered from the backup by its own tools, e.g. pd-recover.
+	PDSingle bool
+	// pdLeaders records the PD leader of every namespace before stopping PD, K: namespace V: pod name.
+	pdLeaders map[string]string
+	// skipped records the pods skipped by the operations because they were not running or had no backup.
+	skipped skippedPods
+	// NoStart leaves the components stopped after BackupWorkflow and RestoreWorkflow, so more work can be done
+	// before starting them by Start, the post restore check is skipped too. They are still started if the workflow is interrupted.
+	NoStart bool
+	// Notify receives the progress of BackupWorkflow and RestoreWorkflow, nil means logging the progress.
+	Notify func(msg string)
+	// DryRun prints the commands instead of executing them, it will not mutate any pods.
+	DryRun bool
+	// Out is the writer of the dry run output.
+	Out io.Writer
+	// LockTTL is the time after which the lock of the namespace is taken as expired, so the lock left by
+	// a crashed operation can be taken over, 0 means not locking.
+	LockTTL time.Duration
+	// lockHolder identifies the operator which holds the lock.
+	lockHolder string
+	// resumed records the locks of the interrupted operations taken over in resume mode,
+	// K: namespace V: the time the interrupted operation acquired the lock.
+	resumed map[string]time.Time
+	// now returns the current time, it is time.Now but can be injected in tests.
+	now func() time.Time
+}
+
+// ClientRate limits the requests to the API server of the clientset, the execs in pods are limited too.
+type ClientRate struct {
+	// QPS is the max average queries per second.
+	QPS float32
+	// Burst is the max queries in a burst.
+	Burst int
+}
+
+// The default rate is higher than the one of client-go, so listing and execing in many pods are not throttled.
+const (
+	DefaultQPS   = 50
+	DefaultBurst = 100
+)
+
+// DefaultClientRate is the default rate of the clientset.
+var DefaultClientRate = ClientRate{QPS: DefaultQPS, Burst: DefaultBurst}
+
+// Validate checks the rate is positive and the burst is not less than the QPS.
+func (r ClientRate) Validate() error {
+	if r.QPS <= 0 {
+		return fmt.Errorf("qps should be positive: %v", r.QPS)
+	}
+	if float32(r.Burst) < r.QPS {
+		return fmt.Errorf("burst %d should not be less than qps %v", r.Burst, r.QPS)
+	}
+	return nil
+}
+
+// newClientset creates the clientset of the config, it is replaced in tests to count the constructions.
+var newClientset = func(config *rest.Config) (kubernetes.Interface, error) {
+	return kubernetes.NewForConfig(config)
 }
 
 // NewCloudOperator creates a cloud operator.
-func NewCloudOperator(namespace, conf string, ctx context.Context) *CloudOperator {
-	// creates the in-cluster config
-	config, err := clientcmd.BuildConfigFromFlags("", conf)
+// The operator can be reused by the sequential operations, e.g. stop, back and start,
+// they share its config and clientset.
+// It uses the in-cluster config if conf is empty or the kube config file doesn't exist.
+// The kubeContext is the context in the kube config file to use, empty means the current context.
+// The rate limits the requests of the clientset to the API server.
+func NewCloudOperator(namespace, conf, kubeContext string, rate ClientRate, ctx context.Context) (*CloudOperator, error) {
+	if err := rate.Validate(); err != nil {
+		return nil, err
+	}
+	config, err := buildConfig(conf, kubeContext)
 	if err != nil {
-		panic(err.Error())
+		return nil, fmt.Errorf("build k8s config from %q failed: %w", conf, err)
 	}
-	// creates the clientset
-	client, err := kubernetes.NewForConfig(config)
+	config.QPS, config.Burst = rate.QPS, rate.Burst
+	// creates the clientset, it is shared by all the operations and the execs of the operator.
+	client, err := newClientset(config)
 	if err != nil {
-		log.Error("k8s load config failed", zap.Error(err))
-		return nil
+		return nil, fmt.Errorf("create k8s client failed: %w", err)
 	}
 	return &CloudOperator{
-		client,
-		config,
-		namespace,
-		ctx,
-	}
+		client:           client,
+		config:           config,
+		executor:         &remoteExecutor{config: config, client: client},
+		Namespaces:       []string{namespace},
+		ctx:              ctx,
+		Components:       []component{TiKV, PD},
+		SelectorTemplate: DefaultSelectorTemplate,
+		PlaceholderFile:  DefaultPlaceholderFile,
+		RetryCount:       MaxRetry,
+		RetryBackoff:     RetryBackoff,
+		RetryMaxBackoff:  RetryBackoff,
+		MinFreeRatio:     MinFreeRatio,
+		Parallel:         DefaultParallel,
+		ProgressInterval: DefaultProgressInterval,
+		StopGracePeriod:  StopGracePeriod,
+		DebugKey:         DebugLabel,
+		DebugValue:       DebugValue,
+		after:            time.After,
+		Out:              os.Stdout,
+		LockTTL:          DefaultLockTTL,
+		lockHolder:       defaultLockHolder(),
+		now:              time.Now,
+	}, nil
 }
 
-// List returns all the backup version of the component in one cluster.
-func (c *CloudOperator) List() (map[string][]string, error) {
-	// k: component, v: versions
-	rst := make(map[string][]string)
-	for _, cp := range []component{TiKV, PD} {
-		options := metav1.ListOptions{
-			LabelSelector: fmt.Sprintf("app.kubernetes.io/component=%s", cp.String()),
+// List returns the backup versions of the components in one cluster which match the filter.
+// The result is ordered by the components and then by the pods.
+// The pods without any matched version are omitted if the filter has a version pattern.
+// The pods are listed concurrently by at most Parallel goroutines, the errors of all the pods are returned together.
+func (c *CloudOperator) List(filter ListFilter) ([]BackupInfo, error) {
+	var pods []corev1.Pod
+	var components []component
+	for _, cp := range c.Components {
+		if !filter.matchComponent(cp) {
+			continue
 		}
-		pods, err := c.client.CoreV1().Pods(c.namespace).List(c.ctx, options)
+		list, err := c.discoverPods(cp)
 		if err != nil {
 			return nil, err
 		}
-		commands := []string{
-			"sh",
-			"-c",
-			fmt.Sprintf("ls %s|grep bat", cp.BataDir()),
+		// the pods are sorted in every component, so the infos are in the order of the result.
+		sort.Slice(list.Items, func(i, j int) bool {
+			return c.podKey(&list.Items[i]) < c.podKey(&list.Items[j])
+		})
+		for range list.Items {
+			components = append(components, cp)
 		}
-		for _, pod := range pods.Items {
-			dirs, err := c.exec(pod.Name, cp.String(), commands)
+		pods = append(pods, list.Items...)
+	}
+	// infos[i] is nil if the pod is omitted or failed.
+	infos := make([]*BackupInfo, len(pods))
+	errs := newPodErrors()
+	errs.expect(len(pods))
+	tasks := make([]func(), 0, len(pods))
+	for i := range pods {
+		i := i
+		tasks = append(tasks, func() {
+			info, err := c.listPod(&pods[i], components[i], filter)
 			if err != nil {
-				log.Error("exec failed", zap.String("pod-name", pod.Name), zap.Any("command", commands))
-				return nil, err
+				errs.add(c.podKey(&pods[i]), err)
+				return
 			}
-			var versions []string
-			if _, ok := rst[pod.Name]; !ok {
-				versions = make([]string, 0)
-			} else {
-				versions = rst[pod.Name]
+			infos[i] = info
+		})
+	}
+	parallel(c.Parallel, tasks)
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	rst := make([]BackupInfo, 0, len(infos))
+	for _, info := range infos {
+		if info != nil {
+			rst = append(rst, *info)
+		}
+	}
+	return rst, nil
+}
+
+// listPod returns the backup versions of the pod which match the filter,
+// it returns nil if the filter has a version pattern and no version matches.
+func (c *CloudOperator) listPod(pod *corev1.Pod, cp component, filter ListFilter) (*BackupInfo, error) {
+	versions, err := c.listVersions(pod, cp)
+	if err != nil {
+		return nil, err
+	}
+	versions = filter.filter(versions)
+	if len(filter.Version) > 0 && len(versions) == 0 {
+		return nil, nil
+	}
+	info := &BackupInfo{Component: cp.String(), Pod: c.podKey(pod), Versions: versions}
+	if filter.Metadata {
+		if info.Metadata, err = c.listMetadata(pod, cp, versions); err != nil {
+			return nil, err
+		}
+	}
+	if filter.Sizes && len(versions) > 0 {
+		if info.Sizes, err = c.listSizes(pod, cp, versions); err != nil {
+			return nil, err
+		}
+	}
+	return info, nil
+}
+
+// listSizes returns the size in KB of the versions in the pod, the backup directory and the compressed backup
+// of a version are summed up, see parsePruneSizes.
+func (c *CloudOperator) listSizes(pod *corev1.Pod, cp component, versions []string) (map[string]int64, error) {
+	container, err := c.container(pod, cp)
+	if err != nil {
+		return nil, err
+	}
+	output, err := c.exec(c.podKey(pod), container, []string{"sh", "-c", cp.PruneSizeExecCmd(c.backupRoot(cp), versions)})
+	if err != nil {
+		return nil, err
+	}
+	return parsePruneSizes(output)
+}
+
+// multiNamespace returns true if it operates across multiple namespaces.
+func (c *CloudOperator) multiNamespace() bool {
+	return len(c.Namespaces) > 1 || (len(c.Namespaces) == 1 && c.Namespaces[0] == metav1.NamespaceAll)
+}
+
+// podKey returns the key of the pod, it is namespace/name if it operates across multiple namespaces,
+// otherwise it is the pod name.
+func (c *CloudOperator) podKey(pod *corev1.Pod) string {
+	if c.multiNamespace() {
+		return pod.Namespace + "/" + pod.Name
+	}
+	return pod.Name
+}
+
+// splitPodKey returns the namespace and the name of the pod key.
+func (c *CloudOperator) splitPodKey(key string) (string, string) {
+	if idx := strings.Index(key, "/"); idx >= 0 {
+		return key[:idx], key[idx+1:]
+	}
+	if len(c.Namespaces) > 0 {
+		return c.Namespaces[0], key
+	}
+	return metav1.NamespaceAll, key
+}
+
+// labelSelector returns the label selector of the component pods.
+func (c *CloudOperator) labelSelector(cp component) string {
+	template := c.SelectorTemplate
+	if template == "" {
+		template = DefaultSelectorTemplate
+	}
+	return fmt.Sprintf(template, cp.String())
+}
+
+// listVersions returns the backup versions in the pod.
+func (c *CloudOperator) listVersions(pod *corev1.Pod, cp component) ([]string, error) {
+	container, err := c.container(pod, cp)
+	if err != nil {
+		return nil, err
+	}
+	commands := []string{
+		"sh",
+		"-c",
+		fmt.Sprintf("ls %s|grep -E '%s'", c.backupRoot(cp), versionPattern),
+	}
+	podName := c.podKey(pod)
+	dirs, err := c.exec(podName, container, commands)
+	if err != nil {
+		log.Error("exec failed", zap.String("pod-name", podName), zap.Any("command", commands))
+		return nil, err
+	}
+	return parseVersions(dirs), nil
+}
+
+// Start starts all the components.
+// It only changes the pods in debug mode, the others are left untouched.
+func (c *CloudOperator) Start() error {
+	return c.StartPods(PodFilter{})
+}
+
+// StartPods starts the pods kept by the filter, the other pods are left untouched.
+// It only changes the pods in debug mode, and waits for the started pods ready.
+func (c *CloudOperator) StartPods(filter PodFilter) error {
+	targets, err := c.filterPods(filter)
+	if err != nil {
+		return err
+	}
+	components := filter.filterComponents(c.startOrder())
+	// K: component V: the pods which are in debug mode
+	changed := make(map[component][]corev1.Pod)
+	for _, name := range components {
+		// it will remove the debug annotation of the pods
+		for _, pod := range targets[name] {
+			// the pods are not annotated by Stop in dry run mode, so it assumes all of them are in debug mode.
+			if _, ok := pod.Annotations[c.DebugKey]; !ok && !c.DryRun {
+				continue
 			}
-			for _, version := range strings.Split(dirs, "\r\n") {
-				if len(version) > 0 {
-					versions = append(versions, strings.TrimSuffix(version, ".bat"))
-				}
+			changed[name] = append(changed[name], pod)
+			if c.DryRun {
+				c.printDryRun("remove annotation %s from pod %s", c.DebugKey, c.podKey(&pod))
+				continue
 			}
-			rst[pod.Name] = versions
+			if err := c.patchAnnotation(&pod, c.DebugKey, nil); err != nil {
+				log.Error("update pods annotation error", zap.Error(err))
+				return err
+			}
+		}
+	}
+
+	deleted := make(map[string]types.UID)
+	for _, name := range components {
+		uids, err := c.delete(changed[name])
+		if err != nil {
+			return err
+		}
+		for key, uid := range uids {
+			deleted[key] = uid
+		}
+	}
+	if c.DryRun || c.WaitTimeout <= 0 {
+		return nil
+	}
+	return c.waitReady(components, filter.Pods, deleted)
+}
+
+// Stop stops all the pods of the component and will enter debug mode.
+func (c *CloudOperator) Stop() error {
+	return c.StopPods(PodFilter{})
+}
+
+// StopPods stops the pods kept by the filter and puts them into debug mode, the other pods are left untouched.
+func (c *CloudOperator) StopPods(filter PodFilter) error {
+	targets, err := c.filterPods(filter)
+	if err != nil {
+		return err
+	}
+	// it fails before mutating any pod in strict mode.
+	if c.Strict {
+		for _, name := range filter.filterComponents(c.stopOrder()) {
+			c.runningPods("stop", name, targets[name])
+		}
+		if err := c.skippedErr("stop"); err != nil {
+			return err
+		}
+	}
+	// it annotates the pods in the start order.
+	for _, name := range filter.filterComponents(c.startOrder()) {
+		// it will annotate all pods of runmode=debug
+		for _, pod := range targets[name] {
+			if c.DryRun {
+				c.printDryRun("annotate pod %s with %s=%s", c.podKey(&pod), c.DebugKey, c.DebugValue)
+				continue
+			}
+			if err := c.patchAnnotation(&pod, c.DebugKey, &c.DebugValue); err != nil {
+				log.Error("update pods annotation failed", zap.Error(err))
+				return err
+			}
+		}
+	}
+
+	for _, cp := range filter.filterComponents(c.stopOrder()) {
+		if err := c.kill(cp, targets[cp]); err != nil {
+			log.Error("kill component failed", zap.String("component", cp.String()), zap.Error(err))
+			return err
+		}
+		log.Info("stop result", zap.String("pod name", cp.String()))
+	}
+	return nil
+}
+
+// stopOrder returns the order to stop the components.
+func (c *CloudOperator) stopOrder() []component {
+	if len(c.StopOrder) == 0 {
+		return DefaultStopOrder
+	}
+	return c.StopOrder
+}
+
+// startOrder returns the order to start the components, it is the reverse of the stop order.
+func (c *CloudOperator) startOrder() []component {
+	stop := c.stopOrder()
+	start := make([]component, 0, len(stop))
+	for i := len(stop) - 1; i >= 0; i-- {
+		start = append(start, stop[i])
+	}
+	return start
+}
+
+// inStopOrder returns the components sorted by the stop order.
+func (c *CloudOperator) inStopOrder(components []component) []component {
+	selected := make(map[component]bool, len(components))
+	for _, cp := range components {
+		selected[cp] = true
+	}
+	ordered := make([]component, 0, len(components))
+	for _, cp := range c.stopOrder() {
+		if selected[cp] {
+			ordered = append(ordered, cp)
+		}
+	}
+	return ordered
+}
+
+// Back backs up all the components, it returns the result of every pod which is backed up.
+func (c *CloudOperator) Back(version string) ([]OperationResult, error) {
+	if err := ValidateVersion(version); err != nil {
+		return nil, err
+	}
+	if err := ValidateExcludes(c.Excludes); err != nil {
+		return nil, err
+	}
+	if err := c.validateResume(version); err != nil {
+		return nil, err
+	}
+	if err := c.backend().Validate(c); err != nil {
+		return nil, err
+	}
+	version = c.backupVersion(version)
+	// it checks all the components before backing up any pod.
+	targets, err := c.prepare("backup", func(cp component, pods []corev1.Pod) error {
+		if !c.checkPodsStatus(cp, pods, false) {
+			return errors.New("check status failed")
+		}
+		return c.backend().Prepare(c, cp, pods)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the pods whose backups are too large are skipped by Prepare.
+	targets = c.withoutSkipped("backup", targets)
+	if c.PDSingle {
+		targets = c.singlePD(targets)
+	}
+	if c.Resume {
+		if targets, err = c.resumeTargets(targets, version); err != nil {
+			return nil, err
 		}
 	}
-	return rst, nil
+	results, err := c.backend().Back(c, version, targets)
+	if err != nil {
+		return results, err
+	}
+	return results, c.retainAfterBack(version)
 }
 
-// Start starts all the components.
-func (c *CloudOperator) Start() error {
-	for _, name := range []component{PD, TiKV, TiDB} {
-		options := metav1.ListOptions{
-			LabelSelector: fmt.Sprintf("app.kubernetes.io/component=%s", name.String()),
-		}
-		pods, err := c.client.CoreV1().Pods(c.namespace).List(c.ctx, options)
-		// it will annotate all pods of runmode=debug
-		for _, pod := range pods.Items {
-			// annotate will not nil
-			newPod := pod.DeepCopy()
-			ann := newPod.ObjectMeta.Annotations
-			delete(ann, DebugLabel)
-			_, err = c.client.CoreV1().Pods(c.namespace).Update(c.ctx, newPod, metav1.UpdateOptions{})
-			if err != nil {
-				log.Error("update pods annotation error", zap.Error(err))
-				return err
-			}
+// backCmd returns the command to back up the pod, it chains the upload command if Upload is set.
+func (c *CloudOperator) backCmd(pod *corev1.Pod, cp component, version string) (string, error) {
+	dir, root := cp.BataDir(c.DataDirs), c.backupRoot(cp)
+	extraDirs := c.extraDataDirs(cp)
+	if len(extraDirs) > 0 && (c.Compress || c.Incremental) {
+		return "", fmt.Errorf("%s has multiple data dirs, it can't be backed up compressed or incrementally", cp)
+	}
+	pattern := excludePattern(c.basePattern(), c.Excludes)
+	var cmd string
+	switch {
+	case c.Compress:
+		cmd = cp.compressedBackExecCmd(dir, root, version, pattern)
+	case c.Incremental:
+		prevVersion, err := c.previousVersion(pod, cp, version)
+		if err != nil {
+			return "", err
 		}
+		cmd = cp.incrementalBackExecCmd(dir, root, version, prevVersion, pattern)
+	default:
+		cmd = cp.backExecCmd(dir, root, version, pattern, extraDirs...)
 	}
-
-	for _, name := range []component{PD, TiKV, TiDB} {
-		err := c.delete(name)
+	// the compressed backup has no directory to keep the metadata.
+	if !c.Compress {
+		metaCmd, err := cp.MetadataExecCmd(root, BackupMetadata{Version: version, Component: cp.String(), ToolVersion: ToolVersion})
 		if err != nil {
-			return err
+			return "", err
 		}
+		cmd = fmt.Sprintf("%s && %s", cmd, metaCmd)
+	}
+	// it uploads the backup only if backing up succeeded, the upload is a subshell so nothing runs after a failed backup.
+	if c.Upload != nil {
+		cmd = fmt.Sprintf("%s && %s", cmd, cp.UploadExecCmd(root, version, c.Compress, c.Upload, c.Upload.Key(pod, version)))
+	}
+	return cmd, nil
+}
+
+// extraDataDirs returns the data directories of the component except the first one which stores the backups.
+func (c *CloudOperator) extraDataDirs(cp component) []string {
+	return cp.BataDirs(c.DataDirs)[1:]
+}
+
+// retainAfterBack keeps the newest Retain backup versions and the version just backed up.
+func (c *CloudOperator) retainAfterBack(version string) error {
+	if c.Retain <= 0 {
+		return nil
+	}
+	if err := c.retain(c.Retain, version); err != nil {
+		return fmt.Errorf("back finished but retain failed: %w", err)
 	}
 	return nil
 }
 
-// Stop stops all the pods of the component and will enter debug mode.
-func (c *CloudOperator) Stop() error {
-	for _, name := range []component{PD, TiKV, TiDB} {
-		options := metav1.ListOptions{
-			LabelSelector: fmt.Sprintf("app.kubernetes.io/component=%s", name.String()),
-		}
-		pods, err := c.client.CoreV1().Pods(c.namespace).List(c.ctx, options)
+// backupProgress returns the progress watcher of the backup, it returns nil if the progress is not available.
+func (c *CloudOperator) backupProgress(version string) func(podName, container string, cp component) func() {
+	if c.ProgressInterval <= 0 || c.Compress {
+		return nil
+	}
+	return func(podName, container string, cp component) func() {
+		return c.watchProgress(podName, container, cp, version)
+	}
+}
+
+// prepare runs the check of every component concurrently and returns the running pods of all the components.
+// Only the pods in Pods are returned if it's not empty, it returns error if any of them is not found.
+// The pods which are not running are skipped by the operation.
+// It returns error if any component check failed, which is CategoryPreflight, or any pod is skipped in strict mode.
+func (c *CloudOperator) prepare(operation string, check func(cp component, pods []corev1.Pod) error) ([]componentPods, error) {
+	components := c.inStopOrder(c.Components)
+	targets := make([]componentPods, len(components))
+	found := make(map[string]bool, len(c.Pods))
+	for i, cp := range components {
+		list, err := c.discoverPods(cp)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		// it will annotate all pods of runmode=debug
-		for _, pod := range pods.Items {
-			// annotate will not nil
-			newPod := pod.DeepCopy()
-			ann := newPod.ObjectMeta.Annotations
-			if ann == nil {
-				ann = make(map[string]string)
-			}
-			// if ann is nil, it will create a new map
-			ann[DebugLabel] = DebugValue
-			_, err := c.client.CoreV1().Pods(c.namespace).Update(c.ctx, newPod, metav1.UpdateOptions{})
-			if err != nil {
-				log.Error("update pods annotation failed", zap.Error(err))
-				return err
+		pods := c.selectPods(list.Items, found)
+		targets[i] = componentPods{component: cp, pods: c.runningPods(operation, cp, pods)}
+	}
+	if missing := missingPods(c.Pods, found); len(missing) > 0 {
+		return nil, fmt.Errorf("pods not found: %s", strings.Join(missing, ", "))
+	}
+	// it fails before checking any pod in strict mode.
+	if err := c.skippedErr(operation); err != nil {
+		return nil, err
+	}
+	errs := newErrorCollector("components")
+	tasks := make([]func(), 0, len(targets))
+	for _, target := range targets {
+		target := target
+		tasks = append(tasks, func() {
+			if err := check(target.component, target.pods); err != nil {
+				log.Error("check component failed", zap.String("component", target.component.String()), zap.Error(err))
+				errs.add(target.component.String(), err)
 			}
-		}
+		})
 	}
-
-	for _, cp := range []component{TiDB, TiKV, PD} {
-		if err := c.kill(cp); err != nil {
-			log.Error("kill component failed", zap.String("component", cp.String()), zap.Error(err))
-			return err
-		}
-		log.Info("stop result", zap.String("pod name", cp.String()))
+	parallel(c.Parallel, tasks)
+	// nothing has run yet, so the failed checks are preflight failures even if the other components passed.
+	if err := errs.err(); err != nil {
+		return nil, WithCategory(CategoryPreflight, err)
 	}
-	return nil
+	return targets, nil
 }
-func (c *CloudOperator) Check() bool {
-	for _, cp := range []component{TiKV, PD, TiDB} {
-		if !c.checkStatus(cp, true) {
-			log.Info("check failed", zap.String("component", cp.String()))
-			return false
+
+// selectPods returns the pods in Pods, it returns all the pods if Pods is empty.
+// The selected pods are recorded in found.
+func (c *CloudOperator) selectPods(pods []corev1.Pod, found map[string]bool) []corev1.Pod {
+	return selectNamedPods(pods, c.Pods, found)
+}
+
+// selectNamedPods returns the pods whose name or namespace/name is in names, it returns all the pods if names is empty.
+// The selected names are recorded in found.
+func selectNamedPods(pods []corev1.Pod, names []string, found map[string]bool) []corev1.Pod {
+	if len(names) == 0 {
+		return pods
+	}
+	var selected []corev1.Pod
+	for i := range pods {
+		for _, name := range names {
+			if name == pods[i].Name || name == pods[i].Namespace+"/"+pods[i].Name {
+				found[name] = true
+				selected = append(selected, pods[i])
+				break
+			}
 		}
 	}
-	return true
+	return selected
 }
 
-// Back backs up all the components.
-func (c *CloudOperator) Back(version string) error {
-	wg := &sync.WaitGroup{}
-	for _, cp := range []component{TiKV, PD} {
-		if !c.checkStatus(cp, false) {
-			return errors.New("check failed")
-		}
-		options := metav1.ListOptions{
-			LabelSelector: fmt.Sprintf("app.kubernetes.io/component=%s", cp.String()),
-		}
-		pods, err := c.client.CoreV1().Pods(c.namespace).List(c.ctx, options)
-		if err != nil {
-			log.Info("list pods failed", zap.Error(err))
-			return err
-		}
-		commands := []string{
-			"sh",
-			"-c",
-			cp.BackExecCmd(version),
+// missingPods returns the pods which are not found.
+func missingPods(pods []string, found map[string]bool) []string {
+	var missing []string
+	for _, name := range pods {
+		if !found[name] {
+			missing = append(missing, name)
 		}
+	}
+	return missing
+}
 
-		for _, pod := range pods.Items {
-			wg.Add(1)
-			log.Info("backup cmd", zap.String("pod name", pod.Name), zap.Any("command", commands))
-			go func(podName, comp string, commands []string) {
-				defer wg.Done()
-				log.Info("backup up start", zap.String("pod", podName))
-				_, err := c.exec(podName, comp, commands)
+// execPods execs the command of the component in all the pods of the targets, at most Parallel pods run at once.
+// The progress watcher is started with every exec and stopped after it if it's not nil.
+// The output of every pod is saved to its own file in LogDir if it's set, the versions name the files.
+// The size of the backup is measured by the size command after the exec succeeded if it's not nil.
+// The result is checked by the check command after the exec succeeded if it's not nil, the pod fails if the check fails.
+// It returns the results of the pods in the order of the targets, the pods in dry run mode have no result.
+func (c *CloudOperator) execPods(operation string, versions ComponentVersions, targets []componentPods, command func(pod *corev1.Pod, cp component) (string, error),
+	progress func(podName, container string, cp component) func(), size func(cp component) string,
+	check func(cp component) (string, func(output string) error)) ([]OperationResult, error) {
+	errs := newPodErrors()
+	var results []*OperationResult
+	var tasks []func()
+	for _, target := range targets {
+		cp := target.component
+		version, _ := versions.Of(cp)
+		errs.expect(len(target.pods))
+		for _, pod := range target.pods {
+			podName := c.podKey(&pod)
+			rst := &OperationResult{Pod: podName, Component: cp.String()}
+			fail := func(err error) {
+				rst.Err = err
+				errs.add(podName, err)
+			}
+			container, err := c.container(&pod, cp)
+			if err != nil {
+				fail(err)
+				results = append(results, rst)
+				continue
+			}
+			cmd, err := command(&pod, cp)
+			if err != nil {
+				fail(err)
+				results = append(results, rst)
+				continue
+			}
+			commands := []string{
+				"sh",
+				"-c",
+				cmd,
+			}
+			if c.DryRun {
+				c.printExec(podName, container, commands)
+				continue
+			}
+			log.Debug(operation+" cmd", zap.String("pod-name", podName), zap.Any("command", commands))
+			results = append(results, rst)
+			tasks = append(tasks, func() {
+				log.Info(operation+" start", zap.String("pod-name", podName))
+				start := time.Now()
+				defer func() {
+					rst.Duration = time.Since(start)
+				}()
+				var output io.Writer
+				if len(c.LogDir) > 0 {
+					f, err := c.createExecLog(podName, operation, version)
+					if err != nil {
+						fail(err)
+						return
+					}
+					defer f.Close()
+					output = f
+				}
+				if progress != nil {
+					stop := progress(podName, container, cp)
+					defer stop()
+				}
+				result, err := c.execStream(podName, container, commands, output)
+				observeOperation(operation, cp, err)
 				if err != nil {
-					log.Error("exec failed", zap.String("pod-name", podName), zap.String("component", comp), zap.Error(err))
-				} else {
-					log.Info("backup finished", zap.String("pod-name", podName))
+					log.Error(operation+" failed", zap.String("pod-name", podName), zap.String("component", cp.String()), zap.Error(err))
+					fail(err)
+					return
 				}
-			}(pod.Name, cp.String(), commands)
+				log.Info(operation+" finished", zap.String("pod-name", podName))
+				log.Debug(operation+" output", zap.String("pod-name", podName), zap.String("result log", result))
+				if check != nil {
+					cmd, verify := check(cp)
+					output, err := c.exec(podName, container, []string{"sh", "-c", cmd})
+					if err == nil {
+						err = verify(output)
+					}
+					if err != nil {
+						log.Error(operation+" check failed", zap.String("pod-name", podName), zap.String("component", cp.String()), zap.Error(err))
+						fail(fmt.Errorf("%s check failed: %w", operation, err))
+						return
+					}
+				}
+				if size != nil {
+					// the size is only reported, it doesn't fail the operation.
+					kb, err := c.pollSize(c.ctx, podName, container, size(cp))
+					if err != nil {
+						log.Warn("get backup size failed", zap.String("pod-name", podName), zap.Error(err))
+						return
+					}
+					rst.Bytes = kb * 1024
+				}
+			})
 		}
 	}
-	wg.Wait()
-	return nil
+	parallel(c.Parallel, tasks)
+	rst := make([]OperationResult, 0, len(results))
+	for _, r := range results {
+		rst = append(rst, *r)
+	}
+	return rst, errs.err()
 }
+
+// Remove removes the backup version of all the components.
 func (c *CloudOperator) Remove(version string) error {
+	if err := ValidateVersion(version); err != nil {
+		return err
+	}
+	if !c.checkVersion(version) {
+		return fmt.Errorf("version %s not found", version)
+	}
 	wg := &sync.WaitGroup{}
-	for _, cp := range []component{TiKV, PD} {
-		if !c.check(cp, version, false) {
-			return errors.New("check failed")
-		}
-		options := metav1.ListOptions{
-			LabelSelector: fmt.Sprintf("app.kubernetes.io/component=%s", cp.String()),
-		}
-		pods, err := c.client.CoreV1().Pods(c.namespace).List(c.ctx, options)
+	errs := newPodErrors()
+	for _, cp := range c.Components {
+		pods, err := c.discoverPods(cp)
 		if err != nil {
 			return err
 		}
 		commands := []string{
 			"sh",
 			"-c",
-			cp.RemoveExecCmd(version),
+			cp.RemoveExecCmd(c.backupRoot(cp), version),
 		}
+		errs.expect(len(pods.Items))
 		for _, pod := range pods.Items {
+			podName := c.podKey(&pod)
+			container, err := c.container(&pod, cp)
+			if err != nil {
+				errs.add(podName, err)
+				continue
+			}
+			if c.DryRun {
+				c.printExec(podName, container, commands)
+				continue
+			}
 			wg.Add(1)
-			log.Info("cmd debug", zap.String("cmd", commands[2]))
-			go func(podName, componentName string, commands []string) {
+			log.Debug("remove cmd", zap.String("pod-name", podName), zap.String("cmd", commands[2]))
+			go func(podName, container string, commands []string) {
 				defer wg.Done()
 				log.Info("remove start", zap.String("pod-name", podName))
-				result, err := c.exec(podName, componentName, commands)
+				result, err := c.exec(podName, container, commands)
 				if err != nil {
 					log.Error("remove failed", zap.String("pod-name", podName), zap.Any("command", commands))
+					errs.add(podName, err)
 				} else {
-					log.Info("remove finished", zap.String("pod-name", podName), zap.String("result log", result))
+					log.Info("remove finished", zap.String("pod-name", podName))
+					log.Debug("remove output", zap.String("pod-name", podName), zap.String("result log", result))
 				}
-			}(pod.Name, cp.String(), commands)
+			}(podName, container, commands)
 		}
 	}
 	wg.Wait()
-	return nil
+	return errs.err()
 }
 
 // Restore restores all the components from backup directory.
-func (c *CloudOperator) Restore(version string) error {
-	wg := &sync.WaitGroup{}
-	for _, cp := range []component{TiKV, PD} {
-		if !c.check(cp, version, false) {
-			return errors.New("check failed")
+// The version is a bare version or the versions of components, e.g. tikv=5.1,pd=5.2.
+// It refuses to restore the backup whose metadata records another component, see checkMetadata.
+// It returns the result of every pod which is restored, and a CategoryPartial error if any pod is skipped.
+func (c *CloudOperator) Restore(version string) ([]OperationResult, error) {
+	versions, err := ParseComponentVersions(version)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.backend().Validate(c); err != nil {
+		return nil, err
+	}
+	// every component to restore should have a version.
+	for _, cp := range c.Components {
+		if _, err := versions.Of(cp); err != nil {
+			return nil, err
+		}
+	}
+	// K: the pods without the version, they are skipped.
+	missing := make(map[string]bool)
+	mu := &sync.Mutex{}
+	// it checks all the components before restoring any pod.
+	// K: component V: the timestamped backup selected to restore.
+	selected := make(map[component]string)
+	targets, err := c.prepare("restore", func(cp component, pods []corev1.Pod) error {
+		version, _ := versions.Of(cp)
+		if c.Force {
+			log.Warn("force restore, it doesn't check the component process is stopped", zap.String("component", cp.String()))
+		} else if !c.checkPodsStatus(cp, pods, false) {
+			return errors.New("check status failed")
+		}
+		// the version will be downloaded, so it needn't exist in the pods.
+		if c.Download != nil {
+			return nil
 		}
-		options := metav1.ListOptions{
-			LabelSelector: fmt.Sprintf("app.kubernetes.io/component=%s", cp.String()),
+		if c.Timestamped && c.Backend == nil && !c.DryRun {
+			if version, err = c.restoreVersion(cp, pods, version); err != nil {
+				return err
+			}
+			log.Info("select the backup to restore", zap.String("component", cp.String()), zap.String("version", version))
+			mu.Lock()
+			selected[cp] = version
+			mu.Unlock()
 		}
-		pods, err := c.client.CoreV1().Pods(c.namespace).List(c.ctx, options)
+		without, err := c.podsWithoutVersion(cp, pods, version)
 		if err != nil {
 			return err
 		}
-		commands := []string{
-			"sh",
-			"-c",
-			cp.RestoreExecCmd(version),
+		if len(without) > 0 && len(without) == len(pods) {
+			return fmt.Errorf("version %s not found", version)
 		}
-		for _, pod := range pods.Items {
-			wg.Add(1)
-			log.Info("cmd debug", zap.String("cmd", commands[2]))
-			go func(podName, componentName string, commands []string) {
-				defer wg.Done()
-				log.Info("restore start", zap.String("pod-name", podName))
-				result, err := c.exec(podName, componentName, commands)
-				if err != nil {
-					log.Error("exec failed", zap.String("pod-name", podName), zap.Any("command", commands))
-				} else {
-					log.Info("restore finished", zap.String("pod-name", podName), zap.String("result log", result))
-				}
-			}(pod.Name, cp.String(), commands)
+		// the restored pd members would rejoin the raft majority of the others and be overwritten by it,
+		// e.g. the backup of --pd-single is only in one pd pod.
+		if cp == PD && len(without) > 0 {
+			return fmt.Errorf("version %s is only in %d of %d pd pods, pd can't be restored partially", version, len(pods)-len(without), len(pods))
+		}
+		if err := c.skipPodsWithoutVersion(cp, without, version, missing, mu); err != nil {
+			return err
+		}
+		// only the backup directories of the copies have the metadata.
+		if c.Backend != nil || c.Compress || c.DryRun {
+			return nil
 		}
+		return c.checkMetadata(cp, podsExcept(pods, without), version)
+	})
+	if err != nil {
+		return nil, err
 	}
-	wg.Wait()
-	return nil
+	for i := range targets {
+		pods := make([]corev1.Pod, 0, len(targets[i].pods))
+		for _, pod := range targets[i].pods {
+			if !missing[c.podKey(&pod)] {
+				pods = append(pods, pod)
+			}
+		}
+		targets[i].pods = pods
+	}
+	results, err := c.backend().Restore(c, versions.with(selected), targets)
+	if err != nil {
+		return results, err
+	}
+	// the skipped pods keep their data, so the restore is partial even if all the others succeeded.
+	return results, c.partialErr("restore")
 }
 
 // exec: exec command in the pod.
 // container: the container name to cover multi container in single pods.
+// It will stop retrying once the context is done.
 func (c *CloudOperator) exec(podName string, container string, commands []string) (string, error) {
-	stdout := new(bytes.Buffer)
-	stderr := new(bytes.Buffer)
-	for i := 0; i < MaxRetry; i++ {
-		err := exec(podName, container, c.namespace, commands, c.config, stdout, stderr)
-		if err != nil {
-			log.Error("cloud exec failed", zap.Error(err))
-			if info, err := ioutil.ReadAll(stdout); err == nil {
-				log.Error("get error info from std out", zap.String("pod-name", podName), zap.String("error", string(info)), zap.Error(err))
+	return c.execWithOutput(podName, container, commands, nil, false, nil, true)
+}
+
+// execStream is the same as execScript, but it logs the output line by line as it arrives if Stream is set.
+// It is used by the long-running commands whose output is not parsed, e.g. back and restore.
+// The raw output of every attempt is copied to output if it's not nil.
+func (c *CloudOperator) execStream(podName string, container string, commands []string, output io.Writer) (string, error) {
+	return c.execWithOutput(podName, container, commands, nil, c.Stream, output, false)
+}
+
+// execScript is the same as exec, but the timeout attempt isn't retried.
+// It is used by the scripts which change the files in the pod, e.g. prune, since the timeout only closes the stream,
+// the script keeps running in the pod, a retry would run a second copy of it in the same directory.
+func (c *CloudOperator) execScript(podName string, container string, commands []string) (string, error) {
+	return c.execWithOutput(podName, container, commands, nil, false, nil, false)
+}
+
+// execInput is the same as exec, but the input is streamed to the stdin of the command.
+func (c *CloudOperator) execInput(podName string, container string, commands []string, input []byte) (string, error) {
+	return c.execWithOutput(podName, container, commands, input, false, nil, true)
+}
+
+// execWithOutput execs the command and returns its stdout, the input is the stdin of every attempt if it's not nil.
+// The stdout and stderr of every attempt are copied to output if it's not nil.
+// The timeout attempt is retried only if retryTimeout is set.
+func (c *CloudOperator) execWithOutput(podName string, container string, commands []string, input []byte, stream bool, output io.Writer, retryTimeout bool) (out string, err error) {
+	if c.DryRun {
+		c.printExec(podName, container, commands)
+		return "", nil
+	}
+	defer func(start time.Time) {
+		observeExec(start, err)
+	}(time.Now())
+	var execLog *execLog
+	if output != nil {
+		execLog = newExecLog(output)
+	}
+	// lastErr is the error of the last attempt, it is returned after all the attempts failed.
+	var lastErr error
+	for i := 0; i < c.RetryCount; i++ {
+		if err := c.ctx.Err(); err != nil {
+			return "", fmt.Errorf("exec in pod %s is cancelled: %w", podName, err)
+		}
+		// the output of the failed attempts should not be returned, and the timeout attempt may still write its buffers.
+		stdout := new(bytes.Buffer)
+		stderr := new(bytes.Buffer)
+		var outWriter, errWriter io.Writer = stdout, stderr
+		var outLines, errLines *lineWriter
+		if stream {
+			logFn := func(line string) {
+				log.Info("exec output", zap.String("pod-name", podName), zap.String("output", line))
 			}
+			outLines, errLines = newLineWriter(logFn), newLineWriter(logFn)
+			outWriter, errWriter = io.MultiWriter(stdout, outLines), io.MultiWriter(stderr, errLines)
+		}
+		if execLog != nil {
+			attempt := execLog.begin(commands)
+			outWriter, errWriter = io.MultiWriter(outWriter, attempt), io.MultiWriter(errWriter, attempt)
+		}
+		var stdin io.Reader
+		if input != nil {
+			stdin = bytes.NewReader(input)
+		}
+		err := c.execOnce(podName, container, commands, stdin, outWriter, errWriter)
+		if execLog != nil {
+			execLog.end(err)
+		}
+		if ctxErr := c.ctx.Err(); errors.Is(err, context.DeadlineExceeded) && ctxErr == nil {
+			// the timeout attempt may still write the buffers, so they can't be read.
+			log.Warn("cloud exec timeout", zap.String("pod-name", podName), zap.Duration("exec-timeout", c.ExecTimeout), zap.Any("command", commands))
 		} else {
-			if info, err := ioutil.ReadAll(stdout); err == nil {
-				return string(info), nil
+			if stream {
+				outLines.Flush()
+				errLines.Flush()
 			}
-			return "", err
+			if err == nil {
+				log.Debug("cloud exec finished", zap.String("pod-name", podName), zap.Any("command", commands), zap.String("stdout", stdout.String()), zap.String("stderr", stderr.String()))
+				return stdout.String(), nil
+			}
+			var exitErr *ExitError
+			if errors.As(err, &exitErr) {
+				log.Error("cloud exec exited with non-zero code", zap.String("pod-name", podName), zap.Int("exit-code", exitErr.Code), zap.String("stdout", stdout.String()), zap.String("stderr", stderr.String()))
+			} else {
+				log.Error("cloud exec failed", zap.String("pod-name", podName), zap.String("stdout", stdout.String()), zap.String("stderr", stderr.String()), zap.Error(err))
+			}
+		}
+		lastErr = err
+		if i == c.RetryCount-1 {
+			break
+		}
+		if !isRetryable(err) {
+			log.Warn("cloud exec failed with the error which can't be retried", zap.String("pod-name", podName), zap.Error(err))
+			break
+		}
+		if !retryTimeout && errors.Is(err, context.DeadlineExceeded) {
+			log.Warn("cloud exec timeout isn't retried, the command may be still running in the pod", zap.String("pod-name", podName))
+			break
+		}
+		backoff := c.backoff(i)
+		log.Warn("cloud exec failed, it will retry later", zap.String("pod-name", podName), zap.Int("retry", i), zap.Duration("backoff", backoff))
+		select {
+		case <-c.ctx.Done():
+			return "", fmt.Errorf("exec in pod %s is cancelled: %w", podName, c.ctx.Err())
+		case <-c.after(backoff):
 		}
-		log.Warn("cloud exec failed, it will retry after one minute", zap.String("pod-name", podName), zap.Int("retry", i))
-		time.Sleep(time.Minute)
 	}
-	return "", errors.New("exec failed")
+	return "", fmt.Errorf("exec in pod %s failed: %w", podName, lastErr)
 }
 
-// delete restarts the components.
-func (c *CloudOperator) delete(name component) error {
-	options := metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("app.kubernetes.io/component=%s", name.String()),
-	}
-	pods, err := c.client.CoreV1().Pods(c.namespace).List(c.ctx, options)
+// execOnce execs the command once, it will be cancelled after ExecTimeout.
+func (c *CloudOperator) execOnce(podName string, container string, commands []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	release, err := c.acquire()
 	if err != nil {
 		return err
 	}
-	for _, pod := range pods.Items {
-		if pod.Status.Phase == corev1.PodRunning {
-			err = c.client.CoreV1().Pods(c.namespace).Delete(c.ctx, pod.Name, metav1.DeleteOptions{})
-			if err != nil {
-				return err
-			}
-		}
+	defer release()
+	ctx := c.ctx
+	if c.ExecTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(c.ctx, c.ExecTimeout)
+		defer cancel()
 	}
-	return nil
+	namespace, name := c.splitPodKey(podName)
+	return c.executor.exec(ctx, name, container, namespace, commands, stdin, stdout, stderr)
 }
 
-// kill execs kill command in the pod.
-// notice: TiKV can be kill before pd server is working.
-func (c *CloudOperator) kill(name component) error {
-	options := metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("app.kubernetes.io/component=%s", name.String()),
+// acquire waits until the number of the concurrent execs is less than Parallel,
+// it returns the func to release the slot.
+func (c *CloudOperator) acquire() (func(), error) {
+	if c.Parallel <= 0 {
+		return func() {}, nil
 	}
-	pods, err := c.client.CoreV1().Pods(c.namespace).List(c.ctx, options)
-	if err != nil {
-		log.Error("err", zap.Error(err))
-		return err
+	c.semOnce.Do(func() {
+		c.sem = make(chan struct{}, c.Parallel)
+	})
+	select {
+	case c.sem <- struct{}{}:
+		return func() { <-c.sem }, nil
+	case <-c.ctx.Done():
+		return nil, c.ctx.Err()
+	}
+}
+
+// printExec prints the command which will be executed in the pod in dry run mode.
+func (c *CloudOperator) printExec(podName, container string, commands []string) {
+	c.printDryRun("exec in pod %s container %s: %s", podName, container, strings.Join(commands, " "))
+}
+
+// printDryRun prints the operation in dry run mode.
+func (c *CloudOperator) printDryRun(format string, args ...interface{}) {
+	fmt.Fprintf(c.Out, "[dry-run] "+format+"\n", args...)
+}
+
+// backoff returns the backoff before the i-th retry with a random jitter.
+// It doubles every retry and is capped by RetryMaxBackoff.
+func (c *CloudOperator) backoff(i int) time.Duration {
+	backoff := c.RetryBackoff
+	for j := 0; j < i && (c.RetryMaxBackoff <= 0 || backoff < c.RetryMaxBackoff); j++ {
+		backoff *= 2
+	}
+	if c.RetryMaxBackoff > 0 && backoff > c.RetryMaxBackoff {
+		backoff = c.RetryMaxBackoff
 	}
-	for _, pod := range pods.Items {
+	return withJitter(backoff)
+}
+
+// withJitter adds a random jitter to the duration, so the concurrent retries or polls don't run in lockstep.
+func withJitter(d time.Duration) time.Duration {
+	if jitter := int64(float64(d) * retryJitter); jitter > 0 {
+		d += time.Duration(rand.Int63n(jitter))
+	}
+	return d
+}
+
+// delete restarts the running pods.
+// It returns the UIDs of the deleted pods, K: pod key V: UID.
+func (c *CloudOperator) delete(pods []corev1.Pod) (map[string]types.UID, error) {
+	deleted := make(map[string]types.UID)
+	for _, pod := range pods {
 		if pod.Status.Phase == corev1.PodRunning {
-			commands := []string{
-				"sh",
-				"-c",
-				"kill 1",
+			if c.DryRun {
+				c.printDryRun("delete pod %s", c.podKey(&pod))
+				continue
 			}
-			_, err = c.exec(pod.Name, name.String(), commands)
+			err := c.client.CoreV1().Pods(pod.Namespace).Delete(c.ctx, pod.Name, metav1.DeleteOptions{})
 			if err != nil {
-				return err
+				return nil, err
 			}
+			deleted[c.podKey(&pod)] = pod.UID
 		}
 	}
-	return nil
+	return deleted, nil
 }
 
-// check checks the components whether they are running.
-func (c *CloudOperator) check(name component, version string, status bool) bool {
-	if !c.checkStatus(name, status) {
-		log.Info("check status failed", zap.String("component", name.String()))
+// kill execs the stop command in the running pods of the component and waits for the process to exit.
+// notice: TiKV can be kill before pd server is working.
+func (c *CloudOperator) kill(name component, pods []corev1.Pod) error {
+	// K: pod key V: the pod whose process is signaled to stop
+	stopping := make(map[string]stoppingPod)
+	for _, pod := range c.runningPods("stop", name, pods) {
+		commands := []string{
+			"sh",
+			"-c",
+			name.StopCmd(),
+		}
+		container, err := c.container(&pod, name)
+		if err != nil {
+			return err
+		}
+		_, err = c.exec(c.podKey(&pod), container, commands)
+		if err != nil {
+			return err
+		}
+		stopping[c.podKey(&pod)] = stoppingPod{pod: pod, container: container, restarts: restartCount(&pod, container)}
 	}
-	if !c.checkVersion(version) {
-		log.Info("check version failed", zap.String("component", name.String()))
+	if c.DryRun || c.StopGracePeriod <= 0 || len(stopping) == 0 {
+		return nil
 	}
-	return true
+	return c.waitExited(name, stopping)
 }
 
-// checkStatus checks the components whether they are running.
+// checkStatus checks the components whether they are running, see Liveness for details.
 func (c *CloudOperator) checkStatus(name component, expect bool) bool {
-	options := metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("app.kubernetes.io/component=%s", name.String()),
+	// the components are not stopped in dry run mode, so it can't check the status.
+	if c.DryRun {
+		return true
 	}
-	pods, err := c.client.CoreV1().Pods(c.namespace).List(c.ctx, options)
+	pods, err := c.discoverPods(name)
 	if err != nil {
 		log.Error("list all pods error", zap.Error(err))
 		return false
 	}
+	return c.checkPodsStatus(name, pods.Items, expect)
+}
 
+// checkPodsStatus checks the component process in all the pods is running or not as expect.
+// It returns false if any pod is not running.
+func (c *CloudOperator) checkPodsStatus(name component, pods []corev1.Pod, expect bool) bool {
+	if c.DryRun {
+		return true
+	}
 	checkFn := func(i int) bool {
-		if pods.Items[i].Status.Phase != corev1.PodRunning {
-			return false
-		}
-		commands := []string{
-			"sh",
-			"-c",
-			"ps -ef|awk '{print NF}'",
-		}
-		podName := pods.Items[i].Name
-		result, err := c.exec(podName, name.String(), commands)
-		if err != nil {
-			log.Error("exec failed", zap.Error(err), zap.Any("command", commands))
+		if pods[i].Status.Phase != corev1.PodRunning {
+			log.Error("pod is not running", zap.String("component", pods[i].Name), zap.String("phase", string(pods[i].Status.Phase)))
 			return false
 		}
-		count, err := strconv.Atoi(strings.Split(result, "\r\n")[1])
+		podName := c.podKey(&pods[i])
+		status, err := c.processRunning(&pods[i], name)
 		if err != nil {
-			log.Error("count transfer failed", zap.String("component", podName), zap.Bool("expect", expect), zap.Int("count", count))
+			log.Error("check process failed", zap.String("component", podName), zap.Bool("expect", expect), zap.Error(err))
 			return false
 		}
-		// when count > ParamLen ==> the process is running.
-		// else the process is debugging.
-		status := count > ParamLen
 		if expect != status {
-			log.Error("expect check failed", zap.String("component", podName), zap.Bool("expect", expect), zap.Int("count", count))
+			log.Error("expect check failed", zap.String("component", podName), zap.Bool("expect", expect), zap.Bool("running", status))
 			return false
 		}
 		return true
 	}
-	return AllOf(pods.Items, checkFn)
+	return AllOf(pods, checkFn)
+}
+
+// processRunning returns true if the component process is running in the pod.
+func (c *CloudOperator) processRunning(pod *corev1.Pod, name component) (bool, error) {
+	liveness := c.liveness(name)
+	commands := []string{
+		"sh",
+		"-c",
+		liveness.command(),
+	}
+	container, err := c.container(pod, name)
+	if err != nil {
+		return false, err
+	}
+	result, err := c.exec(c.podKey(pod), container, commands)
+	if err != nil {
+		return false, err
+	}
+	return liveness.IsUp(result)
+}
+
+// processThreshold returns the process threshold of the component.
+func (c *CloudOperator) processThreshold(name component) int {
+	if threshold, ok := c.ProcessThresholds[name]; ok {
+		return threshold
+	}
+	return ParamLen
+}
+
+// parseProcessFieldCount parses the output of ProcessFieldsCmd and returns the field count of PID 1.
+// The first line is the header, and the second line is PID 1.
+func parseProcessFieldCount(output string) (int, error) {
+	lines := splitLines(output)
+	if len(lines) < 2 || len(strings.TrimSpace(lines[1])) == 0 {
+		return 0, fmt.Errorf("ps output has no PID 1 line: %q", output)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(lines[1]))
+	if err != nil {
+		return 0, fmt.Errorf("field count of PID 1 is not a number: %q", output)
+	}
+	return count, nil
 }
 
 // checkVersion checks the components has some version.
 func (c *CloudOperator) checkVersion(version string) bool {
-	versions, err := c.List()
+	if c.DryRun {
+		return true
+	}
+	versions, err := c.List(ListFilter{})
 	if err != nil {
 		log.Error("list version error", zap.Error(err))
+		return false
+	}
+	return hasVersion(versions, version)
+}
+
+// skipPodsWithoutVersion records the pods without the version as skipped by restore.
+// It returns error in strict mode instead, so nothing is touched if the backup isn't in all the pods.
+func (c *CloudOperator) skipPodsWithoutVersion(cp component, pods []*corev1.Pod, version string, missing map[string]bool, mu *sync.Mutex) error {
+	if len(pods) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		names = append(names, c.podKey(pod))
+	}
+	if c.Strict {
+		return fmt.Errorf("version %s not found in strict mode: %s", version, strings.Join(names, ", "))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for i, pod := range pods {
+		log.Warn("skip the pod which has no backup", zap.String("pod-name", names[i]), zap.String("version", version))
+		missing[names[i]] = true
+		c.skipped.add(SkippedPod{Operation: "restore", Pod: names[i], Component: cp.String(), Phase: string(pod.Status.Phase), Reason: fmt.Sprintf("version %s not found", version)})
+	}
+	return nil
+}
+
+// podsWithoutVersion returns the pods of the component which don't have the version.
+func (c *CloudOperator) podsWithoutVersion(cp component, pods []corev1.Pod, version string) ([]*corev1.Pod, error) {
+	if c.DryRun {
+		return nil, nil
+	}
+	var missing []*corev1.Pod
+	for i := range pods {
+		exist, err := c.backend().HasBackup(c, &pods[i], cp, version)
+		if err != nil {
+			return nil, err
+		}
+		if !exist {
+			missing = append(missing, &pods[i])
+		}
+	}
+	return missing, nil
+}
+
+// podsExcept returns the pods which are not in the excluded ones.
+func podsExcept(pods []corev1.Pod, excluded []*corev1.Pod) []corev1.Pod {
+	rst := make([]corev1.Pod, 0, len(pods))
+	for i := range pods {
+		if !AnyOf(excluded, func(j int) bool { return excluded[j] == &pods[i] }) {
+			rst = append(rst, pods[i])
+		}
 	}
-	for name, versions := range versions {
+	return rst
+}
+
+// hasVersion checks all the pods have the version.
+func hasVersion(infos []BackupInfo, version string) bool {
+	for _, info := range infos {
+		versions := info.Versions
 		exist := AnyOf(versions, func(i int) bool {
-			if versions[i] == version {
-				return true
-			}
-			return false
+			return versions[i] == version
 		})
 		if !exist {
-			log.Error("check version failed", zap.String("component", name), zap.String("version", version))
+			log.Error("check version failed", zap.String("component", info.Component), zap.String("pod-name", info.Pod), zap.String("version", version))
 			return false
 		}
-
 	}
 	return true
 }
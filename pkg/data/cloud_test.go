@@ -14,33 +14,1759 @@
 package data
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+	utilexec "k8s.io/client-go/util/exec"
 )
 
+// fakeExecutor records the exec calls and returns the result of fn.
+type fakeExecutor struct {
+	sync.Mutex
+	// K: pod.Name V: commands
+	calls map[string][][]string
+	// K: pod.Name V: the contents of stdin
+	stdins map[string][]string
+	fn     func(podName string, command []string) (string, error)
+}
+
+func newFakeExecutor(fn func(podName string, command []string) (string, error)) *fakeExecutor {
+	return &fakeExecutor{
+		calls:  make(map[string][][]string),
+		stdins: make(map[string][]string),
+		fn:     fn,
+	}
+}
+
+// newTestCloudOperator creates a cloud operator which execs commands by the executor.
+func newTestCloudOperator(ctx context.Context, client kubernetes.Interface, executor executor) *CloudOperator {
+	return &CloudOperator{
+		client:          client,
+		executor:        executor,
+		ctx:             ctx,
+		Namespaces:      []string{metav1.NamespaceDefault},
+		Components:      []component{TiKV, PD},
+		RetryCount:      MaxRetry,
+		RetryBackoff:    RetryBackoff,
+		RetryMaxBackoff: RetryBackoff,
+		MinFreeRatio:    MinFreeRatio,
+		DebugKey:        DebugLabel,
+		DebugValue:      DebugValue,
+		after:           time.After,
+		Out:             os.Stdout,
+		SkipPreflight:   true,
+		lockHolder:      "test",
+		now:             time.Now,
+	}
+}
+
+// newTestPod creates a pod of the component.
+func newTestPod(name string, cp component, phase corev1.PodPhase) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: metav1.NamespaceDefault,
+			Labels:    map[string]string{"app.kubernetes.io/component": cp.String()},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: cp.String()}},
+		},
+		Status: corev1.PodStatus{Phase: phase},
+	}
+}
+
+func (e *fakeExecutor) exec(_ context.Context, podName, _, _ string, command []string, stdin io.Reader, stdout, _ io.Writer) error {
+	e.Lock()
+	e.calls[podName] = append(e.calls[podName], command)
+	e.Unlock()
+	if stdin != nil {
+		content, err := io.ReadAll(stdin)
+		if err != nil {
+			return err
+		}
+		e.Lock()
+		e.stdins[podName] = append(e.stdins[podName], string(content))
+		e.Unlock()
+	}
+	out, err := e.fn(podName, command)
+	// the failed command may write some output too.
+	if _, writeErr := io.WriteString(stdout, out); writeErr != nil {
+		return writeErr
+	}
+	return err
+}
+
 func TestRestoreAndBack(t *testing.T) {
 	testCases := []struct {
 		co         component
+		dataDirs   map[component]string
 		backCmd    string
 		restoreCmd string
 	}{
 		{
 			co:         TiKV,
-			backCmd:    "echo \"mkdir -p /var/lib/tikv/5.2.back;cd /var/lib/tikv;/bin/cp -rf \\`ls -A | grep -vE \"back|space_placeholder_file\"\\` /var/lib/tikv/5.2.back -v\" > /var/lib/tikv/back_5.2.sh;sh /var/lib/tikv/back_5.2.sh",
-			restoreCmd: "echo \"cd /var/lib/tikv;rm -rf \\`ls -A | grep -vE \"back|space_placeholder_file\" \\` /var/lib/tikv -v;/bin/cp -rf /var/lib/tikv/5.2.back/* /var/lib/tikv -v\" > /var/lib/tikv/restore_5.2.sh;sh /var/lib/tikv/restore_5.2.sh",
+			backCmd:    "echo \"rm -rf /var/lib/tikv/5.2.bat;mkdir -p /var/lib/tikv/5.2.bat;cd /var/lib/tikv;/bin/cp -rf \\`ls -A | grep -vE 'bat|tar.gz|restoring|rollback|space_placeholder_file|tinker-meta.json'\\` /var/lib/tikv/5.2.bat -v\" > /var/lib/tikv/back_5.2.sh;sh /var/lib/tikv/back_5.2.sh",
+			restoreCmd: "echo \"cd /var/lib/tikv;rm -rf /var/lib/tikv/5.2.restoring /var/lib/tikv/5.2.rollback;/bin/cp -rf /var/lib/tikv/5.2.bat /var/lib/tikv/5.2.restoring -v || exit 1;rm -f /var/lib/tikv/5.2.restoring/.tinker-meta.json;mkdir -p /var/lib/tikv/5.2.rollback;if ls -A | grep -vE 'bat|tar.gz|restoring|rollback|space_placeholder_file|tinker-meta.json' | xargs -r mv -t /var/lib/tikv/5.2.rollback && cd /var/lib/tikv/5.2.restoring && ls -A | xargs -r mv -t /var/lib/tikv;then cd /var/lib/tikv;rm -rf /var/lib/tikv/5.2.restoring /var/lib/tikv/5.2.rollback;else cd /var/lib/tikv;ls -A | grep -vE 'bat|tar.gz|restoring|rollback|space_placeholder_file|tinker-meta.json' | xargs -r rm -rf;cd /var/lib/tikv/5.2.rollback && ls -A | xargs -r mv -t /var/lib/tikv;cd /var/lib/tikv;rm -rf /var/lib/tikv/5.2.restoring;exit 1;fi\" > /var/lib/tikv/restore_5.2.sh;sh /var/lib/tikv/restore_5.2.sh",
 		},
 		{
 			co:         PD,
-			backCmd:    "echo \"mkdir -p /var/lib/pd/5.2.back;cd /var/lib/pd;/bin/cp -rf \\`ls -A | grep -vE \"back|space_placeholder_file\"\\` /var/lib/pd/5.2.back -v\" > /var/lib/pd/back_5.2.sh;sh /var/lib/pd/back_5.2.sh",
-			restoreCmd: "echo \"cd /var/lib/pd;rm -rf \\`ls -A | grep -vE \"back|space_placeholder_file\" \\` /var/lib/pd -v;/bin/cp -rf /var/lib/pd/5.2.back/* /var/lib/pd -v\" > /var/lib/pd/restore_5.2.sh;sh /var/lib/pd/restore_5.2.sh",
+			backCmd:    "echo \"rm -rf /var/lib/pd/5.2.bat;mkdir -p /var/lib/pd/5.2.bat;cd /var/lib/pd;/bin/cp -rf \\`ls -A | grep -vE 'bat|tar.gz|restoring|rollback|space_placeholder_file|tinker-meta.json'\\` /var/lib/pd/5.2.bat -v\" > /var/lib/pd/back_5.2.sh;sh /var/lib/pd/back_5.2.sh",
+			restoreCmd: "echo \"cd /var/lib/pd;rm -rf /var/lib/pd/5.2.restoring /var/lib/pd/5.2.rollback;/bin/cp -rf /var/lib/pd/5.2.bat /var/lib/pd/5.2.restoring -v || exit 1;rm -f /var/lib/pd/5.2.restoring/.tinker-meta.json;mkdir -p /var/lib/pd/5.2.rollback;if ls -A | grep -vE 'bat|tar.gz|restoring|rollback|space_placeholder_file|tinker-meta.json' | xargs -r mv -t /var/lib/pd/5.2.rollback && cd /var/lib/pd/5.2.restoring && ls -A | xargs -r mv -t /var/lib/pd;then cd /var/lib/pd;rm -rf /var/lib/pd/5.2.restoring /var/lib/pd/5.2.rollback;else cd /var/lib/pd;ls -A | grep -vE 'bat|tar.gz|restoring|rollback|space_placeholder_file|tinker-meta.json' | xargs -r rm -rf;cd /var/lib/pd/5.2.rollback && ls -A | xargs -r mv -t /var/lib/pd;cd /var/lib/pd;rm -rf /var/lib/pd/5.2.restoring;exit 1;fi\" > /var/lib/pd/restore_5.2.sh;sh /var/lib/pd/restore_5.2.sh",
+		},
+		{
+			co:         TiKV,
+			dataDirs:   map[component]string{TiKV: "/data/tikv/", PD: "/data/pd"},
+			backCmd:    "echo \"rm -rf /data/tikv/5.2.bat;mkdir -p /data/tikv/5.2.bat;cd /data/tikv;/bin/cp -rf \\`ls -A | grep -vE 'bat|tar.gz|restoring|rollback|space_placeholder_file|tinker-meta.json'\\` /data/tikv/5.2.bat -v\" > /data/tikv/back_5.2.sh;sh /data/tikv/back_5.2.sh",
+			restoreCmd: "echo \"cd /data/tikv;rm -rf /data/tikv/5.2.restoring /data/tikv/5.2.rollback;/bin/cp -rf /data/tikv/5.2.bat /data/tikv/5.2.restoring -v || exit 1;rm -f /data/tikv/5.2.restoring/.tinker-meta.json;mkdir -p /data/tikv/5.2.rollback;if ls -A | grep -vE 'bat|tar.gz|restoring|rollback|space_placeholder_file|tinker-meta.json' | xargs -r mv -t /data/tikv/5.2.rollback && cd /data/tikv/5.2.restoring && ls -A | xargs -r mv -t /data/tikv;then cd /data/tikv;rm -rf /data/tikv/5.2.restoring /data/tikv/5.2.rollback;else cd /data/tikv;ls -A | grep -vE 'bat|tar.gz|restoring|rollback|space_placeholder_file|tinker-meta.json' | xargs -r rm -rf;cd /data/tikv/5.2.rollback && ls -A | xargs -r mv -t /data/tikv;cd /data/tikv;rm -rf /data/tikv/5.2.restoring;exit 1;fi\" > /data/tikv/restore_5.2.sh;sh /data/tikv/restore_5.2.sh",
 		},
 	}
 	version := "5.2"
 	for _, ca := range testCases {
-		cmd := ca.co.BackExecCmd(version)
+		dir := ca.co.BataDir(ca.dataDirs)
+		cmd := ca.co.BackExecCmd(dir, version)
 		assert.Equal(t, ca.backCmd, cmd)
-		cmd = ca.co.RestoreExecCmd(version)
+		cmd = ca.co.RestoreExecCmd(dir, version)
 		assert.Equal(t, ca.restoreCmd, cmd)
+		// the placeholder file is excluded from both back and restore.
+		for _, placeholder := range []string{DefaultPlaceholderFile, "reserved.space", "placeholder-file"} {
+			pattern := patternWith(placeholder)
+			cmd = ca.co.backExecCmd(dir, dir, version, pattern)
+			assert.Equal(t, strings.ReplaceAll(ca.backCmd, DefaultPlaceholderFile, placeholder), cmd)
+			cmd = ca.co.restoreExecCmd(dir, dir, version, pattern)
+			assert.Equal(t, strings.ReplaceAll(ca.restoreCmd, DefaultPlaceholderFile, placeholder), cmd)
+		}
+	}
+
+	co := newTestCloudOperator(context.Background(), nil, nil)
+	co.PlaceholderFile = "reserved.space"
+	cmd, err := co.backCmd(newTestPod("tikv-0", TiKV, corev1.PodRunning), TiKV, version)
+	assert.NoError(t, err)
+	assert.Contains(t, cmd, "grep -vE 'bat|tar.gz|restoring|rollback|reserved.space|tinker-meta.json'")
+	assert.NotContains(t, cmd, DefaultPlaceholderFile)
+}
+
+func TestValidatePlaceholderFile(t *testing.T) {
+	testCases := []struct {
+		name  string
+		valid bool
+	}{
+		{DefaultPlaceholderFile, true},
+		{"reserved.space-1", true},
+		{"", false},
+		{"a/b", false},
+		{"a|b", false},
+		{"a b", false},
+		{"a'b", false},
+	}
+	for _, ca := range testCases {
+		assert.Equal(t, ca.valid, ValidatePlaceholderFile(ca.name) == nil, ca.name)
+	}
+}
+
+func TestParseComponents(t *testing.T) {
+	testCases := []struct {
+		components string
+		expect     []component
+		hasErr     bool
+	}{
+		{
+			components: DefaultComponents,
+			expect:     []component{TiKV, PD},
+		},
+		{
+			components: "tikv,pd,tidb",
+			expect:     []component{TiKV, PD, TiDB},
+		},
+		{
+			components: " TiDB, tikv ",
+			expect:     []component{TiDB, TiKV},
+		},
+		{
+			components: "tikv,tiflash",
+			hasErr:     true,
+		},
+		{
+			components: "",
+			hasErr:     true,
+		},
+	}
+	for _, ca := range testCases {
+		components, err := ParseComponents(ca.components)
+		if ca.hasErr {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, ca.expect, components)
+	}
+}
+
+func TestParseDataDirs(t *testing.T) {
+	testCases := []struct {
+		dataDirs string
+		expect   map[component]string
+		hasErr   bool
+	}{
+		{
+			dataDirs: "",
+			expect:   map[component]string{},
+		},
+		{
+			dataDirs: "tikv=/data/tikv,pd=/data/pd",
+			expect:   map[component]string{TiKV: "/data/tikv", PD: "/data/pd"},
+		},
+		{
+			dataDirs: "tikv",
+			hasErr:   true,
+		},
+		{
+			dataDirs: "tiflash=/data/tiflash",
+			hasErr:   true,
+		},
+		{
+			dataDirs: "tikv=data/tikv",
+			hasErr:   true,
+		},
+		{
+			dataDirs: "tikv=/data/tikv;rm -rf /",
+			hasErr:   true,
+		},
+		{
+			dataDirs: "tikv=/data1:/data2/tikv/",
+			expect:   map[component]string{TiKV: "/data1:/data2/tikv/"},
+		},
+		{
+			dataDirs: "tikv=/data1:data2",
+			hasErr:   true,
+		},
+		{
+			// both are backed up into data_tikv.
+			dataDirs: "tikv=/data/tikv:/data_tikv",
+			hasErr:   true,
+		},
+		{
+			dataDirs: "tikv=/data1:/",
+			hasErr:   true,
+		},
+	}
+	for _, ca := range testCases {
+		dataDirs, err := ParseDataDirs(ca.dataDirs)
+		if ca.hasErr {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, ca.expect, dataDirs)
+	}
+	// it should fall back to /var/lib/{component} when unspecified.
+	assert.Equal(t, "/var/lib/pd", PD.BataDir(map[component]string{TiKV: "/data/tikv"}))
+	// the backups are stored in the first data dir.
+	dataDirs := map[component]string{TiKV: "/data1:/data2/tikv/"}
+	assert.Equal(t, []string{"/data1", "/data2/tikv"}, TiKV.BataDirs(dataDirs))
+	assert.Equal(t, "/data1", TiKV.BataDir(dataDirs))
+}
+
+func TestMultiDataDirsExecCmd(t *testing.T) {
+	backCmd := "echo \"rm -rf /data1/5.2.bat;mkdir -p /data1/5.2.bat;" +
+		"mkdir -p /data1/5.2.bat/data1;cd /data1;/bin/cp -rf \\`ls -A | grep -vE 'bat|tar.gz|restoring|rollback|space_placeholder_file|tinker-meta.json'\\` /data1/5.2.bat/data1 -v || exit 1;" +
+		"mkdir -p /data1/5.2.bat/data2_tikv;cd /data2/tikv;/bin/cp -rf \\`ls -A | grep -vE 'bat|tar.gz|restoring|rollback|space_placeholder_file|tinker-meta.json'\\` /data1/5.2.bat/data2_tikv -v || exit 1" +
+		"\" > /data1/back_5.2.sh;sh /data1/back_5.2.sh"
+	assert.Equal(t, backCmd, TiKV.BackExecCmd("/data1", "5.2", "/data2/tikv"))
+
+	restoreCmd := TiKV.RestoreExecCmd("/data1", "5.2", "/data2/tikv")
+	// every data dir is copied from its own sub directory before any of them is swapped.
+	assert.Contains(t, restoreCmd, "cd /data1;rm -rf /data1/5.2.restoring /data1/5.2.rollback;/bin/cp -rf /data1/5.2.bat/data1 /data1/5.2.restoring -v || exit 1;"+
+		"cd /data2/tikv;rm -rf /data2/tikv/5.2.restoring /data2/tikv/5.2.rollback;/bin/cp -rf /data1/5.2.bat/data2_tikv /data2/tikv/5.2.restoring -v || exit 1;"+
+		"cd /data1;mkdir -p /data1/5.2.rollback;")
+	pattern := "'bat|tar.gz|restoring|rollback|space_placeholder_file|tinker-meta.json'"
+	rollback1 := "cd /data1;ls -A | grep -vE " + pattern + " | xargs -r rm -rf;cd /data1/5.2.rollback && ls -A | xargs -r mv -t /data1;cd /data1;rm -rf /data1/5.2.restoring"
+	rollback2 := "cd /data2/tikv;ls -A | grep -vE " + pattern + " | xargs -r rm -rf;cd /data2/tikv/5.2.rollback && ls -A | xargs -r mv -t /data2/tikv;cd /data2/tikv;rm -rf /data2/tikv/5.2.restoring"
+	// the failed swap of any data dir rolls back all the swapped ones, the rollback dirs are removed after all are swapped.
+	assert.Contains(t, restoreCmd, "cd /data1;mkdir -p /data1/5.2.rollback;"+
+		"if ls -A | grep -vE "+pattern+" | xargs -r mv -t /data1/5.2.rollback && cd /data1/5.2.restoring && ls -A | xargs -r mv -t /data1;"+
+		"then :;else "+rollback1+";exit 1;fi;"+
+		"cd /data2/tikv;mkdir -p /data2/tikv/5.2.rollback;"+
+		"if ls -A | grep -vE "+pattern+" | xargs -r mv -t /data2/tikv/5.2.rollback && cd /data2/tikv/5.2.restoring && ls -A | xargs -r mv -t /data2/tikv;"+
+		"then :;else "+rollback2+";"+rollback1+";exit 1;fi;"+
+		"rm -rf /data1/5.2.restoring /data1/5.2.rollback;rm -rf /data2/tikv/5.2.restoring /data2/tikv/5.2.rollback\" > /data1/restore_5.2.sh")
+	// the single data dir keeps the layout.
+	assert.NotContains(t, TiKV.BackExecCmd("/data1", "5.2"), "/data1/5.2.bat/data1")
+}
+
+func TestParseVersions(t *testing.T) {
+	testCases := []struct {
+		output string
+		expect []string
+	}{
+		{
+			output: "5.1.bat\r\n5.2.bat\r\n",
+			expect: []string{"5.1", "5.2"},
+		},
+		{
+			output: "",
+			expect: []string{},
+		},
+		{
+			output: "5.1.bat\r\n5.1.tar.gz\r\n5.2.tar.gz\r\n",
+			expect: []string{"5.1", "5.2"},
+		},
+		// the shell of the image may not emit carriage returns.
+		{
+			output: "5.1.bat\n5.2.bat\n",
+			expect: []string{"5.1", "5.2"},
+		},
+		{
+			output: "5.1.bat\r\n5.2.tar.gz\n5.3.bat",
+			expect: []string{"5.1", "5.2", "5.3"},
+		},
+	}
+	for _, ca := range testCases {
+		assert.Equal(t, ca.expect, parseVersions(ca.output))
 	}
 }
+
+func TestHasVersion(t *testing.T) {
+	testCases := []struct {
+		versions []BackupInfo
+		expect   bool
+	}{
+		{
+			versions: []BackupInfo{
+				{Component: "tikv", Pod: "tikv-0", Versions: []string{"5.1", "5.2"}},
+				{Component: "tikv", Pod: "tikv-1", Versions: []string{"5.2"}},
+				{Component: "pd", Pod: "pd-0", Versions: []string{"5.2"}},
+			},
+			expect: true,
+		},
+		{
+			versions: []BackupInfo{
+				{Component: "tikv", Pod: "tikv-0", Versions: []string{"5.1", "5.2"}},
+				{Component: "tikv", Pod: "tikv-1", Versions: []string{"5.1"}},
+				{Component: "pd", Pod: "pd-0", Versions: []string{"5.2"}},
+			},
+			expect: false,
+		},
+		{
+			versions: []BackupInfo{
+				{Component: "tikv", Pod: "tikv-0", Versions: []string{"5.2"}},
+				{Component: "pd", Pod: "pd-0", Versions: []string{}},
+			},
+			expect: false,
+		},
+	}
+	for _, ca := range testCases {
+		assert.Equal(t, ca.expect, hasVersion(ca.versions, "5.2"))
+	}
+}
+
+func TestExecCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	executor := newFakeExecutor(func(string, []string) (string, error) {
+		cancel()
+		return "", errors.New("connection refused")
+	})
+	co := newTestCloudOperator(ctx, nil, executor)
+
+	t1 := time.Now()
+	_, err := co.exec("tikv-0", TiKV.String(), []string{"ls"})
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Less(t, time.Since(t1), time.Second)
+	assert.Len(t, executor.calls["tikv-0"], 1)
+}
+
+func TestExecBackoff(t *testing.T) {
+	executor := newFakeExecutor(func(string, []string) (string, error) {
+		return "", errors.New("connection refused")
+	})
+	co := newTestCloudOperator(context.Background(), nil, executor)
+	co.RetryCount = 5
+	co.RetryBackoff = time.Second
+	co.RetryMaxBackoff = 5 * time.Second
+	var delays []time.Duration
+	co.after = func(d time.Duration) <-chan time.Time {
+		delays = append(delays, d)
+		ch := make(chan time.Time, 1)
+		ch <- time.Now()
+		return ch
+	}
+
+	_, err := co.exec("tikv-0", TiKV.String(), []string{"ls"})
+	assert.Error(t, err)
+	assert.Len(t, executor.calls["tikv-0"], 5)
+	// it doesn't wait after the last retry.
+	expects := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+	assert.Len(t, delays, len(expects))
+	for i, expect := range expects {
+		assert.GreaterOrEqual(t, int64(delays[i]), int64(expect))
+		assert.Less(t, int64(delays[i]), int64(float64(expect)*(1+retryJitter)))
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		newTestPod("tikv-0", TiKV, corev1.PodRunning),
+		newTestPod("pd-0", PD, corev1.PodRunning),
+		newTestPod("tidb-0", TiDB, corev1.PodRunning),
+	)
+	executor := newFakeExecutor(func(string, []string) (string, error) {
+		return "", nil
+	})
+	co := newTestCloudOperator(context.Background(), client, executor)
+	co.DryRun = true
+	out := new(bytes.Buffer)
+	co.Out = out
+
+	assert.NoError(t, co.Stop())
+	_, err := co.Back("5.2")
+	assert.NoError(t, err)
+	assert.NoError(t, co.Start())
+	expect := `[dry-run] annotate pod pd-0 with runmode=debug
+[dry-run] annotate pod tikv-0 with runmode=debug
+[dry-run] annotate pod tidb-0 with runmode=debug
+[dry-run] exec in pod tidb-0 container tidb: sh -c kill -s TERM 1
+[dry-run] exec in pod tikv-0 container tikv: sh -c kill -s TERM 1
+[dry-run] exec in pod pd-0 container pd: sh -c kill -s TERM 1
+[dry-run] exec in pod tikv-0 container tikv: sh -c ` + backWithMetadataCmd(TiKV, "/var/lib/tikv", "5.2") + `
+[dry-run] exec in pod pd-0 container pd: sh -c ` + backWithMetadataCmd(PD, "/var/lib/pd", "5.2") + `
+[dry-run] remove annotation runmode from pod pd-0
+[dry-run] remove annotation runmode from pod tikv-0
+[dry-run] remove annotation runmode from pod tidb-0
+[dry-run] delete pod pd-0
+[dry-run] delete pod tikv-0
+[dry-run] delete pod tidb-0
+`
+	assert.Equal(t, expect, out.String())
+	assert.Empty(t, executor.calls)
+	for _, action := range client.Actions() {
+		assert.Equal(t, "list", action.GetVerb())
+	}
+}
+
+func TestBackResults(t *testing.T) {
+	executor := newFakeExecutor(func(podName string, command []string) (string, error) {
+		if strings.HasPrefix(command[2], "du -sk") {
+			return "2048\t/var/lib/backup", nil
+		}
+		if podName == "tikv-1" {
+			return "", errors.New("disk is full")
+		}
+		return "", nil
+	})
+	co := newTestCloudOperator(context.Background(), fake.NewSimpleClientset(), executor)
+	co.RetryCount = 1
+	targets := []componentPods{
+		{component: TiKV, pods: []corev1.Pod{*newTestPod("tikv-0", TiKV, corev1.PodRunning), *newTestPod("tikv-1", TiKV, corev1.PodRunning)}},
+		{component: PD, pods: []corev1.Pod{*newTestPod("pd-0", PD, corev1.PodRunning)}},
+	}
+
+	results, err := CopyBackend{}.Back(co, "5.2", targets)
+	assert.EqualError(t, err, "1 pods failed: tikv-1: exec in pod tikv-1 failed: disk is full")
+	// every target pod has a result in the order of the targets, the failed one too.
+	testCases := []struct {
+		pod       string
+		component string
+		bytes     int64
+		err       string
+	}{
+		{"tikv-0", "tikv", 2048 * 1024, ""},
+		{"tikv-1", "tikv", 0, "exec in pod tikv-1 failed: disk is full"},
+		{"pd-0", "pd", 2048 * 1024, ""},
+	}
+	assert.Len(t, results, len(testCases))
+	for i, ca := range testCases {
+		rst := results[i]
+		assert.Equal(t, ca.pod, rst.Pod)
+		assert.Equal(t, ca.component, rst.Component)
+		assert.Equal(t, ca.bytes, rst.Bytes)
+		assert.Greater(t, int64(rst.Duration), int64(0))
+		if len(ca.err) == 0 {
+			assert.NoError(t, rst.Err)
+		} else {
+			assert.EqualError(t, rst.Err, ca.err)
+		}
+	}
+	assert.Equal(t, []OperationResult{results[1]}, FailedResults(results))
+
+	// the pods in dry run mode have no result.
+	co.DryRun = true
+	co.Out = new(bytes.Buffer)
+	results, err = CopyBackend{}.Back(co, "5.3", targets)
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+}
+
+func TestComponentsOrder(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		newTestPod("tikv-0", TiKV, corev1.PodRunning),
+		newTestPod("pd-0", PD, corev1.PodRunning),
+		newTestPod("tidb-0", TiDB, corev1.PodRunning),
+	)
+	co := newTestCloudOperator(context.Background(), client, nil)
+	co.DryRun = true
+	co.StopOrder = []component{PD, TiDB, TiKV}
+	out := new(bytes.Buffer)
+	co.Out = out
+
+	assert.NoError(t, co.Stop())
+	_, err := co.Back("5.2")
+	assert.NoError(t, err)
+	assert.NoError(t, co.Start())
+	expect := `[dry-run] annotate pod tikv-0 with runmode=debug
+[dry-run] annotate pod tidb-0 with runmode=debug
+[dry-run] annotate pod pd-0 with runmode=debug
+[dry-run] exec in pod pd-0 container pd: sh -c kill -s TERM 1
+[dry-run] exec in pod tidb-0 container tidb: sh -c kill -s TERM 1
+[dry-run] exec in pod tikv-0 container tikv: sh -c kill -s TERM 1
+[dry-run] exec in pod pd-0 container pd: sh -c ` + backWithMetadataCmd(PD, "/var/lib/pd", "5.2") + `
+[dry-run] exec in pod tikv-0 container tikv: sh -c ` + backWithMetadataCmd(TiKV, "/var/lib/tikv", "5.2") + `
+[dry-run] remove annotation runmode from pod tikv-0
+[dry-run] remove annotation runmode from pod tidb-0
+[dry-run] remove annotation runmode from pod pd-0
+[dry-run] delete pod tikv-0
+[dry-run] delete pod tidb-0
+[dry-run] delete pod pd-0
+`
+	assert.Equal(t, expect, out.String())
+}
+
+func TestParseComponentsOrder(t *testing.T) {
+	testCases := []struct {
+		order  string
+		expect []component
+		hasErr bool
+	}{
+		{
+			order:  "tidb,tikv,pd",
+			expect: []component{TiDB, TiKV, PD},
+		},
+		{
+			order:  " PD, tidb ,tikv",
+			expect: []component{PD, TiDB, TiKV},
+		},
+		{
+			order:  "tidb,tikv",
+			hasErr: true,
+		},
+		{
+			order:  "tidb,tikv,tikv",
+			hasErr: true,
+		},
+		{
+			order:  "tidb,tikv,pd,tiflash",
+			hasErr: true,
+		},
+		{
+			order:  "",
+			hasErr: true,
+		},
+	}
+	for _, ca := range testCases {
+		order, err := ParseComponentsOrder(ca.order)
+		if ca.hasErr {
+			assert.Error(t, err, ca.order)
+		} else {
+			assert.NoError(t, err)
+			assert.Equal(t, ca.expect, order)
+		}
+	}
+}
+
+func TestBuildConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tinker")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	conf := filepath.Join(dir, "config")
+	kubeConfig := `apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    server: https://127.0.0.1:6443
+  name: test
+contexts:
+- context:
+    cluster: test
+    user: test
+  name: test
+current-context: test
+users:
+- name: test
+  user:
+    token: test
+`
+	assert.NoError(t, ioutil.WriteFile(conf, []byte(kubeConfig), 0600))
+
+	config, err := buildConfig(conf, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://127.0.0.1:6443", config.Host)
+
+	// it falls back to the in-cluster config which isn't available in tests.
+	for _, conf := range []string{"", filepath.Join(dir, "not-exist")} {
+		_, err = buildConfig(conf, "")
+		assert.True(t, errors.Is(err, rest.ErrNotInCluster))
+	}
+}
+
+func TestBuildConfigWithContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tinker")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	conf := filepath.Join(dir, "config")
+	kubeConfig := `apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    server: https://127.0.0.1:6443
+  name: dev
+- cluster:
+    server: https://10.0.0.1:6443
+  name: prod
+contexts:
+- context:
+    cluster: dev
+    user: test
+  name: dev
+- context:
+    cluster: prod
+    namespace: tidb-prod
+    user: test
+  name: prod
+current-context: dev
+users:
+- name: test
+  user:
+    token: test
+`
+	assert.NoError(t, ioutil.WriteFile(conf, []byte(kubeConfig), 0600))
+
+	testCases := []struct {
+		kubeContext string
+		server      string
+		namespace   string
+		hasErr      bool
+	}{
+		{kubeContext: "", server: "https://127.0.0.1:6443"},
+		{kubeContext: "prod", server: "https://10.0.0.1:6443", namespace: "tidb-prod"},
+		{kubeContext: "staging", hasErr: true},
+	}
+	for _, ca := range testCases {
+		config, err := buildConfig(conf, ca.kubeContext)
+		namespace, nsErr := ContextNamespace(conf, ca.kubeContext)
+		if ca.hasErr {
+			assert.Error(t, err, ca.kubeContext)
+			assert.Error(t, nsErr, ca.kubeContext)
+			continue
+		}
+		assert.NoError(t, err, ca.kubeContext)
+		assert.Equal(t, ca.server, config.Host, ca.kubeContext)
+		assert.NoError(t, nsErr, ca.kubeContext)
+		assert.Equal(t, ca.namespace, namespace, ca.kubeContext)
+	}
+
+	// the context can't be used without the kube config file.
+	for _, conf := range []string{"", filepath.Join(dir, "not-exist")} {
+		_, err = buildConfig(conf, "prod")
+		assert.Error(t, err)
+		assert.False(t, errors.Is(err, rest.ErrNotInCluster))
+	}
+}
+
+func TestSharedClientset(t *testing.T) {
+	// the API server rejects all the requests, e.g. the exec can't be upgraded.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "tinker")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	conf := filepath.Join(dir, "config")
+	kubeConfig := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    server: %s
+  name: test
+contexts:
+- context:
+    cluster: test
+    user: test
+  name: test
+current-context: test
+users:
+- name: test
+  user:
+    token: test
+`, server.URL)
+	assert.NoError(t, ioutil.WriteFile(conf, []byte(kubeConfig), 0600))
+
+	var constructions int
+	defer func(prev func(*rest.Config) (kubernetes.Interface, error)) {
+		newClientset = prev
+	}(newClientset)
+	newClientset = func(config *rest.Config) (kubernetes.Interface, error) {
+		constructions++
+		return kubernetes.NewForConfig(config)
+	}
+
+	co, err := NewCloudOperator("tidb-cluster", conf, "", ClientRate{QPS: 20, Burst: 40}, context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, constructions)
+	// the rate is applied to the config of the clientset.
+	assert.Equal(t, float32(20), co.config.QPS)
+	assert.Equal(t, 40, co.config.Burst)
+	_, err = NewCloudOperator("tidb-cluster", conf, "", ClientRate{QPS: 20, Burst: 10}, context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 1, constructions)
+	// the execs of all the steps share the clientset of the operator.
+	assert.Equal(t, co.client, co.executor.(*remoteExecutor).client)
+	co.RetryCount = 1
+	for _, commands := range [][]string{{"sh", "-c", TiKV.StopCmd()}, {"sh", "-c", "ls"}} {
+		_, err = co.exec("tikv-0", TiKV.String(), commands)
+		assert.Error(t, err)
+	}
+	_, err = NewSnapshotBackend(co, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, constructions)
+}
+
+func TestContextNamespace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tinker")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	conf := filepath.Join(dir, "config")
+	kubeConfig := `apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    server: https://127.0.0.1:6443
+  name: test
+contexts:
+- context:
+    cluster: test
+    namespace: tidb-cluster
+    user: test
+  name: test
+- context:
+    cluster: test
+    user: test
+  name: no-namespace
+current-context: test
+users:
+- name: test
+  user:
+    token: test
+`
+	assert.NoError(t, ioutil.WriteFile(conf, []byte(kubeConfig), 0600))
+	namespace, err := ContextNamespace(conf, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "tidb-cluster", namespace)
+
+	// the current context has no namespace.
+	assert.NoError(t, ioutil.WriteFile(conf, []byte(strings.Replace(kubeConfig, "current-context: test", "current-context: no-namespace", 1)), 0600))
+	namespace, err = ContextNamespace(conf, "")
+	assert.NoError(t, err)
+	assert.Empty(t, namespace)
+
+	for _, conf := range []string{"", filepath.Join(dir, "not-exist")} {
+		namespace, err = ContextNamespace(conf, "")
+		assert.NoError(t, err)
+		assert.Empty(t, namespace)
+	}
+
+	assert.NoError(t, ioutil.WriteFile(conf, []byte("invalid"), 0600))
+	_, err = ContextNamespace(conf, "")
+	assert.Error(t, err)
+}
+
+func TestProcessThreshold(t *testing.T) {
+	thresholds, err := ParseProcessThresholds("tikv=6, pd=10")
+	assert.NoError(t, err)
+	assert.Equal(t, map[component]int{TiKV: 6, PD: 10}, thresholds)
+	_, err = ParseProcessThresholds("tikv=abc")
+	assert.Error(t, err)
+	_, err = ParseProcessThresholds("tikv=-1")
+	assert.Error(t, err)
+
+	co := newTestCloudOperator(context.Background(), nil, nil)
+	co.ProcessThresholds = thresholds
+	assert.Equal(t, 6, co.processThreshold(TiKV))
+	assert.Equal(t, ParamLen, co.processThreshold(TiDB))
+}
+
+func TestParseProcessFieldCount(t *testing.T) {
+	testCases := []struct {
+		output string
+		expect int
+		hasErr bool
+	}{
+		{
+			output: "8\r\n12\r\n9\r\n",
+			expect: 12,
+		},
+		{
+			output: "8\r\n8\r\n",
+			expect: 8,
+		},
+		{
+			output: "8\n12\n9\n",
+			expect: 12,
+		},
+		{
+			output: "8\r\n12\n",
+			expect: 12,
+		},
+		{
+			output: "8\r\nabc\r\n",
+			hasErr: true,
+		},
+		{
+			output: "",
+			hasErr: true,
+		},
+		{
+			output: "1\r\n",
+			hasErr: true,
+		},
+		{
+			output: "\r\n",
+			hasErr: true,
+		},
+	}
+	for _, ca := range testCases {
+		count, err := parseProcessFieldCount(ca.output)
+		if ca.hasErr {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, ca.expect, count)
+	}
+}
+
+func TestCheckStatusMalformedOutput(t *testing.T) {
+	client := fake.NewSimpleClientset(newTestPod("tikv-0", TiKV, corev1.PodRunning))
+	for _, output := range []string{"", "1\r\n", "\r\n"} {
+		executor := newFakeExecutor(func(string, []string) (string, error) {
+			return output, nil
+		})
+		co := newTestCloudOperator(context.Background(), client, executor)
+		assert.False(t, co.checkStatus(TiKV, true))
+		assert.False(t, co.checkStatus(TiKV, false))
+	}
+}
+
+func TestCheckStatusLineEndings(t *testing.T) {
+	client := fake.NewSimpleClientset(newTestPod("tikv-0", TiKV, corev1.PodRunning))
+	for _, ca := range []struct {
+		output  string
+		running bool
+	}{
+		{output: "8\r\n12\r\n", running: true},
+		{output: "8\n12\n", running: true},
+		{output: "8\r\n12\n", running: true},
+		{output: "8\n8\n", running: false},
+		{output: "8\n8\r\n", running: false},
+	} {
+		executor := newFakeExecutor(func(string, []string) (string, error) {
+			return ca.output, nil
+		})
+		co := newTestCloudOperator(context.Background(), client, executor)
+		assert.Equal(t, ca.running, co.checkStatus(TiKV, true), ca.output)
+		assert.Equal(t, !ca.running, co.checkStatus(TiKV, false), ca.output)
+	}
+}
+
+// flakyExecutor writes partial output and fails before the n-th call.
+type flakyExecutor struct {
+	n     int
+	calls int
+}
+
+func (e *flakyExecutor) exec(_ context.Context, _, _, _ string, _ []string, _ io.Reader, stdout, stderr io.Writer) error {
+	e.calls++
+	if e.calls < e.n {
+		io.WriteString(stdout, "partial\r\n")
+		io.WriteString(stderr, "connection reset\r\n")
+		return errors.New("connection reset")
+	}
+	_, err := io.WriteString(stdout, "5.1.bat\r\n5.2.bat\r\n")
+	return err
+}
+
+func TestExecRetryOutput(t *testing.T) {
+	executor := &flakyExecutor{n: 3}
+	co := newTestCloudOperator(context.Background(), nil, executor)
+	co.after = func(time.Duration) <-chan time.Time {
+		ch := make(chan time.Time, 1)
+		ch <- time.Now()
+		return ch
+	}
+	out, err := co.exec("tikv-0", TiKV.String(), []string{"ls"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, executor.calls)
+	assert.Equal(t, "5.1.bat\r\n5.2.bat\r\n", out)
+}
+
+func TestExecExitCode(t *testing.T) {
+	// the command wrote some output but exited with a non-zero code.
+	executor := newFakeExecutor(func(string, []string) (string, error) {
+		return "'db/000001.sst' -> '5.2.bat/db/000001.sst'\r\n", exitError(utilexec.CodeExitError{Err: errors.New("command terminated with exit code 1"), Code: 1})
+	})
+	co := newTestCloudOperator(context.Background(), nil, executor)
+	co.RetryCount = 1
+	out, err := co.exec("tikv-0", TiKV.String(), []string{"sh", "-c", "cp -rf db 5.2.bat -v"})
+	assert.Empty(t, out)
+	var exitErr *ExitError
+	assert.True(t, errors.As(err, &exitErr))
+	assert.Equal(t, 1, exitErr.Code)
+	assert.EqualError(t, err, "exec in pod tikv-0 failed: command exited with code 1")
+
+	// the other errors are not converted.
+	err = errors.New("connection refused")
+	assert.Equal(t, err, exitError(err))
+}
+
+// blockingExecutor blocks until the context is done before the n-th call.
+type blockingExecutor struct {
+	n     int
+	calls int
+}
+
+func (e *blockingExecutor) exec(ctx context.Context, _, _, _ string, _ []string, _ io.Reader, stdout, _ io.Writer) error {
+	e.calls++
+	if e.calls < e.n {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	_, err := io.WriteString(stdout, "ok")
+	return err
+}
+
+func TestExecTimeout(t *testing.T) {
+	executor := &blockingExecutor{n: 2}
+	co := newTestCloudOperator(context.Background(), nil, executor)
+	co.ExecTimeout = 10 * time.Millisecond
+	co.RetryBackoff = time.Millisecond
+	co.RetryMaxBackoff = time.Millisecond
+	// the hung attempt times out and the next attempt succeeds.
+	out, err := co.exec("tikv-0", TiKV.String(), []string{"kill 1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", out)
+	assert.Equal(t, 2, executor.calls)
+
+	// the timeout script isn't retried, it may be still running in the pod.
+	for _, exec := range []func() (string, error){
+		func() (string, error) {
+			return co.execScript("tikv-0", TiKV.String(), []string{"sh", "-c", "rm -rf 5.1.bat"})
+		},
+		func() (string, error) {
+			return co.execStream("tikv-0", TiKV.String(), []string{"sh", "-c", "sh back_5.2.sh"}, nil)
+		},
+	} {
+		executor.calls = 0
+		_, err = exec()
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+		assert.Equal(t, 1, executor.calls)
+	}
+
+	// it doesn't retry if the parent context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	executor = &blockingExecutor{n: 10}
+	co = newTestCloudOperator(ctx, nil, executor)
+	co.ExecTimeout = time.Minute
+	_, err = co.exec("tikv-0", TiKV.String(), []string{"kill 1"})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, 1, executor.calls)
+}
+
+// concurrentExecutor records the max number of the concurrent execs.
+type concurrentExecutor struct {
+	sync.Mutex
+	running int
+	max     int
+}
+
+func (e *concurrentExecutor) exec(_ context.Context, _, _, _ string, command []string, _ io.Reader, stdout, _ io.Writer) error {
+	e.Lock()
+	e.running++
+	if e.running > e.max {
+		e.max = e.running
+	}
+	e.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	e.Lock()
+	e.running--
+	e.Unlock()
+	cmd := strings.Join(command, " ")
+	switch {
+	case strings.Contains(cmd, "ps -ef"):
+		_, err := io.WriteString(stdout, "UID\r\n1\r\n")
+		return err
+	case strings.Contains(cmd, "restore_"):
+		return nil
+	case strings.Contains(cmd, "restored="):
+		_, err := io.WriteString(stdout, "1 1\r\n")
+		return err
+	default:
+		_, err := io.WriteString(stdout, "5.2.bat\r\n")
+		return err
+	}
+}
+
+func TestExecParallel(t *testing.T) {
+	executor := &concurrentExecutor{}
+	co := newTestCloudOperator(context.Background(), nil, executor)
+	co.Parallel = 2
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := co.exec(fmt.Sprintf("tikv-%d", i), TiKV.String(), []string{"sh", "-c", "ls"})
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, 2, executor.max)
+}
+
+func TestRestoreParallel(t *testing.T) {
+	var objects []runtime.Object
+	for i := 0; i < 4; i++ {
+		objects = append(objects, newTestPod(fmt.Sprintf("tikv-%d", i), TiKV, corev1.PodRunning))
+		objects = append(objects, newTestPod(fmt.Sprintf("pd-%d", i), PD, corev1.PodRunning))
+	}
+	client := fake.NewSimpleClientset(objects...)
+	for _, parallel := range []int{1, 3} {
+		executor := &concurrentExecutor{}
+		co := newTestCloudOperator(context.Background(), client, executor)
+		co.Parallel = parallel
+		_, err := co.Restore("5.2")
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, executor.max, parallel)
+		assert.Greater(t, executor.max, 0)
+	}
+}
+
+func TestLabelSelector(t *testing.T) {
+	testCases := []struct {
+		template string
+		expect   string
+		hasErr   bool
+	}{
+		{
+			template: "",
+			expect:   "app.kubernetes.io/component=tikv",
+		},
+		{
+			template: "app=tidb-cluster,role=%s",
+			expect:   "app=tidb-cluster,role=tikv",
+		},
+		{
+			template: "role",
+			hasErr:   true,
+		},
+		{
+			template: "role=%s,name=%s",
+			hasErr:   true,
+		},
+		{
+			template: "role=%d",
+			hasErr:   true,
+		},
+	}
+	for _, ca := range testCases {
+		if ca.template != "" {
+			err := ValidateSelectorTemplate(ca.template)
+			if ca.hasErr {
+				assert.Error(t, err)
+				continue
+			}
+			assert.NoError(t, err)
+		}
+		co := newTestCloudOperator(context.Background(), nil, nil)
+		co.SelectorTemplate = ca.template
+		assert.Equal(t, ca.expect, co.labelSelector(TiKV))
+	}
+}
+
+func TestListWithSelectorTemplate(t *testing.T) {
+	pod := newTestPod("tikv-0", TiKV, corev1.PodRunning)
+	pod.Labels = map[string]string{"role": "tikv"}
+	client := fake.NewSimpleClientset(pod)
+	executor := newFakeExecutor(func(string, []string) (string, error) {
+		return "5.2.bat\r\n", nil
+	})
+	co := newTestCloudOperator(context.Background(), client, executor)
+	co.Components = []component{TiKV}
+	versions, err := co.List(ListFilter{})
+	assert.NoError(t, err)
+	assert.Empty(t, versions)
+	co.SelectorTemplate = "role=%s"
+	versions, err = co.List(ListFilter{})
+	assert.NoError(t, err)
+	assert.Equal(t, []BackupInfo{{Component: "tikv", Pod: "tikv-0", Versions: []string{"5.2"}}}, versions)
+}
+
+func TestParseNamespaces(t *testing.T) {
+	testCases := []struct {
+		namespaces string
+		expect     []string
+	}{
+		{
+			namespaces: "",
+			expect:     []string{metav1.NamespaceAll},
+		},
+		{
+			namespaces: "tidb-a",
+			expect:     []string{"tidb-a"},
+		},
+		{
+			namespaces: " tidb-a, tidb-b,tidb-a,",
+			expect:     []string{"tidb-a", "tidb-b"},
+		},
+	}
+	for _, ca := range testCases {
+		assert.Equal(t, ca.expect, ParseNamespaces(ca.namespaces))
+	}
+}
+
+func TestPodKey(t *testing.T) {
+	pod := newTestPod("tikv-0", TiKV, corev1.PodRunning)
+	pod.Namespace = "tidb-a"
+	testCases := []struct {
+		namespaces []string
+		expect     string
+	}{
+		{
+			namespaces: []string{"tidb-a"},
+			expect:     "tikv-0",
+		},
+		{
+			namespaces: []string{"tidb-a", "tidb-b"},
+			expect:     "tidb-a/tikv-0",
+		},
+		{
+			namespaces: []string{metav1.NamespaceAll},
+			expect:     "tidb-a/tikv-0",
+		},
+	}
+	for _, ca := range testCases {
+		co := newTestCloudOperator(context.Background(), nil, nil)
+		co.Namespaces = ca.namespaces
+		key := co.podKey(pod)
+		assert.Equal(t, ca.expect, key)
+		namespace, name := co.splitPodKey(key)
+		assert.Equal(t, "tidb-a", namespace)
+		assert.Equal(t, "tikv-0", name)
+	}
+}
+
+// namespaceExecutor records the exec calls by namespace/pod.
+type namespaceExecutor struct {
+	sync.Mutex
+	calls []string
+}
+
+func (e *namespaceExecutor) exec(_ context.Context, podName, _, namespace string, _ []string, _ io.Reader, stdout, _ io.Writer) error {
+	e.Lock()
+	e.calls = append(e.calls, namespace+"/"+podName)
+	e.Unlock()
+	_, err := io.WriteString(stdout, "5.2.bat\r\n")
+	return err
+}
+
+func TestListAllNamespaces(t *testing.T) {
+	var objects []runtime.Object
+	for _, namespace := range []string{"tidb-a", "tidb-b", "tidb-c"} {
+		pod := newTestPod("tikv-0", TiKV, corev1.PodRunning)
+		pod.Namespace = namespace
+		objects = append(objects, pod)
+	}
+	client := fake.NewSimpleClientset(objects...)
+	testCases := []struct {
+		namespaces []string
+		expect     []BackupInfo
+	}{
+		{
+			namespaces: []string{"tidb-a", "tidb-b"},
+			expect: []BackupInfo{
+				{Component: "tikv", Pod: "tidb-a/tikv-0", Versions: []string{"5.2"}},
+				{Component: "tikv", Pod: "tidb-b/tikv-0", Versions: []string{"5.2"}},
+			},
+		},
+		{
+			namespaces: []string{metav1.NamespaceAll},
+			expect: []BackupInfo{
+				{Component: "tikv", Pod: "tidb-a/tikv-0", Versions: []string{"5.2"}},
+				{Component: "tikv", Pod: "tidb-b/tikv-0", Versions: []string{"5.2"}},
+				{Component: "tikv", Pod: "tidb-c/tikv-0", Versions: []string{"5.2"}},
+			},
+		},
+	}
+	for _, ca := range testCases {
+		executor := &namespaceExecutor{}
+		co := newTestCloudOperator(context.Background(), client, executor)
+		co.Namespaces = ca.namespaces
+		co.Components = []component{TiKV}
+		versions, err := co.List(ListFilter{})
+		assert.NoError(t, err)
+		assert.Equal(t, ca.expect, versions)
+		assert.ElementsMatch(t, []string{"tidb-a/tikv-0", "tidb-b/tikv-0", "tidb-c/tikv-0"}[:len(ca.expect)], executor.calls)
+	}
+}
+
+func TestStartOnlyChangesDebugPods(t *testing.T) {
+	debug := newTestPod("tikv-0", TiKV, corev1.PodRunning)
+	debug.Annotations = map[string]string{DebugLabel: DebugValue}
+	normal := newTestPod("tikv-1", TiKV, corev1.PodRunning)
+	normal.Annotations = map[string]string{"app": "tikv"}
+	client := fake.NewSimpleClientset(debug, normal)
+	co := newTestCloudOperator(context.Background(), client, nil)
+	assert.NoError(t, co.Start())
+
+	_, err := client.CoreV1().Pods(metav1.NamespaceDefault).Get(context.Background(), "tikv-0", metav1.GetOptions{})
+	assert.Error(t, err)
+	pod, err := client.CoreV1().Pods(metav1.NamespaceDefault).Get(context.Background(), "tikv-1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, normal, pod)
+	for _, action := range client.Actions() {
+		switch action.GetVerb() {
+		case "patch":
+			assert.Equal(t, "tikv-0", action.(k8stesting.PatchAction).GetName())
+		case "delete":
+			assert.Equal(t, "tikv-0", action.(k8stesting.DeleteAction).GetName())
+		}
+	}
+}
+
+func TestIncrementalBackExecCmd(t *testing.T) {
+	testCases := []struct {
+		prevVersion string
+		expect      string
+	}{
+		{
+			prevVersion: "",
+			expect:      "echo \"rm -rf /var/lib/tikv/5.2.bat;mkdir -p /var/lib/tikv/5.2.bat;cd /var/lib/tikv;/bin/cp -rf \\`ls -A | grep -vE 'bat|tar.gz|restoring|rollback|space_placeholder_file|tinker-meta.json'\\` /var/lib/tikv/5.2.bat -v\" > /var/lib/tikv/back_5.2.sh;sh /var/lib/tikv/back_5.2.sh",
+		},
+		{
+			prevVersion: "5.1",
+			expect:      "echo \"rm -rf /var/lib/tikv/5.2.bat;mkdir -p /var/lib/tikv/5.2.bat;cd /var/lib/tikv;rsync -a --link-dest=/var/lib/tikv/5.1.bat \\`ls -A | grep -vE 'bat|tar.gz|restoring|rollback|space_placeholder_file|tinker-meta.json'\\` /var/lib/tikv/5.2.bat -v\" > /var/lib/tikv/back_5.2.sh;sh /var/lib/tikv/back_5.2.sh",
+		},
+	}
+	for _, ca := range testCases {
+		assert.Equal(t, ca.expect, TiKV.IncrementalBackExecCmd(TiKV.BataDir(nil), "5.2", ca.prevVersion))
+	}
+}
+
+func TestPreviousVersion(t *testing.T) {
+	testCases := []struct {
+		output string
+		expect string
+	}{
+		{"", ""},
+		{"5.2.bat\r\n", ""},
+		{"5.1.bat\r\n5.3.bat\r\n5.2.bat\r\n", "5.3"},
+		{"4.0.bat\r\n5.1.bat\r\n", "5.1"},
+	}
+	pod := newTestPod("tikv-0", TiKV, corev1.PodRunning)
+	for _, ca := range testCases {
+		executor := newFakeExecutor(func(string, []string) (string, error) {
+			return ca.output, nil
+		})
+		co := newTestCloudOperator(context.Background(), nil, executor)
+		version, err := co.previousVersion(pod, TiKV, "5.2")
+		assert.NoError(t, err)
+		assert.Equal(t, ca.expect, version)
+	}
+}
+
+func TestCompressedExecCmd(t *testing.T) {
+	dir := TiKV.BataDir(nil)
+	assert.Equal(t, "echo \"rm -f /var/lib/tikv/5.2.tar.gz;cd /var/lib/tikv;tar czf /var/lib/tikv/5.2.tar.gz \\`ls -A | grep -vE 'bat|tar.gz|restoring|rollback|space_placeholder_file|tinker-meta.json'\\` -v\" > /var/lib/tikv/back_5.2.sh;sh /var/lib/tikv/back_5.2.sh", TiKV.CompressedBackExecCmd(dir, "5.2"))
+	assert.Equal(t, "echo \"cd /var/lib/tikv;rm -rf /var/lib/tikv/5.2.restoring /var/lib/tikv/5.2.rollback;mkdir -p /var/lib/tikv/5.2.restoring;tar xzf /var/lib/tikv/5.2.tar.gz -C /var/lib/tikv/5.2.restoring -v || exit 1;mkdir -p /var/lib/tikv/5.2.rollback;if ls -A | grep -vE 'bat|tar.gz|restoring|rollback|space_placeholder_file|tinker-meta.json' | xargs -r mv -t /var/lib/tikv/5.2.rollback && cd /var/lib/tikv/5.2.restoring && ls -A | xargs -r mv -t /var/lib/tikv;then cd /var/lib/tikv;rm -rf /var/lib/tikv/5.2.restoring /var/lib/tikv/5.2.rollback;else cd /var/lib/tikv;ls -A | grep -vE 'bat|tar.gz|restoring|rollback|space_placeholder_file|tinker-meta.json' | xargs -r rm -rf;cd /var/lib/tikv/5.2.rollback && ls -A | xargs -r mv -t /var/lib/tikv;cd /var/lib/tikv;rm -rf /var/lib/tikv/5.2.restoring;exit 1;fi\" > /var/lib/tikv/restore_5.2.sh;sh /var/lib/tikv/restore_5.2.sh", TiKV.CompressedRestoreExecCmd(dir, "5.2"))
+	assert.Equal(t, "rm -rf /var/lib/tikv/5.2.bat /var/lib/tikv/5.2.tar.gz", TiKV.RemoveExecCmd(dir, "5.2"))
+}
+
+func TestRejectMaliciousVersion(t *testing.T) {
+	client := fake.NewSimpleClientset(newTestPod("tikv-0", TiKV, corev1.PodRunning))
+	executor := newFakeExecutor(func(string, []string) (string, error) {
+		return "5.2.bat\r\n", nil
+	})
+	co := newTestCloudOperator(context.Background(), client, executor)
+	co.Components = []component{TiKV}
+	version := "5.2; rm -rf /"
+
+	_, err := co.Back(version)
+	assert.Error(t, err)
+	_, err = co.Restore(version)
+	assert.Error(t, err)
+	assert.Error(t, co.Remove(version))
+	assert.Error(t, co.Prune(version))
+	_, err = co.Verify(version)
+	assert.Error(t, err)
+	// the version is rejected before any exec.
+	assert.Empty(t, executor.calls)
+}
+
+func TestRestoreExecCmdSwap(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	writeFile := func(name, content string) {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(name), 0o755))
+		assert.NoError(t, os.WriteFile(name, []byte(content), 0o644))
+	}
+	readDir := func(dir string) []string {
+		entries, err := os.ReadDir(dir)
+		assert.NoError(t, err)
+		names := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		return names
+	}
+
+	dir := t.TempDir()
+	writeFile(filepath.Join(dir, "LOCK"), "live")
+	writeFile(filepath.Join(dir, "db", "1.sst"), "live")
+	writeFile(filepath.Join(dir, "5.2.bat", "db", "2.sst"), "backup")
+	writeFile(filepath.Join(dir, "5.2.bat", ".hidden"), "backup")
+
+	// the copy fails if the backup doesn't exist, the live files are untouched.
+	assert.Error(t, exec.Command("sh", "-c", TiKV.RestoreExecCmd(dir, "5.1")).Run())
+	assert.Equal(t, []string{"5.2.bat", "LOCK", "db", "restore_5.1.sh"}, readDir(dir))
+	assert.Equal(t, []string{"1.sst"}, readDir(filepath.Join(dir, "db")))
+
+	// the restored files are swapped in and the temporary directories are removed,
+	// the scripts are cleared with the live files.
+	assert.NoError(t, exec.Command("sh", "-c", TiKV.RestoreExecCmd(dir, "5.2")).Run())
+	assert.Equal(t, []string{".hidden", "5.2.bat", "db"}, readDir(dir))
+	assert.Equal(t, []string{"2.sst"}, readDir(filepath.Join(dir, "db")))
+}
+
+func TestMultiDataDirsBackAndRestore(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	writeFile := func(name, content string) {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(name), 0o755))
+		assert.NoError(t, os.WriteFile(name, []byte(content), 0o644))
+	}
+	readFile := func(name string) string {
+		content, err := os.ReadFile(name)
+		assert.NoError(t, err)
+		return string(content)
+	}
+
+	root := t.TempDir()
+	dir1, dir2 := filepath.Join(root, "data1"), filepath.Join(root, "data2")
+	writeFile(filepath.Join(dir1, "db", "1.sst"), "v1")
+	writeFile(filepath.Join(dir2, "raft", "1.log"), "v1")
+	assert.NoError(t, exec.Command("sh", "-c", TiKV.BackExecCmd(dir1, "5.2", dir2)).Run())
+	assert.Equal(t, "v1", readFile(filepath.Join(backupDir(dir1, "5.2"), dataSubdir(dir1), "db", "1.sst")))
+	assert.Equal(t, "v1", readFile(filepath.Join(backupDir(dir1, "5.2"), dataSubdir(dir2), "raft", "1.log")))
+
+	// every data dir is restored from its own backup.
+	writeFile(filepath.Join(dir1, "db", "1.sst"), "v2")
+	writeFile(filepath.Join(dir2, "raft", "1.log"), "v2")
+	writeFile(filepath.Join(dir2, "raft", "2.log"), "v2")
+	assert.NoError(t, exec.Command("sh", "-c", TiKV.RestoreExecCmd(dir1, "5.2", dir2)).Run())
+	assert.Equal(t, "v1", readFile(filepath.Join(dir1, "db", "1.sst")))
+	assert.Equal(t, "v1", readFile(filepath.Join(dir2, "raft", "1.log")))
+	assert.NoFileExists(t, filepath.Join(dir2, "raft", "2.log"))
+	assert.NoDirExists(t, restoringDir(dir2, "5.2"))
+	assert.NoDirExists(t, rollbackDir(dir2, "5.2"))
+}
+
+func TestBackupRoot(t *testing.T) {
+	assert.Equal(t, "echo \"rm -rf /backup/tikv/5.2.bat;mkdir -p /backup/tikv/5.2.bat;cd /var/lib/tikv;/bin/cp -rf \\`ls -A | grep -vE 'bat|tar.gz|restoring|rollback|space_placeholder_file|tinker-meta.json'\\` /backup/tikv/5.2.bat -v\" > /var/lib/tikv/back_5.2.sh;sh /var/lib/tikv/back_5.2.sh",
+		TiKV.BackExecCmdTo("/var/lib/tikv", "/backup/tikv", "5.2"))
+	assert.Contains(t, TiKV.RestoreExecCmdFrom("/var/lib/tikv", "/backup/tikv", "5.2"), "/bin/cp -rf /backup/tikv/5.2.bat /var/lib/tikv/5.2.restoring -v || exit 1")
+	assert.Equal(t, TiKV.BackExecCmd("/var/lib/tikv", "5.2"), TiKV.BackExecCmdTo("/var/lib/tikv", "/var/lib/tikv", "5.2"))
+	assert.Equal(t, TiKV.RestoreExecCmd("/var/lib/tikv", "5.2"), TiKV.RestoreExecCmdFrom("/var/lib/tikv", "/var/lib/tikv", "5.2"))
+	assert.True(t, strings.HasPrefix(TiKV.compressedBackExecCmd("/var/lib/tikv", "/backup/tikv", "5.2", backupPattern),
+		"echo \"mkdir -p /backup/tikv;rm -f /backup/tikv/5.2.tar.gz;cd /var/lib/tikv;tar czf /backup/tikv/5.2.tar.gz"))
+
+	co := newTestCloudOperator(context.Background(), nil, nil)
+	assert.Equal(t, "/var/lib/tikv", co.backupRoot(TiKV))
+	co.BackupRoot = "/backup"
+	assert.Equal(t, "/backup/tikv", co.backupRoot(TiKV))
+	assert.Equal(t, "/backup/pd", co.backupRoot(PD))
+	cmd, err := co.backCmd(newTestPod("tikv-0", TiKV, corev1.PodRunning), TiKV, "5.2")
+	assert.NoError(t, err)
+	assert.Contains(t, cmd, "/bin/cp -rf \\`ls -A | grep -vE '"+backupPattern+"'\\` /backup/tikv/5.2.bat -v")
+	assert.Contains(t, cmd, "/backup/tikv/5.2.bat/"+metadataFile)
+
+	testCases := []struct {
+		root     string
+		dataDirs map[component]string
+		err      string
+	}{
+		{"", nil, ""},
+		{"/backup", nil, ""},
+		{"/backup/", nil, ""},
+		{"backup", nil, `invalid backup root "backup", it should be an absolute directory other than /`},
+		{"/", nil, `invalid backup root "/", it should be an absolute directory other than /`},
+		{"/backup;rm -rf /", nil, "backup root \"/backup;rm -rf /\" contains characters which are not allowed in shell commands"},
+		{"/var/lib", nil, "backup root /var/lib/tikv of tikv overlaps its data directory /var/lib/tikv"},
+		{"/var/lib/tikv", nil, "backup root /var/lib/tikv/tikv of tikv overlaps its data directory /var/lib/tikv"},
+		{"/data", map[component]string{TiKV: "/data/tikv/store"}, "backup root /data/tikv of tikv overlaps its data directory /data/tikv/store"},
+		{"/data", map[component]string{TiKV: "/data/tikv-store"}, ""},
+	}
+	for _, ca := range testCases {
+		co := newTestCloudOperator(context.Background(), nil, nil)
+		co.Components = []component{TiKV}
+		co.BackupRoot, co.DataDirs = ca.root, ca.dataDirs
+		err := co.validateBackupRoot()
+		if ca.err == "" {
+			assert.NoError(t, err, ca.root)
+		} else {
+			assert.EqualError(t, err, ca.err, ca.root)
+		}
+	}
+}
+
+func TestBackupRootBackAndRestore(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	writeFile := func(name, content string) {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(name), 0o755))
+		assert.NoError(t, os.WriteFile(name, []byte(content), 0o644))
+	}
+	readFile := func(name string) string {
+		content, err := os.ReadFile(name)
+		assert.NoError(t, err)
+		return string(content)
+	}
+
+	dir, root := t.TempDir(), filepath.Join(t.TempDir(), "tikv")
+	writeFile(filepath.Join(dir, "db", "1.sst"), "v1")
+	assert.NoError(t, exec.Command("sh", "-c", TiKV.BackExecCmdTo(dir, root, "5.2")).Run())
+	assert.Equal(t, "v1", readFile(filepath.Join(backupDir(root, "5.2"), "db", "1.sst")))
+	assert.NoDirExists(t, backupDir(dir, "5.2"))
+
+	// the backup is restored from the root and stays there.
+	writeFile(filepath.Join(dir, "db", "1.sst"), "v2")
+	writeFile(filepath.Join(dir, "db", "2.sst"), "v2")
+	assert.NoError(t, exec.Command("sh", "-c", TiKV.RestoreExecCmdFrom(dir, root, "5.2")).Run())
+	assert.Equal(t, "v1", readFile(filepath.Join(dir, "db", "1.sst")))
+	assert.NoFileExists(t, filepath.Join(dir, "db", "2.sst"))
+	assert.DirExists(t, backupDir(root, "5.2"))
+	assert.NoDirExists(t, restoringDir(dir, "5.2"))
+}
+
+func TestRestoreCheck(t *testing.T) {
+	assert.Equal(t, "restored=$(for d in /var/lib/tikv; do cd $d && files=$(ls -A | grep -vE '"+backupPattern+"') && find $files -type f; done | wc -l);"+
+		"backup=$(find /backup/tikv/5.2.bat -type f ! -name "+metadataFile+" 2>/dev/null | wc -l);echo $restored $backup",
+		TiKV.RestoreCheckExecCmd("/var/lib/tikv", "/backup/tikv", "5.2"))
+	assert.Contains(t, TiKV.RestoreCheckExecCmd("/data1", "/data1", "5.2", "/data2"), "for d in /data1 /data2;")
+
+	testCases := []struct {
+		output string
+		err    string
+	}{
+		{"3 3\r\n", ""},
+		{"4 3", ""},
+		{"1 0", ""},
+		{"0 0", "no file is restored, the data directory is empty"},
+		{"0 3", "no file is restored, the data directory is empty"},
+		{"2 3", "2 files are restored, but the backup has 3 files"},
+		{"", `unexpected output of the restore check: ""`},
+		{"x 3", `unexpected output of the restore check: "x 3"`},
+	}
+	for _, ca := range testCases {
+		err := checkRestored(ca.output)
+		if ca.err == "" {
+			assert.NoError(t, err, ca.output)
+		} else {
+			assert.EqualError(t, err, ca.err, ca.output)
+		}
+	}
+
+	// the pod whose data directory is empty after restoring fails.
+	client := fake.NewSimpleClientset(
+		newTestPod("tikv-0", TiKV, corev1.PodRunning),
+		newTestPod("pd-0", PD, corev1.PodRunning),
+	)
+	executor := newFakeExecutor(func(podName string, command []string) (string, error) {
+		cmd := command[len(command)-1]
+		switch {
+		case strings.Contains(cmd, "ps -ef"):
+			return "UID\r\n1\r\n", nil
+		case strings.HasPrefix(cmd, "restored=") && podName == "tikv-0":
+			return "0 0\r\n", nil
+		case strings.HasPrefix(cmd, "restored="):
+			return "2 2\r\n", nil
+		case strings.HasPrefix(cmd, "ls"):
+			return "5.2.bat\r\n", nil
+		}
+		return "", nil
+	})
+	co := newTestCloudOperator(context.Background(), client, executor)
+	results, err := co.Restore("5.2")
+	assert.EqualError(t, err, "1 pods failed: tikv-0: restore check failed: no file is restored, the data directory is empty")
+	assert.Len(t, results, 2)
+}
+
+func TestRestoreCheckEmptyBackup(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	run := func(cmd string) string {
+		output, err := exec.Command("sh", "-c", cmd).Output()
+		assert.NoError(t, err)
+		return string(output)
+	}
+	writeFile := func(name, content string) {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(name), 0o755))
+		assert.NoError(t, os.WriteFile(name, []byte(content), 0o644))
+	}
+
+	dir := t.TempDir()
+	writeFile(filepath.Join(dir, "db", "1.sst"), "live")
+	writeFile(filepath.Join(dir, "db", "2.sst"), "live")
+	writeFile(filepath.Join(dir, "5.2.bat", "db", "1.sst"), "backup")
+	writeFile(filepath.Join(dir, "5.2.bat", metadataFile), "{}")
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "5.1.bat"), 0o755))
+
+	// the backup files are counted without the metadata.
+	run(TiKV.RestoreExecCmd(dir, "5.2"))
+	output := run(TiKV.RestoreCheckExecCmd(dir, dir, "5.2"))
+	assert.Equal(t, "1 1\n", output)
+	assert.NoError(t, checkRestored(output))
+
+	// restoring the empty backup succeeds, but the check fails.
+	run(TiKV.RestoreExecCmd(dir, "5.1"))
+	output = run(TiKV.RestoreCheckExecCmd(dir, dir, "5.1"))
+	assert.Equal(t, "0 0\n", output)
+	assert.Error(t, checkRestored(output))
+}
+
+func TestBackExecCmdExcludes(t *testing.T) {
+	pattern := excludePattern(backupPattern, []string{`^last_.*\.toml$`, "raftdb_tmp"})
+	assert.Equal(t, `bat|tar.gz|restoring|rollback|space_placeholder_file|tinker-meta.json|^last_.*\.toml$|raftdb_tmp`, pattern)
+	assert.Equal(t, backupPattern, excludePattern(backupPattern, nil))
+	assert.Equal(t, "echo \"rm -rf /var/lib/tikv/5.2.bat;mkdir -p /var/lib/tikv/5.2.bat;cd /var/lib/tikv;/bin/cp -rf \\`ls -A | grep -vE 'bat|tar.gz|restoring|rollback|space_placeholder_file|tinker-meta.json|^last_.*\\.toml$|raftdb_tmp'\\` /var/lib/tikv/5.2.bat -v\" > /var/lib/tikv/back_5.2.sh;sh /var/lib/tikv/back_5.2.sh",
+		TiKV.backExecCmd(TiKV.BataDir(nil), TiKV.BataDir(nil), "5.2", pattern))
+	assert.Equal(t, "cd /var/lib/tikv;du -sk `ls -A | grep -vE 'bat|tar.gz|restoring|rollback|space_placeholder_file|tinker-meta.json|^last_.*\\.toml$|raftdb_tmp'`", TiKV.duExecCmd(TiKV.BataDir(nil), pattern))
+
+	co := newTestCloudOperator(context.Background(), nil, nil)
+	co.Excludes = []string{"raftdb_tmp"}
+	cmd, err := co.backCmd(newTestPod("tikv-0", TiKV, corev1.PodRunning), TiKV, "5.2")
+	assert.NoError(t, err)
+	assert.Contains(t, cmd, "grep -vE 'bat|tar.gz|restoring|rollback|space_placeholder_file|tinker-meta.json|raftdb_tmp'")
+	co.Compress = true
+	cmd, err = co.backCmd(newTestPod("tikv-0", TiKV, corev1.PodRunning), TiKV, "5.2")
+	assert.NoError(t, err)
+	assert.Contains(t, cmd, "grep -vE 'bat|tar.gz|restoring|rollback|space_placeholder_file|tinker-meta.json|raftdb_tmp'")
+
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	// the excluded files are not backed up by the generated shell.
+	dir := t.TempDir()
+	for _, name := range []string{"db", "last_tikv.toml", "tikv.toml", "raftdb_tmp"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644))
+	}
+	assert.NoError(t, exec.Command("sh", "-c", TiKV.backExecCmd(dir, dir, "5.2", pattern)).Run())
+	assert.FileExists(t, filepath.Join(backupDir(dir, "5.2"), "db"))
+	assert.FileExists(t, filepath.Join(backupDir(dir, "5.2"), "tikv.toml"))
+	assert.NoFileExists(t, filepath.Join(backupDir(dir, "5.2"), "last_tikv.toml"))
+	assert.NoFileExists(t, filepath.Join(backupDir(dir, "5.2"), "raftdb_tmp"))
+}
+
+func TestValidateExcludes(t *testing.T) {
+	testCases := []struct {
+		excludes []string
+		err      string
+	}{
+		{excludes: nil},
+		{excludes: []string{"raftdb_tmp", `^last_.*\.toml$`, "a$|b", "log(s)?"}},
+		{excludes: []string{""}, err: "exclude should not be empty"},
+		{excludes: []string{"a'b"}, err: `invalid exclude "a'b", it should not contain quotes, backticks or spaces`},
+		{excludes: []string{`a"b`}, err: `invalid exclude "a\"b", it should not contain quotes, backticks or spaces`},
+		{excludes: []string{"`reboot`"}, err: "invalid exclude \"`reboot`\", it should not contain quotes, backticks or spaces"},
+		{excludes: []string{"a b"}, err: `invalid exclude "a b", it should not contain quotes, backticks or spaces`},
+		{excludes: []string{"$(reboot)"}, err: `invalid exclude "$(reboot)", $ is only allowed as the end anchor`},
+		{excludes: []string{`a\$`}, err: `invalid exclude "a\\$", the backslash should not escape $, backticks, quotes or backslashes`},
+		{excludes: []string{"db", "a("}, err: "invalid exclude \"a(\": error parsing regexp: missing closing ): `a(`"},
+	}
+	for _, ca := range testCases {
+		err := ValidateExcludes(ca.excludes)
+		if len(ca.err) > 0 {
+			assert.EqualError(t, err, ca.err)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+
+	// the invalid excludes are rejected before stopping any pod.
+	co := newTestCloudOperator(context.Background(), nil, nil)
+	co.Excludes = []string{"a'b"}
+	_, err := co.BackupWorkflow(context.Background(), "5.2")
+	assert.Error(t, err)
+	_, err = co.RollingBackupWorkflow(context.Background(), "5.2")
+	assert.Error(t, err)
+}
+
+func TestRestoreAndBackPods(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		newTestPod("tikv-0", TiKV, corev1.PodRunning),
+		newTestPod("tikv-1", TiKV, corev1.PodRunning),
+		newTestPod("pd-0", PD, corev1.PodRunning),
+	)
+	newExecutor := func() *fakeExecutor {
+		return newFakeExecutor(func(_ string, command []string) (string, error) {
+			cmd := command[len(command)-1]
+			switch {
+			case strings.Contains(cmd, "ps -ef"):
+				return "UID\r\n1\r\n", nil
+			case strings.HasPrefix(cmd, "restored="):
+				return "1 1\r\n", nil
+			case strings.HasPrefix(cmd, "ls"):
+				return "5.2.bat\r\n", nil
+			}
+			return "", nil
+		})
+	}
+
+	executor := newExecutor()
+	co := newTestCloudOperator(context.Background(), client, executor)
+	co.Pods = []string{"tikv-1"}
+	_, err := co.Restore("5.2")
+	assert.NoError(t, err)
+	assert.Len(t, executor.calls, 1)
+	assert.Contains(t, executor.calls, "tikv-1")
+
+	executor = newExecutor()
+	co = newTestCloudOperator(context.Background(), client, executor)
+	co.Pods = []string{"tikv-1", "tikv-9"}
+	_, err = co.Restore("5.2")
+	assert.EqualError(t, err, "pods not found: tikv-9")
+	assert.Empty(t, executor.calls)
+
+	co = newTestCloudOperator(context.Background(), client, nil)
+	co.Pods = []string{"default/pd-0"}
+	co.DryRun = true
+	out := new(bytes.Buffer)
+	co.Out = out
+	_, err = co.Back("5.2")
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("[dry-run] exec in pod pd-0 container pd: sh -c %s\n", backWithMetadataCmd(PD, PD.BataDir(nil), "5.2")), out.String())
+}
+
+func TestRestoreComponentVersions(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		newTestPod("tikv-0", TiKV, corev1.PodRunning),
+		newTestPod("pd-0", PD, corev1.PodRunning),
+	)
+	newExecutor := func() *fakeExecutor {
+		return newFakeExecutor(func(_ string, command []string) (string, error) {
+			cmd := command[len(command)-1]
+			switch {
+			case strings.Contains(cmd, "ps -ef"):
+				return "UID\r\n1\r\n", nil
+			case strings.HasPrefix(cmd, "restored="):
+				return "1 1\r\n", nil
+			case strings.HasPrefix(cmd, "ls"):
+				return "5.1.bat\r\n5.2.bat\r\n", nil
+			}
+			return "", nil
+		})
+	}
+
+	executor := newExecutor()
+	co := newTestCloudOperator(context.Background(), client, executor)
+	co.Components = []component{TiKV, PD}
+	_, err := co.Restore("tikv=5.1,pd=5.2")
+	assert.NoError(t, err)
+	// the restore is followed by the restore check.
+	assert.Equal(t, TiKV.RestoreExecCmd(TiKV.BataDir(nil), "5.1"), executor.calls["tikv-0"][len(executor.calls["tikv-0"])-2][2])
+	assert.Equal(t, PD.RestoreExecCmd(PD.BataDir(nil), "5.2"), executor.calls["pd-0"][len(executor.calls["pd-0"])-2][2])
+
+	// it restores nothing if the version of a component is missing.
+	executor = newExecutor()
+	co = newTestCloudOperator(context.Background(), client, executor)
+	co.Components = []component{TiKV, PD}
+	_, err = co.Restore("tikv=5.1")
+	assert.Error(t, err)
+	assert.Empty(t, executor.calls)
+}
+
+func TestForceRestore(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		newTestPod("tikv-0", TiKV, corev1.PodRunning),
+		newTestPod("pd-0", PD, corev1.PodRunning),
+	)
+	newExecutor := func() *fakeExecutor {
+		return newFakeExecutor(func(_ string, command []string) (string, error) {
+			cmd := command[len(command)-1]
+			switch {
+			case strings.Contains(cmd, "ps -ef"):
+				// the component process is still running.
+				return "UID\r\n12\r\n", nil
+			case strings.HasPrefix(cmd, "restored="):
+				return "1 1\r\n", nil
+			case strings.HasPrefix(cmd, "ls"):
+				return "5.2.bat\r\n", nil
+			}
+			return "", nil
+		})
+	}
+	restored := func(executor *fakeExecutor, pod string, cp component) bool {
+		return AnyOf(executor.calls[pod], func(i int) bool {
+			return executor.calls[pod][i][2] == cp.RestoreExecCmd(cp.BataDir(nil), "5.2")
+		})
+	}
+
+	executor := newExecutor()
+	co := newTestCloudOperator(context.Background(), client, executor)
+	_, err := co.Restore("5.2")
+	assert.Error(t, err)
+	assert.False(t, restored(executor, "tikv-0", TiKV))
+
+	// force skips checking the status.
+	executor = newExecutor()
+	co = newTestCloudOperator(context.Background(), client, executor)
+	co.Force = true
+	_, err = co.Restore("5.2")
+	assert.NoError(t, err)
+	assert.True(t, restored(executor, "tikv-0", TiKV))
+	assert.True(t, restored(executor, "pd-0", PD))
+	for _, calls := range executor.calls {
+		for _, command := range calls {
+			assert.NotContains(t, command[2], "ps -ef")
+		}
+	}
+
+	// force still checks the version exists.
+	executor = newExecutor()
+	co = newTestCloudOperator(context.Background(), client, executor)
+	co.Force = true
+	_, err = co.Restore("5.1")
+	assert.EqualError(t, err, "2 components failed: pd: version 5.1 not found; tikv: version 5.1 not found")
+}
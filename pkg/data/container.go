@@ -0,0 +1,82 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DefaultContainerAnnotation is the annotation of the pod to specify the default container.
+const DefaultContainerAnnotation = "kubectl.kubernetes.io/default-container"
+
+// sidecars is the names of the well-known sidecar containers, they will not be picked as the target container.
+var sidecars = map[string]bool{
+	"istio-proxy":   true,
+	"linkerd-proxy": true,
+	"slowlog":       true,
+	"raftlog":       true,
+	"rocksdblog":    true,
+}
+
+// ParseContainers parses the container names of components, e.g. tikv=db,pd=pd.
+func ParseContainers(s string) (map[component]string, error) {
+	return parseComponentValues(s)
+}
+
+// resolveContainer returns the container of the component to exec in the pod.
+// It picks the override, the container named after the component, the default container
+// in the annotation, and the first non-sidecar container in order.
+func resolveContainer(pod *corev1.Pod, cp component, override string) (string, error) {
+	names := make([]string, 0, len(pod.Spec.Containers))
+	for _, container := range pod.Spec.Containers {
+		names = append(names, container.Name)
+	}
+	has := func(name string) bool {
+		return AnyOf(names, func(i int) bool {
+			return names[i] == name
+		})
+	}
+	if len(override) > 0 {
+		if has(override) {
+			return override, nil
+		}
+		return "", fmt.Errorf("container %s not found in pod %s, available containers: %s", override, pod.Name, strings.Join(names, ","))
+	}
+	if has(cp.String()) {
+		return cp.String(), nil
+	}
+	if name := pod.Annotations[DefaultContainerAnnotation]; has(name) {
+		return name, nil
+	}
+	for _, name := range names {
+		if !sidecars[name] {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("no container of %s found in pod %s, available containers: %s, please specify it by --container", cp, pod.Name, strings.Join(names, ","))
+}
+
+// container returns the container of the component to exec in the pod.
+// It is the debug container attached to the target container if the component has a debug image.
+func (c *CloudOperator) container(pod *corev1.Pod, cp component) (string, error) {
+	target, err := resolveContainer(pod, cp, c.Containers[cp])
+	image := c.debugImage(cp)
+	if err != nil || image == "" {
+		return target, err
+	}
+	return c.debugContainer(pod, target, image)
+}
@@ -0,0 +1,78 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestResolveContainer(t *testing.T) {
+	testCases := []struct {
+		containers  []string
+		annotations map[string]string
+		override    string
+		expect      string
+		hasErr      bool
+	}{
+		{
+			containers: []string{"tikv"},
+			expect:     "tikv",
+		},
+		{
+			containers: []string{"raftlog", "tikv"},
+			expect:     "tikv",
+		},
+		{
+			containers: []string{"istio-proxy", "raftlog", "db"},
+			expect:     "db",
+		},
+		{
+			containers:  []string{"init", "db"},
+			annotations: map[string]string{DefaultContainerAnnotation: "db"},
+			expect:      "db",
+		},
+		{
+			containers: []string{"tikv", "db"},
+			override:   "db",
+			expect:     "db",
+		},
+		{
+			containers: []string{"tikv", "db"},
+			override:   "main",
+			hasErr:     true,
+		},
+		{
+			containers: []string{"istio-proxy", "raftlog"},
+			hasErr:     true,
+		},
+	}
+	for _, ca := range testCases {
+		pod := newTestPod("tikv-0", TiKV, corev1.PodRunning)
+		pod.Annotations = ca.annotations
+		pod.Spec.Containers = nil
+		for _, name := range ca.containers {
+			pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: name})
+		}
+		container, err := resolveContainer(pod, TiKV, ca.override)
+		if ca.hasErr {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, ca.expect, container)
+	}
+}
@@ -21,9 +21,11 @@ type Operator interface {
 	Back(version string) error
 	// Restore
 	Restore(version string) error
-	// List return all components versions
-	// K: pod.Name V: version list
-	List() (map[string][]string, error)
-	Check() bool
+	// List return the versions of the component pods which match the filter
+	List(filter ListFilter) ([]BackupInfo, error)
+	// Check returns the health of all the components
+	Check() []StatusResult
 	Remove(version string) error
+	// Prune removes the backup version except the running one
+	Prune(version string) error
 }
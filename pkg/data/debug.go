@@ -0,0 +1,255 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// DebugContainerPrefix is the name prefix of the ephemeral containers injected by the operator.
+	DebugContainerPrefix = "tinker-debug"
+	// debugDoneFile is created in the debug container to make it exit, the ephemeral containers can't be removed.
+	debugDoneFile = "/tmp/tinker-debug-done"
+	// DefaultDebugContainerTimeout bounds the wait for the debug container running if WaitTimeout is 0.
+	DefaultDebugContainerTimeout = 2 * time.Minute
+)
+
+// imageRegexp matches the image references, e.g. busybox, busybox:1.36, registry:5000/tools/busybox@sha256:<digest>.
+var imageRegexp = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*(:[0-9]+)?(/[a-z0-9]+([._-]+[a-z0-9]+)*)*(:[A-Za-z0-9_][A-Za-z0-9_.-]{0,127})?(@sha256:[a-f0-9]{64})?$`)
+
+// ValidateImage checks the image of the debug container, empty means no debug container.
+func ValidateImage(image string) error {
+	if len(image) > 0 && !imageRegexp.MatchString(image) {
+		return fmt.Errorf("invalid image: %q", image)
+	}
+	return nil
+}
+
+// ParseComponentImages parses the debug container images of components, e.g. tikv=busybox,pd=alpine:3.18.
+func ParseComponentImages(s string) (map[component]string, error) {
+	images, err := parseComponentValues(s)
+	if err != nil {
+		return nil, err
+	}
+	for cp, image := range images {
+		if len(image) == 0 {
+			return nil, fmt.Errorf("image of %s should not be empty", cp)
+		}
+		if err := ValidateImage(image); err != nil {
+			return nil, fmt.Errorf("%s: %w", cp, err)
+		}
+	}
+	return images, nil
+}
+
+// debugContainerCmd keeps the debug container running until the done file is created.
+var debugContainerCmd = fmt.Sprintf("trap 'exit 0' TERM; while [ ! -f %s ]; do sleep 1; done", debugDoneFile)
+
+// debugPermissions are needed to inject the debug containers.
+var debugPermissions = []Permission{
+	{Verb: "update", Resource: "pods", Subresource: "ephemeralcontainers"},
+}
+
+// debugContainer is the ephemeral container injected into the pod.
+type debugContainer struct {
+	// uid is the pod which the container is injected into, the recreated pod with the same name doesn't have it.
+	uid  types.UID
+	name string
+}
+
+// debugContainers collects the injected debug containers by pod key, it is safe for concurrent use.
+type debugContainers struct {
+	sync.Mutex
+	containers map[string]debugContainer
+}
+
+// get returns the debug container injected into the pod.
+func (d *debugContainers) get(pod string) (debugContainer, bool) {
+	d.Lock()
+	defer d.Unlock()
+	container, ok := d.containers[pod]
+	return container, ok
+}
+
+// add records the debug container injected into the pod.
+func (d *debugContainers) add(pod string, container debugContainer) {
+	d.Lock()
+	defer d.Unlock()
+	if d.containers == nil {
+		d.containers = make(map[string]debugContainer)
+	}
+	d.containers[pod] = container
+}
+
+// drain returns all the debug containers by pod key and forgets them.
+func (d *debugContainers) drain() map[string]debugContainer {
+	d.Lock()
+	defer d.Unlock()
+	containers := d.containers
+	d.containers = nil
+	return containers
+}
+
+// debugContainerSpec returns the ephemeral container of the image which execs the commands for the target container.
+// It shares the process namespace and mounts the same volumes as the target, so it can stop the process and
+// copy the data directories.
+func debugContainerSpec(pod *corev1.Pod, target, name, image string) (corev1.EphemeralContainer, error) {
+	for _, container := range pod.Spec.Containers {
+		if container.Name != target {
+			continue
+		}
+		return corev1.EphemeralContainer{
+			EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+				Name:                     name,
+				Image:                    image,
+				Command:                  []string{"sh", "-c", debugContainerCmd},
+				VolumeMounts:             append([]corev1.VolumeMount(nil), container.VolumeMounts...),
+				TerminationMessagePolicy: corev1.TerminationMessageReadFile,
+				ImagePullPolicy:          corev1.PullIfNotPresent,
+			},
+			TargetContainerName: target,
+		}, nil
+	}
+	return corev1.EphemeralContainer{}, fmt.Errorf("container %s not found in pod %s", target, pod.Name)
+}
+
+// debugContainerName returns the first name with DebugContainerPrefix which is not used by the pod,
+// the name of the exited ephemeral container can't be reused.
+func debugContainerName(pod *corev1.Pod) string {
+	used := make(map[string]bool, len(pod.Spec.EphemeralContainers))
+	for _, container := range pod.Spec.EphemeralContainers {
+		used[container.Name] = true
+	}
+	for i := 0; ; i++ {
+		name := fmt.Sprintf("%s-%d", DebugContainerPrefix, i)
+		if !used[name] {
+			return name
+		}
+	}
+}
+
+// debugImage returns the image of the debug container of the component, the image in ComponentImages
+// overrides DebugImage, empty means exec in the target container.
+func (c *CloudOperator) debugImage(cp component) string {
+	if image, ok := c.ComponentImages[cp]; ok {
+		return image
+	}
+	return c.DebugImage
+}
+
+// usesDebugContainers returns true if any component execs in the debug containers.
+func (c *CloudOperator) usesDebugContainers() bool {
+	return c.DebugImage != "" || len(c.ComponentImages) > 0
+}
+
+// debugContainer returns the debug container of the image to exec the commands for the target container in the pod.
+// The container is injected and waited running once per pod, it is only printed in dry run mode.
+func (c *CloudOperator) debugContainer(pod *corev1.Pod, target, image string) (string, error) {
+	podName := c.podKey(pod)
+	if container, ok := c.debugs.get(podName); ok && container.uid == pod.UID {
+		return container.name, nil
+	}
+	name := debugContainerName(pod)
+	if c.DryRun {
+		c.printDryRun("inject ephemeral container %s of image %s into pod %s for container %s", name, image, podName, target)
+		c.debugs.add(podName, debugContainer{uid: pod.UID, name: name})
+		return name, nil
+	}
+	latest, err := c.client.CoreV1().Pods(pod.Namespace).Get(c.ctx, pod.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("get pod %s failed: %w", podName, err)
+	}
+	name = debugContainerName(latest)
+	spec, err := debugContainerSpec(latest, target, name, image)
+	if err != nil {
+		return "", err
+	}
+	latest.Spec.EphemeralContainers = append(latest.Spec.EphemeralContainers, spec)
+	if _, err := c.client.CoreV1().Pods(pod.Namespace).UpdateEphemeralContainers(c.ctx, pod.Name, latest, metav1.UpdateOptions{}); err != nil {
+		return "", fmt.Errorf("inject debug container into pod %s failed: %w", podName, err)
+	}
+	c.debugs.add(podName, debugContainer{uid: latest.UID, name: name})
+	log.Info("inject debug container", zap.String("pod-name", podName), zap.String("container", name), zap.String("image", image))
+	timeout := c.WaitTimeout
+	if timeout <= 0 {
+		timeout = DefaultDebugContainerTimeout
+	}
+	err = c.waitFor(func() (bool, error) {
+		return c.debugContainerRunning(pod.Namespace, pod.Name, name)
+	}, timeout)
+	if errors.Is(err, errWaitTimeout) {
+		return "", fmt.Errorf("debug container %s in pod %s is not running after %s", name, podName, timeout)
+	}
+	if err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// debugContainerRunning returns true if the debug container is running, it fails if the container terminated.
+func (c *CloudOperator) debugContainerRunning(namespace, podName, name string) (bool, error) {
+	pod, err := c.client.CoreV1().Pods(namespace).Get(c.ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	for _, status := range pod.Status.EphemeralContainerStatuses {
+		if status.Name != name {
+			continue
+		}
+		if status.State.Terminated != nil {
+			return false, fmt.Errorf("debug container %s in pod %s terminated: %s", name, podName, status.State.Terminated.Reason)
+		}
+		return status.State.Running != nil, nil
+	}
+	return false, nil
+}
+
+// CleanupDebugContainers makes the injected debug containers exit, the ephemeral containers can't be removed from
+// the pods, so they are left terminated. The containers gone with their pods, e.g. restarted by start, are skipped.
+// The failures are only logged, the cleanup doesn't fail the operation.
+func (c *CloudOperator) CleanupDebugContainers() {
+	containers := c.debugs.drain()
+	if c.DryRun || len(containers) == 0 {
+		return
+	}
+	// the operation may be interrupted, but the debug containers should still exit.
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultDebugContainerTimeout)
+	defer cancel()
+	_ = c.withContext(ctx, func() error {
+		for podName, container := range containers {
+			namespace, name := c.splitPodKey(podName)
+			pod, err := c.client.CoreV1().Pods(namespace).Get(c.ctx, name, metav1.GetOptions{})
+			if err != nil || pod.UID != container.uid {
+				continue
+			}
+			if _, err := c.exec(podName, container.name, []string{"touch", debugDoneFile}); err != nil {
+				log.Warn("stop debug container failed", zap.String("pod-name", podName),
+					zap.String("container", container.name), zap.Error(err))
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,231 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// containerExecutor records the containers which the commands are executed in.
+type containerExecutor struct {
+	sync.Mutex
+	// K: pod.Name V: the container and the command
+	calls map[string][]string
+}
+
+func (e *containerExecutor) exec(_ context.Context, podName, container, _ string, command []string, _ io.Reader, _, _ io.Writer) error {
+	e.Lock()
+	defer e.Unlock()
+	e.calls[podName] = append(e.calls[podName], container+": "+strings.Join(command, " "))
+	return nil
+}
+
+func newDebugTestPod(name string, ephemeral ...corev1.ContainerStatus) *corev1.Pod {
+	pod := newTestPod(name, TiKV, corev1.PodRunning)
+	pod.Spec.Containers = []corev1.Container{
+		{Name: "istio-proxy"},
+		{
+			Name: "tikv",
+			VolumeMounts: []corev1.VolumeMount{
+				{Name: "tikv", MountPath: "/var/lib/tikv"},
+				{Name: "config", MountPath: "/etc/tikv", ReadOnly: true},
+			},
+		},
+	}
+	pod.Status.EphemeralContainerStatuses = ephemeral
+	return pod
+}
+
+func runningDebugContainer(name string) corev1.ContainerStatus {
+	return corev1.ContainerStatus{Name: name, State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}}
+}
+
+func TestDebugContainerSpec(t *testing.T) {
+	pod := newDebugTestPod("tikv-0")
+	spec, err := debugContainerSpec(pod, "tikv", "tinker-debug-0", "busybox")
+	assert.NoError(t, err)
+	assert.Equal(t, "tinker-debug-0", spec.Name)
+	assert.Equal(t, "busybox", spec.Image)
+	assert.Equal(t, "tikv", spec.TargetContainerName)
+	assert.Equal(t, []string{"sh", "-c", debugContainerCmd}, spec.Command)
+	assert.Equal(t, pod.Spec.Containers[1].VolumeMounts, spec.VolumeMounts)
+	// the mounts of the pod are not shared with the spec.
+	spec.VolumeMounts[0].MountPath = "/data"
+	assert.Equal(t, "/var/lib/tikv", pod.Spec.Containers[1].VolumeMounts[0].MountPath)
+
+	_, err = debugContainerSpec(pod, "pd", "tinker-debug-0", "busybox")
+	assert.EqualError(t, err, "container pd not found in pod tikv-0")
+}
+
+func TestDebugContainerName(t *testing.T) {
+	testCases := []struct {
+		containers []string
+		expect     string
+	}{
+		{expect: "tinker-debug-0"},
+		{containers: []string{"debugger"}, expect: "tinker-debug-0"},
+		{containers: []string{"tinker-debug-0", "tinker-debug-2"}, expect: "tinker-debug-1"},
+	}
+	for _, ca := range testCases {
+		pod := newDebugTestPod("tikv-0")
+		for _, name := range ca.containers {
+			pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, corev1.EphemeralContainer{
+				EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: name},
+			})
+		}
+		assert.Equal(t, ca.expect, debugContainerName(pod), ca.containers)
+	}
+}
+
+func TestDebugContainer(t *testing.T) {
+	pod := newDebugTestPod("tikv-0", runningDebugContainer("tinker-debug-0"))
+	client := fake.NewSimpleClientset(pod)
+	executor := &containerExecutor{calls: make(map[string][]string)}
+	co := newTestCloudOperator(context.Background(), client, executor)
+	co.DebugImage = "busybox"
+	co.after = func(time.Duration) <-chan time.Time {
+		return time.After(time.Millisecond)
+	}
+
+	// the debug container is injected once and the commands are executed in it.
+	for i := 0; i < 2; i++ {
+		container, err := co.container(pod, TiKV)
+		assert.NoError(t, err)
+		assert.Equal(t, "tinker-debug-0", container)
+	}
+	injected := 0
+	for _, action := range client.Actions() {
+		if action.GetSubresource() == "ephemeralcontainers" {
+			injected++
+		}
+	}
+	assert.Equal(t, 1, injected)
+	latest, err := client.CoreV1().Pods(pod.Namespace).Get(context.Background(), pod.Name, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, latest.Spec.EphemeralContainers, 1)
+	assert.Equal(t, "busybox", latest.Spec.EphemeralContainers[0].Image)
+	assert.Equal(t, "tikv", latest.Spec.EphemeralContainers[0].TargetContainerName)
+	// preflight checks the permission to inject it.
+	assert.Contains(t, co.permissions(), Permission{Verb: "update", Resource: "pods", Subresource: "ephemeralcontainers"})
+
+	// the debug container exits after the cleanup, and it's not reused.
+	co.CleanupDebugContainers()
+	assert.Equal(t, []string{"tinker-debug-0: touch " + debugDoneFile}, executor.calls["tikv-0"])
+	co.CleanupDebugContainers()
+	assert.Len(t, executor.calls["tikv-0"], 1)
+
+	// the debug container which can't run fails the exec.
+	pod = newDebugTestPod("tikv-1", corev1.ContainerStatus{
+		Name:  "tinker-debug-0",
+		State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Error"}},
+	})
+	_, err = client.CoreV1().Pods(pod.Namespace).Create(context.Background(), pod, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	_, err = co.container(pod, TiKV)
+	assert.EqualError(t, err, "debug container tinker-debug-0 in pod tikv-1 terminated: Error")
+
+	// the pod restarted by start doesn't have the debug container, so it's not cleaned up.
+	pod = newDebugTestPod("tikv-2", runningDebugContainer("tinker-debug-0"))
+	_, err = client.CoreV1().Pods(pod.Namespace).Create(context.Background(), pod, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	_, err = co.container(pod, TiKV)
+	assert.NoError(t, err)
+	pod.UID = "restarted"
+	_, err = client.CoreV1().Pods(pod.Namespace).Update(context.Background(), pod, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	co.CleanupDebugContainers()
+	assert.Empty(t, executor.calls["tikv-2"])
+}
+
+func TestDebugContainerDryRun(t *testing.T) {
+	pod := newDebugTestPod("tikv-0")
+	client := fake.NewSimpleClientset(pod)
+	co := newTestCloudOperator(context.Background(), client, &containerExecutor{calls: make(map[string][]string)})
+	co.DebugImage = "busybox"
+	co.DryRun = true
+	out := new(bytes.Buffer)
+	co.Out = out
+
+	container, err := co.container(pod, TiKV)
+	assert.NoError(t, err)
+	assert.Equal(t, "tinker-debug-0", container)
+	assert.Equal(t, "[dry-run] inject ephemeral container tinker-debug-0 of image busybox into pod tikv-0 for container tikv\n", out.String())
+	assert.Empty(t, client.Actions())
+}
+
+func TestParseComponentImages(t *testing.T) {
+	images, err := ParseComponentImages("tikv=busybox:1.36,pd=registry.example.com:5000/tools/alpine")
+	assert.NoError(t, err)
+	assert.Equal(t, map[component]string{TiKV: "busybox:1.36", PD: "registry.example.com:5000/tools/alpine"}, images)
+	images, err = ParseComponentImages("")
+	assert.NoError(t, err)
+	assert.Empty(t, images)
+
+	for _, s := range []string{"tikv=", "tikv=Busybox", "tikv=busybox;reboot", "tikv=busybox:", "tikv", "tiflash=busybox"} {
+		_, err := ParseComponentImages(s)
+		assert.Error(t, err, s)
+	}
+	assert.NoError(t, ValidateImage(""))
+	assert.NoError(t, ValidateImage("busybox@sha256:"+strings.Repeat("a", 64)))
+	assert.Error(t, ValidateImage("busybox@sha256:abc"))
+}
+
+func TestComponentImage(t *testing.T) {
+	pod := newDebugTestPod("tikv-0", runningDebugContainer("tinker-debug-0"))
+	pdPod := newTestPod("pd-0", PD, corev1.PodRunning)
+	client := fake.NewSimpleClientset(pod, pdPod)
+	co := newTestCloudOperator(context.Background(), client, &containerExecutor{calls: make(map[string][]string)})
+	co.ComponentImages = map[component]string{TiKV: "tikv-tools:5.2"}
+	co.after = func(time.Duration) <-chan time.Time {
+		return time.After(time.Millisecond)
+	}
+
+	// the component image is injected without DebugImage.
+	container, err := co.container(pod, TiKV)
+	assert.NoError(t, err)
+	assert.Equal(t, "tinker-debug-0", container)
+	latest, err := client.CoreV1().Pods(pod.Namespace).Get(context.Background(), pod.Name, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, latest.Spec.EphemeralContainers, 1)
+	assert.Equal(t, "tikv-tools:5.2", latest.Spec.EphemeralContainers[0].Image)
+	assert.Equal(t, "tikv", latest.Spec.EphemeralContainers[0].TargetContainerName)
+	assert.Contains(t, co.permissions(), Permission{Verb: "update", Resource: "pods", Subresource: "ephemeralcontainers"})
+	// the component without image execs in the target container.
+	container, err = co.container(pdPod, PD)
+	assert.NoError(t, err)
+	assert.Equal(t, "pd", container)
+
+	// the component image overrides DebugImage, the others use DebugImage.
+	co.DebugImage = "busybox"
+	assert.Equal(t, "tikv-tools:5.2", co.debugImage(TiKV))
+	assert.Equal(t, "busybox", co.debugImage(PD))
+
+	out := new(bytes.Buffer)
+	co.DryRun = true
+	co.Out = out
+	_, err = co.container(newDebugTestPod("tikv-1"), TiKV)
+	assert.NoError(t, err)
+	assert.Equal(t, "[dry-run] inject ephemeral container tinker-debug-0 of image tikv-tools:5.2 into pod tikv-1 for container tikv\n", out.String())
+}
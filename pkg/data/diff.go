@@ -0,0 +1,180 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DiffResult is the files which restoring the backup would change in one pod.
+// The files are the paths in the data directories.
+type DiffResult struct {
+	Pod       string `json:"pod"`
+	Component string `json:"component"`
+	// Changed is the files whose content differs from the backup.
+	Changed []string `json:"changed"`
+	// Added is the files which are only in the backup, restoring adds them.
+	Added []string `json:"added"`
+	// Removed is the files which are not in the backup, restoring removes them.
+	Removed []string `json:"removed"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// DiffExecCmd returns the files which differ between the backup directory and the live data directory.
+// The backup directories, the compressed backups and the temporary directories of restoring are excluded.
+// diff exits with 1 if there is any difference, it is not a failure.
+func (c component) DiffExecCmd(backup, live string) string {
+	return c.diffExecCmd(backup, live, DefaultPlaceholderFile)
+}
+
+// diffExecCmd is the same as DiffExecCmd, but the placeholder file is excluded instead of DefaultPlaceholderFile.
+func (c component) diffExecCmd(backup, live, placeholder string) string {
+	excludes := make([]string, 0, 6)
+	for _, pattern := range strings.Split(patternWith(placeholder), "|") {
+		// the metadata file is hidden, * matches the empty name before its dot.
+		if pattern != placeholder {
+			pattern = "*." + pattern
+		}
+		excludes = append(excludes, fmt.Sprintf("-x '%s'", pattern))
+	}
+	return fmt.Sprintf("diff -rq %s %s %s;test $? -le 1", strings.Join(excludes, " "), backup, live)
+}
+
+// parseDiff parses the output of DiffExecCmd into the result, the files are the paths in the live directory.
+// e.g.
+// Files /var/lib/tikv/5.2.bat/db/1.sst and /var/lib/tikv/db/1.sst differ
+// Only in /var/lib/tikv/5.2.bat/db: 2.sst
+// Only in /var/lib/tikv/db: 3.sst
+func parseDiff(output, backup, live string, rst *DiffResult) error {
+	// livePath maps the path in the backup or live directory to the path in the live directory.
+	livePath := func(p string) (string, bool, error) {
+		for _, base := range []string{backup, live} {
+			if p == base || strings.HasPrefix(p, base+"/") {
+				return path.Join(live, strings.TrimPrefix(p, base)), base == backup, nil
+			}
+		}
+		return "", false, fmt.Errorf("unexpected path in diff output: %q", p)
+	}
+	for _, line := range splitLines(output) {
+		if len(line) == 0 {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "Files ") && strings.HasSuffix(line, " differ"):
+			files := strings.SplitN(strings.TrimSuffix(strings.TrimPrefix(line, "Files "), " differ"), " and ", 2)
+			p, _, err := livePath(files[0])
+			if err != nil {
+				return err
+			}
+			rst.Changed = append(rst.Changed, p)
+		case strings.HasPrefix(line, "Only in "):
+			parts := strings.SplitN(strings.TrimPrefix(line, "Only in "), ": ", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("invalid diff output: %q", line)
+			}
+			dir, inBackup, err := livePath(parts[0])
+			if err != nil {
+				return err
+			}
+			if inBackup {
+				rst.Added = append(rst.Added, path.Join(dir, parts[1]))
+			} else {
+				rst.Removed = append(rst.Removed, path.Join(dir, parts[1]))
+			}
+		default:
+			return fmt.Errorf("invalid diff output: %q", line)
+		}
+	}
+	return nil
+}
+
+// Diff compares the live data with the backup version in every pod without changing anything.
+// The version is a bare version or the versions of components, e.g. tikv=5.1,pd=5.2.
+// The results are sorted by pod name.
+func (c *CloudOperator) Diff(version string) ([]DiffResult, error) {
+	versions, err := ParseComponentVersions(version)
+	if err != nil {
+		return nil, err
+	}
+	wg := &sync.WaitGroup{}
+	mu := &sync.Mutex{}
+	var results []DiffResult
+	for _, cp := range c.Components {
+		version, err := versions.Of(cp)
+		if err != nil {
+			return nil, err
+		}
+		pods, err := c.discoverPods(cp)
+		if err != nil {
+			return nil, err
+		}
+		for i := range pods.Items {
+			wg.Add(1)
+			go func(pod *corev1.Pod, cp component) {
+				defer wg.Done()
+				podName := c.podKey(pod)
+				rst := DiffResult{Pod: podName, Component: cp.String()}
+				if err := c.diffPod(pod, cp, version, &rst); err != nil {
+					log.Error("diff failed", zap.String("pod-name", podName), zap.Error(err))
+					rst.Error = err.Error()
+				}
+				mu.Lock()
+				results = append(results, rst)
+				mu.Unlock()
+			}(&pods.Items[i], cp)
+		}
+	}
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Pod < results[j].Pod
+	})
+	return results, nil
+}
+
+// diffPod compares every data directory of the pod with its backup.
+func (c *CloudOperator) diffPod(pod *corev1.Pod, cp component, version string, rst *DiffResult) error {
+	podName := c.podKey(pod)
+	container, err := c.container(pod, cp)
+	if err != nil {
+		return err
+	}
+	dir := cp.BataDir(c.DataDirs)
+	backup := backupDir(c.backupRoot(cp), version)
+	// K: live directory V: backup directory
+	pairs := [][2]string{{dir, backup}}
+	if extraDirs := c.extraDataDirs(cp); len(extraDirs) > 0 {
+		pairs = pairs[:0]
+		for _, d := range dataDirsOf(dir, extraDirs) {
+			pairs = append(pairs, [2]string{d, fmt.Sprintf("%s/%s", backup, dataSubdir(d))})
+		}
+	}
+	for _, pair := range pairs {
+		output, err := c.exec(podName, container, []string{"sh", "-c", cp.diffExecCmd(pair[1], pair[0], c.placeholderFile())})
+		if err != nil {
+			return err
+		}
+		if err := parseDiff(output, pair[1], pair[0], rst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
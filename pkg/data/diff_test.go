@@ -0,0 +1,136 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDiffExecCmd(t *testing.T) {
+	assert.Equal(t, "diff -rq -x '*.bat' -x '*.tar.gz' -x '*.restoring' -x '*.rollback' -x 'space_placeholder_file' -x '*.tinker-meta.json' /var/lib/tikv/5.2.bat /var/lib/tikv;test $? -le 1",
+		TiKV.DiffExecCmd(backupDir("/var/lib/tikv", "5.2"), "/var/lib/tikv"))
+}
+
+func TestParseDiff(t *testing.T) {
+	testCases := []struct {
+		output  string
+		changed []string
+		added   []string
+		removed []string
+		err     bool
+	}{
+		{output: ""},
+		{
+			output: "Files /var/lib/tikv/5.2.bat/db/1.sst and /var/lib/tikv/db/1.sst differ\r\n" +
+				"Only in /var/lib/tikv/5.2.bat/db: 2.sst\r\n" +
+				"Only in /var/lib/tikv/db: 3.sst\r\n" +
+				"Only in /var/lib/tikv: raft\r\n",
+			changed: []string{"/var/lib/tikv/db/1.sst"},
+			added:   []string{"/var/lib/tikv/db/2.sst"},
+			removed: []string{"/var/lib/tikv/db/3.sst", "/var/lib/tikv/raft"},
+		},
+		{output: "Only in /var/lib/tikv/5.2.bat: LOCK\n", added: []string{"/var/lib/tikv/LOCK"}},
+		{output: "Only in /tmp: x\n", err: true},
+		{output: "diff: /var/lib/tikv/5.2.bat: No such file or directory\n", err: true},
+	}
+	for _, ca := range testCases {
+		rst := DiffResult{}
+		err := parseDiff(ca.output, "/var/lib/tikv/5.2.bat", "/var/lib/tikv", &rst)
+		if ca.err {
+			assert.Error(t, err, ca.output)
+			continue
+		}
+		assert.NoError(t, err, ca.output)
+		assert.Equal(t, ca.changed, rst.Changed, ca.output)
+		assert.Equal(t, ca.added, rst.Added, ca.output)
+		assert.Equal(t, ca.removed, rst.Removed, ca.output)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		newTestPod("tikv-0", TiKV, corev1.PodRunning),
+		newTestPod("tikv-1", TiKV, corev1.PodRunning),
+		newTestPod("pd-0", PD, corev1.PodRunning),
+	)
+	executor := newFakeExecutor(func(podName string, command []string) (string, error) {
+		switch podName {
+		case "tikv-1":
+			return "Files /var/lib/tikv/5.1.bat/db/1.sst and /var/lib/tikv/db/1.sst differ\r\n", nil
+		case "pd-0":
+			return "", &ExitError{Code: 1}
+		}
+		return "", nil
+	})
+	co := newTestCloudOperator(context.Background(), client, executor)
+	co.RetryCount = 1
+	results, err := co.Diff("tikv=5.1,pd=5.2")
+	assert.NoError(t, err)
+	assert.Len(t, results, 3)
+	assert.Equal(t, "pd-0", results[0].Pod)
+	assert.NotEmpty(t, results[0].Error)
+	assert.Equal(t, "tikv-0", results[1].Pod)
+	assert.Empty(t, results[1].Changed)
+	assert.Equal(t, []string{"/var/lib/tikv/db/1.sst"}, results[2].Changed)
+	for _, calls := range executor.calls {
+		for _, call := range calls {
+			assert.True(t, strings.HasPrefix(call[2], "diff -rq"), call[2])
+		}
+	}
+
+	_, err = co.Diff("tikv=5.1")
+	assert.Error(t, err)
+}
+
+func TestDiffExecCmdRun(t *testing.T) {
+	if _, err := exec.LookPath("diff"); err != nil {
+		t.Skip("diff is not available")
+	}
+	writeFile := func(name, content string) {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(name), 0o755))
+		assert.NoError(t, os.WriteFile(name, []byte(content), 0o644))
+	}
+	dir := t.TempDir()
+	writeFile(filepath.Join(dir, "db", "1.sst"), "v1")
+	writeFile(filepath.Join(dir, "db", "2.sst"), "v1")
+	assert.NoError(t, exec.Command("sh", "-c", TiKV.BackExecCmd(dir, "5.2")).Run())
+	backup := backupDir(dir, "5.2")
+
+	output, err := exec.Command("sh", "-c", TiKV.DiffExecCmd(backup, dir)).Output()
+	assert.NoError(t, err)
+	assert.Empty(t, string(output))
+
+	writeFile(filepath.Join(dir, "db", "1.sst"), "v2")
+	assert.NoError(t, os.Remove(filepath.Join(dir, "db", "2.sst")))
+	writeFile(filepath.Join(dir, "db", "3.sst"), "v2")
+	output, err = exec.Command("sh", "-c", TiKV.DiffExecCmd(backup, dir)).Output()
+	assert.NoError(t, err)
+	rst := DiffResult{}
+	assert.NoError(t, parseDiff(string(output), backup, dir, &rst))
+	assert.Equal(t, []string{filepath.Join(dir, "db", "1.sst")}, rst.Changed)
+	assert.Equal(t, []string{filepath.Join(dir, "db", "2.sst")}, rst.Added)
+	assert.Equal(t, []string{filepath.Join(dir, "db", "3.sst")}, rst.Removed)
+
+	// the missing backup is a failure.
+	assert.Error(t, exec.Command("sh", "-c", TiKV.DiffExecCmd(backupDir(dir, "5.1"), dir)).Run())
+}
@@ -0,0 +1,141 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"fmt"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// The ways to discover the pods of the components.
+const (
+	// DiscoveryLabel discovers the pods by the label selector of SelectorTemplate.
+	DiscoveryLabel = "label"
+	// DiscoveryStatefulSet discovers the stateful sets by the label selector of SelectorTemplate,
+	// then the pods owned by them.
+	DiscoveryStatefulSet = "statefulset"
+)
+
+// statefulSetPermissions are needed to discover the pods by the stateful sets.
+var statefulSetPermissions = []Permission{
+	{Verb: "list", Group: "apps", Resource: "statefulsets"},
+}
+
+// ValidateDiscovery checks the discovery is supported, empty means DiscoveryLabel.
+func ValidateDiscovery(discovery string) error {
+	switch discovery {
+	case "", DiscoveryLabel, DiscoveryStatefulSet:
+		return nil
+	}
+	return fmt.Errorf("unknown discovery %q, it should be one of %s|%s", discovery, DiscoveryLabel, DiscoveryStatefulSet)
+}
+
+// ValidateNamespaceSelector checks the namespace selector is a valid label selector, empty means no selector.
+func ValidateNamespaceSelector(selector string) error {
+	if selector == "" {
+		return nil
+	}
+	if _, err := labels.Parse(selector); err != nil {
+		return fmt.Errorf("invalid namespace selector %q: %w", selector, err)
+	}
+	return nil
+}
+
+// SelectNamespaces lists the namespaces by the label selector and operates in them, they are ordered by the names.
+// It fails if no namespace matches, so it doesn't fall back to all the namespaces.
+func (c *CloudOperator) SelectNamespaces(selector string) error {
+	list, err := c.client.CoreV1().Namespaces().List(c.ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return fmt.Errorf("list namespaces by %s failed: %w", selector, err)
+	}
+	if len(list.Items) == 0 {
+		return fmt.Errorf("no namespace matches the selector %s", selector)
+	}
+	namespaces := make([]string, 0, len(list.Items))
+	for _, namespace := range list.Items {
+		namespaces = append(namespaces, namespace.Name)
+	}
+	sort.Strings(namespaces)
+	c.Namespaces = namespaces
+	return nil
+}
+
+// discoverPods returns the pods of the component in all the namespaces, all the operations find the pods by it.
+func (c *CloudOperator) discoverPods(cp component) (*corev1.PodList, error) {
+	if c.Discovery == DiscoveryStatefulSet {
+		return c.statefulSetPods(cp)
+	}
+	return c.labelPods(cp)
+}
+
+// labelPods lists the pods of the component by the label selector in all the namespaces.
+func (c *CloudOperator) labelPods(cp component) (*corev1.PodList, error) {
+	options := metav1.ListOptions{
+		LabelSelector: c.labelSelector(cp),
+	}
+	rst := &corev1.PodList{}
+	for _, namespace := range c.Namespaces {
+		pods, err := c.client.CoreV1().Pods(namespace).List(c.ctx, options)
+		if err != nil {
+			return nil, err
+		}
+		rst.Items = append(rst.Items, pods.Items...)
+	}
+	return rst, nil
+}
+
+// statefulSetPods lists the pods owned by the stateful sets of the component in all the namespaces.
+// The pods are matched by the controller reference instead of the labels, so the pods whose labels are
+// transiently absent during rolling update are still found, and the pods which only share the labels are not.
+// The pods are ordered by the stateful sets and then by the names.
+func (c *CloudOperator) statefulSetPods(cp component) (*corev1.PodList, error) {
+	options := metav1.ListOptions{
+		LabelSelector: c.labelSelector(cp),
+	}
+	rst := &corev1.PodList{}
+	for _, namespace := range c.Namespaces {
+		sets, err := c.client.AppsV1().StatefulSets(namespace).List(c.ctx, options)
+		if err != nil {
+			return nil, err
+		}
+		if len(sets.Items) == 0 {
+			continue
+		}
+		pods, err := c.client.CoreV1().Pods(namespace).List(c.ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for i := range sets.Items {
+			rst.Items = append(rst.Items, ownedPods(&sets.Items[i], pods.Items)...)
+		}
+	}
+	return rst, nil
+}
+
+// ownedPods returns the pods controlled by the stateful set ordered by the names.
+func ownedPods(set *appsv1.StatefulSet, pods []corev1.Pod) []corev1.Pod {
+	var owned []corev1.Pod
+	for _, pod := range pods {
+		if ref := metav1.GetControllerOf(&pod); ref != nil && ref.UID == set.UID {
+			owned = append(owned, pod)
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool { return owned[i].Name < owned[j].Name })
+	return owned
+}
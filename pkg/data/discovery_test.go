@@ -0,0 +1,162 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newTestStatefulSet creates a stateful set of the component.
+func newTestStatefulSet(name string, cp component) *appsv1.StatefulSet {
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: metav1.NamespaceDefault,
+			UID:       types.UID(name + "-uid"),
+			Labels:    map[string]string{"app.kubernetes.io/component": cp.String()},
+		},
+	}
+}
+
+// ownedBy makes the stateful set the controller of the pod.
+func ownedBy(pod *corev1.Pod, set *appsv1.StatefulSet) *corev1.Pod {
+	pod.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(set, appsv1.SchemeGroupVersion.WithKind("StatefulSet"))}
+	return pod
+}
+
+func TestDiscoverPods(t *testing.T) {
+	tikv := newTestStatefulSet("tikv", TiKV)
+	pd := newTestStatefulSet("pd", PD)
+	// the labels of tikv-1 are absent during rolling update.
+	unlabeled := ownedBy(newTestPod("tikv-1", TiKV, corev1.PodRunning), tikv)
+	unlabeled.Labels = nil
+	client := fake.NewSimpleClientset(
+		tikv,
+		pd,
+		ownedBy(newTestPod("tikv-0", TiKV, corev1.PodRunning), tikv),
+		unlabeled,
+		// the pod only shares the labels with the stateful set.
+		newTestPod("tikv-debug", TiKV, corev1.PodRunning),
+		ownedBy(newTestPod("pd-0", PD, corev1.PodRunning), pd),
+	)
+	co := newTestCloudOperator(context.Background(), client, nil)
+
+	testCases := []struct {
+		discovery string
+		cp        component
+		expect    []string
+	}{
+		{"", TiKV, []string{"tikv-0", "tikv-debug"}},
+		{DiscoveryLabel, PD, []string{"pd-0"}},
+		{DiscoveryStatefulSet, TiKV, []string{"tikv-0", "tikv-1"}},
+		{DiscoveryStatefulSet, PD, []string{"pd-0"}},
+		{DiscoveryStatefulSet, TiDB, nil},
+	}
+	for _, ca := range testCases {
+		co.Discovery = ca.discovery
+		pods, err := co.discoverPods(ca.cp)
+		assert.NoError(t, err)
+		var names []string
+		for _, pod := range pods.Items {
+			names = append(names, pod.Name)
+		}
+		assert.Equal(t, ca.expect, names, ca.discovery)
+	}
+
+	// the operations find the pods by the discovery too.
+	co.Discovery = DiscoveryStatefulSet
+	pods, err := co.filterPods(PodFilter{Pods: []string{"tikv-1"}})
+	assert.NoError(t, err)
+	assert.Len(t, pods[TiKV], 1)
+	_, err = co.filterPods(PodFilter{Pods: []string{"tikv-debug"}})
+	assert.EqualError(t, err, "pods not found: tikv-debug")
+	assert.Contains(t, co.permissions(), Permission{Verb: "list", Group: "apps", Resource: "statefulsets"})
+}
+
+func TestValidateDiscovery(t *testing.T) {
+	testCases := []struct {
+		discovery string
+		valid     bool
+	}{
+		{"", true},
+		{DiscoveryLabel, true},
+		{DiscoveryStatefulSet, true},
+		{"deployment", false},
+	}
+	for _, ca := range testCases {
+		assert.Equal(t, ca.valid, ValidateDiscovery(ca.discovery) == nil, ca.discovery)
+	}
+}
+
+func TestValidateNamespaceSelector(t *testing.T) {
+	testCases := []struct {
+		selector string
+		valid    bool
+	}{
+		{"", true},
+		{"app=tidb-cluster", true},
+		{"app in (tidb, tikv),tenant!=test", true},
+		{"app==", true},
+		{"app=(", false},
+	}
+	for _, ca := range testCases {
+		assert.Equal(t, ca.valid, ValidateNamespaceSelector(ca.selector) == nil, ca.selector)
+	}
+}
+
+func TestSelectNamespaces(t *testing.T) {
+	newNamespace := func(name string, labels map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+	var objects []runtime.Object
+	for _, namespace := range []string{"tidb-a", "tidb-b", "tidb-c"} {
+		pod := newTestPod("tikv-0", TiKV, corev1.PodRunning)
+		pod.Namespace = namespace
+		objects = append(objects, pod)
+	}
+	objects = append(objects,
+		newNamespace("tidb-c", map[string]string{"app": "tidb-cluster"}),
+		newNamespace("tidb-a", map[string]string{"app": "tidb-cluster"}),
+		newNamespace("tidb-b", map[string]string{"app": "other"}),
+	)
+	client := fake.NewSimpleClientset(objects...)
+
+	co := newTestCloudOperator(context.Background(), client, nil)
+	assert.EqualError(t, co.SelectNamespaces("app=missing"), "no namespace matches the selector app=missing")
+	assert.Equal(t, []string{metav1.NamespaceDefault}, co.Namespaces)
+	assert.NoError(t, co.SelectNamespaces("app=tidb-cluster"))
+	assert.Equal(t, []string{"tidb-a", "tidb-c"}, co.Namespaces)
+
+	// the operations fan out across the matched namespaces.
+	executor := &namespaceExecutor{}
+	co = newTestCloudOperator(context.Background(), client, executor)
+	co.Components = []component{TiKV}
+	assert.NoError(t, co.SelectNamespaces("app=tidb-cluster"))
+	versions, err := co.List(ListFilter{})
+	assert.NoError(t, err)
+	assert.Equal(t, []BackupInfo{
+		{Component: "tikv", Pod: "tidb-a/tikv-0", Versions: []string{"5.2"}},
+		{Component: "tikv", Pod: "tidb-c/tikv-0", Versions: []string{"5.2"}},
+	}, versions)
+	assert.ElementsMatch(t, []string{"tidb-a/tikv-0", "tidb-c/tikv-0"}, executor.calls)
+}
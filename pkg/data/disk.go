@@ -0,0 +1,213 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DfExecCmd returns the file system size of the directory in KB.
+// The nearest existing parent is checked if the directory doesn't exist yet, e.g. the backup root before the first backup.
+func (c component) DfExecCmd(dir string) string {
+	return fmt.Sprintf(`df -Pk "$(d=%s; while [ ! -d "$d" ]; do d=${d%%/*}; d=${d:-/}; done; echo "$d")"`, dir)
+}
+
+// DuExecCmd returns the size in KB of the files which will be backed up in the directory.
+func (c component) DuExecCmd(dir string) string {
+	return c.duExecCmd(dir, backupPattern)
+}
+
+// duExecCmd is the same as DuExecCmd, but the files matching the pattern are excluded.
+func (c component) duExecCmd(dir, pattern string) string {
+	return fmt.Sprintf("cd %s;du -sk `ls -A | grep -vE '%s'`", dir, pattern)
+}
+
+// parseDf parses the output of `df -Pk` and returns the total and available size in KB.
+// e.g.
+// Filesystem     1024-blocks     Used Available Capacity Mounted on
+// /dev/sda1        102687672 43720272  53708172      45% /var/lib/tikv
+func parseDf(output string) (total, avail int64, err error) {
+	lines := splitLines(strings.TrimSpace(output))
+	if len(lines) < 2 {
+		return 0, 0, fmt.Errorf("invalid df output: %q", output)
+	}
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(fields) < 4 {
+		return 0, 0, fmt.Errorf("invalid df output: %q", output)
+	}
+	if total, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid df total size: %q", fields[1])
+	}
+	if avail, err = strconv.ParseInt(fields[3], 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid df available size: %q", fields[3])
+	}
+	return total, avail, nil
+}
+
+// parseDu parses the output of `du -sk` and returns the sum size in KB.
+// The sizes with units like the output of `du -sh` are accepted too, see parseSize.
+// e.g.
+// 4       LOCK
+// 1024    db
+// 1.5G    raft
+func parseDu(output string) (int64, error) {
+	var size int64
+	for _, line := range splitLines(output) {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		n, err := parseSize(fields[0])
+		if err != nil {
+			return 0, fmt.Errorf("invalid du output: %q", line)
+		}
+		size += n
+	}
+	return size, nil
+}
+
+// sizeUnits are the binary units of the sizes in KB.
+var sizeUnits = map[string]int64{
+	"K": 1,
+	"M": 1 << 10,
+	"G": 1 << 20,
+	"T": 1 << 30,
+}
+
+// parseSize parses the size with an optional binary unit into KB, the size without unit is in KB.
+// The unit is case insensitive and may end with i or B, e.g. 512, 4K, 1.5M, 200G, 200GiB, 1TB.
+func parseSize(size string) (int64, error) {
+	upper := strings.ToUpper(size)
+	num := strings.TrimRight(upper, "KMGTIB")
+	unit := strings.TrimSuffix(strings.TrimSuffix(upper[len(num):], "B"), "I")
+	scale := int64(1)
+	if len(unit) > 0 {
+		var ok bool
+		if scale, ok = sizeUnits[unit]; !ok {
+			return 0, fmt.Errorf("invalid size unit: %q", size)
+		}
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 || math.IsInf(n, 0) || math.IsNaN(n) {
+		return 0, fmt.Errorf("invalid size: %q", size)
+	}
+	return int64(math.Ceil(n * float64(scale))), nil
+}
+
+// ParseMaxBackupSize parses the max backup size with an optional binary unit into KB, e.g. 200G, 512Mi,
+// empty means no limit.
+func ParseMaxBackupSize(size string) (int64, error) {
+	if len(size) == 0 {
+		return 0, nil
+	}
+	kb, err := parseSize(size)
+	if err != nil {
+		return 0, err
+	}
+	if kb == 0 {
+		return 0, fmt.Errorf("max backup size should be positive: %q", size)
+	}
+	return kb, nil
+}
+
+// checkBackupSize checks the size of the files to back up doesn't exceed the max backup size, 0 means no limit.
+func checkBackupSize(duOutput string, maxSize int64) error {
+	size, err := parseDu(duOutput)
+	if err != nil {
+		return err
+	}
+	if maxSize > 0 && size > maxSize {
+		return fmt.Errorf("backup size %dKB exceeds the max backup size %dKB", size, maxSize)
+	}
+	return nil
+}
+
+// checkFreeSpace checks the file system has enough space to back up the directory,
+// the free space ratio after backing up should not be less than minFreeRatio.
+func checkFreeSpace(dfOutput, duOutput string, minFreeRatio float64) error {
+	total, avail, err := parseDf(dfOutput)
+	if err != nil {
+		return err
+	}
+	size, err := parseDu(duOutput)
+	if err != nil {
+		return err
+	}
+	if total <= 0 {
+		return errors.New("file system size is zero")
+	}
+	if free := avail - size; free < 0 || float64(free)/float64(total) < minFreeRatio {
+		return fmt.Errorf("insufficient space, available: %dKB, backup size: %dKB, total: %dKB, min free ratio: %.2f", avail, size, total, minFreeRatio)
+	}
+	return nil
+}
+
+// checkDiskSpace checks all the pods have enough space to back up.
+// The pods whose backups exceed MaxBackupSize are skipped by backup, it fails in strict mode instead.
+func (c *CloudOperator) checkDiskSpace(cp component, pods []corev1.Pod) error {
+	if c.DryRun {
+		return nil
+	}
+	// the backups take the space of the volume which holds the backup directory of the component.
+	dir := c.backupRoot(cp)
+	errs := newPodErrors()
+	for i := range pods {
+		podName := c.podKey(&pods[i])
+		container, err := c.container(&pods[i], cp)
+		if err != nil {
+			errs.add(podName, err)
+			continue
+		}
+		dfOutput, err := c.exec(podName, container, []string{"sh", "-c", cp.DfExecCmd(dir)})
+		if err != nil {
+			errs.add(podName, err)
+			continue
+		}
+		// all the data directories are backed up into the first one.
+		var duOutput string
+		for _, dataDir := range cp.BataDirs(c.DataDirs) {
+			var output string
+			if output, err = c.exec(podName, container, []string{"sh", "-c", cp.duExecCmd(dataDir, excludePattern(c.basePattern(), c.Excludes))}); err != nil {
+				break
+			}
+			duOutput += output
+		}
+		if err != nil {
+			errs.add(podName, err)
+			continue
+		}
+		if err := checkBackupSize(duOutput, c.MaxBackupSize); err != nil {
+			if c.Strict {
+				errs.add(podName, fmt.Errorf("%w in strict mode", err))
+				continue
+			}
+			log.Warn("skip the pod whose backup is too large", zap.String("pod-name", podName), zap.Error(err))
+			c.skipped.add(SkippedPod{Operation: "backup", Pod: podName, Component: cp.String(), Phase: string(pods[i].Status.Phase), Reason: err.Error()})
+			continue
+		}
+		if err := checkFreeSpace(dfOutput, duOutput, c.MinFreeRatio); err != nil {
+			log.Error("check disk space failed", zap.String("pod-name", podName), zap.Error(err))
+			errs.add(podName, err)
+		}
+	}
+	return errs.err()
+}
@@ -0,0 +1,219 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCheckFreeSpace(t *testing.T) {
+	df := "Filesystem     1024-blocks     Used Available Capacity Mounted on\r\n" +
+		"/dev/sda1             1000      400       600      40% /var/lib/tikv\r\n"
+	testCases := []struct {
+		df           string
+		du           string
+		minFreeRatio float64
+		hasErr       bool
+	}{
+		{
+			df:           df,
+			du:           "4\tLOCK\r\n396\tdb\r\n",
+			minFreeRatio: 0.1,
+		},
+		{
+			// 600 - 550 < 1000 * 0.1
+			df:           df,
+			du:           "550\tdb\r\n",
+			minFreeRatio: 0.1,
+			hasErr:       true,
+		},
+		{
+			df:     df,
+			du:     "700\tdb\r\n",
+			hasErr: true,
+		},
+		{
+			df:     "",
+			du:     "4\tLOCK\r\n",
+			hasErr: true,
+		},
+		{
+			df:     df,
+			du:     "du: can't open 'db': Permission denied\r\n",
+			hasErr: true,
+		},
+	}
+	for _, ca := range testCases {
+		err := checkFreeSpace(ca.df, ca.du, ca.minFreeRatio)
+		if ca.hasErr {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+}
+
+func TestParseDfAndDu(t *testing.T) {
+	total, avail, err := parseDf("Filesystem 1024-blocks Used Available Capacity Mounted on\n/dev/sda1 102687672 43720272 53708172 45% /var/lib/tikv\n")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(102687672), total)
+	assert.Equal(t, int64(53708172), avail)
+
+	size, err := parseDu("4\tLOCK\n1024\tdb\n\n")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1028), size)
+}
+
+func TestParseSize(t *testing.T) {
+	testCases := []struct {
+		size   string
+		expect int64
+		hasErr bool
+	}{
+		{size: "512", expect: 512},
+		{size: "4K", expect: 4},
+		{size: "1.5M", expect: 1536},
+		{size: "200G", expect: 200 << 20},
+		{size: "200GiB", expect: 200 << 20},
+		{size: "200gb", expect: 200 << 20},
+		{size: "1T", expect: 1 << 30},
+		{size: "0", expect: 0},
+		{size: "", hasErr: true},
+		{size: "G", hasErr: true},
+		{size: "1P", hasErr: true},
+		{size: "-1G", hasErr: true},
+		{size: "1KK", hasErr: true},
+	}
+	for _, ca := range testCases {
+		size, err := parseSize(ca.size)
+		if ca.hasErr {
+			assert.Error(t, err, ca.size)
+			continue
+		}
+		assert.NoError(t, err, ca.size)
+		assert.Equal(t, ca.expect, size, ca.size)
+	}
+
+	size, err := ParseMaxBackupSize("")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), size)
+	_, err = ParseMaxBackupSize("0G")
+	assert.Error(t, err)
+}
+
+func TestCheckBackupSize(t *testing.T) {
+	testCases := []struct {
+		du      string
+		maxSize int64
+		hasErr  bool
+	}{
+		// du -sk
+		{du: "4\tLOCK\r\n1024\tdb\r\n", maxSize: 1028},
+		{du: "4\tLOCK\r\n1024\tdb\r\n", maxSize: 1027, hasErr: true},
+		// du -sh
+		{du: "4.0K\tLOCK\r\n512M\tdb\r\n1.5G\traft\r\n", maxSize: 2<<20 + 4},
+		{du: "4.0K\tLOCK\r\n512M\tdb\r\n1.5G\traft\r\n", maxSize: 2<<20 + 3, hasErr: true},
+		{du: "300G\tdb\r\n", maxSize: 200 << 20, hasErr: true},
+		// no limit
+		{du: "300G\tdb\r\n"},
+		{du: "du: can't open 'db': Permission denied\r\n", maxSize: 1 << 20, hasErr: true},
+	}
+	for _, ca := range testCases {
+		err := checkBackupSize(ca.du, ca.maxSize)
+		if ca.hasErr {
+			assert.Error(t, err, ca.du)
+		} else {
+			assert.NoError(t, err, ca.du)
+		}
+	}
+}
+
+func TestBackMaxBackupSize(t *testing.T) {
+	newOperator := func() (*CloudOperator, *fakeExecutor) {
+		client := fake.NewSimpleClientset(
+			newTestPod("tikv-0", TiKV, corev1.PodRunning),
+			newTestPod("tikv-1", TiKV, corev1.PodRunning),
+			newTestPod("pd-0", PD, corev1.PodRunning),
+		)
+		executor := newFakeExecutor(func(podName string, command []string) (string, error) {
+			cmd := command[len(command)-1]
+			switch {
+			case strings.Contains(cmd, "ps -ef"):
+				return "UID\r\n1\r\n", nil
+			case strings.HasPrefix(cmd, "df"):
+				return "Filesystem 1024-blocks Used Available Capacity Mounted on\r\n/dev/sda1 1000 400 600 40% /var/lib\r\n", nil
+			case strings.Contains(cmd, "du -sk `ls"):
+				if podName == "tikv-1" {
+					return "307200\tdb\r\n", nil
+				}
+				return "4\tdb\r\n", nil
+			}
+			return "", nil
+		})
+		co := newTestCloudOperator(context.Background(), client, executor)
+		co.RetryCount = 1
+		co.MaxBackupSize = 200 << 10
+		return co, executor
+	}
+
+	// the pod whose backup is too large is skipped.
+	co, executor := newOperator()
+	results, err := co.Back("5.2")
+	assert.NoError(t, err)
+	var pods []string
+	for _, rst := range results {
+		pods = append(pods, rst.Pod)
+	}
+	assert.ElementsMatch(t, []string{"tikv-0", "pd-0"}, pods)
+	for _, call := range executor.calls["tikv-1"] {
+		assert.NotContains(t, call[2], "back_5.2.sh")
+	}
+	assert.Equal(t, []SkippedPod{{Operation: "backup", Pod: "tikv-1", Component: "tikv", Phase: "Running",
+		Reason: "backup size 307200KB exceeds the max backup size 204800KB"}}, co.Skipped())
+
+	// nothing is backed up in strict mode.
+	co, executor = newOperator()
+	co.Strict = true
+	_, err = co.Back("5.2")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "tikv-1: backup size 307200KB exceeds the max backup size 204800KB in strict mode")
+	for _, calls := range executor.calls {
+		for _, call := range calls {
+			assert.NotContains(t, call[2], "back_5.2.sh")
+		}
+	}
+}
+
+func TestCheckDiskSpaceDir(t *testing.T) {
+	for root, dir := range map[string]string{"": TiKV.BataDir(nil), "/backup": "/backup/tikv"} {
+		pod := newTestPod("tikv-0", TiKV, corev1.PodRunning)
+		executor := newFakeExecutor(func(_ string, command []string) (string, error) {
+			if strings.HasPrefix(command[len(command)-1], "df") {
+				return "Filesystem 1024-blocks Used Available Capacity Mounted on\r\n/dev/sda1 1000 400 600 40% /var/lib\r\n", nil
+			}
+			return "4\tdb\r\n", nil
+		})
+		co := newTestCloudOperator(context.Background(), fake.NewSimpleClientset(pod), executor)
+		co.BackupRoot = root
+		assert.NoError(t, co.checkDiskSpace(TiKV, []corev1.Pod{*pod}))
+		// df runs on the directory the backup is written into, not the backup root or the data directory.
+		assert.Contains(t, executor.calls["tikv-0"], []string{"sh", "-c", TiKV.DfExecCmd(dir)}, root)
+	}
+}
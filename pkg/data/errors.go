@@ -0,0 +1,197 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	// exitCodeNotExecutable is the exit code of the shell if the command can't be executed.
+	exitCodeNotExecutable = 126
+	// exitCodeNotFound is the exit code of the shell if the command is not found.
+	exitCodeNotFound = 127
+)
+
+// ErrorCategory is the kind of the failure, it lets the callers, e.g. the exit code of the command line,
+// tell the failures apart.
+type ErrorCategory int
+
+// The error categories.
+const (
+	// CategoryGeneric is the failure which doesn't belong to any other category.
+	CategoryGeneric ErrorCategory = iota
+	// CategoryPreflight is the failure of the preflight checks or the missing permissions.
+	CategoryPreflight
+	// CategoryPartial is the failure of some pods or components while the others may succeed.
+	CategoryPartial
+	// CategoryTimeout is the failure of the timeout or the exceeded deadline.
+	CategoryTimeout
+)
+
+// String implements fmt.Stringer interface.
+func (c ErrorCategory) String() string {
+	switch c {
+	case CategoryPreflight:
+		return "preflight"
+	case CategoryPartial:
+		return "partial"
+	case CategoryTimeout:
+		return "timeout"
+	default:
+		return "generic"
+	}
+}
+
+// CategorizedError is the error with its category, the message is the one of the wrapped error.
+type CategorizedError struct {
+	Category ErrorCategory
+	Err      error
+}
+
+// Error implements error interface.
+func (e *CategorizedError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *CategorizedError) Unwrap() error {
+	return e.Err
+}
+
+// WithCategory wraps the error with the category, it returns nil if err is nil.
+func WithCategory(category ErrorCategory, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &CategorizedError{Category: category, Err: err}
+}
+
+// Category returns the category of the error, the outermost CategorizedError wins.
+// The uncategorized timeouts and permission errors are recognized too, the others are CategoryGeneric.
+func Category(err error) ErrorCategory {
+	var categorized *CategorizedError
+	switch {
+	case err == nil:
+		return CategoryGeneric
+	case errors.As(err, &categorized):
+		return categorized.Category
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errWaitTimeout):
+		return CategoryTimeout
+	case apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err):
+		return CategoryPreflight
+	}
+	return CategoryGeneric
+}
+
+// fatalExecMessages are the messages of the exec errors which are not returned as API status,
+// e.g. kubelet replies them in plain text when the connection can't be upgraded.
+var fatalExecMessages = []string{
+	"container not found",
+	"pod does not exist",
+}
+
+// errorCollector collects the errors of pods or components, it is safe for concurrent use.
+type errorCollector struct {
+	sync.Mutex
+	// kind is the kind of the targets, e.g. pods.
+	kind string
+	// K: target name V: error
+	errs map[string]error
+	// targets is the number of the targets, including the succeeded ones.
+	targets int
+}
+
+func newErrorCollector(kind string) *errorCollector {
+	return &errorCollector{kind: kind, errs: make(map[string]error)}
+}
+
+// newPodErrors collects the errors of pods.
+func newPodErrors() *errorCollector {
+	return newErrorCollector("pods")
+}
+
+// add records the error of the target.
+func (p *errorCollector) add(name string, err error) {
+	p.Lock()
+	defer p.Unlock()
+	p.errs[name] = err
+}
+
+// expect adds n targets whose errors are collected, so err knows whether any target succeeded.
+func (p *errorCollector) expect(n int) {
+	p.Lock()
+	defer p.Unlock()
+	p.targets += n
+}
+
+// err combines all the errors into one, it returns nil if no target failed.
+// The error is CategoryPartial if any expected target succeeded, otherwise it keeps the category
+// shared by all the errors, e.g. all the pods timed out, the mixed errors are CategoryGeneric.
+func (p *errorCollector) err() error {
+	p.Lock()
+	defer p.Unlock()
+	if len(p.errs) == 0 {
+		return nil
+	}
+	pods := make([]string, 0, len(p.errs))
+	for pod := range p.errs {
+		pods = append(pods, pod)
+	}
+	sort.Strings(pods)
+	msgs := make([]string, 0, len(pods))
+	category := Category(p.errs[pods[0]])
+	for _, pod := range pods {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", pod, p.errs[pod]))
+		if Category(p.errs[pod]) != category {
+			category = CategoryGeneric
+		}
+	}
+	if p.targets > len(pods) {
+		category = CategoryPartial
+	}
+	return WithCategory(category, fmt.Errorf("%d %s failed: %s", len(pods), p.kind, strings.Join(msgs, "; ")))
+}
+
+// isRetryable returns whether the exec may succeed if it's retried.
+// The cancelled operation and the misconfiguration, e.g. the pod or the container is not found,
+// fail fast, while the transient API and connection errors and the timeout attempt are retried.
+func isRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		// the command may fail due to the transient state in the pod, but the missing command never succeeds.
+		return exitErr.Code != exitCodeNotExecutable && exitErr.Code != exitCodeNotFound
+	}
+	if apierrors.IsNotFound(err) || apierrors.IsBadRequest(err) || apierrors.IsInvalid(err) ||
+		apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err) || apierrors.IsMethodNotSupported(err) {
+		return false
+	}
+	msg := err.Error()
+	for _, fatal := range fatalExecMessages {
+		if strings.Contains(msg, fatal) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,166 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPodErrors(t *testing.T) {
+	errs := newPodErrors()
+	assert.NoError(t, errs.err())
+
+	wg := &sync.WaitGroup{}
+	for _, pod := range []string{"tikv-2", "tikv-0", "pd-0"} {
+		wg.Add(1)
+		go func(pod string) {
+			defer wg.Done()
+			errs.add(pod, errors.New("exec failed"))
+		}(pod)
+	}
+	wg.Wait()
+	assert.EqualError(t, errs.err(), "3 pods failed: pd-0: exec failed; tikv-0: exec failed; tikv-2: exec failed")
+}
+
+func TestErrorCategory(t *testing.T) {
+	pods := schema.GroupResource{Resource: "pods"}
+	errs := newPodErrors()
+	errs.expect(2)
+	errs.add("tikv-0", errors.New("exec failed"))
+	partial := errs.err()
+	testCases := []struct {
+		err      error
+		category ErrorCategory
+	}{
+		{nil, CategoryGeneric},
+		{errors.New("version 5.2 not found"), CategoryGeneric},
+		{partial, CategoryPartial},
+		{fmt.Errorf("stop cloud operator failed: %w", partial), CategoryPartial},
+		// the outermost category wins.
+		{WithCategory(CategoryPreflight, partial), CategoryPreflight},
+		{WithCategory(CategoryTimeout, fmt.Errorf("back failed: %w", partial)), CategoryTimeout},
+		{fmt.Errorf("wait pods ready failed: %w", errWaitTimeout), CategoryTimeout},
+		{fmt.Errorf("exec in pod tikv-0 is cancelled: %w", context.DeadlineExceeded), CategoryTimeout},
+		{context.Canceled, CategoryGeneric},
+		{fmt.Errorf("lock namespace tidb failed: %w", apierrors.NewForbidden(pods, "tikv-0", errors.New("cannot patch pods"))), CategoryPreflight},
+		{apierrors.NewUnauthorized("token expired"), CategoryPreflight},
+	}
+	for _, ca := range testCases {
+		assert.Equal(t, ca.category, Category(ca.err), "%v", ca.err)
+	}
+	// the category doesn't change the message.
+	assert.EqualError(t, WithCategory(CategoryPreflight, partial), "1 pods failed: tikv-0: exec failed")
+	assert.NoError(t, WithCategory(CategoryPreflight, nil))
+}
+
+func TestErrorCollectorCategory(t *testing.T) {
+	pods := schema.GroupResource{Resource: "pods"}
+	timeout := fmt.Errorf("exec in pod is cancelled: %w", context.DeadlineExceeded)
+	forbidden := apierrors.NewForbidden(pods, "tikv-0", errors.New("cannot create pods/exec"))
+	testCases := []struct {
+		targets  int
+		errs     []error
+		category ErrorCategory
+	}{
+		// some targets succeeded.
+		{3, []error{errors.New("exec failed")}, CategoryPartial},
+		{3, []error{timeout, timeout}, CategoryPartial},
+		// all the targets failed with the same category.
+		{2, []error{errors.New("exec failed"), errors.New("exec failed")}, CategoryGeneric},
+		{2, []error{timeout, timeout}, CategoryTimeout},
+		{2, []error{forbidden, forbidden}, CategoryPreflight},
+		// the targets aren't expected, e.g. the pre-checks, so no target is taken as succeeded.
+		{0, []error{timeout}, CategoryTimeout},
+		// all the targets failed with mixed categories.
+		{2, []error{timeout, forbidden}, CategoryGeneric},
+	}
+	for _, ca := range testCases {
+		errs := newPodErrors()
+		errs.expect(ca.targets)
+		for i, err := range ca.errs {
+			errs.add(fmt.Sprintf("tikv-%d", i), err)
+		}
+		assert.Equal(t, ca.category, Category(errs.err()), "%v", ca)
+	}
+
+	// the failed pre-checks are preflight failures even if the other components passed.
+	client := fake.NewSimpleClientset(
+		newTestPod("tikv-0", TiKV, corev1.PodRunning),
+		newTestPod("pd-0", PD, corev1.PodRunning),
+	)
+	co := newTestCloudOperator(context.Background(), client, newFakeExecutor(nil))
+	_, err := co.prepare("backup", func(cp component, _ []corev1.Pod) error {
+		if cp == TiKV {
+			return timeout
+		}
+		return nil
+	})
+	assert.Error(t, err)
+	assert.Equal(t, CategoryPreflight, Category(err))
+}
+
+func TestIsRetryable(t *testing.T) {
+	pods := schema.GroupResource{Resource: "pods"}
+	testCases := []struct {
+		err       error
+		retryable bool
+	}{
+		{nil, false},
+		{context.Canceled, false},
+		{fmt.Errorf("exec in pod tikv-0 is cancelled: %w", context.Canceled), false},
+		// the attempt timeout is retried.
+		{context.DeadlineExceeded, true},
+		{apierrors.NewNotFound(pods, "tikv-0"), false},
+		{apierrors.NewBadRequest("container db is not valid for pod tikv-0"), false},
+		{apierrors.NewForbidden(pods, "tikv-0", errors.New("cannot create pods/exec")), false},
+		{apierrors.NewUnauthorized("token expired"), false},
+		{errors.New(`unable to upgrade connection: container not found ("tikv")`), false},
+		{errors.New("unable to upgrade connection: pod does not exist"), false},
+		{apierrors.NewInternalError(errors.New("etcd leader changed")), true},
+		{apierrors.NewServiceUnavailable("apiserver is shutting down"), true},
+		{apierrors.NewTooManyRequests("throttled", 1), true},
+		{apierrors.NewTimeoutError("request timeout", 1), true},
+		{errors.New("error dialing backend: dial tcp 10.0.0.1:10250: connect: connection refused"), true},
+		{&ExitError{Code: 1}, true},
+		{&ExitError{Code: exitCodeNotExecutable}, false},
+		{&ExitError{Code: exitCodeNotFound}, false},
+	}
+	for _, ca := range testCases {
+		assert.Equal(t, ca.retryable, isRetryable(ca.err), "%v", ca.err)
+	}
+}
+
+func TestExecFailFast(t *testing.T) {
+	client := fake.NewSimpleClientset(newTestPod("tikv-0", TiKV, corev1.PodRunning))
+	executor := newFakeExecutor(func(_ string, _ []string) (string, error) {
+		return "", apierrors.NewBadRequest("container db is not valid for pod tikv-0")
+	})
+	co := newTestCloudOperator(context.Background(), client, executor)
+	co.RetryCount = MaxRetry
+	co.RetryBackoff = 0
+	co.RetryMaxBackoff = 0
+	_, err := co.exec("tikv-0", "db", []string{"ls"})
+	assert.EqualError(t, err, "exec in pod tikv-0 failed: container db is not valid for pod tikv-0")
+	assert.Len(t, executor.calls["tikv-0"], 1)
+}
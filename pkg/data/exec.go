@@ -0,0 +1,92 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ExecResult is the output of the command in one pod.
+type ExecResult struct {
+	Pod       string `json:"pod"`
+	Component string `json:"component"`
+	Output    string `json:"output"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ExecPods runs the command in the container of the component in every pod, or only the pods in Pods if it's not empty.
+// The input is the stdin of the command if it's not nil, it's sent to every pod.
+// The pods which are not running are skipped, and the results are sorted by pod name.
+func (c *CloudOperator) ExecPods(componentName string, command []string, input []byte) ([]ExecResult, error) {
+	cp, ok := nameToComponent[strings.ToLower(componentName)]
+	if !ok {
+		return nil, fmt.Errorf("unknown component: %s", componentName)
+	}
+	if len(command) == 0 {
+		return nil, errors.New("no command to exec")
+	}
+	list, err := c.discoverPods(cp)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]bool, len(c.Pods))
+	pods := c.runningPods("exec", cp, c.selectPods(list.Items, found))
+	if missing := missingPods(c.Pods, found); len(missing) > 0 {
+		return nil, fmt.Errorf("pods not found: %s", strings.Join(missing, ", "))
+	}
+	if err := c.skippedErr("exec"); err != nil {
+		return nil, err
+	}
+	wg := &sync.WaitGroup{}
+	mu := &sync.Mutex{}
+	results := make([]ExecResult, 0, len(pods))
+	for i := range pods {
+		wg.Add(1)
+		go func(pod *corev1.Pod) {
+			defer wg.Done()
+			podName := c.podKey(pod)
+			rst := ExecResult{Pod: podName, Component: cp.String()}
+			if output, err := c.execPod(pod, cp, command, input); err != nil {
+				log.Error("exec failed", zap.String("pod-name", podName), zap.Error(err))
+				rst.Error = err.Error()
+			} else {
+				rst.Output = output
+			}
+			mu.Lock()
+			results = append(results, rst)
+			mu.Unlock()
+		}(&pods[i])
+	}
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Pod < results[j].Pod
+	})
+	return results, nil
+}
+
+func (c *CloudOperator) execPod(pod *corev1.Pod, cp component, command []string, input []byte) (string, error) {
+	container, err := c.container(pod, cp)
+	if err != nil {
+		return "", err
+	}
+	return c.execInput(c.podKey(pod), container, command, input)
+}
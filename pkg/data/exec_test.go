@@ -0,0 +1,100 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestExecPods(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		newTestPod("tikv-0", TiKV, corev1.PodRunning),
+		newTestPod("tikv-1", TiKV, corev1.PodRunning),
+		newTestPod("tikv-2", TiKV, corev1.PodPending),
+		newTestPod("pd-0", PD, corev1.PodRunning),
+	)
+	executor := newFakeExecutor(func(podName string, command []string) (string, error) {
+		if podName == "tikv-1" {
+			return "", errors.New("connection refused")
+		}
+		return podName + ": " + strings.Join(command, " ") + "\r\n", nil
+	})
+	co := newTestCloudOperator(context.Background(), client, executor)
+	co.RetryCount = 1
+	results, err := co.ExecPods("tikv", []string{"sh", "-c", "cat > /tmp/config.toml"}, []byte("[server]\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []ExecResult{
+		{Pod: "tikv-0", Component: "tikv", Output: "tikv-0: sh -c cat > /tmp/config.toml\r\n"},
+		{Pod: "tikv-1", Component: "tikv", Error: "exec in pod tikv-1 failed: connection refused"},
+	}, results)
+	assert.Equal(t, []string{"[server]\n"}, executor.stdins["tikv-0"])
+	assert.Empty(t, executor.calls["tikv-2"])
+	assert.Empty(t, executor.calls["pd-0"])
+	assert.Equal(t, []SkippedPod{{Operation: "exec", Pod: "tikv-2", Component: "tikv", Phase: "Pending"}}, co.Skipped())
+
+	// only the pods in Pods, the command without stdin.
+	co.Pods = []string{"tikv-0"}
+	results, err = co.ExecPods("TiKV", []string{"ls"}, nil)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Len(t, executor.calls["tikv-0"], 2)
+	assert.Len(t, executor.stdins["tikv-0"], 1)
+	co.Pods = []string{"tikv-9"}
+	_, err = co.ExecPods("tikv", []string{"ls"}, nil)
+	assert.EqualError(t, err, "pods not found: tikv-9")
+	co.Pods = nil
+
+	_, err = co.ExecPods("tiflash", []string{"ls"}, nil)
+	assert.EqualError(t, err, "unknown component: tiflash")
+	_, err = co.ExecPods("tikv", nil, nil)
+	assert.Error(t, err)
+
+	// it only prints the command in dry run mode.
+	out := new(bytes.Buffer)
+	co.DryRun = true
+	co.Out = out
+	co.Pods = []string{"tikv-0"}
+	_, err = co.ExecPods("tikv", []string{"ls"}, []byte("x"))
+	assert.NoError(t, err)
+	assert.Equal(t, "[dry-run] exec in pod tikv-0 container tikv: ls\n", out.String())
+	assert.Len(t, executor.calls["tikv-0"], 2)
+}
+
+func TestExecInputRetry(t *testing.T) {
+	client := fake.NewSimpleClientset(newTestPod("tikv-0", TiKV, corev1.PodRunning))
+	var calls int
+	executor := newFakeExecutor(func(_ string, _ []string) (string, error) {
+		calls++
+		if calls == 1 {
+			return "", errors.New("connection reset")
+		}
+		return "ok", nil
+	})
+	co := newTestCloudOperator(context.Background(), client, executor)
+	co.RetryBackoff = 0
+	co.RetryMaxBackoff = 0
+	results, err := co.ExecPods("tikv", []string{"cat"}, []byte("input"))
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", results[0].Output)
+	// every attempt reads the whole input.
+	assert.Equal(t, []string{"input", "input"}, executor.stdins["tikv-0"])
+}
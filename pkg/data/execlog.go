@@ -0,0 +1,96 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ExecLogName returns the name of the file which saves the exec output of the operation in the pod.
+// The namespace of the pod key is joined by an underscore, e.g. tidb_tikv-0-backup-5.2.log.
+func ExecLogName(podName, operation, version string) string {
+	return fmt.Sprintf("%s-%s-%s.log", strings.ReplaceAll(podName, "/", "_"), operation, version)
+}
+
+// createExecLog creates the file in LogDir to save the exec output of the operation in the pod.
+// Every pod has its own file, so the parallel pods don't write the same file.
+func (c *CloudOperator) createExecLog(podName, operation, version string) (*os.File, error) {
+	if err := os.MkdirAll(c.LogDir, 0755); err != nil {
+		return nil, fmt.Errorf("create log dir %s failed: %w", c.LogDir, err)
+	}
+	f, err := os.Create(filepath.Join(c.LogDir, ExecLogName(podName, operation, version)))
+	if err != nil {
+		return nil, fmt.Errorf("create exec log failed: %w", err)
+	}
+	return f, nil
+}
+
+// execLog copies the stdout and stderr of every exec attempt to w, it is safe for concurrent use.
+// The errors of writing w are ignored, the log should not fail the exec.
+type execLog struct {
+	mu sync.Mutex
+	w  io.Writer
+	// attempts is the number of the started attempts.
+	attempts int
+	// active is the running attempt, it is 0 if no attempt is running.
+	// The output of the ended attempts is dropped, e.g. the timeout attempt may still write it.
+	active int
+}
+
+func newExecLog(w io.Writer) *execLog {
+	return &execLog{w: w}
+}
+
+// begin starts a new attempt of the command, it returns the writer of its output.
+func (l *execLog) begin(commands []string) io.Writer {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.attempts++
+	l.active = l.attempts
+	fmt.Fprintf(l.w, "==> attempt %d: %s <==\n", l.attempts, strings.Join(commands, " "))
+	return &attemptWriter{log: l, attempt: l.attempts}
+}
+
+// end ends the running attempt with its error.
+func (l *execLog) end(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err != nil {
+		fmt.Fprintf(l.w, "\n==> attempt %d failed: %v <==\n", l.active, err)
+	} else {
+		fmt.Fprintf(l.w, "\n==> attempt %d succeeded <==\n", l.active)
+	}
+	l.active = 0
+}
+
+// attemptWriter writes the output of an attempt to the exec log.
+type attemptWriter struct {
+	log     *execLog
+	attempt int
+}
+
+// Write implements io.Writer interface.
+func (w *attemptWriter) Write(p []byte) (int, error) {
+	w.log.mu.Lock()
+	defer w.log.mu.Unlock()
+	if w.log.active == w.attempt {
+		_, _ = w.log.w.Write(p)
+	}
+	return len(p), nil
+}
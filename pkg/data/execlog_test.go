@@ -0,0 +1,100 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestExecLogName(t *testing.T) {
+	assert.Equal(t, "tikv-0-backup-5.2.log", ExecLogName("tikv-0", "backup", "5.2"))
+	assert.Equal(t, "tidb_tikv-0-restore-5.2-rc.1.log", ExecLogName("tidb/tikv-0", "restore", "5.2-rc.1"))
+}
+
+func TestExecLog(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l := newExecLog(buf)
+	first := l.begin([]string{"sh", "-c", "ls"})
+	_, err := io.WriteString(first, "partial")
+	assert.NoError(t, err)
+	l.end(context.DeadlineExceeded)
+	second := l.begin([]string{"sh", "-c", "ls"})
+	// the timeout attempt still writes after it ended.
+	_, err = io.WriteString(first, "late")
+	assert.NoError(t, err)
+	_, err = io.WriteString(second, "5.2.bat")
+	assert.NoError(t, err)
+	l.end(nil)
+	assert.Equal(t, "==> attempt 1: sh -c ls <==\npartial\n==> attempt 1 failed: context deadline exceeded <==\n"+
+		"==> attempt 2: sh -c ls <==\n5.2.bat\n==> attempt 2 succeeded <==\n", buf.String())
+}
+
+func TestRestoreLogDir(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		newTestPod("tikv-0", TiKV, corev1.PodRunning),
+		newTestPod("tikv-1", TiKV, corev1.PodRunning),
+		newTestPod("pd-0", PD, corev1.PodRunning),
+	)
+	failed := false
+	executor := newFakeExecutor(func(podName string, command []string) (string, error) {
+		cmd := command[len(command)-1]
+		switch {
+		case strings.Contains(cmd, "ps -ef"):
+			return "UID\r\n1\r\n", nil
+		case strings.HasPrefix(cmd, "restored="):
+			return "1 1\r\n", nil
+		case strings.HasPrefix(cmd, "ls"):
+			return "5.1.bat\r\n5.2.bat\r\n", nil
+		case strings.HasPrefix(cmd, "cat"):
+			// the backups have no metadata.
+			return "", nil
+		case podName == "tikv-0" && !failed:
+			failed = true
+			return "cp: no space left on device\r\n", errors.New("connection reset")
+		}
+		return "restored " + podName + "\r\n", nil
+	})
+	co := newTestCloudOperator(context.Background(), client, executor)
+	co.RetryBackoff = 0
+	co.RetryMaxBackoff = 0
+	co.Parallel = 1
+	co.LogDir = filepath.Join(t.TempDir(), "logs")
+	_, err := co.Restore("tikv=5.1,pd=5.2")
+	assert.NoError(t, err)
+
+	read := func(name string) string {
+		content, err := os.ReadFile(filepath.Join(co.LogDir, name))
+		assert.NoError(t, err)
+		return string(content)
+	}
+	tikvCmd := "sh -c " + TiKV.RestoreExecCmd(TiKV.BataDir(nil), "5.1")
+	assert.Equal(t, "==> attempt 1: "+tikvCmd+" <==\ncp: no space left on device\r\n\n==> attempt 1 failed: connection reset <==\n"+
+		"==> attempt 2: "+tikvCmd+" <==\nrestored tikv-0\r\n\n==> attempt 2 succeeded <==\n", read("tikv-0-restore-5.1.log"))
+	assert.Contains(t, read("tikv-1-restore-5.1.log"), "restored tikv-1")
+	assert.Contains(t, read("pd-0-restore-5.2.log"), "restored pd-0")
+	entries, err := os.ReadDir(co.LogDir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+}
@@ -0,0 +1,76 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PodFilter narrows the pods stopped by StopPods and started by StartPods, the zero value matches all the pods.
+type PodFilter struct {
+	// Components keeps the pods of the components, empty means all the components.
+	Components []component
+	// Pods keeps the pods, the pod is the name or namespace/name, empty means all the pods of the components.
+	Pods []string
+}
+
+// ParsePodFilter parses the comma separated components and the pods, empty components means all the components.
+func ParsePodFilter(components string, pods []string) (PodFilter, error) {
+	filter := PodFilter{Pods: pods}
+	if len(strings.TrimSpace(components)) > 0 {
+		cps, err := ParseComponents(components)
+		if err != nil {
+			return filter, err
+		}
+		filter.Components = cps
+	}
+	return filter, nil
+}
+
+// matchComponent returns true if the pods of the component should be kept.
+func (f PodFilter) matchComponent(cp component) bool {
+	return len(f.Components) == 0 || AnyOf(f.Components, func(i int) bool { return f.Components[i] == cp })
+}
+
+// filterComponents returns the components kept by the filter in the order.
+func (f PodFilter) filterComponents(order []component) []component {
+	components := make([]component, 0, len(order))
+	for _, cp := range order {
+		if f.matchComponent(cp) {
+			components = append(components, cp)
+		}
+	}
+	return components
+}
+
+// filterPods lists the pods of the components kept by the filter, K: component V: the pods kept by the filter.
+// It returns error if any pod of the filter is not found.
+func (c *CloudOperator) filterPods(filter PodFilter) (map[component][]corev1.Pod, error) {
+	rst := make(map[component][]corev1.Pod)
+	found := make(map[string]bool, len(filter.Pods))
+	for _, cp := range filter.filterComponents(c.stopOrder()) {
+		list, err := c.discoverPods(cp)
+		if err != nil {
+			return nil, err
+		}
+		rst[cp] = selectNamedPods(list.Items, filter.Pods, found)
+	}
+	if missing := missingPods(filter.Pods, found); len(missing) > 0 {
+		return nil, fmt.Errorf("pods not found: %s", strings.Join(missing, ", "))
+	}
+	return rst, nil
+}
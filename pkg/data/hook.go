@@ -0,0 +1,147 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DefaultHealthTimeout is the default time to wait for the components healthy after restoring.
+const DefaultHealthTimeout = 5 * time.Minute
+
+// PostRestoreHook checks the cluster after it is restored and all the components are up.
+type PostRestoreHook interface {
+	// Check returns error if the restored cluster isn't healthy, the error fails the restore.
+	Check(c *CloudOperator) error
+}
+
+// HealthCheckHook execs the health command of every component in all its running pods,
+// the cluster is healthy if all the commands exit with 0, e.g. pd=/pd-ctl cluster.
+type HealthCheckHook struct {
+	// Commands is the health command of the components, K: component V: shell command,
+	// the components without command are not checked.
+	Commands map[component]string
+	// Timeout bounds the wait for the components healthy, 0 means checking once.
+	Timeout time.Duration
+}
+
+// ParseHealthCommands parses the health commands of the components, every item is component=command,
+// the command can contain commas, e.g. "pd=/pd-ctl -u http://127.0.0.1:2379 cluster".
+func ParseHealthCommands(items []string) (map[component]string, error) {
+	commands := make(map[component]string, len(items))
+	for _, item := range items {
+		cp, cmd, err := parseComponentValue(item)
+		if err != nil {
+			return nil, err
+		}
+		if len(cmd) == 0 {
+			return nil, fmt.Errorf("health command of %s should not be empty", cp)
+		}
+		commands[cp] = cmd
+	}
+	return commands, nil
+}
+
+// Check implements PostRestoreHook interface, it polls the restored components until all of them are healthy
+// or Timeout elapses.
+func (h *HealthCheckHook) Check(c *CloudOperator) error {
+	if c.DryRun {
+		for _, cp := range c.inStopOrder(c.Components) {
+			if cmd, ok := h.Commands[cp]; ok {
+				c.printDryRun("check health of %s: sh -c %s", cp, cmd)
+			}
+		}
+		return nil
+	}
+	var unhealthy error
+	check := func() (bool, error) {
+		unhealthy = h.checkOnce(c)
+		if unhealthy != nil {
+			log.Info("waiting for the cluster healthy", zap.Error(unhealthy))
+		}
+		return unhealthy == nil, nil
+	}
+	if h.Timeout <= 0 {
+		check()
+		return unhealthy
+	}
+	err := backoffWait(c.ctx, check, WaitInterval, h.Timeout, func(d time.Duration) <-chan time.Time {
+		return c.after(withJitter(d))
+	})
+	switch {
+	case errors.Is(err, errWaitTimeout):
+		return fmt.Errorf("not healthy after %s: %w", h.Timeout, unhealthy)
+	case err != nil && unhealthy != nil:
+		return fmt.Errorf("wait healthy is cancelled: %w", err)
+	}
+	return nil
+}
+
+// checkOnce execs the health commands of the restored components once.
+func (h *HealthCheckHook) checkOnce(c *CloudOperator) error {
+	errs := newErrorCollector("components")
+	for _, cp := range c.inStopOrder(c.Components) {
+		cmd, ok := h.Commands[cp]
+		if !ok {
+			continue
+		}
+		if err := h.checkComponent(c, cp, cmd); err != nil {
+			errs.add(cp.String(), err)
+		}
+	}
+	return errs.err()
+}
+
+// checkComponent execs the health command in all the running pods of the component without retrying,
+// the component without running pods isn't healthy.
+func (h *HealthCheckHook) checkComponent(c *CloudOperator, cp component, cmd string) error {
+	list, err := c.discoverPods(cp)
+	if err != nil {
+		return err
+	}
+	errs := newPodErrors()
+	var running int
+	for i := range list.Items {
+		pod := &list.Items[i]
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		running++
+		podName := c.podKey(pod)
+		container, err := c.container(pod, cp)
+		if err != nil {
+			errs.add(podName, err)
+			continue
+		}
+		stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+		if err := c.executor.exec(c.ctx, pod.Name, container, pod.Namespace, []string{"sh", "-c", cmd}, nil, stdout, stderr); err != nil {
+			if output := strings.TrimSpace(stderr.String() + stdout.String()); len(output) > 0 {
+				err = fmt.Errorf("%w: %s", err, output)
+			}
+			errs.add(podName, err)
+		}
+	}
+	if running == 0 {
+		return errors.New("no running pods")
+	}
+	return errs.err()
+}
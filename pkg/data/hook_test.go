@@ -0,0 +1,170 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeHook records the checks and fails them with err.
+type fakeHook struct {
+	calls int
+	err   error
+}
+
+func (h *fakeHook) Check(*CloudOperator) error {
+	h.calls++
+	return h.err
+}
+
+func TestParseHealthCommands(t *testing.T) {
+	testCases := []struct {
+		items  []string
+		expect map[component]string
+		hasErr bool
+	}{
+		{
+			items:  []string{"pd=/pd-ctl -u http://127.0.0.1:2379 cluster", "TiKV = curl -sf http://127.0.0.1:20180/status,metrics"},
+			expect: map[component]string{PD: "/pd-ctl -u http://127.0.0.1:2379 cluster", TiKV: "curl -sf http://127.0.0.1:20180/status,metrics"},
+		},
+		{items: nil, expect: map[component]string{}},
+		{items: []string{"pd"}, hasErr: true},
+		{items: []string{"tiflash=true"}, hasErr: true},
+		{items: []string{"pd="}, hasErr: true},
+	}
+	for _, ca := range testCases {
+		commands, err := ParseHealthCommands(ca.items)
+		if ca.hasErr {
+			assert.Error(t, err, ca.items)
+			continue
+		}
+		assert.NoError(t, err, ca.items)
+		assert.Equal(t, ca.expect, commands)
+	}
+}
+
+func TestHealthCheckHook(t *testing.T) {
+	newOperator := func(failures map[string]int) (*CloudOperator, *fakeExecutor) {
+		client := fake.NewSimpleClientset(
+			newTestPod("tikv-0", TiKV, corev1.PodRunning),
+			newTestPod("tikv-1", TiKV, corev1.PodRunning),
+			newTestPod("pd-0", PD, corev1.PodRunning),
+			newTestPod("pd-1", PD, corev1.PodPending),
+		)
+		executor := newFakeExecutor(func(podName string, _ []string) (string, error) {
+			// the pod fails the health command for the times.
+			if failures[podName] != 0 {
+				failures[podName]--
+				return "store is down", errors.New("exit 1")
+			}
+			return "", nil
+		})
+		co := newTestCloudOperator(context.Background(), client, executor)
+		co.after = func(time.Duration) <-chan time.Time {
+			return time.After(time.Millisecond)
+		}
+		return co, executor
+	}
+	commands := map[component]string{PD: "pd-ctl cluster", TiKV: "tikv-probe", TiDB: "tidb-probe"}
+
+	// it waits until all the components are healthy.
+	co, executor := newOperator(map[string]int{"tikv-1": 2})
+	hook := &HealthCheckHook{Commands: commands, Timeout: time.Minute}
+	assert.NoError(t, hook.Check(co))
+	assert.Len(t, executor.calls["tikv-1"], 3)
+	assert.Equal(t, []string{"sh", "-c", "tikv-probe"}, executor.calls["tikv-0"][0])
+	// the pods which are not running and the components which are not restored are not checked.
+	assert.Empty(t, executor.calls["pd-1"])
+	for _, calls := range executor.calls {
+		for _, call := range calls {
+			assert.NotEqual(t, "tidb-probe", call[2])
+		}
+	}
+
+	// it checks once without timeout.
+	co, executor = newOperator(map[string]int{"tikv-1": 2})
+	hook.Timeout = 0
+	assert.EqualError(t, hook.Check(co), "1 components failed: tikv: 1 pods failed: tikv-1: exit 1: store is down")
+	assert.Len(t, executor.calls["tikv-1"], 1)
+
+	// the unhealthy component fails the check after the timeout.
+	co, _ = newOperator(map[string]int{"pd-0": -1})
+	hook.Timeout = 20 * time.Millisecond
+	err := hook.Check(co)
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "not healthy after 20ms: 1 components failed: pd:"), err.Error())
+
+	// the component without running pods isn't healthy.
+	co, _ = newOperator(nil)
+	co.Components = []component{TiDB}
+	hook.Timeout = 0
+	assert.EqualError(t, hook.Check(co), "1 components failed: tidb: no running pods")
+}
+
+func TestPostRestoreHook(t *testing.T) {
+	newOperator := func(hook PostRestoreHook) *CloudOperator {
+		client := fake.NewSimpleClientset(
+			newTestPod("tikv-0", TiKV, corev1.PodRunning),
+			newTestPod("pd-0", PD, corev1.PodRunning),
+		)
+		executor := newFakeExecutor(func(_ string, command []string) (string, error) {
+			cmd := command[len(command)-1]
+			switch {
+			case strings.Contains(cmd, "ps -ef"):
+				return "UID\r\n1\r\n", nil
+			case strings.HasPrefix(cmd, "df"):
+				return "Filesystem 1024-blocks Used Available Capacity Mounted on\r\n/dev/sda1 1000 400 600 40% /var/lib\r\n", nil
+			case strings.Contains(cmd, "du -sk"):
+				return "4\tdb\r\n", nil
+			case strings.HasPrefix(cmd, "restored="):
+				return "1 1\r\n", nil
+			case strings.HasPrefix(cmd, "ls"):
+				return "5.2.bat\r\n", nil
+			}
+			return "", nil
+		})
+		co := newTestCloudOperator(context.Background(), client, executor)
+		co.Notify = func(string) {}
+		co.PostRestoreHook = hook
+		return co
+	}
+
+	// the hook checks the cluster after it is restored and started.
+	hook := &fakeHook{}
+	_, err := newOperator(hook).RestoreWorkflow(context.Background(), "5.2")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, hook.calls)
+
+	// the error of the hook fails the restore.
+	hook = &fakeHook{err: errors.New("pd is unhealthy")}
+	_, err = newOperator(hook).RestoreWorkflow(context.Background(), "5.2")
+	assert.EqualError(t, err, "post restore check failed: pd is unhealthy")
+	assert.Equal(t, 1, hook.calls)
+
+	// the hook isn't called if restoring failed or backing up.
+	hook = &fakeHook{}
+	_, err = newOperator(hook).RestoreWorkflow(context.Background(), "5.1")
+	assert.Error(t, err)
+	_, err = newOperator(hook).BackupWorkflow(context.Background(), "5.3")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, hook.calls)
+}
@@ -0,0 +1,113 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PDLeaderURL is the PD API which returns the leader member, it is served in every PD pod.
+const PDLeaderURL = "http://127.0.0.1:2379/pd/api/v1/leader"
+
+// PDLeaderCmd prints the leader member of PD by curl or wget, it prints nothing if PD doesn't answer,
+// so the stopped PD isn't retried as a failed exec.
+func (c component) PDLeaderCmd() string {
+	return fmt.Sprintf("(curl -s %[1]s || wget -qO- %[1]s) 2>/dev/null;true", PDLeaderURL)
+}
+
+// parsePDLeader parses the output of PDLeaderCmd and returns the name of the leader, it is the pod name of PD.
+func parsePDLeader(output string) (string, error) {
+	var leader struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(output)), &leader); err != nil || len(leader.Name) == 0 {
+		return "", fmt.Errorf("invalid pd leader: %q", output)
+	}
+	return leader.Name, nil
+}
+
+// detectPDLeaders asks the running PD pods for the leader of every namespace, K: namespace V: leader pod name.
+// It tries the pods of a namespace one by one until one answers, the namespace is omitted if none answers,
+// e.g. PD is stopped. It should be called before stopping the components.
+func (c *CloudOperator) detectPDLeaders() map[string]string {
+	leaders := make(map[string]string)
+	if c.DryRun {
+		return leaders
+	}
+	pods, err := c.discoverPods(PD)
+	if err != nil {
+		log.Warn("discover pd pods failed, the first pd pod will be backed up", zap.Error(err))
+		return leaders
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if _, ok := leaders[pod.Namespace]; ok || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		container, err := c.container(pod, PD)
+		if err != nil {
+			continue
+		}
+		output, err := c.exec(c.podKey(pod), container, []string{"sh", "-c", PD.PDLeaderCmd()})
+		if err != nil {
+			log.Warn("get pd leader failed", zap.String("pod-name", c.podKey(pod)), zap.Error(err))
+			continue
+		}
+		leader, err := parsePDLeader(output)
+		if err != nil {
+			log.Warn("get pd leader failed", zap.String("pod-name", c.podKey(pod)), zap.Error(err))
+			continue
+		}
+		leaders[pod.Namespace] = leader
+	}
+	return leaders
+}
+
+// singlePD keeps one PD pod of every namespace in the targets, since the replicas share the same raft state.
+// It keeps the leader in pdLeaders if it's in the targets, otherwise the first pod by name.
+func (c *CloudOperator) singlePD(targets []componentPods) []componentPods {
+	for i := range targets {
+		if targets[i].component != PD {
+			continue
+		}
+		pods := append([]corev1.Pod(nil), targets[i].pods...)
+		sort.Slice(pods, func(i, j int) bool {
+			return pods[i].Name < pods[j].Name
+		})
+		// K: namespace V: the index of the kept pod in pods
+		kept := make(map[string]int)
+		for j := range pods {
+			k, ok := kept[pods[j].Namespace]
+			if !ok || pods[j].Name == c.pdLeaders[pods[j].Namespace] && pods[k].Name != c.pdLeaders[pods[j].Namespace] {
+				kept[pods[j].Namespace] = j
+			}
+		}
+		selected := make([]corev1.Pod, 0, len(kept))
+		for j := range pods {
+			if kept[pods[j].Namespace] == j {
+				selected = append(selected, pods[j])
+				log.Info("back up the single pd pod", zap.String("pod-name", c.podKey(&pods[j])), zap.Bool("leader", pods[j].Name == c.pdLeaders[pods[j].Namespace]))
+			}
+		}
+		targets[i].pods = selected
+	}
+	return targets
+}
@@ -0,0 +1,146 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParsePDLeader(t *testing.T) {
+	leader, err := parsePDLeader(`{"name":"pd-1","member_id":1,"client_urls":["http://pd-1:2379"]}` + "\r\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "pd-1", leader)
+	for _, output := range []string{"", "\r\n", "no leader", `{"member_id":1}`} {
+		_, err := parsePDLeader(output)
+		assert.Error(t, err, output)
+	}
+}
+
+func TestBackPDSingle(t *testing.T) {
+	newOperator := func(leader string) (*CloudOperator, *fakeExecutor) {
+		client := fake.NewSimpleClientset(
+			newTestPod("tikv-0", TiKV, corev1.PodRunning),
+			newTestPod("pd-0", PD, corev1.PodRunning),
+			newTestPod("pd-1", PD, corev1.PodRunning),
+			newTestPod("pd-2", PD, corev1.PodRunning),
+		)
+		executor := newFakeExecutor(func(podName string, command []string) (string, error) {
+			cmd := command[len(command)-1]
+			switch {
+			case cmd == PD.PDLeaderCmd():
+				// pd-0 doesn't answer, e.g. it has no curl or wget.
+				if podName == "pd-0" || len(leader) == 0 {
+					return "", nil
+				}
+				return `{"name":"` + leader + `","member_id":1}`, nil
+			case strings.Contains(cmd, "ps -ef"):
+				return "UID\r\n1\r\n", nil
+			case strings.HasPrefix(cmd, "df"):
+				return "Filesystem 1024-blocks Used Available Capacity Mounted on\r\n/dev/sda1 1000 400 600 40% /var/lib\r\n", nil
+			}
+			return "", nil
+		})
+		co := newTestCloudOperator(context.Background(), client, executor)
+		co.RetryCount = 1
+		co.PDSingle = true
+		return co, executor
+	}
+	backedUp := func(executor *fakeExecutor) []string {
+		var pods []string
+		for pod, calls := range executor.calls {
+			for _, call := range calls {
+				if strings.Contains(call[len(call)-1], "back_5.2.sh") {
+					pods = append(pods, pod)
+				}
+			}
+		}
+		return pods
+	}
+
+	// the leader is detected before PD is stopped.
+	co, executor := newOperator("pd-2")
+	co.pdLeaders = co.detectPDLeaders()
+	assert.Equal(t, map[string]string{"default": "pd-2"}, co.pdLeaders)
+	results, err := co.Back("5.2")
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+	assert.ElementsMatch(t, []string{"tikv-0", "pd-2"}, backedUp(executor))
+
+	// it falls back to the first pod if the leader is unknown.
+	co, executor = newOperator("")
+	co.pdLeaders = co.detectPDLeaders()
+	assert.Empty(t, co.pdLeaders)
+	_, err = co.Back("5.2")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"tikv-0", "pd-0"}, backedUp(executor))
+
+	// the leader which is not in the targets is ignored, e.g. it's restricted by Pods.
+	co, executor = newOperator("pd-2")
+	co.pdLeaders = co.detectPDLeaders()
+	co.Pods = []string{"tikv-0", "pd-1", "pd-0"}
+	_, err = co.Back("5.2")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"tikv-0", "pd-0"}, backedUp(executor))
+
+	// all the pd pods are backed up without PDSingle.
+	co, executor = newOperator("pd-2")
+	co.PDSingle = false
+	_, err = co.Back("5.2")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"tikv-0", "pd-0", "pd-1", "pd-2"}, backedUp(executor))
+}
+
+func TestRestorePDSingle(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		newTestPod("tikv-0", TiKV, corev1.PodRunning),
+		newTestPod("pd-0", PD, corev1.PodRunning),
+		newTestPod("pd-1", PD, corev1.PodRunning),
+	)
+	// only pd-0 has the backup of 5.2, like the backup of --pd-single.
+	executor := newFakeExecutor(func(podName string, command []string) (string, error) {
+		cmd := command[len(command)-1]
+		switch {
+		case strings.Contains(cmd, "ps -ef"):
+			return "UID\r\n1\r\n", nil
+		case strings.HasPrefix(cmd, "restored="):
+			return "1 1\r\n", nil
+		case strings.HasPrefix(cmd, "ls") && podName == "pd-1":
+			return "5.1.bat\r\n", nil
+		case strings.HasPrefix(cmd, "ls"):
+			return "5.1.bat\r\n5.2.bat\r\n", nil
+		}
+		return "", nil
+	})
+	co := newTestCloudOperator(context.Background(), client, executor)
+	_, err := co.Restore("5.2")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "version 5.2 is only in 1 of 2 pd pods, pd can't be restored partially")
+	// nothing is restored.
+	for pod, calls := range executor.calls {
+		for _, call := range calls {
+			assert.NotContains(t, call[len(call)-1], restoringSuffix, pod)
+		}
+	}
+
+	// the other components are restored if pd isn't restored.
+	co.Components = []component{TiKV}
+	_, err = co.Restore("5.2")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// BackupInfo is the backup versions of one pod.
+type BackupInfo struct {
+	Component string `json:"component"`
+	// Pod is the pod name, or namespace/pod name across multiple namespaces.
+	Pod      string   `json:"pod"`
+	Versions []string `json:"versions"`
+	// Metadata is the metadata of the versions which have it, it's only read if ListFilter.Metadata is set.
+	Metadata []BackupMetadata `json:"metadata,omitempty"`
+	// Sizes is the size in KB of the versions on the disk, K: version, it's only measured if ListFilter.Sizes is set.
+	Sizes map[string]int64 `json:"sizes,omitempty"`
+}
+
+// ListFilter narrows the backup versions returned by List.
+type ListFilter struct {
+	// Components keeps the pods of the components, empty means all the components.
+	Components []component
+	// Version keeps the versions matching the glob pattern, e.g. 5.*,
+	// or the versions having the prefix if it's not a glob pattern, e.g. 5.
+	// Empty means all the versions.
+	Version string
+	// Metadata reads the metadata of the versions too.
+	Metadata bool
+	// Sizes measures the size of the versions by du too, it takes long for the large backups.
+	Sizes bool
+}
+
+// ParseListFilter parses the comma separated components and the version pattern,
+// empty components means all the components.
+func ParseListFilter(components, version string) (ListFilter, error) {
+	filter := ListFilter{Version: version}
+	if len(strings.TrimSpace(components)) > 0 {
+		cps, err := ParseComponents(components)
+		if err != nil {
+			return filter, err
+		}
+		filter.Components = cps
+	}
+	if _, err := path.Match(version, ""); err != nil {
+		return filter, fmt.Errorf("invalid version pattern %q: %w", version, err)
+	}
+	return filter, nil
+}
+
+// matchComponent returns true if the pods of the component should be kept.
+func (f ListFilter) matchComponent(cp component) bool {
+	return len(f.Components) == 0 || AnyOf(f.Components, func(i int) bool { return f.Components[i] == cp })
+}
+
+// matchVersion returns true if the version should be kept.
+func (f ListFilter) matchVersion(version string) bool {
+	if strings.ContainsAny(f.Version, "*?[") {
+		matched, _ := path.Match(f.Version, version)
+		return matched
+	}
+	return strings.HasPrefix(version, f.Version)
+}
+
+// filter returns the versions of one pod which should be kept.
+func (f ListFilter) filter(versions []string) []string {
+	if len(f.Version) == 0 {
+		return versions
+	}
+	var rst []string
+	for _, version := range versions {
+		if f.matchVersion(version) {
+			rst = append(rst, version)
+		}
+	}
+	return rst
+}
@@ -0,0 +1,233 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseListFilter(t *testing.T) {
+	testCases := []struct {
+		components string
+		version    string
+		expect     ListFilter
+		hasErr     bool
+	}{
+		{
+			expect: ListFilter{},
+		},
+		{
+			components: "tikv",
+			version:    "5.",
+			expect:     ListFilter{Components: []component{TiKV}, Version: "5."},
+		},
+		{
+			components: "tiflash",
+			hasErr:     true,
+		},
+		{
+			version: "5.[",
+			hasErr:  true,
+		},
+	}
+	for _, ca := range testCases {
+		filter, err := ParseListFilter(ca.components, ca.version)
+		if ca.hasErr {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, ca.expect, filter)
+	}
+}
+
+func TestListFilterVersions(t *testing.T) {
+	versions := []string{"4.0", "5.1", "5.2", "5.10", "50", "v5.2"}
+	testCases := []struct {
+		version string
+		expect  []string
+	}{
+		{version: "", expect: versions},
+		{version: "5.", expect: []string{"5.1", "5.2", "5.10"}},
+		{version: "5", expect: []string{"5.1", "5.2", "5.10", "50"}},
+		{version: "5.?", expect: []string{"5.1", "5.2"}},
+		{version: "*5.2", expect: []string{"5.2", "v5.2"}},
+		{version: "6.", expect: nil},
+	}
+	for _, ca := range testCases {
+		assert.Equal(t, ca.expect, ListFilter{Version: ca.version}.filter(versions), ca.version)
+	}
+}
+
+func TestListWithFilter(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		newTestPod("tikv-0", TiKV, corev1.PodRunning),
+		newTestPod("pd-0", PD, corev1.PodRunning),
+	)
+	executor := newFakeExecutor(func(podName string, _ []string) (string, error) {
+		if podName == "pd-0" {
+			return "4.0.bat\r\n", nil
+		}
+		return "4.0.bat\r\n5.1.bat\r\n5.2.tar.gz\r\n", nil
+	})
+	co := newTestCloudOperator(context.Background(), client, executor)
+	testCases := []struct {
+		filter ListFilter
+		expect []BackupInfo
+	}{
+		{
+			filter: ListFilter{},
+			expect: []BackupInfo{
+				{Component: "tikv", Pod: "tikv-0", Versions: []string{"4.0", "5.1", "5.2"}},
+				{Component: "pd", Pod: "pd-0", Versions: []string{"4.0"}},
+			},
+		},
+		{
+			filter: ListFilter{Components: []component{PD}},
+			expect: []BackupInfo{{Component: "pd", Pod: "pd-0", Versions: []string{"4.0"}}},
+		},
+		{
+			// the pods without any matched version are omitted.
+			filter: ListFilter{Version: "5."},
+			expect: []BackupInfo{{Component: "tikv", Pod: "tikv-0", Versions: []string{"5.1", "5.2"}}},
+		},
+		{
+			filter: ListFilter{Components: []component{PD}, Version: "5."},
+			expect: []BackupInfo{},
+		},
+	}
+	for _, ca := range testCases {
+		versions, err := co.List(ca.filter)
+		assert.NoError(t, err)
+		assert.Equal(t, ca.expect, versions)
+	}
+}
+
+func TestListWithMetadata(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		newTestPod("tikv-0", TiKV, corev1.PodRunning),
+	)
+	executor := newFakeExecutor(func(_ string, command []string) (string, error) {
+		if strings.HasPrefix(command[2], "cat") {
+			// 4.0 was backed up before the metadata was recorded.
+			return "{\"version\":\"5.1\",\"component\":\"tikv\",\"timestamp\":\"2021-12-01T08:00:00Z\",\"size\":1024,\"toolVersion\":\"v0.1.0\"}\r\n", nil
+		}
+		return "4.0.bat\r\n5.1.bat\r\n", nil
+	})
+	co := newTestCloudOperator(context.Background(), client, executor)
+	co.Components = []component{TiKV}
+	infos, err := co.List(ListFilter{Metadata: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []BackupInfo{{
+		Component: "tikv",
+		Pod:       "tikv-0",
+		Versions:  []string{"4.0", "5.1"},
+		Metadata:  []BackupMetadata{{Version: "5.1", Component: "tikv", Timestamp: time.Date(2021, 12, 1, 8, 0, 0, 0, time.UTC), Size: 1024, ToolVersion: "v0.1.0"}},
+	}}, infos)
+
+	// the metadata isn't read by default.
+	infos, err = co.List(ListFilter{})
+	assert.NoError(t, err)
+	assert.Nil(t, infos[0].Metadata)
+	assert.Len(t, executor.calls["tikv-0"], 3)
+}
+
+func TestListConcurrently(t *testing.T) {
+	var objects []runtime.Object
+	var expect []BackupInfo
+	for _, cp := range []component{TiKV, PD} {
+		for i := 0; i < 12; i++ {
+			name := fmt.Sprintf("%s-%02d", cp, i)
+			objects = append(objects, newTestPod(name, cp, corev1.PodRunning))
+			expect = append(expect, BackupInfo{Component: cp.String(), Pod: name, Versions: []string{"5.1", name}})
+		}
+	}
+	mu := &sync.Mutex{}
+	var running, maxRunning int
+	executor := newFakeExecutor(func(podName string, _ []string) (string, error) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		// the later pods return earlier, so the results arrive out of order.
+		time.Sleep(time.Duration(10-int(podName[len(podName)-1]-'0')) * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return fmt.Sprintf("5.1.bat\r\n%s.bat\r\n", podName), nil
+	})
+	co := newTestCloudOperator(context.Background(), fake.NewSimpleClientset(objects...), executor)
+	co.Parallel = 4
+	infos, err := co.List(ListFilter{})
+	assert.NoError(t, err)
+	assert.Equal(t, expect, infos)
+	assert.Greater(t, maxRunning, 1)
+	assert.LessOrEqual(t, maxRunning, co.Parallel)
+
+	// the errors of all the failed pods are returned.
+	co.executor = newFakeExecutor(func(podName string, _ []string) (string, error) {
+		if podName == "tikv-03" || podName == "pd-07" {
+			return "", errors.New("connection refused")
+		}
+		return "5.1.bat\r\n", nil
+	})
+	co.RetryCount = 1
+	_, err = co.List(ListFilter{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "tikv-03")
+	assert.Contains(t, err.Error(), "pd-07")
+}
+
+func TestListWithSizes(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		newTestPod("tikv-0", TiKV, corev1.PodRunning),
+	)
+	executor := newFakeExecutor(func(_ string, command []string) (string, error) {
+		if strings.Contains(command[2], "du -sk") {
+			return "1024\t5.1.bat\r\n512\t5.2.bat\r\n256\t5.2.tar.gz\r\n", nil
+		}
+		return "4.0.bat\r\n5.1.bat\r\n5.2.bat\r\n5.2.tar.gz\r\n", nil
+	})
+	co := newTestCloudOperator(context.Background(), client, executor)
+	co.Components = []component{TiKV}
+	infos, err := co.List(ListFilter{Version: "5.", Sizes: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []BackupInfo{{
+		Component: "tikv",
+		Pod:       "tikv-0",
+		Versions:  []string{"5.1", "5.2"},
+		Sizes:     map[string]int64{"5.1": 1024, "5.2": 768},
+	}}, infos)
+	// only the listed versions are measured.
+	assert.Equal(t, []string{"sh", "-c", TiKV.PruneSizeExecCmd(TiKV.BataDir(nil), []string{"5.1", "5.2"})}, executor.calls["tikv-0"][1])
+
+	// the sizes aren't measured by default.
+	infos, err = co.List(ListFilter{})
+	assert.NoError(t, err)
+	assert.Nil(t, infos[0].Sizes)
+	assert.Len(t, executor.calls["tikv-0"], 3)
+}
@@ -0,0 +1,100 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Liveness decides whether the component process is running by the output of a command in the pod.
+type Liveness struct {
+	// Command is the shell command to run in the pod, empty means ProcessFieldsCmd.
+	// Its exit code is ignored, e.g. pgrep exits with 1 if the process isn't found.
+	Command string
+	// Expect is matched against the output of Command, the process is running if it matches.
+	// nil means the process is running if the output isn't empty.
+	Expect *regexp.Regexp
+	// Threshold is the field count of PID 1 which the running process exceeds, it's only used by ProcessFieldsCmd.
+	Threshold int
+}
+
+// ParseLiveness parses the liveness commands and the expected outputs of the components,
+// every item is component=value, e.g. tikv="pgrep tikv-server" and tikv='^[0-9]+'.
+// The expected output is an extended regular expression, it needs the command of the same component.
+func ParseLiveness(commands, expects []string) (map[component]Liveness, error) {
+	liveness := make(map[component]Liveness, len(commands))
+	for _, item := range commands {
+		cp, cmd, err := parseComponentValue(item)
+		if err != nil {
+			return nil, err
+		}
+		if len(cmd) == 0 {
+			return nil, fmt.Errorf("liveness command of %s should not be empty", cp)
+		}
+		liveness[cp] = Liveness{Command: cmd}
+	}
+	for _, item := range expects {
+		cp, expect, err := parseComponentValue(item)
+		if err != nil {
+			return nil, err
+		}
+		l, ok := liveness[cp]
+		if !ok {
+			return nil, fmt.Errorf("liveness expect of %s needs its liveness command", cp)
+		}
+		if l.Expect, err = regexp.Compile(expect); err != nil {
+			return nil, fmt.Errorf("invalid liveness expect of %s %q: %w", cp, expect, err)
+		}
+		liveness[cp] = l
+	}
+	return liveness, nil
+}
+
+// command returns the command to check the process, the exit code of the custom command is ignored,
+// so it's not retried as a failed exec.
+func (l Liveness) command() string {
+	if len(l.Command) == 0 {
+		return ProcessFieldsCmd
+	}
+	return fmt.Sprintf("%s || true", l.Command)
+}
+
+// IsUp returns true if the output of the command shows the process is running.
+//
+// The default command counts the fields of the PID 1 line in `ps -ef`, the fields are
+// UID PID PPID C STIME TTY TIME CMD and the arguments of the command.
+// The component is running if the count is greater than the threshold,
+// otherwise PID 1 is the debug process without arguments.
+func (l Liveness) IsUp(output string) (bool, error) {
+	if len(l.Command) == 0 {
+		count, err := parseProcessFieldCount(output)
+		if err != nil {
+			return false, err
+		}
+		return count > l.Threshold, nil
+	}
+	if l.Expect == nil {
+		return len(strings.TrimSpace(output)) > 0, nil
+	}
+	return l.Expect.MatchString(output), nil
+}
+
+// liveness returns the liveness of the component, it is ProcessFieldsCmd with the process threshold by default.
+func (c *CloudOperator) liveness(cp component) Liveness {
+	l := c.Liveness[cp]
+	l.Threshold = c.processThreshold(cp)
+	return l
+}
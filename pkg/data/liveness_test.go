@@ -0,0 +1,102 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseLiveness(t *testing.T) {
+	liveness, err := ParseLiveness([]string{"tikv=pgrep tikv-server", "pd=pidof pd-server"}, []string{"tikv=^[0-9]+"})
+	assert.NoError(t, err)
+	assert.Equal(t, "pgrep tikv-server", liveness[TiKV].Command)
+	assert.Equal(t, "^[0-9]+", liveness[TiKV].Expect.String())
+	assert.Equal(t, "pidof pd-server", liveness[PD].Command)
+	assert.Nil(t, liveness[PD].Expect)
+
+	for _, ca := range []struct {
+		commands []string
+		expects  []string
+	}{
+		{commands: []string{"tikv"}},
+		{commands: []string{"tikv="}},
+		{commands: []string{"tiflash=pgrep tiflash"}},
+		// the expect needs the command of the same component.
+		{commands: []string{"pd=pgrep pd-server"}, expects: []string{"tikv=.+"}},
+		{commands: []string{"tikv=pgrep tikv-server"}, expects: []string{"tikv=[0-9"}},
+	} {
+		_, err := ParseLiveness(ca.commands, ca.expects)
+		assert.Error(t, err, ca)
+	}
+}
+
+func TestLivenessIsUp(t *testing.T) {
+	testCases := []struct {
+		liveness Liveness
+		output   string
+		up       bool
+		hasErr   bool
+	}{
+		{liveness: Liveness{Threshold: ParamLen}, output: "8\r\n12\r\n", up: true},
+		{liveness: Liveness{Threshold: ParamLen}, output: "8\r\n8\r\n", up: false},
+		{liveness: Liveness{Threshold: 12}, output: "8\r\n12\r\n", up: false},
+		{liveness: Liveness{Threshold: ParamLen}, output: "", hasErr: true},
+		{liveness: Liveness{Command: "pgrep tikv-server"}, output: "42\n", up: true},
+		{liveness: Liveness{Command: "pgrep tikv-server"}, output: " \r\n", up: false},
+		{liveness: Liveness{Command: "cat /proc/1/comm", Expect: regexp.MustCompile(`^tikv-server`)}, output: "tikv-server\n", up: true},
+		{liveness: Liveness{Command: "cat /proc/1/comm", Expect: regexp.MustCompile(`^tikv-server`)}, output: "sh\n", up: false},
+	}
+	for _, ca := range testCases {
+		up, err := ca.liveness.IsUp(ca.output)
+		if ca.hasErr {
+			assert.Error(t, err, ca)
+			continue
+		}
+		assert.NoError(t, err, ca)
+		assert.Equal(t, ca.up, up, ca)
+	}
+}
+
+func TestProcessRunningByLiveness(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		newTestPod("tikv-0", TiKV, corev1.PodRunning),
+		newTestPod("pd-0", PD, corev1.PodRunning),
+	)
+	executor := newFakeExecutor(func(podName string, command []string) (string, error) {
+		switch command[2] {
+		case "pgrep tikv-server || true":
+			return "42\n", nil
+		case ProcessFieldsCmd:
+			return "8\r\n8\r\n", nil
+		}
+		return "", nil
+	})
+	co := newTestCloudOperator(context.Background(), client, executor)
+	co.Liveness = map[component]Liveness{TiKV: {Command: "pgrep tikv-server"}}
+
+	running, err := co.processRunning(newTestPod("tikv-0", TiKV, corev1.PodRunning), TiKV)
+	assert.NoError(t, err)
+	assert.True(t, running)
+	// the component without liveness counts the fields of PID 1.
+	running, err = co.processRunning(newTestPod("pd-0", PD, corev1.PodRunning), PD)
+	assert.NoError(t, err)
+	assert.False(t, running)
+	assert.Equal(t, []string{"sh", "-c", ProcessFieldsCmd}, executor.calls["pd-0"][0])
+}
@@ -0,0 +1,218 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// LockName is the name of the config map which locks the namespace during back and restore.
+	LockName = "tinker-lock"
+	// DefaultLockTTL is the default time after which the lock is taken as expired.
+	DefaultLockTTL = 6 * time.Hour
+
+	lockHolderKey      = "holder"
+	lockOperationKey   = "operation"
+	lockVersionKey     = "version"
+	lockAcquiredAtKey  = "acquired-at"
+	lockTTLKey         = "ttl"
+	lockInterruptedKey = "interrupted"
+
+	// unlockTimeout bounds unlocking, it doesn't use the context of the operation which may have been cancelled.
+	unlockTimeout = 10 * time.Second
+)
+
+// defaultLockHolder returns the host name and the pid of the process.
+func defaultLockHolder() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// withLock locks all the namespaces for the operation of the version before running fn and unlocks them after it.
+// It fails if any namespace is locked by another operation which isn't expired,
+// unless it resumes the same operation of the same version.
+// The locks are kept and marked as interrupted if the operation is interrupted, so it can be resumed.
+func (c *CloudOperator) withLock(operation, version string, fn func() error) error {
+	if c.DryRun || c.LockTTL <= 0 {
+		return fn()
+	}
+	namespaces, err := c.lockNamespaces()
+	if err != nil {
+		return err
+	}
+	c.resumed = make(map[string]time.Time)
+	locked := make([]string, 0, len(namespaces))
+	defer func() {
+		interrupted := c.ctx.Err() != nil
+		for _, namespace := range locked {
+			c.unlock(namespace, interrupted)
+		}
+		if interrupted && operation == "back" && len(locked) > 0 {
+			c.notify("the lock %s of the interrupted back %s is kept, run back %s with --resume to resume it", LockName, version, version)
+		}
+	}()
+	for _, namespace := range namespaces {
+		if err := c.lock(namespace, operation, version); err != nil {
+			return err
+		}
+		locked = append(locked, namespace)
+	}
+	return fn()
+}
+
+// lockNamespaces returns the namespaces to lock in order, they are the namespaces of the pods across all the namespaces.
+func (c *CloudOperator) lockNamespaces() ([]string, error) {
+	if !AnyOf(c.Namespaces, func(i int) bool { return c.Namespaces[i] == metav1.NamespaceAll }) {
+		namespaces := append([]string(nil), c.Namespaces...)
+		sort.Strings(namespaces)
+		return namespaces, nil
+	}
+	seen := make(map[string]bool)
+	var namespaces []string
+	for _, cp := range c.Components {
+		pods, err := c.discoverPods(cp)
+		if err != nil {
+			return nil, err
+		}
+		for _, pod := range pods.Items {
+			if !seen[pod.Namespace] {
+				seen[pod.Namespace] = true
+				namespaces = append(namespaces, pod.Namespace)
+			}
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
+// lock creates the lock config map in the namespace, the expired lock is taken over.
+// The lock left by the interrupted operation of the same version is taken over in resume mode,
+// even if it has expired, and its acquired time is recorded in resumed.
+// The lock marked as interrupted is taken over by any operation, its holder has exited.
+func (c *CloudOperator) lock(namespace, operation, version string) error {
+	configMaps := c.client.CoreV1().ConfigMaps(namespace)
+	lock := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   LockName,
+			Labels: map[string]string{"app.kubernetes.io/managed-by": "tinker"},
+		},
+		Data: map[string]string{
+			lockHolderKey:     c.lockHolder,
+			lockOperationKey:  operation,
+			lockVersionKey:    version,
+			lockAcquiredAtKey: c.now().UTC().Format(time.RFC3339),
+			lockTTLKey:        c.LockTTL.String(),
+		},
+	}
+	_, err := configMaps.Create(c.ctx, lock, metav1.CreateOptions{})
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("lock namespace %s failed: %w", namespace, err)
+	}
+	current, err := configMaps.Get(c.ctx, LockName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("lock namespace %s failed: %w", namespace, err)
+	}
+	acquiredAt, err := time.Parse(time.RFC3339, current.Data[lockAcquiredAtKey])
+	resume := c.Resume && current.Data[lockOperationKey] == operation && current.Data[lockVersionKey] == version && err == nil
+	switch {
+	case resume:
+		log.Warn("take over the lock of the interrupted operation to resume it", zap.String("namespace", namespace), zap.Any("lock", current.Data))
+	case current.Data[lockInterruptedKey] != "":
+		log.Warn("take over the lock of the interrupted operation", zap.String("namespace", namespace), zap.Any("lock", current.Data))
+	case c.lockExpired(current):
+		log.Warn("take over the expired lock", zap.String("namespace", namespace), zap.Any("lock", current.Data))
+	default:
+		return lockedError(namespace, current)
+	}
+	lock.ResourceVersion = current.ResourceVersion
+	if _, err := configMaps.Update(c.ctx, lock, metav1.UpdateOptions{}); err != nil {
+		// another operation has taken over the lock.
+		if apierrors.IsConflict(err) {
+			return lockedError(namespace, current)
+		}
+		return fmt.Errorf("lock namespace %s failed: %w", namespace, err)
+	}
+	if resume {
+		if c.resumed == nil {
+			c.resumed = make(map[string]time.Time)
+		}
+		c.resumed[namespace] = acquiredAt
+	}
+	return nil
+}
+
+// lockExpired returns true if the lock has expired, the broken lock is taken as expired.
+func (c *CloudOperator) lockExpired(lock *corev1.ConfigMap) bool {
+	acquiredAt, err := time.Parse(time.RFC3339, lock.Data[lockAcquiredAtKey])
+	if err != nil {
+		return true
+	}
+	ttl, err := time.ParseDuration(lock.Data[lockTTLKey])
+	if err != nil {
+		return true
+	}
+	return c.now().After(acquiredAt.Add(ttl))
+}
+
+func lockedError(namespace string, lock *corev1.ConfigMap) error {
+	return fmt.Errorf("operation already in progress: %s %s in namespace %s is locked by %s since %s, the lock expires after %s, "+
+		"resume it or delete the config map %s if the operation has crashed",
+		lock.Data[lockOperationKey], lock.Data[lockVersionKey], namespace, lock.Data[lockHolderKey], lock.Data[lockAcquiredAtKey], lock.Data[lockTTLKey], LockName)
+}
+
+// unlock deletes the lock config map in the namespace if it is still held by the operator.
+// The lock of the interrupted operation is marked as interrupted instead, resume mode needs it.
+// The error is only logged, the lock will expire after the TTL.
+func (c *CloudOperator) unlock(namespace string, interrupted bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), unlockTimeout)
+	defer cancel()
+	configMaps := c.client.CoreV1().ConfigMaps(namespace)
+	current, err := configMaps.Get(ctx, LockName, metav1.GetOptions{})
+	if err != nil {
+		log.Warn("unlock namespace failed", zap.String("namespace", namespace), zap.Error(err))
+		return
+	}
+	if current.Data[lockHolderKey] != c.lockHolder {
+		log.Warn("the lock is held by another operation", zap.String("namespace", namespace), zap.Any("lock", current.Data))
+		return
+	}
+	if interrupted {
+		current.Data[lockInterruptedKey] = "true"
+		if _, err := configMaps.Update(ctx, current, metav1.UpdateOptions{}); err != nil {
+			log.Warn("mark the lock as interrupted failed", zap.String("namespace", namespace), zap.Error(err))
+		}
+		return
+	}
+	precondition := metav1.DeleteOptions{Preconditions: &metav1.Preconditions{ResourceVersion: &current.ResourceVersion}}
+	if err := configMaps.Delete(ctx, LockName, precondition); err != nil {
+		log.Warn("unlock namespace failed", zap.String("namespace", namespace), zap.Error(err))
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLock(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	newOperator := func(holder string) *CloudOperator {
+		co := newTestCloudOperator(context.Background(), client, newFakeExecutor(nil))
+		co.LockTTL = time.Hour
+		co.lockHolder = holder
+		return co
+	}
+	getLock := func() (*corev1.ConfigMap, error) {
+		return client.CoreV1().ConfigMaps(metav1.NamespaceDefault).Get(context.Background(), LockName, metav1.GetOptions{})
+	}
+	co1, co2 := newOperator("alice"), newOperator("bob")
+
+	// the lock is held during the operation and released after it.
+	err := co1.withLock("back", "5.2", func() error {
+		lock, err := getLock()
+		assert.NoError(t, err)
+		assert.Equal(t, "alice", lock.Data[lockHolderKey])
+		assert.Equal(t, "back", lock.Data[lockOperationKey])
+
+		// the concurrent operation is refused and the lock is kept.
+		called := false
+		err = co2.withLock("restore", "5.2", func() error {
+			called = true
+			return nil
+		})
+		assert.False(t, called)
+		assert.Contains(t, err.Error(), "operation already in progress: back 5.2 in namespace default is locked by alice")
+		lock, err = getLock()
+		assert.NoError(t, err)
+		assert.Equal(t, "alice", lock.Data[lockHolderKey])
+		return nil
+	})
+	assert.NoError(t, err)
+	_, err = getLock()
+	assert.True(t, apierrors.IsNotFound(err))
+
+	// the lock is released even if the operation failed.
+	assert.EqualError(t, co1.withLock("back", "5.2", func() error { return errors.New("back failed") }), "back failed")
+	_, err = getLock()
+	assert.True(t, apierrors.IsNotFound(err))
+
+	// the expired lock left by the crashed operation is taken over.
+	assert.NoError(t, co1.lock(metav1.NamespaceDefault, "back", "5.2"))
+	co2.now = func() time.Time {
+		return time.Now().Add(2 * time.Hour)
+	}
+	assert.NoError(t, co2.withLock("restore", "5.2", func() error {
+		lock, err := getLock()
+		assert.NoError(t, err)
+		assert.Equal(t, "bob", lock.Data[lockHolderKey])
+		// the operator doesn't unlock the lock of others.
+		co1.unlock(metav1.NamespaceDefault, false)
+		_, err = getLock()
+		assert.NoError(t, err)
+		return nil
+	}))
+	_, err = getLock()
+	assert.True(t, apierrors.IsNotFound(err))
+
+	// the lock left by the interrupted backup is taken over only to resume the same backup.
+	assert.NoError(t, co1.lock(metav1.NamespaceDefault, "back", "5.2"))
+	co2.Resume = true
+	co2.now = time.Now
+	assert.Error(t, co2.lock(metav1.NamespaceDefault, "back", "5.3"))
+	assert.Error(t, co2.lock(metav1.NamespaceDefault, "restore", "5.2"))
+	assert.NoError(t, co2.withLock("back", "5.2", func() error {
+		lock, err := getLock()
+		assert.NoError(t, err)
+		assert.Equal(t, "bob", lock.Data[lockHolderKey])
+		assert.Equal(t, "5.2", lock.Data[lockVersionKey])
+		// the backup is resumed from the time the interrupted one acquired the lock.
+		assert.Contains(t, co2.resumed, metav1.NamespaceDefault)
+		return nil
+	}))
+	_, err = getLock()
+	assert.True(t, apierrors.IsNotFound(err))
+
+	// the lock marked as interrupted is taken over by any operation.
+	assert.NoError(t, co1.lock(metav1.NamespaceDefault, "back", "5.2"))
+	co1.unlock(metav1.NamespaceDefault, true)
+	co2.Resume = false
+	assert.NoError(t, co2.withLock("restore", "5.3", func() error {
+		lock, err := getLock()
+		assert.NoError(t, err)
+		assert.Equal(t, "bob", lock.Data[lockHolderKey])
+		assert.Empty(t, lock.Data[lockInterruptedKey])
+		return nil
+	}))
+	_, err = getLock()
+	assert.True(t, apierrors.IsNotFound(err))
+
+	// it doesn't lock in dry run mode or without TTL.
+	co1.DryRun = true
+	assert.NoError(t, co1.withLock("back", "5.2", func() error {
+		_, err := getLock()
+		assert.True(t, apierrors.IsNotFound(err))
+		return nil
+	}))
+}
+
+func TestLockNamespaces(t *testing.T) {
+	tikv := newTestPod("tikv-0", TiKV, corev1.PodRunning)
+	tikv.Namespace = "tidb-b"
+	pd := newTestPod("pd-0", PD, corev1.PodRunning)
+	pd.Namespace = "tidb-a"
+	client := fake.NewSimpleClientset(tikv, pd)
+	co := newTestCloudOperator(context.Background(), client, newFakeExecutor(nil))
+	co.LockTTL = time.Hour
+
+	co.Namespaces = []string{"tidb-b", "tidb-a"}
+	namespaces, err := co.lockNamespaces()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tidb-a", "tidb-b"}, namespaces)
+
+	// the namespaces of the pods are locked across all the namespaces.
+	co.Namespaces = []string{metav1.NamespaceAll}
+	namespaces, err = co.lockNamespaces()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tidb-a", "tidb-b"}, namespaces)
+
+	// nothing is locked if any namespace is locked by others.
+	other := newTestCloudOperator(context.Background(), client, newFakeExecutor(nil))
+	other.LockTTL = time.Hour
+	other.lockHolder = "other"
+	assert.NoError(t, other.lock("tidb-b", "back", "5.2"))
+	assert.Error(t, co.withLock("back", "5.2", func() error { return nil }))
+	_, err = client.CoreV1().ConfigMaps("tidb-a").Get(context.Background(), LockName, metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+}
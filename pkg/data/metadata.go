@@ -0,0 +1,140 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// metadataFile is the file in the backup directory which records the metadata of the backup.
+const metadataFile = ".tinker-meta.json"
+
+// ToolVersion is the version of the tool recorded in the metadata of backups.
+var ToolVersion = "None"
+
+// BackupMetadata is the metadata of the backup of one pod.
+type BackupMetadata struct {
+	Version   string    `json:"version"`
+	Component string    `json:"component"`
+	Timestamp time.Time `json:"timestamp"`
+	// Size is the size of the backed up data in KB.
+	Size        int64  `json:"size"`
+	ToolVersion string `json:"toolVersion"`
+}
+
+// The timestamp and the size in the marshaled metadata are replaced by the ones measured in the pod.
+const (
+	metadataTimestamp = `"timestamp":"0001-01-01T00:00:00Z"`
+	metadataSize      = `"size":0`
+)
+
+// MetadataExecCmd writes the metadata into the backup directory after backing up,
+// the timestamp is the time of the pod and the size is the size of the backup directory.
+// The json has no single quote, so it can be quoted by the shell.
+func (c component) MetadataExecCmd(dir string, meta BackupMetadata) (string, error) {
+	meta.Timestamp, meta.Size = time.Time{}, 0
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return "", err
+	}
+	content := string(b)
+	if strings.Contains(content, "'") {
+		return "", fmt.Errorf("metadata %s contains single quote", content)
+	}
+	content = strings.Replace(content, metadataTimestamp, `"timestamp":"'$(date -u +%Y-%m-%dT%H:%M:%SZ)'"`, 1)
+	content = strings.Replace(content, metadataSize, `"size":'$(du -sk `+backupDir(dir, meta.Version)+` | cut -f1)'`, 1)
+	return fmt.Sprintf("echo '%s' > %s/%s", content, backupDir(dir, meta.Version), metadataFile), nil
+}
+
+// MetadataListCmd prints the metadata of all the backups in the data directory, one per line.
+func (c component) MetadataListCmd(dir string) string {
+	return fmt.Sprintf("cat %s/*%s/%s 2>/dev/null;true", dir, BackupSuffix, metadataFile)
+}
+
+// parseMetadata parses the output of MetadataListCmd, the invalid lines are skipped with a warning,
+// so a broken metadata file doesn't fail listing the backups.
+func parseMetadata(output string) map[string]BackupMetadata {
+	rst := make(map[string]BackupMetadata)
+	for _, line := range splitLines(output) {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var meta BackupMetadata
+		if err := json.Unmarshal([]byte(line), &meta); err != nil || len(meta.Version) == 0 {
+			log.Warn("skip the invalid backup metadata", zap.String("metadata", line), zap.Error(err))
+			continue
+		}
+		rst[meta.Version] = meta
+	}
+	return rst
+}
+
+// listMetadata returns the metadata of the versions in the pod, the versions without metadata are omitted.
+func (c *CloudOperator) listMetadata(pod *corev1.Pod, cp component, versions []string) ([]BackupMetadata, error) {
+	container, err := c.container(pod, cp)
+	if err != nil {
+		return nil, err
+	}
+	output, err := c.exec(c.podKey(pod), container, []string{"sh", "-c", cp.MetadataListCmd(c.backupRoot(cp))})
+	if err != nil {
+		return nil, err
+	}
+	metas := parseMetadata(output)
+	var rst []BackupMetadata
+	for _, version := range versions {
+		if meta, ok := metas[version]; ok {
+			rst = append(rst, meta)
+		}
+	}
+	return rst, nil
+}
+
+// MetadataReadCmd prints the metadata of the backup of the version, it prints nothing if the backup has no metadata.
+func (c component) MetadataReadCmd(dir, version string) string {
+	return fmt.Sprintf("cat %s/%s 2>/dev/null;true", backupDir(dir, version), metadataFile)
+}
+
+// checkMetadata checks the metadata of the backup of the version in every pod records the component,
+// so the backup copied from another component is never restored. The backup without metadata passes,
+// e.g. it was taken before the metadata was recorded.
+func (c *CloudOperator) checkMetadata(cp component, pods []corev1.Pod, version string) error {
+	errs := newPodErrors()
+	for i := range pods {
+		podName := c.podKey(&pods[i])
+		container, err := c.container(&pods[i], cp)
+		if err != nil {
+			errs.add(podName, err)
+			continue
+		}
+		output, err := c.exec(podName, container, []string{"sh", "-c", cp.MetadataReadCmd(c.backupRoot(cp), version)})
+		if err != nil {
+			errs.add(podName, err)
+			continue
+		}
+		for _, meta := range parseMetadata(output) {
+			if meta.Component != cp.String() {
+				errs.add(podName, fmt.Errorf("backup %s was taken from %s, it can't be restored to %s", version, meta.Component, cp))
+			}
+		}
+	}
+	return errs.err()
+}
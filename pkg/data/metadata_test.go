@@ -0,0 +1,150 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// backWithMetadataCmd returns the command of Back which writes the metadata after backing up.
+func backWithMetadataCmd(cp component, dir, version string) string {
+	metaCmd, _ := cp.MetadataExecCmd(dir, BackupMetadata{Version: version, Component: cp.String(), ToolVersion: ToolVersion})
+	return cp.BackExecCmd(dir, version) + " && " + metaCmd
+}
+
+func TestMetadataExecCmd(t *testing.T) {
+	cmd, err := TiKV.MetadataExecCmd("/var/lib/tikv", BackupMetadata{Version: "5.2", Component: "tikv", ToolVersion: "v0.1.0"})
+	assert.NoError(t, err)
+	assert.Equal(t, "echo '{\"version\":\"5.2\",\"component\":\"tikv\",\"timestamp\":\"'$(date -u +%Y-%m-%dT%H:%M:%SZ)'\",\"size\":'$(du -sk /var/lib/tikv/5.2.bat | cut -f1)',\"toolVersion\":\"v0.1.0\"}' > /var/lib/tikv/5.2.bat/.tinker-meta.json", cmd)
+
+	_, err = TiKV.MetadataExecCmd("/var/lib/tikv", BackupMetadata{Version: "5.2", Component: "tikv", ToolVersion: "it's"})
+	assert.Error(t, err)
+}
+
+func TestParseMetadata(t *testing.T) {
+	timestamp := time.Date(2021, 12, 1, 8, 0, 0, 0, time.UTC)
+	meta := BackupMetadata{Version: "5.2", Component: "tikv", Timestamp: timestamp, Size: 1024, ToolVersion: "v0.1.0"}
+	b, err := json.Marshal(meta)
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		output string
+		expect map[string]BackupMetadata
+	}{
+		{output: "", expect: map[string]BackupMetadata{}},
+		{output: string(b) + "\r\n", expect: map[string]BackupMetadata{"5.2": meta}},
+		{
+			output: "{\"version\":\"5.1\",\"component\":\"tikv\",\"timestamp\":\"2021-11-01T08:00:00Z\",\"size\":10,\"toolVersion\":\"None\"}\r\n" + string(b) + "\r\n",
+			expect: map[string]BackupMetadata{
+				"5.1": {Version: "5.1", Component: "tikv", Timestamp: time.Date(2021, 11, 1, 8, 0, 0, 0, time.UTC), Size: 10, ToolVersion: "None"},
+				"5.2": meta,
+			},
+		},
+		// the broken metadata is skipped.
+		{output: "{\"version\":\r\n{}\r\n" + string(b), expect: map[string]BackupMetadata{"5.2": meta}},
+	}
+	for _, ca := range testCases {
+		assert.Equal(t, ca.expect, parseMetadata(ca.output), ca.output)
+	}
+}
+
+func TestMetadataExecCmdRun(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "db"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "db", "1.sst"), []byte("v1"), 0o644))
+	assert.NoError(t, exec.Command("sh", "-c", backWithMetadataCmd(TiKV, dir, "5.2")).Run())
+
+	output, err := exec.Command("sh", "-c", TiKV.MetadataListCmd(dir)).Output()
+	assert.NoError(t, err)
+	metas := parseMetadata(string(output))
+	assert.Len(t, metas, 1)
+	meta := metas["5.2"]
+	assert.Equal(t, "tikv", meta.Component)
+	assert.Equal(t, ToolVersion, meta.ToolVersion)
+	assert.Greater(t, meta.Size, int64(0))
+	assert.WithinDuration(t, time.Now(), meta.Timestamp, time.Minute)
+
+	// the metadata isn't restored into the data directory.
+	assert.NoError(t, exec.Command("sh", "-c", TiKV.RestoreExecCmd(dir, "5.2")).Run())
+	assert.NoFileExists(t, filepath.Join(dir, metadataFile))
+	assert.FileExists(t, filepath.Join(backupDir(dir, "5.2"), metadataFile))
+}
+
+func TestRestoreMetadataMismatch(t *testing.T) {
+	newOperator := func(metadata map[string]string) (*CloudOperator, *fakeExecutor) {
+		client := fake.NewSimpleClientset(
+			newTestPod("tikv-0", TiKV, corev1.PodRunning),
+			newTestPod("tikv-1", TiKV, corev1.PodRunning),
+			newTestPod("pd-0", PD, corev1.PodRunning),
+		)
+		executor := newFakeExecutor(func(podName string, command []string) (string, error) {
+			cmd := command[len(command)-1]
+			switch {
+			case strings.Contains(cmd, "ps -ef"):
+				return "UID\r\n1\r\n", nil
+			case strings.HasPrefix(cmd, "ls"):
+				return "5.2.bat\r\n", nil
+			case strings.HasPrefix(cmd, "cat"):
+				return metadata[podName], nil
+			case strings.HasPrefix(cmd, "restored="):
+				return "1 1\r\n", nil
+			}
+			return "", nil
+		})
+		return newTestCloudOperator(context.Background(), client, executor), executor
+	}
+	restored := func(executor *fakeExecutor) bool {
+		for _, calls := range executor.calls {
+			for _, command := range calls {
+				if strings.Contains(command[len(command)-1], "restore_") {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	// the pd backup copied into tikv-1 aborts the restore before restoring any pod.
+	co, executor := newOperator(map[string]string{
+		"tikv-0": `{"version":"5.2","component":"tikv"}`,
+		"tikv-1": `{"version":"5.2","component":"pd"}`,
+		"pd-0":   `{"version":"5.2","component":"pd"}`,
+	})
+	_, err := co.Restore("5.2")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "tikv-1: backup 5.2 was taken from pd, it can't be restored to tikv")
+	assert.NotContains(t, err.Error(), "tikv-0")
+	assert.Contains(t, executor.calls["tikv-1"], []string{"sh", "-c", TiKV.MetadataReadCmd(TiKV.BataDir(nil), "5.2")})
+	assert.False(t, restored(executor))
+
+	// the backups without metadata are restored.
+	co, executor = newOperator(map[string]string{"tikv-0": `{"version":"5.2","component":"tikv"}`})
+	_, err = co.Restore("5.2")
+	assert.NoError(t, err)
+	assert.True(t, restored(executor))
+}
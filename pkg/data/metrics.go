@@ -0,0 +1,77 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	resultSuccess = "success"
+	resultFailure = "failure"
+)
+
+var (
+	// Registry is the registry of the tinker metrics.
+	Registry = prometheus.NewRegistry()
+
+	backupCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "tinker",
+		Name:      "backup_total",
+		Help:      "Total number of the pod backups by the component and the result.",
+	}, []string{"component", "result"})
+	restoreCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "tinker",
+		Name:      "restore_total",
+		Help:      "Total number of the pod restores by the component and the result.",
+	}, []string{"component", "result"})
+	execDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "tinker",
+		Name:      "exec_duration_seconds",
+		Help:      "Duration of the execs in pods including the retries.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10),
+	}, []string{"result"})
+)
+
+func init() {
+	Registry.MustRegister(backupCounter, restoreCounter, execDuration)
+}
+
+// operationCounters maps the operation of execPods to its counter.
+var operationCounters = map[string]*prometheus.CounterVec{
+	"backup":  backupCounter,
+	"restore": restoreCounter,
+}
+
+// result returns the result label of the error.
+func result(err error) string {
+	if err != nil {
+		return resultFailure
+	}
+	return resultSuccess
+}
+
+// observeOperation counts the operation of the component in one pod.
+func observeOperation(operation string, cp component, err error) {
+	if counter, ok := operationCounters[operation]; ok {
+		counter.WithLabelValues(cp.String(), result(err)).Inc()
+	}
+}
+
+// observeExec records the duration of the exec since start.
+func observeExec(start time.Time, err error) {
+	execDuration.WithLabelValues(result(err)).Observe(time.Since(start).Seconds())
+}
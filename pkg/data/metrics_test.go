@@ -0,0 +1,94 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// scrape gathers the registry and returns the value of the counter or the sample count of the histogram with the labels.
+func scrape(t *testing.T, name string, labels map[string]string) float64 {
+	families, err := Registry.Gather()
+	assert.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			matched := true
+			for _, label := range metric.GetLabel() {
+				if value, ok := labels[label.GetName()]; ok && value != label.GetValue() {
+					matched = false
+				}
+			}
+			if !matched {
+				continue
+			}
+			if metric.GetCounter() != nil {
+				return metric.GetCounter().GetValue()
+			}
+			return float64(metric.GetHistogram().GetSampleCount())
+		}
+	}
+	return 0
+}
+
+func TestBackMetrics(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		newTestPod("tikv-0", TiKV, corev1.PodRunning),
+		newTestPod("tikv-1", TiKV, corev1.PodRunning),
+		newTestPod("pd-0", PD, corev1.PodRunning),
+	)
+	executor := newFakeExecutor(func(podName string, command []string) (string, error) {
+		cmd := command[len(command)-1]
+		switch {
+		case strings.Contains(cmd, "ps -ef"):
+			return "UID\r\n1\r\n", nil
+		case strings.HasPrefix(cmd, "df"):
+			return "Filesystem 1024-blocks Used Available Capacity Mounted on\r\n/dev/sda1 1000 400 600 40% /var/lib\r\n", nil
+		case strings.Contains(cmd, "back_5.2.sh") && podName == "tikv-1":
+			return "", errors.New("no space left on device")
+		case strings.Contains(cmd, "du -sk"):
+			return "100\tdb\r\n", nil
+		}
+		return "", nil
+	})
+	co := newTestCloudOperator(context.Background(), client, executor)
+	co.RetryCount = 1
+
+	tikvSuccess := map[string]string{"component": "tikv", "result": resultSuccess}
+	tikvFailure := map[string]string{"component": "tikv", "result": resultFailure}
+	pdSuccess := map[string]string{"component": "pd", "result": resultSuccess}
+	execFailure := map[string]string{"result": resultFailure}
+	before := []float64{
+		scrape(t, "tinker_backup_total", tikvSuccess),
+		scrape(t, "tinker_backup_total", tikvFailure),
+		scrape(t, "tinker_backup_total", pdSuccess),
+		scrape(t, "tinker_exec_duration_seconds", execFailure),
+	}
+
+	_, err := co.Back("5.2")
+	assert.Error(t, err)
+	assert.Equal(t, before[0]+1, scrape(t, "tinker_backup_total", tikvSuccess))
+	assert.Equal(t, before[1]+1, scrape(t, "tinker_backup_total", tikvFailure))
+	assert.Equal(t, before[2]+1, scrape(t, "tinker_backup_total", pdSuccess))
+	assert.Equal(t, before[3]+1, scrape(t, "tinker_exec_duration_seconds", execFailure))
+}
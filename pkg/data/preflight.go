@@ -0,0 +1,155 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"fmt"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Permission is a verb on a resource which the operator needs, e.g. create pods/exec.
+type Permission struct {
+	Verb string
+	// Group is the API group of the resource, empty means the core group.
+	Group       string
+	Resource    string
+	Subresource string
+}
+
+// String implements fmt.Stringer interface.
+func (p Permission) String() string {
+	resource := p.Resource
+	if len(p.Group) > 0 {
+		resource = fmt.Sprintf("%s.%s", resource, p.Group)
+	}
+	if len(p.Subresource) > 0 {
+		return fmt.Sprintf("%s %s/%s", p.Verb, resource, p.Subresource)
+	}
+	return fmt.Sprintf("%s %s", p.Verb, resource)
+}
+
+// podPermissions are needed by all the operations, the pods are patched to enter the debug mode and deleted to restart.
+var podPermissions = []Permission{
+	{Verb: "list", Resource: "pods"},
+	{Verb: "get", Resource: "pods"},
+	{Verb: "patch", Resource: "pods"},
+	{Verb: "delete", Resource: "pods"},
+	{Verb: "create", Resource: "pods", Subresource: "exec"},
+}
+
+// lockPermissions are needed to lock the namespaces.
+var lockPermissions = []Permission{
+	{Verb: "create", Resource: "configmaps"},
+	{Verb: "get", Resource: "configmaps"},
+	{Verb: "update", Resource: "configmaps"},
+	{Verb: "delete", Resource: "configmaps"},
+}
+
+// PermissionCheck is the result of checking a permission in a namespace.
+type PermissionCheck struct {
+	// Namespace is empty if the permission is checked across all the namespaces.
+	Namespace  string `json:"namespace"`
+	Permission string `json:"permission"`
+	Allowed    bool   `json:"allowed"`
+	// Reason is the reason of the authorizer, it may be empty.
+	Reason string `json:"reason,omitempty"`
+}
+
+// permissions returns the permissions which back and restore need.
+func (c *CloudOperator) permissions() []Permission {
+	permissions := append([]Permission(nil), podPermissions...)
+	permissions = append(permissions, c.backend().Permissions()...)
+	if c.Discovery == DiscoveryStatefulSet {
+		permissions = append(permissions, statefulSetPermissions...)
+	}
+	if c.usesDebugContainers() {
+		permissions = append(permissions, debugPermissions...)
+	}
+	if c.LockTTL > 0 {
+		permissions = append(permissions, lockPermissions...)
+	}
+	return permissions
+}
+
+// CheckPermissions checks the permissions which back and restore need in every namespace by SelfSubjectAccessReview.
+func (c *CloudOperator) CheckPermissions() ([]PermissionCheck, error) {
+	var checks []PermissionCheck
+	for _, namespace := range c.Namespaces {
+		for _, permission := range c.permissions() {
+			review := &authorizationv1.SelfSubjectAccessReview{
+				Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+					ResourceAttributes: &authorizationv1.ResourceAttributes{
+						Namespace:   namespace,
+						Verb:        permission.Verb,
+						Group:       permission.Group,
+						Resource:    permission.Resource,
+						Subresource: permission.Subresource,
+					},
+				},
+			}
+			result, err := c.client.AuthorizationV1().SelfSubjectAccessReviews().Create(c.ctx, review, metav1.CreateOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("check permission %s failed: %w", permission, err)
+			}
+			checks = append(checks, PermissionCheck{
+				Namespace:  namespace,
+				Permission: permission.String(),
+				Allowed:    result.Status.Allowed,
+				Reason:     result.Status.Reason,
+			})
+		}
+	}
+	return checks, nil
+}
+
+// Preflight checks the permissions before any destructive action, it reports all the missing permissions.
+func (c *CloudOperator) Preflight() error {
+	checks, err := c.CheckPermissions()
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for _, check := range checks {
+		if !check.Allowed {
+			missing = append(missing, fmt.Sprintf("%s in %s", check.Permission, namespaceName(check.Namespace)))
+		}
+	}
+	if len(missing) > 0 {
+		return WithCategory(CategoryPreflight, fmt.Errorf("preflight failed, missing permissions: %s", strings.Join(missing, "; ")))
+	}
+	return nil
+}
+
+// preflight checks the permissions and the tools in the containers before back and restore,
+// it is skipped in dry run mode which changes nothing.
+func (c *CloudOperator) preflight(operation string) error {
+	if c.DryRun || c.SkipPreflight {
+		return nil
+	}
+	if err := c.Preflight(); err != nil {
+		return WithCategory(CategoryPreflight, err)
+	}
+	return WithCategory(CategoryPreflight, c.checkTools(operation))
+}
+
+// namespaceName returns the readable name of the namespace.
+func namespaceName(namespace string) string {
+	if namespace == metav1.NamespaceAll {
+		return "all namespaces"
+	}
+	return "namespace " + namespace
+}
@@ -0,0 +1,141 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// reviewReactor allows all the permissions except the denied ones, K: namespace V: denied permissions.
+func reviewReactor(denied map[string][]string) k8stesting.ReactionFunc {
+	return func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview).DeepCopy()
+		attrs := review.Spec.ResourceAttributes
+		permission := Permission{Verb: attrs.Verb, Resource: attrs.Resource, Subresource: attrs.Subresource}.String()
+		review.Status.Allowed = true
+		for _, d := range denied[attrs.Namespace] {
+			if d == permission {
+				review.Status.Allowed = false
+				review.Status.Reason = "RBAC: access denied"
+			}
+		}
+		return true, review, nil
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	testCases := []struct {
+		namespaces []string
+		lockTTL    bool
+		denied     map[string][]string
+		err        string
+	}{
+		{
+			namespaces: []string{"tidb"},
+		},
+		{
+			namespaces: []string{"tidb"},
+			lockTTL:    true,
+		},
+		{
+			namespaces: []string{"tidb-a", "tidb-b"},
+			denied:     map[string][]string{"tidb-b": {"create pods/exec", "patch pods"}},
+			err:        "preflight failed, missing permissions: patch pods in namespace tidb-b; create pods/exec in namespace tidb-b",
+		},
+		{
+			namespaces: []string{metav1.NamespaceAll},
+			denied:     map[string][]string{metav1.NamespaceAll: {"delete pods"}},
+			err:        "preflight failed, missing permissions: delete pods in all namespaces",
+		},
+		{
+			// the config maps are only needed by the lock.
+			namespaces: []string{"tidb"},
+			denied:     map[string][]string{"tidb": {"update configmaps"}},
+		},
+		{
+			namespaces: []string{"tidb"},
+			lockTTL:    true,
+			denied:     map[string][]string{"tidb": {"update configmaps"}},
+			err:        "preflight failed, missing permissions: update configmaps in namespace tidb",
+		},
+	}
+	for _, ca := range testCases {
+		client := fake.NewSimpleClientset()
+		client.PrependReactor("create", "selfsubjectaccessreviews", reviewReactor(ca.denied))
+		co := newTestCloudOperator(context.Background(), client, newFakeExecutor(nil))
+		co.Namespaces = ca.namespaces
+		if ca.lockTTL {
+			co.LockTTL = DefaultLockTTL
+		}
+		err := co.Preflight()
+		if len(ca.err) > 0 {
+			assert.EqualError(t, err, ca.err)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+}
+
+func TestCheckPermissions(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "selfsubjectaccessreviews", reviewReactor(map[string][]string{"tidb": {"create pods/exec"}}))
+	co := newTestCloudOperator(context.Background(), client, newFakeExecutor(nil))
+	co.Namespaces = []string{"tidb"}
+	checks, err := co.CheckPermissions()
+	assert.NoError(t, err)
+	assert.Len(t, checks, len(podPermissions))
+	assert.Equal(t, PermissionCheck{Namespace: "tidb", Permission: "list pods", Allowed: true}, checks[0])
+	assert.Equal(t, PermissionCheck{Namespace: "tidb", Permission: "create pods/exec", Allowed: false, Reason: "RBAC: access denied"}, checks[len(checks)-1])
+}
+
+func TestPreflightBeforeWorkflow(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		newTestPod("tikv-0", TiKV, corev1.PodRunning),
+		newTestPod("pd-0", PD, corev1.PodRunning),
+	)
+	client.PrependReactor("create", "selfsubjectaccessreviews", reviewReactor(map[string][]string{metav1.NamespaceDefault: {"create pods/exec"}}))
+	executor := newFakeExecutor(func(string, []string) (string, error) {
+		return "", nil
+	})
+	co := newTestCloudOperator(context.Background(), client, executor)
+	co.SkipPreflight = false
+	expected := "preflight failed, missing permissions: create pods/exec in namespace default"
+	_, err := co.BackupWorkflow(context.Background(), "5.2")
+	assert.EqualError(t, err, expected)
+	_, err = co.RestoreWorkflow(context.Background(), "5.2")
+	assert.EqualError(t, err, expected)
+	_, err = co.RollingBackupWorkflow(context.Background(), "5.2")
+	assert.EqualError(t, err, expected)
+	// nothing is stopped.
+	assert.Empty(t, executor.calls)
+	pods, err := client.CoreV1().Pods(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, pods.Items, 2)
+
+	// the dry run changes nothing, so it doesn't need the permissions.
+	co.DryRun = true
+	co.Out = new(bytes.Buffer)
+	_, err = co.BackupWorkflow(context.Background(), "5.2")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,96 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// DefaultProgressInterval is the default interval to poll the backup progress.
+const DefaultProgressInterval = 10 * time.Second
+
+// BackupSizeExecCmd returns the size in KB of the backup directory of the version.
+func (c component) BackupSizeExecCmd(dir, version string) string {
+	return fmt.Sprintf("du -sk %s", backupDir(dir, version))
+}
+
+// progressPercent returns the percentage of the done size in the total size, it is capped at 100.
+// The empty total is regarded as completed.
+func progressPercent(done, total int64) float64 {
+	if total <= 0 || done >= total {
+		return 100
+	}
+	if done <= 0 {
+		return 0
+	}
+	return float64(done) * 100 / float64(total)
+}
+
+// watchProgress polls the size of the backup directory against the data directory every ProgressInterval
+// and logs the percentage until the returned func is called.
+// The polls bypass the retry and the Parallel limit, the failed poll is only logged.
+func (c *CloudOperator) watchProgress(podName, container string, cp component, version string) func() {
+	ctx, cancel := context.WithCancel(c.ctx)
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		dir := cp.BataDir(c.DataDirs)
+		total, err := c.pollSize(ctx, podName, container, cp.duExecCmd(dir, excludePattern(c.basePattern(), c.Excludes)))
+		if err != nil {
+			log.Warn("get data size failed, it will not report the backup progress", zap.String("pod-name", podName), zap.Error(err))
+			return
+		}
+		ticker := time.NewTicker(c.ProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			done, err := c.pollSize(ctx, podName, container, cp.BackupSizeExecCmd(c.backupRoot(cp), version))
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Warn("get backup size failed", zap.String("pod-name", podName), zap.Error(err))
+				}
+				continue
+			}
+			log.Info("backup progress", zap.String("pod-name", podName),
+				zap.String("percent", fmt.Sprintf("%.1f%%", progressPercent(done, total))),
+				zap.Int64("backup-size-kb", done), zap.Int64("data-size-kb", total))
+		}
+	}()
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// pollSize execs the du command once and returns the size in KB.
+func (c *CloudOperator) pollSize(ctx context.Context, podName, container, cmd string) (int64, error) {
+	stdout := new(bytes.Buffer)
+	namespace, name := c.splitPodKey(podName)
+	if err := c.executor.exec(ctx, name, container, namespace, []string{"sh", "-c", cmd}, nil, stdout, new(bytes.Buffer)); err != nil {
+		return 0, err
+	}
+	return parseDu(stdout.String())
+}
@@ -0,0 +1,84 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgressPercent(t *testing.T) {
+	testCases := []struct {
+		done    int64
+		total   int64
+		percent float64
+	}{
+		{done: 0, total: 100, percent: 0},
+		{done: 25, total: 100, percent: 25},
+		{done: 1, total: 3, percent: 100.0 / 3},
+		// the hard links or the file system blocks may exceed the data size.
+		{done: 120, total: 100, percent: 100},
+		{done: 0, total: 0, percent: 100},
+		{done: -1, total: 100, percent: 0},
+	}
+	for _, ca := range testCases {
+		assert.InDelta(t, ca.percent, progressPercent(ca.done, ca.total), 1e-9)
+	}
+}
+
+func TestWatchProgress(t *testing.T) {
+	polled := make(chan struct{}, 1)
+	executor := newFakeExecutor(func(podName string, command []string) (string, error) {
+		if strings.HasPrefix(command[2], "du -sk /var/lib/tikv/5.2.bat") {
+			select {
+			case polled <- struct{}{}:
+			default:
+			}
+			return "512\t/var/lib/tikv/5.2.bat", nil
+		}
+		return "4\tLOCK\n1020\tdb\n", nil
+	})
+	co := newTestCloudOperator(context.Background(), nil, executor)
+	co.ProgressInterval = time.Millisecond
+	stop := co.watchProgress("tikv-0", TiKV.String(), TiKV, "5.2")
+	select {
+	case <-polled:
+	case <-time.After(time.Second):
+		t.Fatal("backup size is not polled")
+	}
+	stop()
+
+	executor.Lock()
+	calls := len(executor.calls["tikv-0"])
+	assert.Equal(t, TiKV.DuExecCmd(TiKV.BataDir(nil)), executor.calls["tikv-0"][0][2])
+	executor.Unlock()
+	// it stops polling after the stop func returns.
+	time.Sleep(10 * time.Millisecond)
+	executor.Lock()
+	assert.Equal(t, calls, len(executor.calls["tikv-0"]))
+	executor.Unlock()
+}
+
+func TestBackupProgress(t *testing.T) {
+	co := newTestCloudOperator(context.Background(), nil, nil)
+	assert.Nil(t, co.backupProgress("5.2"))
+	co.ProgressInterval = time.Second
+	assert.NotNil(t, co.backupProgress("5.2"))
+	co.Compress = true
+	assert.Nil(t, co.backupProgress("5.2"))
+}
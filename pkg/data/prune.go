@@ -0,0 +1,258 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PruneExecCmd removes the backup directories and the compressed backups of the versions.
+func (c component) PruneExecCmd(dir string, versions []string) string {
+	dirs := make([]string, 0, 2*len(versions))
+	for _, version := range versions {
+		dirs = append(dirs, backupDir(dir, version), backupArchive(dir, version))
+	}
+	return fmt.Sprintf("rm -rf %s", strings.Join(dirs, " "))
+}
+
+// PruneSizeExecCmd returns the sizes in KB of the backups of the versions, the missing backups are ignored.
+func (c component) PruneSizeExecCmd(dir string, versions []string) string {
+	names := make([]string, 0, 2*len(versions))
+	for _, version := range versions {
+		names = append(names, version+BackupSuffix, version+ArchiveSuffix)
+	}
+	return fmt.Sprintf("cd %s;ls -d %s 2>/dev/null | xargs -r du -sk", dir, strings.Join(names, " "))
+}
+
+// parsePruneSizes parses the output of PruneSizeExecCmd and returns the size in KB of every version,
+// the size of a version is the sum of its backup directory and compressed backup.
+// e.g.
+// 1024    5.1.bat
+// 512     5.2.tar.gz
+func parsePruneSizes(output string) (map[string]int64, error) {
+	sizes := make(map[string]int64)
+	for _, line := range splitLines(output) {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid du output: %q", line)
+		}
+		size, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid du output: %q", line)
+		}
+		name := path.Base(fields[1])
+		version := strings.TrimSuffix(strings.TrimSuffix(name, BackupSuffix), ArchiveSuffix)
+		if version == name {
+			return nil, fmt.Errorf("invalid backup in du output: %q", line)
+		}
+		sizes[version] += size
+	}
+	return sizes, nil
+}
+
+// PruneTarget is the backup versions to be pruned in a pod.
+type PruneTarget struct {
+	Pod       string   `json:"pod"`
+	Component string   `json:"component"`
+	Versions  []string `json:"versions"`
+	// Size is the size in KB which will be reclaimed.
+	Size int64 `json:"size"`
+}
+
+// pruneTask is the versions to be removed from a pod.
+type pruneTask struct {
+	podName   string
+	container string
+	cp        component
+	versions  []string
+}
+
+// pruneTargets execs du in the pods to get the space which will be reclaimed by the tasks.
+func (c *CloudOperator) pruneTargets(tasks []pruneTask) ([]PruneTarget, error) {
+	targets := make([]PruneTarget, 0, len(tasks))
+	for _, task := range tasks {
+		output, err := c.exec(task.podName, task.container, []string{"sh", "-c", task.cp.PruneSizeExecCmd(c.backupRoot(task.cp), task.versions)})
+		if err != nil {
+			return nil, fmt.Errorf("get backup size of pod %s failed: %w", task.podName, err)
+		}
+		sizes, err := parsePruneSizes(output)
+		if err != nil {
+			return nil, fmt.Errorf("get backup size of pod %s failed: %w", task.podName, err)
+		}
+		var size int64
+		for _, version := range task.versions {
+			size += sizes[version]
+		}
+		targets = append(targets, PruneTarget{Pod: task.podName, Component: task.cp.String(), Versions: task.versions, Size: size})
+	}
+	return targets, nil
+}
+
+// runningVersion returns the image tag of the component container, e.g. v5.2.1.
+// It returns empty if the tag is not detectable.
+func runningVersion(pod *corev1.Pod, name string) string {
+	for _, container := range pod.Spec.Containers {
+		if container.Name != name {
+			continue
+		}
+		idx := strings.LastIndex(container.Image, ":")
+		// the colon may belong to the registry port, e.g. localhost:5000/pingcap/tikv
+		if idx < 0 || strings.Contains(container.Image[idx:], "/") {
+			return ""
+		}
+		return container.Image[idx+1:]
+	}
+	return ""
+}
+
+// Prune removes the backup version from all the pods, the timestamped backups of the version are removed too.
+// It refuses to remove the version which is running.
+func (c *CloudOperator) Prune(version string) error {
+	if err := ValidateVersion(version); err != nil {
+		return err
+	}
+	return c.prune(func(versions []string, running string) ([]string, error) {
+		var targets []string
+		for _, v := range versions {
+			if matchVersion(v, version) {
+				targets = append(targets, v)
+			}
+		}
+		if len(targets) == 0 {
+			return nil, nil
+		}
+		if isRunningVersion(version, running) {
+			return nil, fmt.Errorf("version %s is running", version)
+		}
+		return targets, nil
+	})
+}
+
+// PruneAllExcept removes all the backup versions except the newest n in every pod.
+// The running version and the non-numeric versions will be kept.
+func (c *CloudOperator) PruneAllExcept(n int) error {
+	return c.retain(n, "")
+}
+
+// retain keeps the newest n backup versions and the keep version in every pod.
+// The running version and the non-numeric versions will be kept.
+func (c *CloudOperator) retain(n int, keep string) error {
+	return c.prune(func(versions []string, running string) ([]string, error) {
+		var targets []string
+		for _, version := range retainVersions(versions, n, keep) {
+			if isRunningVersion(version, running) {
+				log.Warn("skip pruning the running version", zap.String("version", version), zap.String("running", running))
+				continue
+			}
+			targets = append(targets, version)
+		}
+		return targets, nil
+	})
+}
+
+// retainVersions returns the versions to be removed to keep the newest n versions and the keep version.
+func retainVersions(versions []string, n int, keep string) []string {
+	var targets []string
+	for _, version := range versionsExcept(versions, n) {
+		if version != keep {
+			targets = append(targets, version)
+		}
+	}
+	return targets
+}
+
+// prune removes the versions selected from the backup versions of every pod.
+// It calls ConfirmPrune with the space to be reclaimed before removing any version if it is set.
+func (c *CloudOperator) prune(selectFn func(versions []string, running string) ([]string, error)) error {
+	errs := newPodErrors()
+	var tasks []pruneTask
+	for _, cp := range c.Components {
+		pods, err := c.discoverPods(cp)
+		if err != nil {
+			return err
+		}
+		// it only prunes the backed up pods after backing up some pods.
+		selected := c.selectPods(pods.Items, make(map[string]bool))
+		errs.expect(len(selected))
+		for i := range selected {
+			pod := &selected[i]
+			podName := c.podKey(pod)
+			container, err := c.container(pod, cp)
+			if err != nil {
+				errs.add(podName, err)
+				continue
+			}
+			versions, err := c.listVersions(pod, cp)
+			if err != nil {
+				errs.add(podName, err)
+				continue
+			}
+			targets, err := selectFn(versions, runningVersion(pod, container))
+			if err != nil {
+				errs.add(podName, err)
+				continue
+			}
+			if len(targets) == 0 {
+				continue
+			}
+			tasks = append(tasks, pruneTask{podName: podName, container: container, cp: cp, versions: targets})
+		}
+	}
+	if c.ConfirmPrune != nil && !c.DryRun && len(tasks) > 0 {
+		targets, err := c.pruneTargets(tasks)
+		if err != nil {
+			return err
+		}
+		if err := c.ConfirmPrune(targets); err != nil {
+			return err
+		}
+	}
+	wg := &sync.WaitGroup{}
+	for _, task := range tasks {
+		commands := []string{
+			"sh",
+			"-c",
+			task.cp.PruneExecCmd(c.backupRoot(task.cp), task.versions),
+		}
+		if c.DryRun {
+			c.printExec(task.podName, task.container, commands)
+			continue
+		}
+		wg.Add(1)
+		go func(podName, container string, commands []string) {
+			defer wg.Done()
+			log.Info("prune start", zap.String("pod-name", podName))
+			log.Debug("prune cmd", zap.String("pod-name", podName), zap.String("cmd", commands[2]))
+			if _, err := c.execScript(podName, container, commands); err != nil {
+				log.Error("prune failed", zap.String("pod-name", podName), zap.Error(err))
+				errs.add(podName, err)
+			} else {
+				log.Info("prune finished", zap.String("pod-name", podName))
+			}
+		}(task.podName, task.container, commands)
+	}
+	wg.Wait()
+	return errs.err()
+}
@@ -0,0 +1,186 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPruneExecCmd(t *testing.T) {
+	assert.Equal(t, "rm -rf /var/lib/tikv/5.1.bat /var/lib/tikv/5.1.tar.gz /var/lib/tikv/5.2.bat /var/lib/tikv/5.2.tar.gz", TiKV.PruneExecCmd("/var/lib/tikv", []string{"5.1", "5.2"}))
+}
+
+func TestRunningVersion(t *testing.T) {
+	testCases := []struct {
+		image  string
+		expect string
+	}{
+		{"pingcap/tikv:v5.2.1", "v5.2.1"},
+		{"localhost:5000/pingcap/tikv", ""},
+		{"localhost:5000/pingcap/tikv:v5.1.0", "v5.1.0"},
+	}
+	for _, ca := range testCases {
+		pod := newTestPod("tikv-0", TiKV, corev1.PodRunning)
+		pod.Spec.Containers = []corev1.Container{{Name: "tikv", Image: ca.image}}
+		assert.Equal(t, ca.expect, runningVersion(pod, TiKV.String()))
+	}
+}
+
+func TestPrune(t *testing.T) {
+	tikv := newTestPod("tikv-0", TiKV, corev1.PodRunning)
+	tikv.Spec.Containers = []corev1.Container{{Name: "tikv", Image: "pingcap/tikv:v5.2.1"}}
+	pd := newTestPod("pd-0", PD, corev1.PodRunning)
+	newOperator := func() (*CloudOperator, *fakeExecutor) {
+		executor := newFakeExecutor(func(_ string, command []string) (string, error) {
+			if strings.HasPrefix(command[2], "ls") {
+				return "4.0.bat\r\n5.1.bat\r\n5.2.bat\r\n", nil
+			}
+			return "", nil
+		})
+		return newTestCloudOperator(context.Background(), fake.NewSimpleClientset(tikv, pd), executor), executor
+	}
+
+	co, executor := newOperator()
+	assert.NoError(t, co.Prune("5.1"))
+	assert.Equal(t, TiKV.PruneExecCmd("/var/lib/tikv", []string{"5.1"}), executor.calls["tikv-0"][1][2])
+	assert.Equal(t, PD.PruneExecCmd("/var/lib/pd", []string{"5.1"}), executor.calls["pd-0"][1][2])
+
+	// it refuses to prune the running version.
+	co, executor = newOperator()
+	assert.Error(t, co.Prune("5.2"))
+	assert.Len(t, executor.calls["tikv-0"], 1)
+	assert.Len(t, executor.calls["pd-0"], 2)
+
+	co, executor = newOperator()
+	assert.NoError(t, co.PruneAllExcept(1))
+	assert.Equal(t, TiKV.PruneExecCmd("/var/lib/tikv", []string{"5.1", "4.0"}), executor.calls["tikv-0"][1][2])
+	assert.Equal(t, PD.PruneExecCmd("/var/lib/pd", []string{"5.1", "4.0"}), executor.calls["pd-0"][1][2])
+}
+
+func TestParsePruneSizes(t *testing.T) {
+	testCases := []struct {
+		output string
+		expect map[string]int64
+		hasErr bool
+	}{
+		{
+			output: "1024\t5.1.bat\r\n512\t5.1.tar.gz\r\n2048\t4.0.bat\r\n",
+			expect: map[string]int64{"5.1": 1536, "4.0": 2048},
+		},
+		{
+			output: "",
+			expect: map[string]int64{},
+		},
+		{
+			output: "du: can't open '5.1.bat': Permission denied\r\n",
+			hasErr: true,
+		},
+		{
+			output: "4\tLOCK\r\n",
+			hasErr: true,
+		},
+	}
+	for _, ca := range testCases {
+		sizes, err := parsePruneSizes(ca.output)
+		if ca.hasErr {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, ca.expect, sizes)
+	}
+}
+
+func TestConfirmPrune(t *testing.T) {
+	tikv := newTestPod("tikv-0", TiKV, corev1.PodRunning)
+	newOperator := func() (*CloudOperator, *fakeExecutor) {
+		executor := newFakeExecutor(func(_ string, command []string) (string, error) {
+			switch {
+			case strings.HasPrefix(command[2], "ls"):
+				return "4.0.bat\r\n5.1.bat\r\n5.2.bat\r\n", nil
+			case strings.Contains(command[2], "du -sk"):
+				return "1024\t4.0.bat\r\n512\t5.1.tar.gz\r\n", nil
+			}
+			return "", nil
+		})
+		co := newTestCloudOperator(context.Background(), fake.NewSimpleClientset(tikv), executor)
+		co.Components = []component{TiKV}
+		return co, executor
+	}
+
+	co, executor := newOperator()
+	var confirmed []PruneTarget
+	co.ConfirmPrune = func(targets []PruneTarget) error {
+		confirmed = targets
+		return nil
+	}
+	assert.NoError(t, co.PruneAllExcept(1))
+	assert.Equal(t, []PruneTarget{{Pod: "tikv-0", Component: "tikv", Versions: []string{"5.1", "4.0"}, Size: 1536}}, confirmed)
+	assert.Equal(t, TiKV.PruneSizeExecCmd("/var/lib/tikv", []string{"5.1", "4.0"}), executor.calls["tikv-0"][1][2])
+	assert.Equal(t, TiKV.PruneExecCmd("/var/lib/tikv", []string{"5.1", "4.0"}), executor.calls["tikv-0"][2][2])
+
+	// nothing is removed if it is not confirmed.
+	co, executor = newOperator()
+	co.ConfirmPrune = func([]PruneTarget) error {
+		return errors.New("cancelled")
+	}
+	assert.EqualError(t, co.PruneAllExcept(1), "cancelled")
+	assert.Len(t, executor.calls["tikv-0"], 2)
+}
+
+func TestRetainVersions(t *testing.T) {
+	testCases := []struct {
+		versions []string
+		n        int
+		keep     string
+		expect   []string
+	}{
+		{
+			versions: []string{"5.0", "5.1", "5.2", "5.3"},
+			n:        2,
+			keep:     "5.3",
+			expect:   []string{"5.1", "5.0"},
+		},
+		{
+			// it never removes the version just created even if it's older.
+			versions: []string{"4.0", "5.1", "5.2"},
+			n:        1,
+			keep:     "4.0",
+			expect:   []string{"5.1"},
+		},
+		{
+			// the non-numeric versions are ignored.
+			versions: []string{"nightly", "5.1", "5.2", "test"},
+			n:        1,
+			keep:     "5.2",
+			expect:   []string{"5.1"},
+		},
+		{
+			versions: []string{"5.1"},
+			n:        3,
+			keep:     "5.1",
+			expect:   nil,
+		},
+	}
+	for _, ca := range testCases {
+		assert.Equal(t, ca.expect, retainVersions(ca.versions, ca.n, ca.keep))
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// OperationResult is the outcome of backing up or restoring a pod.
+type OperationResult struct {
+	// Pod is the pod name, or namespace/pod name across multiple namespaces.
+	Pod       string        `json:"pod"`
+	Component string        `json:"component"`
+	Duration  time.Duration `json:"duration"`
+	// Bytes is the size of the backup, it is 0 if the size is unknown, e.g. the pod is restored or failed.
+	Bytes int64 `json:"bytes"`
+	Err   error `json:"-"`
+}
+
+// MarshalJSON implements json.Marshaler interface, the error is marshaled as its message.
+func (r OperationResult) MarshalJSON() ([]byte, error) {
+	type result OperationResult
+	var msg string
+	if r.Err != nil {
+		msg = r.Err.Error()
+	}
+	return json.Marshal(struct {
+		result
+		Error string `json:"error,omitempty"`
+	}{result(r), msg})
+}
+
+// FailedResults returns the results of the pods which failed.
+func FailedResults(results []OperationResult) []OperationResult {
+	var failed []OperationResult
+	for _, rst := range results {
+		if rst.Err != nil {
+			failed = append(failed, rst)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,101 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// RestartTimeout bounds starting the components after the operation is interrupted,
+// the context of the operation is cancelled, so a new one is used.
+const RestartTimeout = 10 * time.Minute
+
+// validateResume checks the backup can be resumed, the pods are taken as backed up if the metadata exists,
+// the compressed backups have no metadata, and the upload after the metadata may have failed.
+func (c *CloudOperator) validateResume(version string) error {
+	if !c.Resume {
+		return nil
+	}
+	if c.Compress {
+		return errors.New("--resume can't be used with --compress")
+	}
+	if c.Upload != nil {
+		return errors.New("--resume can't be used with --upload")
+	}
+	// the lock tells which backup is interrupted and when it started.
+	if c.LockTTL <= 0 && !c.DryRun {
+		return errors.New("--resume needs the lock of the interrupted backup, it can't be used with --lock-ttl 0")
+	}
+	// a new timestamp names a new backup, so the interrupted one is resumed by its timestamped version.
+	if _, _, ok := splitTimestamp(version); c.Timestamped && !ok {
+		return fmt.Errorf("--resume with --timestamped needs the timestamped version of the interrupted backup, e.g. %s", TimestampedVersion(version, c.now()))
+	}
+	return nil
+}
+
+// resumeTargets removes the pods which have been backed up by the interrupted backup from the targets.
+// The interrupted backup is the lock of the same version taken over by withLock, it fails without it,
+// so an old backup of the same version is never taken as done. The metadata is written after all the data
+// is copied, so only the pod with the metadata written after the interrupted backup acquired the lock is done,
+// the others are backed up again, the half-done backup directory is removed before it.
+func (c *CloudOperator) resumeTargets(targets []componentPods, version string) ([]componentPods, error) {
+	if c.DryRun {
+		return targets, nil
+	}
+	var done []string
+	rst := make([]componentPods, 0, len(targets))
+	for _, target := range targets {
+		remaining := componentPods{component: target.component}
+		for i := range target.pods {
+			pod := &target.pods[i]
+			acquiredAt, ok := c.resumed[pod.Namespace]
+			if !ok {
+				return nil, fmt.Errorf("no interrupted back %s to resume in namespace %s, the lock %s is not found", version, pod.Namespace, LockName)
+			}
+			metas, err := c.listMetadata(pod, target.component, []string{version})
+			if err != nil {
+				return nil, fmt.Errorf("check the backup of pod %s failed: %w", c.podKey(pod), err)
+			}
+			if len(metas) > 0 && !metas[0].Timestamp.Before(acquiredAt) {
+				done = append(done, c.podKey(pod))
+				continue
+			}
+			remaining.pods = append(remaining.pods, *pod)
+		}
+		rst = append(rst, remaining)
+	}
+	if len(done) > 0 {
+		c.notify("it will resume backup %s, the pods already backed up are skipped: %s", version, strings.Join(done, ", "))
+	}
+	return rst, nil
+}
+
+// uninterrupted runs fn with a new context bounded by RestartTimeout if the operation is interrupted,
+// so the components stopped by the operation can still be started.
+func (c *CloudOperator) uninterrupted(fn func() error) error {
+	if c.ctx.Err() == nil {
+		return fn()
+	}
+	log.Warn("the operation is interrupted, it still starts the components", zap.Error(c.ctx.Err()))
+	ctx, cancel := context.WithTimeout(context.Background(), RestartTimeout)
+	defer cancel()
+	return c.withContext(ctx, fn)
+}
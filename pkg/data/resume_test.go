@@ -0,0 +1,183 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestResumeTargets(t *testing.T) {
+	metaAt := func(version, timestamp string) string {
+		return `{"version":"` + version + `","component":"tikv","timestamp":"` + timestamp + `","size":4,"toolVersion":"None"}`
+	}
+	meta := func(version string) string {
+		return metaAt(version, "2021-11-01T08:00:00Z")
+	}
+	// the backup of 5.2 acquired the lock at 07:00 and is interrupted after tikv-0 and pd-0 finished,
+	// tikv-1 has only an older backup, the metadata of tikv-2 is broken, tikv-3 has no backup
+	// and tikv-4 has a complete backup of 5.2 from an earlier run.
+	metadata := map[string]string{
+		"tikv-0": meta("5.1") + "\r\n" + meta("5.2") + "\r\n",
+		"tikv-1": meta("5.1") + "\r\n",
+		"tikv-2": `{"version":"5.2",` + "\r\n",
+		"tikv-3": "",
+		"tikv-4": metaAt("5.2", "2021-10-30T08:00:00Z") + "\r\n",
+		"pd-0":   meta("5.2") + "\r\n",
+	}
+	executor := newFakeExecutor(func(podName string, command []string) (string, error) {
+		if strings.Contains(command[len(command)-1], metadataFile) {
+			return metadata[podName], nil
+		}
+		return "", nil
+	})
+	var progress []string
+	co := newTestCloudOperator(context.Background(), fake.NewSimpleClientset(), executor)
+	co.Notify = func(msg string) {
+		progress = append(progress, msg)
+	}
+	co.resumed = map[string]time.Time{metav1.NamespaceDefault: time.Date(2021, 11, 1, 7, 0, 0, 0, time.UTC)}
+	targets := []componentPods{
+		{component: PD, pods: []corev1.Pod{*newTestPod("pd-0", PD, corev1.PodRunning)}},
+		{component: TiKV, pods: []corev1.Pod{
+			*newTestPod("tikv-0", TiKV, corev1.PodRunning),
+			*newTestPod("tikv-1", TiKV, corev1.PodRunning),
+			*newTestPod("tikv-2", TiKV, corev1.PodRunning),
+			*newTestPod("tikv-3", TiKV, corev1.PodRunning),
+			*newTestPod("tikv-4", TiKV, corev1.PodRunning),
+		}},
+	}
+	rst, err := co.resumeTargets(targets, "5.2")
+	assert.NoError(t, err)
+	assert.Len(t, rst, 2)
+	assert.Equal(t, PD, rst[0].component)
+	assert.Empty(t, rst[0].pods)
+	assert.Equal(t, TiKV, rst[1].component)
+	var names []string
+	for _, pod := range rst[1].pods {
+		names = append(names, pod.Name)
+	}
+	assert.Equal(t, []string{"tikv-1", "tikv-2", "tikv-3", "tikv-4"}, names)
+	assert.Equal(t, []string{"it will resume backup 5.2, the pods already backed up are skipped: pd-0, tikv-0"}, progress)
+
+	// nothing is skipped if the version is not backed up in any pod.
+	progress = nil
+	rst, err = co.resumeTargets(targets, "5.3")
+	assert.NoError(t, err)
+	assert.Len(t, rst[1].pods, 5)
+	assert.Empty(t, progress)
+
+	// nothing is resumed without the lock of the interrupted backup.
+	co.resumed = map[string]time.Time{}
+	_, err = co.resumeTargets(targets, "5.2")
+	assert.EqualError(t, err, "no interrupted back 5.2 to resume in namespace default, the lock tinker-lock is not found")
+	co.resumed = map[string]time.Time{metav1.NamespaceDefault: time.Date(2021, 11, 1, 7, 0, 0, 0, time.UTC)}
+
+	// it can't decide if the metadata can't be read.
+	co.executor = newFakeExecutor(func(string, []string) (string, error) {
+		return "", errors.New("container not found")
+	})
+	_, err = co.resumeTargets(targets, "5.2")
+	assert.Error(t, err)
+}
+
+func TestValidateResume(t *testing.T) {
+	testCases := []struct {
+		resume   bool
+		compress bool
+		upload   bool
+		lockTTL  time.Duration
+		hasErr   bool
+	}{
+		{false, true, true, 0, false},
+		{true, false, false, DefaultLockTTL, false},
+		{true, true, false, DefaultLockTTL, true},
+		{true, false, true, DefaultLockTTL, true},
+		{true, false, false, 0, true},
+	}
+	for _, ca := range testCases {
+		co := &CloudOperator{Resume: ca.resume, Compress: ca.compress, LockTTL: ca.lockTTL}
+		if ca.upload {
+			co.Upload = &fakeUploader{}
+		}
+		assert.Equal(t, ca.hasErr, co.validateResume("5.2") != nil, ca)
+	}
+}
+
+func TestInterruptedWorkflow(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		newTestPod("tikv-0", TiKV, corev1.PodRunning),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := true
+	executor := newFakeExecutor(func(_ string, command []string) (string, error) {
+		cmd := command[len(command)-1]
+		switch {
+		case strings.Contains(cmd, "ps -ef"):
+			return "UID\r\n1\r\n", nil
+		case strings.HasPrefix(cmd, "df"):
+			return "Filesystem 1024-blocks Used Available Capacity Mounted on\r\n/dev/sda1 1000 400 600 40% /var/lib\r\n", nil
+		case strings.Contains(cmd, "back_") && interrupt:
+			// it is interrupted while backing up.
+			cancel()
+			return "", context.Canceled
+		}
+		return "", nil
+	})
+	var progress []string
+	co := newTestCloudOperator(context.Background(), client, executor)
+	co.Components = []component{TiKV}
+	co.LockTTL = time.Hour
+	co.Notify = func(msg string) {
+		progress = append(progress, msg)
+	}
+	_, err := co.BackupWorkflow(ctx, "5.2")
+	assert.Error(t, err)
+	assert.Contains(t, progress, "it is interrupted, it will try to start all component")
+	assert.Contains(t, progress, "check success")
+	// the pod is restarted with a new context.
+	pods, err := client.CoreV1().Pods(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, pods.Items)
+	// the lock is kept for resuming the interrupted backup.
+	lock, err := client.CoreV1().ConfigMaps(metav1.NamespaceDefault).Get(context.Background(), LockName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "true", lock.Data[lockInterruptedKey])
+	assert.Contains(t, progress, "the lock tinker-lock of the interrupted back 5.2 is kept, run back 5.2 with --resume to resume it")
+
+	// the interrupted backup is resumed.
+	_, err = client.CoreV1().Pods(metav1.NamespaceDefault).Create(context.Background(), newTestPod("tikv-0", TiKV, corev1.PodRunning), metav1.CreateOptions{})
+	assert.NoError(t, err)
+	interrupt = false
+	co = newTestCloudOperator(context.Background(), client, executor)
+	co.Components = []component{TiKV}
+	co.LockTTL = time.Hour
+	co.Resume = true
+	results, err := co.BackupWorkflow(context.Background(), "5.2")
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Contains(t, co.resumed, metav1.NamespaceDefault)
+	_, err = client.CoreV1().ConfigMaps(metav1.NamespaceDefault).Get(context.Background(), LockName, metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+}
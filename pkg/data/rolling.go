@@ -0,0 +1,212 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RollingBackupWorkflow backs up the pods one by one to keep the cluster serving,
+// every pod is stopped, backed up and started before the next one.
+// It refuses to stop a pod if any other pod of the component is not ready, so at most one pod is down.
+// It stops at the first pod which failed to back up, the pod is started before returning.
+// It returns the result of every pod which is backed up, including the failed one.
+func (c *CloudOperator) RollingBackupWorkflow(ctx context.Context, version string) ([]OperationResult, error) {
+	if err := ValidateVersion(version); err != nil {
+		return nil, err
+	}
+	if err := ValidateExcludes(c.Excludes); err != nil {
+		return nil, err
+	}
+	if err := c.validateResume(version); err != nil {
+		return nil, err
+	}
+	if err := c.backend().Validate(c); err != nil {
+		return nil, err
+	}
+	// all the pods share the timestamp of the backup.
+	version = c.backupVersion(version)
+	var results []OperationResult
+	err := c.withContext(ctx, func() error {
+		if err := c.preflight("back"); err != nil {
+			return err
+		}
+		return c.withLock("back", version, func() error {
+			targets, err := c.prepare("backup", func(component, []corev1.Pod) error {
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if c.PDSingle {
+				c.pdLeaders = c.detectPDLeaders()
+				targets = c.singlePD(targets)
+			}
+			if c.Resume {
+				if targets, err = c.resumeTargets(targets, version); err != nil {
+					return err
+				}
+			}
+			for _, target := range targets {
+				for i := range target.pods {
+					pod := &target.pods[i]
+					rst, err := c.rollingBack(target.component, pod, version)
+					if err == nil && c.skipped.has("backup", rst.Pod) {
+						continue
+					}
+					results = append(results, rst)
+					if err != nil {
+						return fmt.Errorf("rolling back pod %s failed: %w", c.podKey(pod), err)
+					}
+				}
+			}
+			if err := c.retainAfterBack(version); err != nil {
+				return err
+			}
+			c.notify("it finished all")
+			return nil
+		})
+	})
+	return results, err
+}
+
+// rollingBack stops the pod, backs it up and starts it again, it returns the result of the pod.
+func (c *CloudOperator) rollingBack(cp component, pod *corev1.Pod, version string) (OperationResult, error) {
+	podName := c.podKey(pod)
+	rst := OperationResult{Pod: podName, Component: cp.String()}
+	fail := func(err error) (OperationResult, error) {
+		rst.Err = err
+		return rst, err
+	}
+	if err := c.checkQuorum(cp, pod); err != nil {
+		return fail(err)
+	}
+	if err := c.backend().Prepare(c, cp, []corev1.Pod{*pod}); err != nil {
+		return fail(err)
+	}
+	// the pod whose backup is too large is skipped without stopping it.
+	if c.skipped.has("backup", podName) {
+		c.notify("it skips pod %s whose backup is too large", podName)
+		return rst, nil
+	}
+	c.notify("it will stop pod %s", podName)
+	// it should start the pod even if it failed to stop or back up.
+	err := c.stopPod(cp, pod)
+	if err == nil {
+		c.notify("it will back pod %s", podName)
+		var results []OperationResult
+		results, err = c.backend().Back(c, version, []componentPods{{component: cp, pods: []corev1.Pod{*pod}}})
+		if len(results) > 0 {
+			rst = results[0]
+		}
+	}
+	c.notify("it will start pod %s", podName)
+	if startErr := c.uninterrupted(func() error { return c.startPod(cp, pod) }); startErr != nil {
+		log.Error("start pod failed", zap.String("pod-name", podName), zap.Error(startErr))
+		if err == nil {
+			err = startErr
+		}
+	}
+	if err != nil {
+		return fail(err)
+	}
+	return rst, nil
+}
+
+// checkQuorum checks all the other pods of the component are ready, so stopping the pod keeps the quorum.
+func (c *CloudOperator) checkQuorum(cp component, pod *corev1.Pod) error {
+	pods, err := c.discoverPods(cp)
+	if err != nil {
+		return err
+	}
+	podName := c.podKey(pod)
+	for i := range pods.Items {
+		other := &pods.Items[i]
+		if key := c.podKey(other); key != podName && !isPodReady(other) {
+			return fmt.Errorf("pod %s of %s is not ready, stopping %s may break the quorum", key, cp, podName)
+		}
+	}
+	return nil
+}
+
+// stopPod puts the pod into debug mode and stops the component process in it,
+// then it waits until the process is stopped or WaitTimeout elapses.
+func (c *CloudOperator) stopPod(cp component, pod *corev1.Pod) error {
+	podName := c.podKey(pod)
+	container, err := c.container(pod, cp)
+	if err != nil {
+		return err
+	}
+	if c.DryRun {
+		c.printDryRun("annotate pod %s with %s=%s", podName, c.DebugKey, c.DebugValue)
+	} else if err := c.patchAnnotation(pod, c.DebugKey, &c.DebugValue); err != nil {
+		return err
+	}
+	if _, err := c.exec(podName, container, []string{"sh", "-c", cp.StopCmd()}); err != nil {
+		return err
+	}
+	if c.DryRun {
+		return nil
+	}
+	if c.StopGracePeriod > 0 {
+		stopping := map[string]stoppingPod{podName: {pod: *pod, container: container, restarts: restartCount(pod, container)}}
+		if err := c.waitExited(cp, stopping); err != nil {
+			return err
+		}
+	}
+	err = c.waitFor(func() (bool, error) {
+		current, err := c.client.CoreV1().Pods(pod.Namespace).Get(c.ctx, pod.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			// the pod is being recreated after it was force deleted.
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		stopped := c.checkPodsStatus(cp, []corev1.Pod{*current}, false)
+		if !stopped {
+			log.Info("waiting for pod stopped", zap.String("pod-name", podName))
+		}
+		return stopped, nil
+	}, c.WaitTimeout)
+	if errors.Is(err, errWaitTimeout) {
+		return fmt.Errorf("%s is not stopped after %s", podName, c.WaitTimeout)
+	}
+	return err
+}
+
+// startPod removes the debug annotation and deletes the pod to restart it, then it waits for the component ready.
+func (c *CloudOperator) startPod(cp component, pod *corev1.Pod) error {
+	if c.DryRun {
+		c.printDryRun("remove annotation %s from pod %s", c.DebugKey, c.podKey(pod))
+	} else if err := c.patchAnnotation(pod, c.DebugKey, nil); err != nil {
+		return err
+	}
+	deleted, err := c.delete([]corev1.Pod{*pod})
+	if err != nil {
+		return err
+	}
+	if c.DryRun || c.WaitTimeout <= 0 {
+		return nil
+	}
+	return c.waitReady([]component{cp}, nil, deleted)
+}
@@ -0,0 +1,98 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestRollingBackupWorkflow(t *testing.T) {
+	newReadyPod := func(name string, cp component) *corev1.Pod {
+		pod := newTestPod(name, cp, corev1.PodRunning)
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+		return pod
+	}
+	newOperator := func(failed string, pods ...runtime.Object) (*CloudOperator, *[]string) {
+		var lock sync.Mutex
+		var events []string
+		record := func(event string) {
+			lock.Lock()
+			defer lock.Unlock()
+			events = append(events, event)
+		}
+		client := fake.NewSimpleClientset(pods...)
+		client.PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			record(action.(k8stesting.DeleteAction).GetName() + " start")
+			return false, nil, nil
+		})
+		executor := newFakeExecutor(func(podName string, command []string) (string, error) {
+			cmd := command[len(command)-1]
+			switch {
+			case strings.Contains(cmd, "ps -ef"):
+				// PID 1 is the debug process without arguments.
+				return "UID\r\n1\r\n", nil
+			case strings.HasPrefix(cmd, "df"):
+				return "Filesystem 1024-blocks Used Available Capacity Mounted on\r\n/dev/sda1 1000 400 600 40% /var/lib\r\n", nil
+			case strings.Contains(cmd, "back_"):
+				record(podName + " back")
+				if podName == failed {
+					return "", errors.New("back failed")
+				}
+			case strings.Contains(cmd, "du -sk"):
+				return "4\tdb\r\n", nil
+			case strings.HasPrefix(cmd, "kill"):
+				record(podName + " stop")
+			}
+			return "", nil
+		})
+		co := newTestCloudOperator(context.Background(), client, executor)
+		co.Components = []component{TiKV, PD}
+		co.Notify = func(string) {}
+		co.RetryCount = 1
+		return co, &events
+	}
+
+	co, events := newOperator("", newReadyPod("tikv-0", TiKV), newReadyPod("tikv-1", TiKV), newReadyPod("pd-0", PD))
+	_, err := co.RollingBackupWorkflow(context.Background(), "5.2")
+	assert.NoError(t, err)
+	// every pod is started before stopping the next one.
+	assert.Equal(t, []string{
+		"tikv-0 stop", "tikv-0 back", "tikv-0 start",
+		"tikv-1 stop", "tikv-1 back", "tikv-1 start",
+		"pd-0 stop", "pd-0 back", "pd-0 start",
+	}, *events)
+
+	// it refuses to stop any pod if another pod of the component is not ready.
+	co, events = newOperator("", newReadyPod("tikv-0", TiKV), newTestPod("tikv-1", TiKV, corev1.PodRunning), newReadyPod("pd-0", PD))
+	_, err = co.RollingBackupWorkflow(context.Background(), "5.2")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "tikv-1 of tikv is not ready")
+	assert.Empty(t, *events)
+
+	// it starts the failed pod and stops rolling.
+	co, events = newOperator("tikv-0", newReadyPod("tikv-0", TiKV), newReadyPod("tikv-1", TiKV), newReadyPod("pd-0", PD))
+	_, err = co.RollingBackupWorkflow(context.Background(), "5.2")
+	assert.Error(t, err)
+	assert.Equal(t, []string{"tikv-0 stop", "tikv-0 back", "tikv-0 start"}, *events)
+}
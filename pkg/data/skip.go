@@ -0,0 +1,154 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// SkippedPod is the pod which is skipped by the operation because it's not running.
+// Restore also skips the running pods which have no backup of the version, and backup skips the running pods
+// whose backups exceed MaxBackupSize, the Reason tells why.
+type SkippedPod struct {
+	Operation string `json:"operation"`
+	Pod       string `json:"pod"`
+	Component string `json:"component"`
+	Phase     string `json:"phase"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// skippedPods collects the skipped pods, it is safe for concurrent use.
+// A pod is recorded once per operation.
+type skippedPods struct {
+	sync.Mutex
+	pods []SkippedPod
+}
+
+// add records the pod skipped by the operation.
+func (s *skippedPods) add(pod SkippedPod) {
+	s.Lock()
+	defer s.Unlock()
+	for _, p := range s.pods {
+		if p.Operation == pod.Operation && p.Pod == pod.Pod {
+			return
+		}
+	}
+	s.pods = append(s.pods, pod)
+}
+
+// has returns true if the pod is skipped by the operation.
+func (s *skippedPods) has(operation, pod string) bool {
+	s.Lock()
+	defer s.Unlock()
+	for _, p := range s.pods {
+		if p.Operation == operation && p.Pod == pod {
+			return true
+		}
+	}
+	return false
+}
+
+// list returns the skipped pods of the operation sorted by pod, empty operation means all the operations.
+func (s *skippedPods) list(operation string) []SkippedPod {
+	s.Lock()
+	defer s.Unlock()
+	var pods []SkippedPod
+	for _, p := range s.pods {
+		if len(operation) == 0 || p.Operation == operation {
+			pods = append(pods, p)
+		}
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		if pods[i].Operation != pods[j].Operation {
+			return pods[i].Operation < pods[j].Operation
+		}
+		return pods[i].Pod < pods[j].Pod
+	})
+	return pods
+}
+
+// Skipped returns the pods skipped by the operations of the operator because they were not running or had no backup.
+func (c *CloudOperator) Skipped() []SkippedPod {
+	return c.skipped.list("")
+}
+
+// runningPods returns the running pods and records the others as skipped by the operation.
+func (c *CloudOperator) runningPods(operation string, cp component, pods []corev1.Pod) []corev1.Pod {
+	running := make([]corev1.Pod, 0, len(pods))
+	for i := range pods {
+		if pods[i].Status.Phase == corev1.PodRunning {
+			running = append(running, pods[i])
+			continue
+		}
+		podName := c.podKey(&pods[i])
+		log.Warn("skip the pod which is not running", zap.String("operation", operation), zap.String("pod-name", podName), zap.String("phase", string(pods[i].Status.Phase)))
+		c.skipped.add(SkippedPod{Operation: operation, Pod: podName, Component: cp.String(), Phase: string(pods[i].Status.Phase)})
+	}
+	return running
+}
+
+// withoutSkipped returns the targets without the pods skipped by the operation.
+func (c *CloudOperator) withoutSkipped(operation string, targets []componentPods) []componentPods {
+	for i := range targets {
+		pods := make([]corev1.Pod, 0, len(targets[i].pods))
+		for _, pod := range targets[i].pods {
+			if !c.skipped.has(operation, c.podKey(&pod)) {
+				pods = append(pods, pod)
+			}
+		}
+		targets[i].pods = pods
+	}
+	return targets
+}
+
+// skippedErr returns error if Strict is set and some pods are skipped by the operation.
+func (c *CloudOperator) skippedErr(operation string) error {
+	if !c.Strict {
+		return nil
+	}
+	pods := c.skipped.list(operation)
+	if len(pods) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		msgs = append(msgs, fmt.Sprintf("%s(%s)", pod.Pod, pod.Phase))
+	}
+	return fmt.Errorf("%d pods are not running in strict mode: %s", len(pods), strings.Join(msgs, ", "))
+}
+
+// partialErr returns a CategoryPartial error if some pods are skipped by the operation,
+// so the restore which leaves the skipped pods on their old data isn't reported as success.
+func (c *CloudOperator) partialErr(operation string) error {
+	pods := c.skipped.list(operation)
+	if len(pods) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		reason := pod.Reason
+		if len(reason) == 0 {
+			reason = fmt.Sprintf("phase %s", pod.Phase)
+		}
+		msgs = append(msgs, fmt.Sprintf("%s(%s)", pod.Pod, reason))
+	}
+	return WithCategory(CategoryPartial, fmt.Errorf("%d pods are skipped by %s, the cluster may be mixed: %s", len(pods), operation, strings.Join(msgs, ", ")))
+}
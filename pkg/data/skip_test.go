@@ -0,0 +1,147 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSkipNotRunningPods(t *testing.T) {
+	newClient := func() *fake.Clientset {
+		return fake.NewSimpleClientset(
+			newTestPod("tikv-0", TiKV, corev1.PodRunning),
+			newTestPod("tikv-1", TiKV, corev1.PodPending),
+			newTestPod("pd-0", PD, corev1.PodFailed),
+			newTestPod("pd-1", PD, corev1.PodRunning),
+		)
+	}
+	newExecutor := func() *fakeExecutor {
+		return newFakeExecutor(func(_ string, command []string) (string, error) {
+			cmd := command[len(command)-1]
+			switch {
+			case strings.Contains(cmd, "ps -ef"):
+				return "UID\r\n1\r\n", nil
+			case strings.HasPrefix(cmd, "restored="):
+				return "1 1\r\n", nil
+			case strings.HasPrefix(cmd, "ls"):
+				return "5.2.bat\r\n", nil
+			}
+			return "", nil
+		})
+	}
+
+	executor := newExecutor()
+	co := newTestCloudOperator(context.Background(), newClient(), executor)
+	assert.NoError(t, co.Stop())
+	_, err := co.Restore("5.2")
+	// the running pods are restored, but the restore is partial.
+	assert.EqualError(t, err, "2 pods are skipped by restore, the cluster may be mixed: pd-0(phase Failed), tikv-1(phase Pending)")
+	assert.Equal(t, CategoryPartial, Category(err))
+	assert.Equal(t, []SkippedPod{
+		{Operation: "restore", Pod: "pd-0", Component: "pd", Phase: "Failed"},
+		{Operation: "restore", Pod: "tikv-1", Component: "tikv", Phase: "Pending"},
+		{Operation: "stop", Pod: "pd-0", Component: "pd", Phase: "Failed"},
+		{Operation: "stop", Pod: "tikv-1", Component: "tikv", Phase: "Pending"},
+	}, co.Skipped())
+	assert.NotEmpty(t, executor.calls["tikv-0"])
+	assert.NotEmpty(t, executor.calls["pd-1"])
+	assert.Empty(t, executor.calls["tikv-1"])
+	assert.Empty(t, executor.calls["pd-0"])
+
+	// it fails before touching any pod in strict mode.
+	executor = newExecutor()
+	client := newClient()
+	co = newTestCloudOperator(context.Background(), client, executor)
+	co.Strict = true
+	err = co.Stop()
+	assert.EqualError(t, err, "2 pods are not running in strict mode: pd-0(Failed), tikv-1(Pending)")
+	_, err = co.Restore("5.2")
+	assert.Error(t, err)
+	assert.Empty(t, executor.calls)
+	for _, action := range client.Actions() {
+		assert.Equal(t, "list", action.GetVerb())
+	}
+}
+
+func TestSkipPodsWithoutBackup(t *testing.T) {
+	newClient := func() *fake.Clientset {
+		return fake.NewSimpleClientset(
+			newTestPod("tikv-0", TiKV, corev1.PodRunning),
+			newTestPod("tikv-1", TiKV, corev1.PodRunning),
+			newTestPod("pd-0", PD, corev1.PodRunning),
+		)
+	}
+	// tikv-1 has no backup of 5.2.
+	newExecutor := func() *fakeExecutor {
+		return newFakeExecutor(func(podName string, command []string) (string, error) {
+			cmd := command[len(command)-1]
+			switch {
+			case strings.Contains(cmd, "ps -ef"):
+				return "UID\r\n1\r\n", nil
+			case strings.HasPrefix(cmd, "restored="):
+				return "1 1\r\n", nil
+			case strings.HasPrefix(cmd, "ls") && podName == "tikv-1":
+				return "5.1.bat\r\n", nil
+			case strings.HasPrefix(cmd, "ls"):
+				return "5.1.bat\r\n5.2.bat\r\n", nil
+			}
+			return "", nil
+		})
+	}
+	restored := func(executor *fakeExecutor, podName string) bool {
+		for _, call := range executor.calls[podName] {
+			if strings.Contains(call[len(call)-1], restoringSuffix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	executor := newExecutor()
+	co := newTestCloudOperator(context.Background(), newClient(), executor)
+	_, err := co.Restore("5.2")
+	assert.EqualError(t, err, "1 pods are skipped by restore, the cluster may be mixed: tikv-1(version 5.2 not found)")
+	assert.Equal(t, CategoryPartial, Category(err))
+	assert.Equal(t, []SkippedPod{
+		{Operation: "restore", Pod: "tikv-1", Component: "tikv", Phase: "Running", Reason: "version 5.2 not found"},
+	}, co.Skipped())
+	assert.True(t, restored(executor, "tikv-0"))
+	assert.True(t, restored(executor, "pd-0"))
+	assert.False(t, restored(executor, "tikv-1"))
+
+	// it fails before touching any pod in strict mode.
+	executor = newExecutor()
+	co = newTestCloudOperator(context.Background(), newClient(), executor)
+	co.Strict = true
+	_, err = co.Restore("5.2")
+	assert.EqualError(t, err, "1 components failed: tikv: version 5.2 not found in strict mode: tikv-1")
+	for podName := range executor.calls {
+		assert.False(t, restored(executor, podName), podName)
+	}
+
+	// it fails if no pod of the component has the version.
+	executor = newExecutor()
+	co = newTestCloudOperator(context.Background(), newClient(), executor)
+	_, err = co.Restore("tikv=5.3,pd=5.2")
+	assert.Error(t, err)
+	for podName := range executor.calls {
+		assert.False(t, restored(executor, podName), podName)
+	}
+}
@@ -0,0 +1,492 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// VolumeSnapshotResource is the resource of the CSI volume snapshots.
+var VolumeSnapshotResource = schema.GroupVersionResource{Group: "snapshot.storage.k8s.io", Version: "v1", Resource: "volumesnapshots"}
+
+// The labels of the volume snapshots created by SnapshotBackend.
+const (
+	SnapshotVersionLabel = "tinker/version"
+	SnapshotPodLabel     = "tinker/pod"
+)
+
+// snapshotPermissions are needed to snapshot the claims and provision the claims from the snapshots.
+var snapshotPermissions = []Permission{
+	{Verb: "create", Group: VolumeSnapshotResource.Group, Resource: VolumeSnapshotResource.Resource},
+	{Verb: "get", Group: VolumeSnapshotResource.Group, Resource: VolumeSnapshotResource.Resource},
+	{Verb: "get", Resource: "persistentvolumeclaims"},
+	{Verb: "create", Resource: "persistentvolumeclaims"},
+	{Verb: "delete", Resource: "persistentvolumeclaims"},
+	{Verb: "patch", Resource: "persistentvolumes"},
+}
+
+// SnapshotBackend backs up the persistent volume claim of the data directory by a CSI volume snapshot,
+// the volume snapshot is named <claim>-<version>.
+// It restores the pod by provisioning a new claim from the snapshot and swapping its volume in as the data claim,
+// the stateful set recreates the pod with the swapped claim.
+type SnapshotBackend struct {
+	client dynamic.Interface
+	// Class is the VolumeSnapshotClass of the snapshots, empty means the default class of the CSI driver.
+	Class string
+}
+
+// NewSnapshotBackend creates a snapshot backend with the config of the operator.
+func NewSnapshotBackend(c *CloudOperator, class string) (*SnapshotBackend, error) {
+	client, err := dynamic.NewForConfig(c.config)
+	if err != nil {
+		return nil, fmt.Errorf("create k8s dynamic client failed: %w", err)
+	}
+	return &SnapshotBackend{client: client, Class: class}, nil
+}
+
+// snapshotName returns the name of the volume snapshot of the claim, the name should be lowercase.
+func snapshotName(claim, version string) string {
+	return strings.ToLower(fmt.Sprintf("%s-%s", claim, version))
+}
+
+// newVolumeSnapshot builds the volume snapshot of the claim of the pod.
+func newVolumeSnapshot(pod *corev1.Pod, cp component, claim, class, version string) *unstructured.Unstructured {
+	spec := map[string]interface{}{
+		"source": map[string]interface{}{
+			"persistentVolumeClaimName": claim,
+		},
+	}
+	if len(class) > 0 {
+		spec["volumeSnapshotClassName"] = class
+	}
+	snapshot := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	snapshot.SetAPIVersion(VolumeSnapshotResource.GroupVersion().String())
+	snapshot.SetKind("VolumeSnapshot")
+	snapshot.SetName(snapshotName(claim, version))
+	snapshot.SetNamespace(pod.Namespace)
+	snapshot.SetLabels(map[string]string{
+		"app.kubernetes.io/managed-by": "tinker",
+		"app.kubernetes.io/component":  cp.String(),
+		SnapshotVersionLabel:           version,
+		SnapshotPodLabel:               pod.Name,
+	})
+	return snapshot
+}
+
+// newRestoreClaim builds the claim named name which is provisioned from the snapshot, it keeps the spec of the claim.
+// It isn't labeled like the claim, so it's not taken as a claim of the component.
+func newRestoreClaim(claim *corev1.PersistentVolumeClaim, name, snapshot string) *corev1.PersistentVolumeClaim {
+	group := VolumeSnapshotResource.Group
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: claim.Namespace,
+			Labels:    map[string]string{"app.kubernetes.io/managed-by": "tinker"},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      claim.Spec.AccessModes,
+			Resources:        claim.Spec.Resources,
+			StorageClassName: claim.Spec.StorageClassName,
+			VolumeMode:       claim.Spec.VolumeMode,
+			DataSource: &corev1.TypedLocalObjectReference{
+				APIGroup: &group,
+				Kind:     "VolumeSnapshot",
+				Name:     snapshot,
+			},
+		},
+	}
+}
+
+// newSwappedClaim builds the data claim which binds to the restored volume, it keeps the spec of the claim.
+func newSwappedClaim(claim *corev1.PersistentVolumeClaim, volume string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        claim.Name,
+			Namespace:   claim.Namespace,
+			Labels:      claim.Labels,
+			Annotations: claim.Annotations,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      claim.Spec.AccessModes,
+			Resources:        claim.Spec.Resources,
+			StorageClassName: claim.Spec.StorageClassName,
+			VolumeMode:       claim.Spec.VolumeMode,
+			VolumeName:       volume,
+		},
+	}
+}
+
+// snapshotReady returns true if the volume snapshot can be used to provision claims,
+// it returns the error of the snapshot reported by the CSI driver.
+func snapshotReady(snapshot *unstructured.Unstructured) (bool, error) {
+	if msg, ok, _ := unstructured.NestedString(snapshot.Object, "status", "error", "message"); ok {
+		return false, fmt.Errorf("volume snapshot %s failed: %s", snapshot.GetName(), msg)
+	}
+	ready, _, _ := unstructured.NestedBool(snapshot.Object, "status", "readyToUse")
+	return ready, nil
+}
+
+// snapshotSize returns the restore size of the snapshot in bytes, it's 0 if the driver doesn't report it.
+func snapshotSize(snapshot *unstructured.Unstructured) int64 {
+	size, ok, _ := unstructured.NestedString(snapshot.Object, "status", "restoreSize")
+	if !ok {
+		return 0
+	}
+	quantity, err := resource.ParseQuantity(size)
+	if err != nil {
+		return 0
+	}
+	return quantity.Value()
+}
+
+// Validate implements Backend interface, it checks the options which only work with the copies in the pods are not set.
+func (b *SnapshotBackend) Validate(c *CloudOperator) error {
+	var options []string
+	for _, option := range []struct {
+		name string
+		set  bool
+	}{
+		{"compress", c.Compress},
+		{"incremental", c.Incremental},
+		{"upload", c.Upload != nil},
+		{"download", c.Download != nil},
+		{"exclude", len(c.Excludes) > 0},
+		{"resume", c.Resume},
+		{"max-backup-size", c.MaxBackupSize > 0},
+		{"retain", c.Retain > 0},
+		{"backup-root", c.BackupRoot != ""},
+		{"timestamped", c.Timestamped},
+	} {
+		if option.set {
+			options = append(options, "--"+option.name)
+		}
+	}
+	if len(options) > 0 {
+		return fmt.Errorf("%s can't be used with --snapshot", strings.Join(options, ", "))
+	}
+	return nil
+}
+
+// dataClaim returns the persistent volume claim mounted at the data directory of the component in the pod,
+// the longest mount path containing the data directory wins.
+func (c *CloudOperator) dataClaim(pod *corev1.Pod, cp component) (string, error) {
+	// the debug container has the same mounts, but the volumes are looked up in the target container.
+	name, err := resolveContainer(pod, cp, c.Containers[cp])
+	if err != nil {
+		return "", err
+	}
+	dir := cp.BataDir(c.DataDirs)
+	var mount corev1.VolumeMount
+	for _, container := range pod.Spec.Containers {
+		if container.Name != name {
+			continue
+		}
+		for _, m := range container.VolumeMounts {
+			path := strings.TrimSuffix(m.MountPath, "/")
+			if (dir == path || strings.HasPrefix(dir, path+"/")) && len(m.MountPath) > len(mount.MountPath) {
+				mount = m
+			}
+		}
+	}
+	if len(mount.Name) == 0 {
+		return "", fmt.Errorf("no volume is mounted at %s in pod %s", dir, c.podKey(pod))
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Name != mount.Name {
+			continue
+		}
+		if volume.PersistentVolumeClaim == nil {
+			return "", fmt.Errorf("volume %s mounted at %s in pod %s is not a persistent volume claim", volume.Name, mount.MountPath, c.podKey(pod))
+		}
+		return volume.PersistentVolumeClaim.ClaimName, nil
+	}
+	return "", fmt.Errorf("volume %s mounted at %s is not found in pod %s", mount.Name, mount.MountPath, c.podKey(pod))
+}
+
+// Prepare implements Backend interface, it checks the data directory of every pod is a persistent volume claim.
+func (b *SnapshotBackend) Prepare(c *CloudOperator, cp component, pods []corev1.Pod) error {
+	if len(c.extraDataDirs(cp)) > 0 {
+		return fmt.Errorf("%s has multiple data dirs, it can't be backed up by snapshots", cp)
+	}
+	for i := range pods {
+		if _, err := c.dataClaim(&pods[i], cp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Back implements Backend interface, it waits for the snapshots ready to use, so the pods can be started after it.
+// The size of the backup is the restore size of the snapshot.
+func (b *SnapshotBackend) Back(c *CloudOperator, version string, targets []componentPods) ([]OperationResult, error) {
+	return b.runPods(c, "backup", ComponentVersions{all: version}, targets, b.snapshot)
+}
+
+// HasBackup implements Backend interface, the snapshot should be ready to use.
+func (b *SnapshotBackend) HasBackup(c *CloudOperator, pod *corev1.Pod, cp component, version string) (bool, error) {
+	claim, err := c.dataClaim(pod, cp)
+	if err != nil {
+		return false, err
+	}
+	snapshot, err := b.client.Resource(VolumeSnapshotResource).Namespace(pod.Namespace).Get(c.ctx, snapshotName(claim, version), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return snapshotReady(snapshot)
+}
+
+// Restore implements Backend interface, the pods are deleted and recreated by the stateful set.
+func (b *SnapshotBackend) Restore(c *CloudOperator, versions ComponentVersions, targets []componentPods) ([]OperationResult, error) {
+	return b.runPods(c, "restore", versions, targets, func(c *CloudOperator, pod *corev1.Pod, cp component, version string) (int64, error) {
+		return 0, b.restore(c, pod, cp, version)
+	})
+}
+
+// Permissions implements Backend interface.
+func (b *SnapshotBackend) Permissions() []Permission {
+	return snapshotPermissions
+}
+
+// runPods runs the operation of every pod of the targets, at most Parallel pods run at once.
+// The run returns the size of the backup in bytes, it returns the results of the pods in the order of the targets.
+func (b *SnapshotBackend) runPods(c *CloudOperator, operation string, versions ComponentVersions, targets []componentPods,
+	run func(c *CloudOperator, pod *corev1.Pod, cp component, version string) (int64, error)) ([]OperationResult, error) {
+	errs := newPodErrors()
+	var results []*OperationResult
+	var tasks []func()
+	for _, target := range targets {
+		cp := target.component
+		version, err := versions.Of(cp)
+		if err != nil {
+			return nil, err
+		}
+		errs.expect(len(target.pods))
+		for i := range target.pods {
+			pod := &target.pods[i]
+			rst := &OperationResult{Pod: c.podKey(pod), Component: cp.String()}
+			results = append(results, rst)
+			tasks = append(tasks, func() {
+				log.Info(operation+" start", zap.String("pod-name", rst.Pod))
+				start := time.Now()
+				bytes, err := run(c, pod, cp, version)
+				rst.Duration = time.Since(start)
+				observeOperation(operation, cp, err)
+				if err != nil {
+					log.Error(operation+" failed", zap.String("pod-name", rst.Pod), zap.String("component", cp.String()), zap.Error(err))
+					rst.Err = err
+					errs.add(rst.Pod, err)
+					return
+				}
+				rst.Bytes = bytes
+				log.Info(operation+" finished", zap.String("pod-name", rst.Pod))
+			})
+		}
+	}
+	parallel(c.Parallel, tasks)
+	rst := make([]OperationResult, 0, len(results))
+	for _, r := range results {
+		rst = append(rst, *r)
+	}
+	return rst, errs.err()
+}
+
+// snapshot creates the volume snapshot of the data claim of the pod and waits for it ready to use.
+func (b *SnapshotBackend) snapshot(c *CloudOperator, pod *corev1.Pod, cp component, version string) (int64, error) {
+	claim, err := c.dataClaim(pod, cp)
+	if err != nil {
+		return 0, err
+	}
+	snapshot := newVolumeSnapshot(pod, cp, claim, b.Class, version)
+	if c.DryRun {
+		c.printDryRun("create volume snapshot %s/%s of claim %s", pod.Namespace, snapshot.GetName(), claim)
+		return 0, nil
+	}
+	snapshots := b.client.Resource(VolumeSnapshotResource).Namespace(pod.Namespace)
+	if _, err := snapshots.Create(c.ctx, snapshot, metav1.CreateOptions{}); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return 0, fmt.Errorf("volume snapshot %s already exists, delete it to back up version %s again", snapshot.GetName(), version)
+		}
+		return 0, err
+	}
+	var current *unstructured.Unstructured
+	err = c.waitFor(func() (bool, error) {
+		current, err = snapshots.Get(c.ctx, snapshot.GetName(), metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return snapshotReady(current)
+	}, c.WaitTimeout)
+	if errors.Is(err, errWaitTimeout) {
+		return 0, fmt.Errorf("volume snapshot %s is not ready after %s", snapshot.GetName(), c.WaitTimeout)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return snapshotSize(current), nil
+}
+
+// restoreClaimName returns the name of the claim provisioned from the snapshot before it's swapped in.
+func restoreClaimName(claim, version string) string {
+	return strings.ToLower(fmt.Sprintf("%s-restore-%s", claim, version))
+}
+
+// restore replaces the data claim of the pod by the volume provisioned from the snapshot of the version.
+// The live claim is never deleted before the restored volume is ready, the steps are:
+//  1. provision a new claim from the snapshot and wait for it bound.
+//  2. retain both the old and the restored volumes, so deleting the claims doesn't delete the data.
+//  3. delete the new claim and pre-bind the restored volume to the name of the data claim.
+//  4. delete the data claim and the pod, the stateful set recreates the claim which binds to the pre-bound volume.
+//
+// The old volume is left released, it can be bound again to roll back the restore.
+func (b *SnapshotBackend) restore(c *CloudOperator, pod *corev1.Pod, cp component, version string) error {
+	name, err := c.dataClaim(pod, cp)
+	if err != nil {
+		return err
+	}
+	snapshot, restoreName := snapshotName(name, version), restoreClaimName(name, version)
+	if c.DryRun {
+		c.printDryRun("provision claim %s/%s from volume snapshot %s, then swap it in as claim %s of pod %s", pod.Namespace, restoreName, snapshot, name, c.podKey(pod))
+		return nil
+	}
+	claims := c.client.CoreV1().PersistentVolumeClaims(pod.Namespace)
+	claim, err := claims.Get(c.ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if len(claim.Spec.VolumeName) == 0 {
+		return fmt.Errorf("claim %s is not bound to any volume", name)
+	}
+	oldVolume := claim.Spec.VolumeName
+	// the claim left by the failed restore is reused.
+	if _, err := claims.Create(c.ctx, newRestoreClaim(claim, restoreName, snapshot), metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	var volume string
+	err = c.waitFor(func() (bool, error) {
+		current, err := claims.Get(c.ctx, restoreName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		volume = current.Spec.VolumeName
+		return current.Status.Phase == corev1.ClaimBound && len(volume) > 0, nil
+	}, c.WaitTimeout)
+	if errors.Is(err, errWaitTimeout) {
+		return fmt.Errorf("claim %s provisioned from volume snapshot %s is not bound after %s, claim %s is kept", restoreName, snapshot, c.WaitTimeout, name)
+	}
+	if err != nil {
+		return err
+	}
+	for _, v := range []string{oldVolume, volume} {
+		if err := b.retainVolume(c, v); err != nil {
+			return err
+		}
+	}
+	if err := claims.Delete(c.ctx, restoreName, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if err := b.waitClaimDeleted(c, pod.Namespace, restoreName, ""); err != nil {
+		return err
+	}
+	if err := b.prebindVolume(c, volume, pod.Namespace, name); err != nil {
+		return err
+	}
+	if err := claims.Delete(c.ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if err := c.client.CoreV1().Pods(pod.Namespace).Delete(c.ctx, pod.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	// the stateful set may have recreated the claim already, it binds to the pre-bound volume too.
+	if err := b.waitClaimDeleted(c, pod.Namespace, name, claim.UID); err != nil {
+		return err
+	}
+	if _, err := claims.Create(c.ctx, newSwappedClaim(claim, volume), metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	err = c.waitFor(func() (bool, error) {
+		current, err := claims.Get(c.ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if len(current.Spec.VolumeName) > 0 && current.Spec.VolumeName != volume {
+			return false, fmt.Errorf("claim %s is bound to volume %s instead of the restored volume %s, the old volume %s is retained", name, current.Spec.VolumeName, volume, oldVolume)
+		}
+		return current.Spec.VolumeName == volume, nil
+	}, c.WaitTimeout)
+	if errors.Is(err, errWaitTimeout) {
+		return fmt.Errorf("claim %s is not bound to the restored volume %s after %s, the old volume %s is retained", name, volume, c.WaitTimeout, oldVolume)
+	}
+	if err != nil {
+		return err
+	}
+	log.Info("restored claim from volume snapshot, the old volume is retained, delete it once the restore is verified",
+		zap.String("claim", name), zap.String("volume", volume), zap.String("old-volume", oldVolume))
+	return nil
+}
+
+// waitClaimDeleted waits until the claim is deleted, or it is recreated with another uid if uid isn't empty.
+func (b *SnapshotBackend) waitClaimDeleted(c *CloudOperator, namespace, name string, uid types.UID) error {
+	err := c.waitFor(func() (bool, error) {
+		current, err := c.client.CoreV1().PersistentVolumeClaims(namespace).Get(c.ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return len(uid) > 0 && current.UID != uid, nil
+	}, c.WaitTimeout)
+	if errors.Is(err, errWaitTimeout) {
+		return fmt.Errorf("claim %s is not deleted after %s", name, c.WaitTimeout)
+	}
+	return err
+}
+
+// retainVolume sets the reclaim policy of the volume to Retain, so the data is kept after its claim is deleted.
+func (b *SnapshotBackend) retainVolume(c *CloudOperator, volume string) error {
+	patch := []byte(fmt.Sprintf(`{"spec":{"persistentVolumeReclaimPolicy":%q}}`, corev1.PersistentVolumeReclaimRetain))
+	if _, err := c.client.CoreV1().PersistentVolumes().Patch(c.ctx, volume, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("retain volume %s failed: %w", volume, err)
+	}
+	return nil
+}
+
+// prebindVolume binds the released volume to the claim name, the claim of the name binds to it once it's created.
+func (b *SnapshotBackend) prebindVolume(c *CloudOperator, volume, namespace, claim string) error {
+	patch := []byte(fmt.Sprintf(`{"spec":{"claimRef":{"namespace":%q,"name":%q,"uid":null,"resourceVersion":null}}}`, namespace, claim))
+	if _, err := c.client.CoreV1().PersistentVolumes().Patch(c.ctx, volume, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("bind volume %s to claim %s failed: %w", volume, claim, err)
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newClaimPod creates a pod of the component whose data directory is the claim.
+func newClaimPod(name string, cp component, claim string) *corev1.Pod {
+	pod := newTestPod(name, cp, corev1.PodRunning)
+	pod.Spec.Containers[0].VolumeMounts = []corev1.VolumeMount{
+		{Name: "config", MountPath: "/etc/" + cp.String()},
+		{Name: cp.String(), MountPath: cp.BataDir(nil)},
+	}
+	pod.Spec.Volumes = []corev1.Volume{
+		{Name: "config", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{}}},
+		{Name: cp.String(), VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim}}},
+	}
+	return pod
+}
+
+// newFakeSnapshotBackend creates a snapshot backend whose snapshots are created with the status.
+func newFakeSnapshotBackend(status map[string]interface{}) (*SnapshotBackend, *dynamicfake.FakeDynamicClient) {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{VolumeSnapshotResource: "VolumeSnapshotList"})
+	client.PrependReactor("create", VolumeSnapshotResource.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+		snapshot := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured)
+		if status != nil {
+			snapshot.Object["status"] = status
+		}
+		return false, nil, nil
+	})
+	return &SnapshotBackend{client: client, Class: "csi-snapclass"}, client
+}
+
+func TestNewVolumeSnapshot(t *testing.T) {
+	pod := newClaimPod("tikv-0", TiKV, "tikv-tikv-0")
+	snapshot := newVolumeSnapshot(pod, TiKV, "tikv-tikv-0", "csi-snapclass", "5.2-RC.1")
+	assert.Equal(t, "snapshot.storage.k8s.io/v1", snapshot.GetAPIVersion())
+	assert.Equal(t, "VolumeSnapshot", snapshot.GetKind())
+	assert.Equal(t, "tikv-tikv-0-5.2-rc.1", snapshot.GetName())
+	assert.Equal(t, metav1.NamespaceDefault, snapshot.GetNamespace())
+	assert.Equal(t, map[string]string{
+		"app.kubernetes.io/managed-by": "tinker",
+		"app.kubernetes.io/component":  "tikv",
+		SnapshotVersionLabel:           "5.2-RC.1",
+		SnapshotPodLabel:               "tikv-0",
+	}, snapshot.GetLabels())
+	claim, _, _ := unstructured.NestedString(snapshot.Object, "spec", "source", "persistentVolumeClaimName")
+	assert.Equal(t, "tikv-tikv-0", claim)
+	class, _, _ := unstructured.NestedString(snapshot.Object, "spec", "volumeSnapshotClassName")
+	assert.Equal(t, "csi-snapclass", class)
+
+	// the default class of the driver is used.
+	snapshot = newVolumeSnapshot(pod, TiKV, "tikv-tikv-0", "", "5.2")
+	_, ok, _ := unstructured.NestedString(snapshot.Object, "spec", "volumeSnapshotClassName")
+	assert.False(t, ok)
+}
+
+func TestDataClaim(t *testing.T) {
+	co := newTestCloudOperator(context.Background(), fake.NewSimpleClientset(), nil)
+
+	pod := newClaimPod("tikv-0", TiKV, "tikv-tikv-0")
+	claim, err := co.dataClaim(pod, TiKV)
+	assert.NoError(t, err)
+	assert.Equal(t, "tikv-tikv-0", claim)
+
+	// the longest mount path wins.
+	pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{Name: "root", MountPath: "/"})
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{Name: "root", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "root"}}})
+	claim, err = co.dataClaim(pod, TiKV)
+	assert.NoError(t, err)
+	assert.Equal(t, "tikv-tikv-0", claim)
+	co.DataDirs = map[component]string{TiKV: "/data/tikv"}
+	claim, err = co.dataClaim(pod, TiKV)
+	assert.NoError(t, err)
+	assert.Equal(t, "root", claim)
+
+	// the data directory is not mounted from a claim.
+	co.DataDirs = map[component]string{TiKV: "/etc/tikv/data"}
+	_, err = co.dataClaim(pod, TiKV)
+	assert.EqualError(t, err, "volume config mounted at /etc/tikv in pod tikv-0 is not a persistent volume claim")
+	_, err = co.dataClaim(newTestPod("tikv-0", TiKV, corev1.PodRunning), TiKV)
+	assert.EqualError(t, err, "no volume is mounted at /etc/tikv/data in pod tikv-0")
+}
+
+func TestSnapshotBack(t *testing.T) {
+	pods := []*corev1.Pod{newClaimPod("tikv-0", TiKV, "tikv-tikv-0"), newClaimPod("tikv-1", TiKV, "tikv-tikv-1")}
+	backend, client := newFakeSnapshotBackend(map[string]interface{}{"readyToUse": true})
+	co := newTestCloudOperator(context.Background(), fake.NewSimpleClientset(), nil)
+	co.Backend = backend
+	targets := []componentPods{{component: TiKV, pods: []corev1.Pod{*pods[0], *pods[1]}}}
+
+	_, err := backend.Back(co, "5.2", targets)
+	assert.NoError(t, err)
+	for _, name := range []string{"tikv-tikv-0-5.2", "tikv-tikv-1-5.2"} {
+		snapshot, err := client.Resource(VolumeSnapshotResource).Namespace(metav1.NamespaceDefault).Get(context.Background(), name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "5.2", snapshot.GetLabels()[SnapshotVersionLabel])
+	}
+	for _, ca := range []struct {
+		version string
+		exist   bool
+	}{{"5.2", true}, {"5.1", false}} {
+		exist, err := backend.HasBackup(co, pods[0], TiKV, ca.version)
+		assert.NoError(t, err)
+		assert.Equal(t, ca.exist, exist, ca)
+	}
+
+	// the existing snapshot is not overwritten.
+	_, err = backend.Back(co, "5.2", targets)
+	assert.Contains(t, err.Error(), "volume snapshot tikv-tikv-0-5.2 already exists")
+
+	// the error of the driver fails the backup.
+	backend, _ = newFakeSnapshotBackend(map[string]interface{}{"readyToUse": false, "error": map[string]interface{}{"message": "driver failed"}})
+	co.Backend = backend
+	_, err = backend.Back(co, "5.2", targets[:1])
+	assert.Contains(t, err.Error(), "volume snapshot tikv-tikv-0-5.2 failed: driver failed")
+
+	// it isn't ready in time.
+	backend, _ = newFakeSnapshotBackend(nil)
+	co.Backend = backend
+	co.WaitTimeout = 10 * time.Millisecond
+	co.after = func(time.Duration) <-chan time.Time {
+		return time.After(time.Millisecond)
+	}
+	_, err = backend.Back(co, "5.2", targets[:1])
+	assert.Contains(t, err.Error(), "volume snapshot tikv-tikv-0-5.2 is not ready after 10ms")
+	exist, err := backend.HasBackup(co, pods[0], TiKV, "5.2")
+	assert.NoError(t, err)
+	assert.False(t, exist)
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	pod := newClaimPod("tikv-0", TiKV, "tikv-tikv-0")
+	storageClass := "csi-hostpath"
+	claim := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "tikv-tikv-0", Namespace: metav1.NamespaceDefault, UID: "old", Labels: map[string]string{"app": "tikv"}},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			StorageClassName: &storageClass,
+			VolumeName:       "pv-0",
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")},
+			},
+		},
+	}
+	volumes := []runtime.Object{
+		&corev1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-0"}, Spec: corev1.PersistentVolumeSpec{PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimDelete}},
+		&corev1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-restored"}, Spec: corev1.PersistentVolumeSpec{PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimDelete}},
+	}
+	newClient := func(bound bool) *fake.Clientset {
+		client := fake.NewSimpleClientset(append([]runtime.Object{pod, claim.DeepCopy()}, volumes...)...)
+		client.PrependReactor("create", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			created := action.(k8stesting.CreateAction).GetObject().(*corev1.PersistentVolumeClaim)
+			if bound && created.Name == "tikv-tikv-0-restore-5.2" {
+				created.Spec.VolumeName = "pv-restored"
+				created.Status.Phase = corev1.ClaimBound
+			}
+			return false, nil, nil
+		})
+		return client
+	}
+	client := newClient(true)
+	backend, _ := newFakeSnapshotBackend(nil)
+	co := newTestCloudOperator(context.Background(), client, nil)
+	co.Backend = backend
+
+	_, err := backend.Restore(co, ComponentVersions{all: "5.2"}, []componentPods{{component: TiKV, pods: []corev1.Pod{*pod}}})
+	assert.NoError(t, err)
+	// the pod is deleted to be recreated with the claim bound to the restored volume.
+	_, err = client.CoreV1().Pods(metav1.NamespaceDefault).Get(context.Background(), "tikv-0", metav1.GetOptions{})
+	assert.Error(t, err)
+	restored, err := client.CoreV1().PersistentVolumeClaims(metav1.NamespaceDefault).Get(context.Background(), "tikv-tikv-0", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.NotEqual(t, claim.UID, restored.UID)
+	assert.Equal(t, claim.Labels, restored.Labels)
+	assert.Equal(t, claim.Spec.Resources, restored.Spec.Resources)
+	assert.Equal(t, &storageClass, restored.Spec.StorageClassName)
+	assert.Equal(t, "pv-restored", restored.Spec.VolumeName)
+	// the claim provisioned from the snapshot is deleted.
+	_, err = client.CoreV1().PersistentVolumeClaims(metav1.NamespaceDefault).Get(context.Background(), "tikv-tikv-0-restore-5.2", metav1.GetOptions{})
+	assert.Error(t, err)
+	// both volumes are retained and the restored one is pre-bound to the data claim.
+	for _, name := range []string{"pv-0", "pv-restored"} {
+		volume, err := client.CoreV1().PersistentVolumes().Get(context.Background(), name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, corev1.PersistentVolumeReclaimRetain, volume.Spec.PersistentVolumeReclaimPolicy, name)
+	}
+	volume, err := client.CoreV1().PersistentVolumes().Get(context.Background(), "pv-restored", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "tikv-tikv-0", volume.Spec.ClaimRef.Name)
+	assert.Equal(t, metav1.NamespaceDefault, volume.Spec.ClaimRef.Namespace)
+
+	// the live claim and the pod are kept if the provisioned claim isn't bound.
+	client = newClient(false)
+	co = newTestCloudOperator(context.Background(), client, nil)
+	co.Backend = backend
+	co.WaitTimeout = 10 * time.Millisecond
+	co.after = func(time.Duration) <-chan time.Time {
+		return time.After(time.Millisecond)
+	}
+	_, err = backend.Restore(co, ComponentVersions{all: "5.2"}, []componentPods{{component: TiKV, pods: []corev1.Pod{*pod}}})
+	assert.Contains(t, err.Error(), "claim tikv-tikv-0-restore-5.2 provisioned from volume snapshot tikv-tikv-0-5.2 is not bound after 10ms, claim tikv-tikv-0 is kept")
+	kept, err := client.CoreV1().PersistentVolumeClaims(metav1.NamespaceDefault).Get(context.Background(), "tikv-tikv-0", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, claim.UID, kept.UID)
+	assert.Equal(t, "pv-0", kept.Spec.VolumeName)
+	_, err = client.CoreV1().Pods(metav1.NamespaceDefault).Get(context.Background(), "tikv-0", metav1.GetOptions{})
+	assert.NoError(t, err)
+}
+
+func TestSnapshotValidate(t *testing.T) {
+	backend, _ := newFakeSnapshotBackend(nil)
+	co := newTestCloudOperator(context.Background(), fake.NewSimpleClientset(), nil)
+	co.Backend = backend
+	assert.NoError(t, backend.Validate(co))
+	co.Compress = true
+	co.Retain = 3
+	assert.EqualError(t, backend.Validate(co), "--compress, --retain can't be used with --snapshot")
+	_, err := co.BackupWorkflow(context.Background(), "5.2")
+	assert.EqualError(t, err, "--compress, --retain can't be used with --snapshot")
+	co.BackupRoot = "/backup"
+	assert.EqualError(t, backend.Validate(co), "--compress, --retain, --backup-root can't be used with --snapshot")
+	assert.NoError(t, CopyBackend{}.Validate(co))
+	assert.Contains(t, co.permissions(), Permission{Verb: "create", Group: "snapshot.storage.k8s.io", Resource: "volumesnapshots"})
+	assert.Equal(t, "create volumesnapshots.snapshot.storage.k8s.io", snapshotPermissions[0].String())
+}
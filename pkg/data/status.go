@@ -0,0 +1,98 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Process states of the component in the pod.
+const (
+	ProcessRunning = "running"
+	ProcessStopped = "stopped"
+	ProcessUnknown = "unknown"
+)
+
+// PodStatus is the status of the component pod.
+type PodStatus struct {
+	Pod       string   `json:"pod"`
+	Component string   `json:"component"`
+	Phase     string   `json:"phase"`
+	Debug     bool     `json:"debug"`
+	Process   string   `json:"process"`
+	Versions  []string `json:"versions"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// Status returns the status of all the component pods sorted by pod, it is read-only.
+// The error of a pod is reported in its status instead of failing the whole report.
+func (c *CloudOperator) Status() ([]PodStatus, error) {
+	var pods []corev1.Pod
+	var components []component
+	for _, cp := range c.Components {
+		list, err := c.discoverPods(cp)
+		if err != nil {
+			return nil, err
+		}
+		for range list.Items {
+			components = append(components, cp)
+		}
+		pods = append(pods, list.Items...)
+	}
+	results := make([]PodStatus, len(pods))
+	tasks := make([]func(), 0, len(pods))
+	for i := range pods {
+		i := i
+		tasks = append(tasks, func() {
+			results[i] = c.podStatus(&pods[i], components[i])
+		})
+	}
+	parallel(c.Parallel, tasks)
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Pod < results[j].Pod
+	})
+	return results, nil
+}
+
+// podStatus returns the status of the component pod.
+func (c *CloudOperator) podStatus(pod *corev1.Pod, cp component) PodStatus {
+	rst := PodStatus{
+		Pod:       c.podKey(pod),
+		Component: cp.String(),
+		Phase:     string(pod.Status.Phase),
+		Debug:     pod.Annotations[c.DebugKey] == c.DebugValue,
+		Process:   ProcessUnknown,
+	}
+	if pod.Status.Phase != corev1.PodRunning {
+		return rst
+	}
+	running, err := c.processRunning(pod, cp)
+	if err != nil {
+		rst.Error = err.Error()
+		return rst
+	}
+	rst.Process = ProcessStopped
+	if running {
+		rst.Process = ProcessRunning
+	}
+	versions, err := c.listVersions(pod, cp)
+	if err != nil {
+		rst.Error = err.Error()
+		return rst
+	}
+	rst.Versions = versions
+	return rst
+}
@@ -0,0 +1,54 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestStatus(t *testing.T) {
+	tikv := newTestPod("tikv-0", TiKV, corev1.PodRunning)
+	tikv.Annotations = map[string]string{DebugLabel: DebugValue}
+	pd := newTestPod("pd-0", PD, corev1.PodRunning)
+	pending := newTestPod("pd-1", PD, corev1.PodPending)
+	client := fake.NewSimpleClientset(tikv, pd, pending)
+	executor := newFakeExecutor(func(podName string, command []string) (string, error) {
+		switch {
+		case strings.HasPrefix(command[2], "ps"):
+			if podName == "tikv-0" {
+				return "UID\r\n1\r\n", nil
+			}
+			return "UID\r\n10\r\n", nil
+		case podName == "pd-0":
+			return "", errors.New("connection refused")
+		}
+		return "5.1.bat\r\n5.2.bat\r\n", nil
+	})
+	co := newTestCloudOperator(context.Background(), client, executor)
+	co.RetryCount = 1
+	status, err := co.Status()
+	assert.NoError(t, err)
+	assert.Equal(t, []PodStatus{
+		{Pod: "pd-0", Component: "pd", Phase: "Running", Process: ProcessRunning, Error: "exec in pod pd-0 failed: connection refused"},
+		{Pod: "pd-1", Component: "pd", Phase: "Pending", Process: ProcessUnknown},
+		{Pod: "tikv-0", Component: "tikv", Phase: "Running", Debug: true, Process: ProcessStopped, Versions: []string{"5.1", "5.2"}},
+	}, status)
+}
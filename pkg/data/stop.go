@@ -0,0 +1,203 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// StopCmd returns the command to stop the component process, the process is PID 1 in the container.
+// The container will be restarted in debug mode after the process exits.
+func (c component) StopCmd() string {
+	return "kill -s TERM 1"
+}
+
+// stoppingPod is the pod whose process is signaled to stop.
+type stoppingPod struct {
+	pod       corev1.Pod
+	container string
+	// restarts is the restart count of the container before stopping.
+	restarts int32
+}
+
+// restartCount returns the restart count of the container in the pod.
+func restartCount(pod *corev1.Pod, container string) int32 {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == container {
+			return status.RestartCount
+		}
+	}
+	return 0
+}
+
+// exited returns true if the process of the stopping pod exited, the pod is the latest one of the same key.
+func (s *stoppingPod) exited(pod *corev1.Pod) bool {
+	if pod == nil || pod.UID != s.pod.UID {
+		return true
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name != s.container {
+			continue
+		}
+		return status.RestartCount > s.restarts || status.State.Running == nil
+	}
+	return false
+}
+
+// waitExited waits for the processes of the stopping pods to exit until StopGracePeriod elapses,
+// then it force stops the pods which are still running.
+func (c *CloudOperator) waitExited(cp component, stopping map[string]stoppingPod) error {
+	err := c.waitStopping(cp, stopping)
+	switch {
+	case errors.Is(err, errWaitTimeout):
+		return c.forceStop(cp, stopping)
+	case err != nil && c.ctx.Err() != nil:
+		return fmt.Errorf("wait process exited is cancelled: %w", err)
+	}
+	return err
+}
+
+// waitStopping removes the pods whose process exited from stopping until it's empty or StopGracePeriod elapses.
+func (c *CloudOperator) waitStopping(cp component, stopping map[string]stoppingPod) error {
+	return c.waitFor(func() (bool, error) {
+		pods, err := c.discoverPods(cp)
+		if err != nil {
+			return false, err
+		}
+		latest := make(map[string]*corev1.Pod)
+		for i := range pods.Items {
+			latest[c.podKey(&pods.Items[i])] = &pods.Items[i]
+		}
+		for key, s := range stopping {
+			if s.exited(latest[key]) {
+				delete(stopping, key)
+			}
+		}
+		return len(stopping) == 0, nil
+	}, c.StopGracePeriod)
+}
+
+// forceStop deletes the pods without grace period, the container runtime kills the processes.
+// The pods recreated by the controller have no debug annotation, so the component would start normally,
+// they are annotated and stopped again. It fails if the recreated processes don't exit either,
+// or the pods aren't recreated in WaitTimeout, which is the grace period if WaitTimeout is 0.
+// notice: the process can't be killed in the container, PID 1 ignores SIGKILL from its own PID namespace.
+func (c *CloudOperator) forceStop(cp component, stopping map[string]stoppingPod) error {
+	grace := int64(0)
+	for key, s := range stopping {
+		log.Warn("process doesn't exit in grace period, force delete the pod", zap.String("pod-name", key), zap.Duration("grace-period", c.StopGracePeriod))
+		err := c.client.CoreV1().Pods(s.pod.Namespace).Delete(c.ctx, s.pod.Name, metav1.DeleteOptions{GracePeriodSeconds: &grace})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	// 0 WaitTimeout means not waiting for the pods ready, but the recreated pods must be stopped,
+	// so the wait is bounded by the grace period instead of waiting forever.
+	timeout := c.WaitTimeout
+	if timeout <= 0 {
+		timeout = c.StopGracePeriod
+	}
+	// K: pod key V: the recreated pod whose process is signaled to stop
+	recreated := make(map[string]stoppingPod)
+	err := c.waitFor(func() (bool, error) {
+		for key, s := range stopping {
+			if _, ok := recreated[key]; ok {
+				continue
+			}
+			pod, err := c.client.CoreV1().Pods(s.pod.Namespace).Get(c.ctx, s.pod.Name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return false, err
+			}
+			if pod.UID == s.pod.UID || !containerRunning(pod, s.container) {
+				continue
+			}
+			if err := c.patchAnnotation(pod, c.DebugKey, &c.DebugValue); err != nil {
+				return false, err
+			}
+			if _, err := c.exec(key, s.container, []string{"sh", "-c", cp.StopCmd()}); err != nil {
+				return false, err
+			}
+			recreated[key] = stoppingPod{pod: *pod, container: s.container, restarts: restartCount(pod, s.container)}
+		}
+		return len(recreated) == len(stopping), nil
+	}, timeout)
+	if errors.Is(err, errWaitTimeout) {
+		return fmt.Errorf("%d force deleted pods of %s are not recreated after %s", len(stopping)-len(recreated), cp, timeout)
+	}
+	if err != nil {
+		return err
+	}
+	err = c.waitStopping(cp, recreated)
+	if errors.Is(err, errWaitTimeout) {
+		keys := make([]string, 0, len(recreated))
+		for key := range recreated {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return fmt.Errorf("process of the recreated pods %s doesn't exit in grace period %s", strings.Join(keys, ","), c.StopGracePeriod)
+	}
+	return err
+}
+
+// containerRunning returns true if the container of the pod is running.
+func containerRunning(pod *corev1.Pod, container string) bool {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == container {
+			return status.State.Running != nil
+		}
+	}
+	return false
+}
+
+// WaitStopped waits until the processes of the component are stopped, or WaitTimeout elapses.
+// It returns error if the component refuses to stop.
+func (c *CloudOperator) WaitStopped(cp component) error {
+	err := c.waitFor(func() (bool, error) {
+		pods, err := c.discoverPods(cp)
+		if err != nil {
+			return false, err
+		}
+		// the pods which are not running are skipped by stop, they have been reported by Stop.
+		running := make([]corev1.Pod, 0, len(pods.Items))
+		for i := range pods.Items {
+			if pods.Items[i].Status.Phase == corev1.PodRunning {
+				running = append(running, pods.Items[i])
+			}
+		}
+		if c.checkPodsStatus(cp, running, false) {
+			return true, nil
+		}
+		log.Info("waiting for component stopped", zap.String("component", cp.String()))
+		return false, nil
+	}, c.WaitTimeout)
+	switch {
+	case errors.Is(err, errWaitTimeout):
+		return fmt.Errorf("%s is not stopped after %s", cp, c.WaitTimeout)
+	case err != nil && c.ctx.Err() != nil:
+		return fmt.Errorf("wait %s stopped is cancelled: %w", cp, err)
+	}
+	return err
+}
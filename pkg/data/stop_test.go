@@ -0,0 +1,248 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestStopCmd(t *testing.T) {
+	for _, cp := range []component{TiDB, PD, TiKV} {
+		assert.Equal(t, "kill -s TERM 1", cp.StopCmd())
+	}
+}
+
+func TestKillWaitExited(t *testing.T) {
+	newPod := func() *corev1.Pod {
+		pod := newTestPod("tikv-0", TiKV, corev1.PodRunning)
+		pod.UID = "tikv-0"
+		pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+			Name:  "tikv",
+			State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+		}}
+		return pod
+	}
+
+	// the container restarts after the stop signal.
+	client := fake.NewSimpleClientset(newPod())
+	executor := newFakeExecutor(func(string, []string) (string, error) {
+		restarted := newPod()
+		restarted.Status.ContainerStatuses[0].RestartCount = 1
+		_, err := client.CoreV1().Pods(metav1.NamespaceDefault).Update(context.Background(), restarted, metav1.UpdateOptions{})
+		return "", err
+	})
+	co := newTestCloudOperator(context.Background(), client, executor)
+	co.StopGracePeriod = time.Minute
+	assert.NoError(t, co.kill(TiKV, []corev1.Pod{*newPod()}))
+	assert.Equal(t, [][]string{{"sh", "-c", "kill -s TERM 1"}}, executor.calls["tikv-0"])
+	_, err := client.CoreV1().Pods(metav1.NamespaceDefault).Get(context.Background(), "tikv-0", metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	// the pod is force deleted if the process ignores the stop signal, the recreated pod is stopped again.
+	newRecreating := func(exits bool) (*CloudOperator, *fake.Clientset, *fakeExecutor) {
+		client := fake.NewSimpleClientset(newPod())
+		client.PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			name := action.(k8stesting.DeleteAction).GetName()
+			if err := client.Tracker().Delete(corev1.SchemeGroupVersion.WithResource("pods"), metav1.NamespaceDefault, name); err != nil {
+				return true, nil, err
+			}
+			if name == "never-recreated" {
+				return true, nil, nil
+			}
+			recreated := newPod()
+			recreated.UID = "recreated"
+			return true, nil, client.Tracker().Add(recreated)
+		})
+		executor := newFakeExecutor(func(string, []string) (string, error) {
+			pod, err := client.CoreV1().Pods(metav1.NamespaceDefault).Get(context.Background(), "tikv-0", metav1.GetOptions{})
+			if err != nil || pod.UID != "recreated" || !exits {
+				return "", err
+			}
+			pod.Status.ContainerStatuses[0].RestartCount = 1
+			_, err = client.CoreV1().Pods(metav1.NamespaceDefault).Update(context.Background(), pod, metav1.UpdateOptions{})
+			return "", err
+		})
+		co := newTestCloudOperator(context.Background(), client, executor)
+		co.StopGracePeriod = 10 * time.Millisecond
+		co.WaitTimeout = time.Minute
+		co.after = func(time.Duration) <-chan time.Time {
+			return time.After(time.Millisecond)
+		}
+		return co, client, executor
+	}
+	co, client, executor = newRecreating(true)
+	assert.NoError(t, co.kill(TiKV, []corev1.Pod{*newPod()}))
+	pod, err := client.CoreV1().Pods(metav1.NamespaceDefault).Get(context.Background(), "tikv-0", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "recreated", string(pod.UID))
+	assert.Equal(t, DebugValue, pod.Annotations[DebugLabel])
+	assert.Equal(t, [][]string{{"sh", "-c", "kill -s TERM 1"}, {"sh", "-c", "kill -s TERM 1"}}, executor.calls["tikv-0"])
+
+	// the recreated pod isn't deleted again if its process ignores the stop signal too.
+	co, _, _ = newRecreating(false)
+	err = co.kill(TiKV, []corev1.Pod{*newPod()})
+	assert.EqualError(t, err, "process of the recreated pods tikv-0 doesn't exit in grace period 10ms")
+
+	// the pod which is never recreated fails after the grace period without WaitTimeout, instead of hanging.
+	co, _, _ = newRecreating(false)
+	co.WaitTimeout = 0
+	pod = newPod()
+	pod.Name = "never-recreated"
+	_, err = co.client.CoreV1().Pods(metav1.NamespaceDefault).Create(context.Background(), pod, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	err = co.kill(TiKV, []corev1.Pod{*pod})
+	assert.EqualError(t, err, "1 force deleted pods of tikv are not recreated after 10ms")
+}
+
+func TestWaitStopped(t *testing.T) {
+	client := fake.NewSimpleClientset(newTestPod("tikv-0", TiKV, corev1.PodRunning))
+	newOperator := func(stopAfter int) (*CloudOperator, *fakeExecutor) {
+		polls := 0
+		executor := newFakeExecutor(func(string, []string) (string, error) {
+			polls++
+			if polls > stopAfter {
+				// PID 1 is the debug process without arguments.
+				return "UID\r\n1\r\n", nil
+			}
+			return "UID\r\n10\r\n", nil
+		})
+		co := newTestCloudOperator(context.Background(), client, executor)
+		co.after = func(time.Duration) <-chan time.Time {
+			ch := make(chan time.Time, 1)
+			ch <- time.Now()
+			return ch
+		}
+		return co, executor
+	}
+
+	co, executor := newOperator(3)
+	co.WaitTimeout = time.Minute
+	assert.NoError(t, co.WaitStopped(TiKV))
+	assert.Len(t, executor.calls["tikv-0"], 4)
+
+	co, _ = newOperator(1 << 30)
+	co.WaitTimeout = 10 * time.Millisecond
+	co.after = func(time.Duration) <-chan time.Time {
+		return time.After(time.Millisecond)
+	}
+	assert.Error(t, co.WaitStopped(TiKV))
+}
+
+func TestDebugAnnotation(t *testing.T) {
+	// the pods are not running, so they will not be killed or deleted.
+	pd := newTestPod("pd-0", PD, corev1.PodPending)
+	tikv := newTestPod("tikv-0", TiKV, corev1.PodPending)
+	tikv.Annotations = map[string]string{"app": "tikv"}
+	client := fake.NewSimpleClientset(pd, tikv)
+	co := newTestCloudOperator(context.Background(), client, nil)
+	co.DebugKey = "tidb.pingcap.com/debug"
+	co.DebugValue = "true"
+	get := func(name string) map[string]string {
+		pod, err := client.CoreV1().Pods(metav1.NamespaceDefault).Get(context.Background(), name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		return pod.Annotations
+	}
+
+	assert.NoError(t, co.Stop())
+	assert.Equal(t, map[string]string{"tidb.pingcap.com/debug": "true"}, get("pd-0"))
+	assert.Equal(t, map[string]string{"app": "tikv", "tidb.pingcap.com/debug": "true"}, get("tikv-0"))
+
+	assert.NoError(t, co.Start())
+	assert.Empty(t, get("pd-0"))
+	assert.Equal(t, map[string]string{"app": "tikv"}, get("tikv-0"))
+}
+
+func TestStopPodsFilter(t *testing.T) {
+	newOperator := func() (*CloudOperator, *fake.Clientset, *fakeExecutor) {
+		client := fake.NewSimpleClientset(
+			newTestPod("tikv-0", TiKV, corev1.PodRunning),
+			newTestPod("tikv-1", TiKV, corev1.PodRunning),
+			newTestPod("pd-0", PD, corev1.PodRunning),
+			newTestPod("tidb-0", TiDB, corev1.PodRunning),
+		)
+		executor := newFakeExecutor(func(string, []string) (string, error) {
+			return "", nil
+		})
+		return newTestCloudOperator(context.Background(), client, executor), client, executor
+	}
+	annotated := func(client *fake.Clientset) []string {
+		pods, err := client.CoreV1().Pods(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+		assert.NoError(t, err)
+		var names []string
+		for _, pod := range pods.Items {
+			if _, ok := pod.Annotations[DebugLabel]; ok {
+				names = append(names, pod.Name)
+			}
+		}
+		return names
+	}
+	killed := func(executor *fakeExecutor) []string {
+		var names []string
+		for name, calls := range executor.calls {
+			for _, command := range calls {
+				if command[len(command)-1] == TiKV.StopCmd() {
+					names = append(names, name)
+				}
+			}
+		}
+		return names
+	}
+
+	testCases := []struct {
+		components string
+		pods       []string
+		expect     []string
+		hasErr     bool
+	}{
+		{"", nil, []string{"pd-0", "tidb-0", "tikv-0", "tikv-1"}, false},
+		{"tikv", nil, []string{"tikv-0", "tikv-1"}, false},
+		{"tikv,pd", nil, []string{"pd-0", "tikv-0", "tikv-1"}, false},
+		{"", []string{"tikv-1"}, []string{"tikv-1"}, false},
+		{"tikv", []string{"default/tikv-1"}, []string{"tikv-1"}, false},
+		// the pod is not of the component.
+		{"pd", []string{"tikv-1"}, nil, true},
+		{"", []string{"tikv-2"}, nil, true},
+	}
+	for _, ca := range testCases {
+		filter, err := ParsePodFilter(ca.components, ca.pods)
+		assert.NoError(t, err)
+		co, client, executor := newOperator()
+		err = co.StopPods(filter)
+		assert.Equal(t, ca.hasErr, err != nil, ca)
+		assert.ElementsMatch(t, ca.expect, annotated(client), ca)
+		assert.ElementsMatch(t, ca.expect, killed(executor), ca)
+	}
+
+	// only the targeted pods in debug mode are started, the others are left stopped.
+	co, client, _ := newOperator()
+	assert.NoError(t, co.Stop())
+	assert.NoError(t, co.StartPods(PodFilter{Components: []component{TiKV}, Pods: []string{"tikv-0"}}))
+	assert.ElementsMatch(t, []string{"pd-0", "tidb-0", "tikv-1"}, annotated(client))
+	_, err := client.CoreV1().Pods(metav1.NamespaceDefault).Get(context.Background(), "tikv-0", metav1.GetOptions{})
+	assert.Error(t, err)
+	assert.NoError(t, co.StartPods(PodFilter{Components: []component{PD, TiDB}}))
+	assert.ElementsMatch(t, []string{"tikv-1"}, annotated(client))
+
+	_, err = ParsePodFilter("tiflash", nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,132 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// timestampedRegexp matches the timestamped backup version, e.g. 5.2-1638316800, the suffix is the unix time in seconds.
+var timestampedRegexp = regexp.MustCompile(`^(.+)-([0-9]{10})$`)
+
+// TimestampedVersion returns the version with the unix time of t, e.g. 5.2-1638316800,
+// so the backups of the same version taken at different times don't overwrite each other.
+func TimestampedVersion(version string, t time.Time) string {
+	return fmt.Sprintf("%s-%d", version, t.Unix())
+}
+
+// splitTimestamp splits the timestamped version into the version and the unix time, ok is false if it's not timestamped.
+func splitTimestamp(version string) (string, int64, bool) {
+	matches := timestampedRegexp.FindStringSubmatch(version)
+	if matches == nil {
+		return version, 0, false
+	}
+	unix, err := strconv.ParseInt(matches[2], 10, 64)
+	if err != nil {
+		return version, 0, false
+	}
+	return matches[1], unix, true
+}
+
+// matchVersion returns true if the backup is the version, or a timestamped backup of the version.
+func matchVersion(backup, version string) bool {
+	if backup == version {
+		return true
+	}
+	base, _, ok := splitTimestamp(backup)
+	return ok && base == version
+}
+
+// ParseAt parses the time which selects the timestamped backup, it is the unix time in seconds or RFC3339,
+// e.g. 1638316800 or 2021-12-01T00:00:00Z.
+func ParseAt(s string) (time.Time, error) {
+	if unix, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, it should be the unix time or RFC3339, e.g. 2021-12-01T00:00:00Z", s)
+	}
+	return t, nil
+}
+
+// selectVersion returns the timestamped backup of the version to restore among the backups, it is the latest one,
+// or the latest one taken at or before at if it's not zero. The timestamped version is returned as is.
+// It returns the version itself if there is no timestamped backup of it and at is zero.
+func selectVersion(backups []string, version string, at time.Time) (string, error) {
+	if _, _, ok := splitTimestamp(version); ok {
+		return version, nil
+	}
+	selected, latest := "", int64(-1)
+	for _, backup := range backups {
+		base, unix, ok := splitTimestamp(backup)
+		if !ok || base != version || (!at.IsZero() && unix > at.Unix()) {
+			continue
+		}
+		if unix > latest {
+			selected, latest = backup, unix
+		}
+	}
+	if len(selected) > 0 {
+		return selected, nil
+	}
+	if !at.IsZero() {
+		return "", fmt.Errorf("no backup of version %s taken at or before %s", version, at.UTC().Format(time.RFC3339))
+	}
+	return version, nil
+}
+
+// validateTimestamped checks Timestamped only works with the backups in the pods, restore can't list the uploaded
+// objects or the artifacts to select the timestamped one, so it would look for the plain version instead.
+func (c *CloudOperator) validateTimestamped() error {
+	if c.Timestamped && (c.Upload != nil || c.Download != nil) {
+		return errors.New("--timestamped only works with the backups in the pods, it can't be used with --upload, --download or --from")
+	}
+	return nil
+}
+
+// backupVersion returns the version to back up, it is timestamped by the current time if Timestamped is set.
+// The timestamped version is returned as is, so the workflows can name the backup once before calling Back.
+func (c *CloudOperator) backupVersion(version string) string {
+	if !c.Timestamped {
+		return version
+	}
+	if _, _, ok := splitTimestamp(version); ok {
+		return version
+	}
+	return TimestampedVersion(version, c.now())
+}
+
+// restoreVersion returns the backup of the version to restore in the pods of the component, see selectVersion.
+// The backups of all the pods are candidates, the pods without the selected one are handled as missing the version.
+func (c *CloudOperator) restoreVersion(cp component, pods []corev1.Pod, version string) (string, error) {
+	if _, _, ok := splitTimestamp(version); ok {
+		return version, nil
+	}
+	var backups []string
+	for i := range pods {
+		versions, err := c.listVersions(&pods[i], cp)
+		if err != nil {
+			return "", err
+		}
+		backups = append(backups, versions...)
+	}
+	return selectVersion(backups, version, c.At)
+}
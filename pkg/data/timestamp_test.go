@@ -0,0 +1,246 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestTimestampedVersion(t *testing.T) {
+	version := TimestampedVersion("5.2", time.Unix(1638316800, 0))
+	assert.Equal(t, "5.2-1638316800", version)
+	assert.NoError(t, ValidateVersion(version))
+
+	testCases := []struct {
+		version string
+		base    string
+		unix    int64
+		ok      bool
+	}{
+		{version: "5.2-1638316800", base: "5.2", unix: 1638316800, ok: true},
+		{version: "5.2-rc.1-1638316800", base: "5.2-rc.1", unix: 1638316800, ok: true},
+		{version: "5.2", base: "5.2"},
+		{version: "5.2-rc.1", base: "5.2-rc.1"},
+		// the suffix which is not a unix time in seconds is a part of the version.
+		{version: "5.2-20211201", base: "5.2-20211201"},
+	}
+	for _, ca := range testCases {
+		base, unix, ok := splitTimestamp(ca.version)
+		assert.Equal(t, ca.base, base, ca.version)
+		assert.Equal(t, ca.unix, unix, ca.version)
+		assert.Equal(t, ca.ok, ok, ca.version)
+	}
+
+	assert.True(t, matchVersion("5.2", "5.2"))
+	assert.True(t, matchVersion("5.2-1638316800", "5.2"))
+	assert.True(t, matchVersion("5.2-1638316800", "5.2-1638316800"))
+	assert.False(t, matchVersion("5.2.1-1638316800", "5.2"))
+	assert.False(t, matchVersion("5.2", "5.2-1638316800"))
+}
+
+func TestParseAt(t *testing.T) {
+	at, err := ParseAt("1638316800")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1638316800), at.Unix())
+	at, err = ParseAt("2021-12-01T08:00:00+08:00")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1638316800), at.Unix())
+	_, err = ParseAt("yesterday")
+	assert.Error(t, err)
+}
+
+func TestSelectVersion(t *testing.T) {
+	backups := []string{"5.1-1638316800", "5.2", "5.2-1638316800", "5.2-1638403200", "5.2-1638320400"}
+	testCases := []struct {
+		version string
+		at      int64
+		expect  string
+		hasErr  bool
+	}{
+		// the latest timestamped backup of the version.
+		{version: "5.2", expect: "5.2-1638403200"},
+		{version: "5.1", expect: "5.1-1638316800"},
+		// the latest one taken at or before the time.
+		{version: "5.2", at: 1638320400, expect: "5.2-1638320400"},
+		{version: "5.2", at: 1638400000, expect: "5.2-1638320400"},
+		{version: "5.2", at: 1638316799, hasErr: true},
+		// the plain backup is restored if there is no timestamped one.
+		{version: "5.3", expect: "5.3"},
+		{version: "5.3", at: 1638316800, hasErr: true},
+		// the timestamped version is restored as is.
+		{version: "5.2-1638316800", expect: "5.2-1638316800"},
+	}
+	for _, ca := range testCases {
+		var at time.Time
+		if ca.at > 0 {
+			at = time.Unix(ca.at, 0)
+		}
+		version, err := selectVersion(backups, ca.version, at)
+		if ca.hasErr {
+			assert.Error(t, err, ca)
+			continue
+		}
+		assert.NoError(t, err, ca)
+		assert.Equal(t, ca.expect, version, ca)
+	}
+}
+
+func TestSortTimestampedVersions(t *testing.T) {
+	versions := []string{"5.2-1638316800", "5.1", "5.2", "5.2-1638403200", "5.1-1638316800", "latest"}
+	assert.Equal(t, []string{"5.2-1638403200", "5.2-1638316800", "5.2", "5.1-1638316800", "5.1"}, sortVersions(versions))
+	assert.Equal(t, []string{"5.2", "5.1-1638316800", "5.1"}, versionsExcept(versions, 2))
+	assert.True(t, isRunningVersion("5.2-1638316800", "v5.2.1"))
+	assert.False(t, isRunningVersion("5.1-1638316800", "v5.2.1"))
+}
+
+// newTimestampedExecutor lists the backups in every pod, the other commands succeed.
+func newTimestampedExecutor(backups string) *fakeExecutor {
+	return newFakeExecutor(func(podName string, command []string) (string, error) {
+		cmd := command[len(command)-1]
+		switch {
+		case strings.HasPrefix(cmd, "ls "):
+			return backups, nil
+		case strings.Contains(cmd, "ps -ef"):
+			return "UID\r\n1\r\n", nil
+		case strings.HasPrefix(cmd, "restored="):
+			return "3 3\r\n", nil
+		case strings.HasPrefix(cmd, "df"):
+			return "Filesystem 1024-blocks Used Available Capacity Mounted on\r\n/dev/sda1 1000 400 600 40% /var/lib\r\n", nil
+		}
+		return "", nil
+	})
+}
+
+// execsContaining returns the commands of the pod which contain the substring.
+func execsContaining(executor *fakeExecutor, pod, substr string) []string {
+	var rst []string
+	for _, call := range executor.calls[pod] {
+		if strings.Contains(call[len(call)-1], substr) {
+			rst = append(rst, call[len(call)-1])
+		}
+	}
+	return rst
+}
+
+func TestBackTimestamped(t *testing.T) {
+	client := fake.NewSimpleClientset(newTestPod("tikv-0", TiKV, corev1.PodRunning))
+	executor := newTimestampedExecutor("")
+	co := newTestCloudOperator(context.Background(), client, executor)
+	co.Components = []component{TiKV}
+	co.RetryCount = 1
+	co.Timestamped = true
+	co.now = func() time.Time {
+		return time.Unix(1638316800, 0)
+	}
+
+	_, err := co.Back("5.2")
+	assert.NoError(t, err)
+	assert.Len(t, execsContaining(executor, "tikv-0", "sh /var/lib/tikv/back_5.2-1638316800.sh"), 1)
+	// the timestamped version is backed up as is, e.g. it's resumed.
+	co.now = func() time.Time {
+		return time.Unix(1638403200, 0)
+	}
+	_, err = co.Back("5.2-1638316800")
+	assert.NoError(t, err)
+	assert.Len(t, execsContaining(executor, "tikv-0", "sh /var/lib/tikv/back_5.2-1638316800.sh"), 2)
+	assert.Empty(t, execsContaining(executor, "tikv-0", "5.2-1638403200"))
+
+	// the interrupted timestamped backup can't be resumed by the plain version.
+	co.Resume = true
+	_, err = co.Back("5.2")
+	assert.Error(t, err)
+}
+
+func TestRestoreTimestamped(t *testing.T) {
+	backups := "5.2.bat\r\n5.2-1638316800.bat\r\n5.2-1638403200.bat\r\n"
+	newOperator := func() (*CloudOperator, *fakeExecutor) {
+		client := fake.NewSimpleClientset(newTestPod("tikv-0", TiKV, corev1.PodRunning))
+		executor := newTimestampedExecutor(backups)
+		co := newTestCloudOperator(context.Background(), client, executor)
+		co.Components = []component{TiKV}
+		co.RetryCount = 1
+		co.Timestamped = true
+		return co, executor
+	}
+	restoreCmd := TiKV.RestoreExecCmd("/var/lib/tikv", "5.2-1638403200")
+
+	// the latest timestamped backup is restored.
+	co, executor := newOperator()
+	_, err := co.Restore("5.2")
+	assert.NoError(t, err)
+	assert.Contains(t, execsContaining(executor, "tikv-0", "/bin/cp"), restoreCmd)
+	// the restored files are checked against the selected backup.
+	assert.Len(t, execsContaining(executor, "tikv-0", "find /var/lib/tikv/5.2-1638403200.bat"), 1)
+
+	// the latest one taken at or before At is restored.
+	co, executor = newOperator()
+	co.At = time.Unix(1638400000, 0)
+	_, err = co.Restore("5.2")
+	assert.NoError(t, err)
+	assert.Contains(t, execsContaining(executor, "tikv-0", "/bin/cp"), TiKV.RestoreExecCmd("/var/lib/tikv", "5.2-1638316800"))
+
+	co, _ = newOperator()
+	co.At = time.Unix(1638300000, 0)
+	_, err = co.Restore("5.2")
+	assert.Error(t, err)
+
+	// the plain backup is restored without Timestamped.
+	co, executor = newOperator()
+	co.Timestamped = false
+	_, err = co.Restore("5.2")
+	assert.NoError(t, err)
+	assert.Contains(t, execsContaining(executor, "tikv-0", "/bin/cp"), TiKV.RestoreExecCmd("/var/lib/tikv", "5.2"))
+}
+
+func TestValidateTimestamped(t *testing.T) {
+	co := newTestCloudOperator(context.Background(), fake.NewSimpleClientset(), nil)
+	co.Timestamped = true
+	assert.NoError(t, co.backend().Validate(co))
+	// the backups out of the pods can't be selected by the timestamp.
+	co.Upload = &fakeUploader{}
+	_, err := co.Back("5.2")
+	assert.EqualError(t, err, "--timestamped only works with the backups in the pods, it can't be used with --upload, --download or --from")
+	co.Upload = nil
+	co.Download = &fakeDownloader{}
+	_, err = co.RestoreWorkflow(context.Background(), "5.2")
+	assert.EqualError(t, err, "--timestamped only works with the backups in the pods, it can't be used with --upload, --download or --from")
+	co.Download = nil
+	co.Backend, _ = newFakeSnapshotBackend(nil)
+	_, err = co.Restore("5.2")
+	assert.EqualError(t, err, "--timestamped can't be used with --snapshot")
+}
+
+func TestPruneTimestamped(t *testing.T) {
+	client := fake.NewSimpleClientset(newTestPod("tikv-0", TiKV, corev1.PodRunning))
+	executor := newTimestampedExecutor("5.1.bat\r\n5.2.bat\r\n5.2-1638316800.bat\r\n5.2-1638403200.tar.gz\r\n")
+	co := newTestCloudOperator(context.Background(), client, executor)
+	co.Components = []component{TiKV}
+	co.RetryCount = 1
+
+	// the plain version removes its timestamped backups too.
+	assert.NoError(t, co.Prune("5.2"))
+	removed := execsContaining(executor, "tikv-0", "rm ")
+	assert.Len(t, removed, 1)
+	for _, version := range []string{"5.2.bat", "5.2-1638316800.bat", "5.2-1638403200"} {
+		assert.Contains(t, removed[0], version)
+	}
+	assert.NotContains(t, removed[0], "5.1.bat")
+}
@@ -0,0 +1,140 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// baseTools are the tools which the commands run in the containers besides sh.
+// The process check and the checksum only need sh builtins, so awk is not needed.
+var baseTools = []string{"ls", "grep", "cp", "mv", "rm", "mkdir", "xargs", "ps", "kill", "df", "du", "cut", "date"}
+
+// ToolCheck is the result of checking the tools in the container of a pod.
+type ToolCheck struct {
+	Pod       string   `json:"pod"`
+	Component string   `json:"component"`
+	Missing   []string `json:"missing"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// ToolsExecCmd prints the tools which are not found, one per line. It only uses sh builtins.
+func (c component) ToolsExecCmd(tools []string) string {
+	return fmt.Sprintf("for t in %s; do command -v $t >/dev/null 2>&1 || echo $t; done", strings.Join(tools, " "))
+}
+
+// parseMissingTools parses the output of ToolsExecCmd.
+func parseMissingTools(output string) []string {
+	var missing []string
+	for _, line := range splitLines(output) {
+		if tool := strings.TrimSpace(line); len(tool) > 0 {
+			missing = append(missing, tool)
+		}
+	}
+	return missing
+}
+
+// requiredTools returns the tools which the operation needs with the options of the operator.
+func (c *CloudOperator) requiredTools(operation string) []string {
+	tools := append([]string(nil), baseTools...)
+	// the backups are uploaded and downloaded as tarballs.
+	if c.Compress || (operation != "restore" && c.Upload != nil) || (operation != "back" && c.Download != nil) {
+		tools = append(tools, "tar")
+	}
+	if c.Incremental && operation != "restore" {
+		tools = append(tools, "rsync")
+	}
+	return tools
+}
+
+// CheckTools checks the tools which the operation needs exist in the containers of the running pods, the results are sorted by pod.
+// The operation is back or restore, the tools of both are checked if it's empty.
+func (c *CloudOperator) CheckTools(operation string) ([]ToolCheck, error) {
+	var pods []corev1.Pod
+	var components []component
+	found := make(map[string]bool, len(c.Pods))
+	for _, cp := range c.Components {
+		list, err := c.discoverPods(cp)
+		if err != nil {
+			return nil, err
+		}
+		for _, pod := range c.selectPods(list.Items, found) {
+			if pod.Status.Phase == corev1.PodRunning {
+				pods = append(pods, pod)
+				components = append(components, cp)
+			}
+		}
+	}
+	tools := c.requiredTools(operation)
+	results := make([]ToolCheck, len(pods))
+	tasks := make([]func(), 0, len(pods))
+	for i := range pods {
+		i := i
+		results[i] = ToolCheck{Pod: c.podKey(&pods[i]), Component: components[i].String()}
+		tasks = append(tasks, func() {
+			missing, err := c.missingTools(&pods[i], components[i], tools)
+			if err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+			results[i].Missing = missing
+		})
+	}
+	parallel(c.Parallel, tasks)
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Pod < results[j].Pod
+	})
+	return results, nil
+}
+
+// missingTools returns the tools which are not found in the container of the pod.
+// The missing sh is reported as a missing tool instead of an error.
+func (c *CloudOperator) missingTools(pod *corev1.Pod, cp component, tools []string) ([]string, error) {
+	container, err := c.container(pod, cp)
+	if err != nil {
+		return nil, err
+	}
+	output, err := c.exec(c.podKey(pod), container, []string{"sh", "-c", cp.ToolsExecCmd(tools)})
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) && (exitErr.Code == exitCodeNotExecutable || exitErr.Code == exitCodeNotFound) {
+		return []string{"sh"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseMissingTools(output), nil
+}
+
+// checkTools fails if any tool which the operation needs is missing in any pod.
+func (c *CloudOperator) checkTools(operation string) error {
+	results, err := c.CheckTools(operation)
+	if err != nil {
+		return err
+	}
+	errs := newPodErrors()
+	for _, rst := range results {
+		switch {
+		case len(rst.Error) > 0:
+			errs.add(rst.Pod, fmt.Errorf("check tools failed: %s", rst.Error))
+		case len(rst.Missing) > 0:
+			errs.add(rst.Pod, fmt.Errorf("missing tools in container: %s", strings.Join(rst.Missing, ", ")))
+		}
+	}
+	return errs.err()
+}
@@ -0,0 +1,116 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseMissingTools(t *testing.T) {
+	testCases := []struct {
+		output  string
+		missing []string
+	}{
+		{"", nil},
+		{"\r\n", nil},
+		{"grep\r\n", []string{"grep"}},
+		{"grep\r\nps\r\n", []string{"grep", "ps"}},
+		{"rsync\n tar \n", []string{"rsync", "tar"}},
+	}
+	for _, ca := range testCases {
+		assert.Equal(t, ca.missing, parseMissingTools(ca.output))
+	}
+}
+
+func TestToolsExecCmd(t *testing.T) {
+	cmd := TiKV.ToolsExecCmd([]string{"ls", "tinker-missing-tool", "kill"})
+	assert.Equal(t, "for t in ls tinker-missing-tool kill; do command -v $t >/dev/null 2>&1 || echo $t; done", cmd)
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	output, err := exec.Command("sh", "-c", cmd).Output()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tinker-missing-tool"}, parseMissingTools(string(output)))
+}
+
+func TestRequiredTools(t *testing.T) {
+	co := newTestCloudOperator(context.Background(), nil, nil)
+	assert.Equal(t, baseTools, co.requiredTools("back"))
+	assert.NotContains(t, baseTools, "awk")
+
+	co.Incremental = true
+	assert.Equal(t, append(append([]string(nil), baseTools...), "rsync"), co.requiredTools("back"))
+	assert.Equal(t, baseTools, co.requiredTools("restore"))
+
+	co.Incremental = false
+	co.Compress = true
+	assert.Equal(t, append(append([]string(nil), baseTools...), "tar"), co.requiredTools("restore"))
+}
+
+func TestCheckTools(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		newTestPod("tikv-0", TiKV, corev1.PodRunning),
+		newTestPod("tikv-1", TiKV, corev1.PodRunning),
+		newTestPod("tikv-2", TiKV, corev1.PodPending),
+		newTestPod("pd-0", PD, corev1.PodRunning),
+		newTestPod("pd-1", PD, corev1.PodRunning),
+	)
+	executor := newFakeExecutor(func(podName string, command []string) (string, error) {
+		switch podName {
+		case "tikv-1":
+			// the distroless image has no grep and ps.
+			return "grep\r\nps\r\n", nil
+		case "pd-0":
+			// sh is not found.
+			return "", &ExitError{Code: exitCodeNotFound}
+		case "pd-1":
+			return "", errors.New("connection refused")
+		}
+		return "", nil
+	})
+	co := newTestCloudOperator(context.Background(), client, executor)
+	co.RetryCount = 1
+	results, err := co.CheckTools("back")
+	assert.NoError(t, err)
+	assert.Equal(t, []ToolCheck{
+		{Pod: "pd-0", Component: "pd", Missing: []string{"sh"}},
+		{Pod: "pd-1", Component: "pd", Error: "exec in pod pd-1 failed: connection refused"},
+		{Pod: "tikv-0", Component: "tikv"},
+		{Pod: "tikv-1", Component: "tikv", Missing: []string{"grep", "ps"}},
+	}, results)
+	assert.Equal(t, []string{"sh", "-c", TiKV.ToolsExecCmd(baseTools)}, executor.calls["tikv-0"][0])
+	assert.EqualError(t, co.checkTools("back"), "3 pods failed: pd-0: missing tools in container: sh; "+
+		"pd-1: check tools failed: exec in pod pd-1 failed: connection refused; tikv-1: missing tools in container: grep, ps")
+
+	// the workflow fails before stopping any pod.
+	client.PrependReactor("create", "selfsubjectaccessreviews", reviewReactor(nil))
+	executor.calls = make(map[string][][]string)
+	co.SkipPreflight = false
+	_, err = co.BackupWorkflow(context.Background(), "5.2")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "tikv-1: missing tools in container: grep, ps")
+	for _, calls := range executor.calls {
+		for _, command := range calls {
+			assert.True(t, strings.HasPrefix(command[2], "for t in"), command[2])
+		}
+	}
+}
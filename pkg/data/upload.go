@@ -0,0 +1,173 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Downloader generates the commands to download the backups from the object storage to the pod.
+// The commands run in the pod, so the pod should have the client of the object storage.
+type Downloader interface {
+	// Key returns the object key of the backup of the version in the pod.
+	Key(pod *corev1.Pod, version string) string
+	// DownloadCmd downloads the object to the local file.
+	DownloadCmd(key, local string) string
+}
+
+// Uploader generates the commands to transfer the backups between the pod and the object storage.
+type Uploader interface {
+	Downloader
+	// URL returns the url of the object, e.g. s3://bucket/prefix/key.
+	URL(key string) string
+	// UploadCmd uploads the local file to the object, "-" means stdin.
+	UploadCmd(local, key string) string
+}
+
+// ParseUploader parses the url of the object storage, e.g. s3://bucket/prefix.
+func ParseUploader(rawURL string) (Uploader, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if len(u.Host) == 0 {
+		return nil, fmt.Errorf("bucket is missing in %s", rawURL)
+	}
+	switch u.Scheme {
+	case "s3":
+		uploader := &S3Uploader{
+			Bucket:   u.Host,
+			Prefix:   strings.Trim(u.Path, "/"),
+			Endpoint: u.Query().Get("endpoint"),
+		}
+		// the object storage url is spliced into the commands in pods.
+		for kind, value := range map[string]string{"bucket": uploader.Bucket, "prefix": uploader.Prefix, "endpoint": uploader.Endpoint} {
+			if err := validateShellSafe(kind, value); err != nil {
+				return nil, err
+			}
+		}
+		return uploader, nil
+	}
+	return nil, fmt.Errorf("unsupported object storage: %s, only s3 is supported", rawURL)
+}
+
+// Placeholders of the artifact url, they are replaced by the pod and the version to restore.
+const (
+	NamespacePlaceholder = "<namespace>"
+	PodPlaceholder       = "<pod>"
+	VersionPlaceholder   = "<version>"
+)
+
+// ParseArtifact parses the url of the backup artifacts in the object storage,
+// e.g. s3://bucket/prefix/<pod>/<version>.tar.gz, the placeholders are replaced by every pod.
+// The artifact is a compressed backup, so it should end with ArchiveSuffix.
+func ParseArtifact(rawURL string) (Downloader, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(u.Path, ArchiveSuffix) {
+		return nil, fmt.Errorf("artifact %s should be a %s file", rawURL, ArchiveSuffix)
+	}
+	template := strings.TrimPrefix(u.Path, "/")
+	// the artifact is downloaded by the uploader of its bucket.
+	u.Path = ""
+	uploader, err := ParseUploader(u.String())
+	if err != nil {
+		return nil, err
+	}
+	s3, ok := uploader.(*S3Uploader)
+	if !ok {
+		return nil, fmt.Errorf("unsupported object storage: %s", rawURL)
+	}
+	replaced := strings.NewReplacer(NamespacePlaceholder, "", PodPlaceholder, "", VersionPlaceholder, "").Replace(template)
+	if err := validateShellSafe("artifact", replaced); err != nil {
+		return nil, err
+	}
+	return &S3Artifact{S3Uploader: *s3, Template: template}, nil
+}
+
+// S3Artifact downloads the backups whose keys are generated by the template, e.g. prefix/<pod>/<version>.tar.gz.
+type S3Artifact struct {
+	S3Uploader
+	Template string
+}
+
+// Key implements Downloader interface.
+func (s *S3Artifact) Key(pod *corev1.Pod, version string) string {
+	return strings.NewReplacer(NamespacePlaceholder, pod.Namespace, PodPlaceholder, pod.Name, VersionPlaceholder, version).Replace(s.Template)
+}
+
+// S3Uploader transfers the backups by the aws cli, it supports the S3-compatible storage by Endpoint.
+type S3Uploader struct {
+	Bucket   string
+	Prefix   string
+	Endpoint string
+}
+
+// URL implements Uploader interface.
+func (s *S3Uploader) URL(key string) string {
+	return fmt.Sprintf("s3://%s/%s", s.Bucket, path.Join(s.Prefix, key))
+}
+
+// UploadCmd implements Uploader interface.
+func (s *S3Uploader) UploadCmd(local, key string) string {
+	return s.cp(local, s.URL(key))
+}
+
+// Key implements Downloader interface.
+func (s *S3Uploader) Key(pod *corev1.Pod, version string) string {
+	return uploadKey(pod, version)
+}
+
+// DownloadCmd implements Downloader interface.
+func (s *S3Uploader) DownloadCmd(key, local string) string {
+	return s.cp(s.URL(key), local)
+}
+
+func (s *S3Uploader) cp(src, dst string) string {
+	if len(s.Endpoint) > 0 {
+		return fmt.Sprintf("aws s3 cp --endpoint-url %s %s %s", s.Endpoint, src, dst)
+	}
+	return fmt.Sprintf("aws s3 cp %s %s", src, dst)
+}
+
+// uploadKey returns the object key of the backup in the pod, e.g. tidb/tikv-0/5.2.tar.gz.
+func uploadKey(pod *corev1.Pod, version string) string {
+	return path.Join(pod.Namespace, pod.Name, version+ArchiveSuffix)
+}
+
+// UploadExecCmd uploads the backup of the version as a compressed tarball.
+// The tarball has the same layout as the compressed backup. It runs in a subshell which fails if the backup
+// directory is missing or tar fails, not only if the upload fails. The shell may not support pipefail,
+// so tar leaves a marker file on failure, the marker contains "bat" so it's never backed up or restored.
+func (c component) UploadExecCmd(dir, version string, compress bool, uploader Uploader, key string) string {
+	if compress {
+		return uploader.UploadCmd(backupArchive(dir, version), key)
+	}
+	bakDir := backupDir(dir, version)
+	marker := bakDir + ".failed"
+	return fmt.Sprintf("(cd %s && { tar czf - . || touch %s; } | %s && test ! -e %s;s=$?;rm -f %s;exit $s)",
+		bakDir, marker, uploader.UploadCmd("-", key), marker, marker)
+}
+
+// DownloadExecCmd downloads the backup of the version as the compressed backup.
+func (c component) DownloadExecCmd(dir, version string, downloader Downloader, key string) string {
+	return downloader.DownloadCmd(key, backupArchive(dir, version))
+}
@@ -0,0 +1,174 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeUploader generates the commands which only echo the objects.
+type fakeUploader struct{}
+
+func (*fakeUploader) Key(pod *corev1.Pod, version string) string {
+	return uploadKey(pod, version)
+}
+
+func (*fakeUploader) URL(key string) string {
+	return "fake://" + key
+}
+
+func (*fakeUploader) UploadCmd(local, key string) string {
+	return fmt.Sprintf("upload %s %s", local, key)
+}
+
+func (*fakeUploader) DownloadCmd(key, local string) string {
+	return fmt.Sprintf("download %s %s", key, local)
+}
+
+// fakeDownloader generates the commands which only echo the artifacts, they are keyed by the pod name.
+type fakeDownloader struct{}
+
+func (*fakeDownloader) Key(pod *corev1.Pod, version string) string {
+	return fmt.Sprintf("artifacts/%s/%s.tar.gz", pod.Name, version)
+}
+
+func (*fakeDownloader) DownloadCmd(key, local string) string {
+	return fmt.Sprintf("fetch %s %s", key, local)
+}
+
+func TestParseUploader(t *testing.T) {
+	testCases := []struct {
+		url    string
+		expect Uploader
+		hasErr bool
+	}{
+		{
+			url:    "s3://bucket/prefix/",
+			expect: &S3Uploader{Bucket: "bucket", Prefix: "prefix"},
+		},
+		{
+			url:    "s3://bucket?endpoint=http://minio:9000",
+			expect: &S3Uploader{Bucket: "bucket", Endpoint: "http://minio:9000"},
+		},
+		{
+			url:    "gcs://bucket/prefix",
+			hasErr: true,
+		},
+		{
+			url:    "s3:///prefix",
+			hasErr: true,
+		},
+		{
+			url:    "s3://bucket/prefix;reboot",
+			hasErr: true,
+		},
+		{
+			url:    "s3://bucket?endpoint=http://minio:9000$(reboot)",
+			hasErr: true,
+		},
+	}
+	for _, ca := range testCases {
+		uploader, err := ParseUploader(ca.url)
+		if ca.hasErr {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, ca.expect, uploader)
+	}
+
+	s3 := &S3Uploader{Bucket: "bucket", Prefix: "prefix", Endpoint: "http://minio:9000"}
+	assert.Equal(t, "aws s3 cp --endpoint-url http://minio:9000 - s3://bucket/prefix/tidb/tikv-0/5.2.tar.gz", s3.UploadCmd("-", "tidb/tikv-0/5.2.tar.gz"))
+	assert.Equal(t, "aws s3 cp s3://bucket/tidb/tikv-0/5.2.tar.gz /var/lib/tikv/5.2.tar.gz", (&S3Uploader{Bucket: "bucket"}).DownloadCmd("tidb/tikv-0/5.2.tar.gz", "/var/lib/tikv/5.2.tar.gz"))
+}
+
+func TestUploadExecCmd(t *testing.T) {
+	uploader := &fakeUploader{}
+	dir := TiKV.BataDir(nil)
+	assert.Equal(t, "(cd /var/lib/tikv/5.2.bat && { tar czf - . || touch /var/lib/tikv/5.2.bat.failed; } | upload - tidb/tikv-0/5.2.tar.gz && test ! -e /var/lib/tikv/5.2.bat.failed;s=$?;rm -f /var/lib/tikv/5.2.bat.failed;exit $s)", TiKV.UploadExecCmd(dir, "5.2", false, uploader, "tidb/tikv-0/5.2.tar.gz"))
+	assert.Equal(t, "upload /var/lib/tikv/5.2.tar.gz tidb/tikv-0/5.2.tar.gz", TiKV.UploadExecCmd(dir, "5.2", true, uploader, "tidb/tikv-0/5.2.tar.gz"))
+	assert.Equal(t, "download tidb/tikv-0/5.2.tar.gz /var/lib/tikv/5.2.tar.gz", TiKV.DownloadExecCmd(dir, "5.2", uploader, "tidb/tikv-0/5.2.tar.gz"))
+}
+
+func TestBackUploadAndRestoreDownload(t *testing.T) {
+	client := fake.NewSimpleClientset(newTestPod("tikv-0", TiKV, corev1.PodRunning))
+	co := newTestCloudOperator(context.Background(), client, nil)
+	co.Components = []component{TiKV}
+	co.DryRun = true
+	co.Upload = &fakeUploader{}
+	co.Download = &fakeUploader{}
+	out := new(bytes.Buffer)
+	co.Out = out
+	dir := TiKV.BataDir(nil)
+
+	_, err := co.Back("5.2")
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("[dry-run] exec in pod tikv-0 container tikv: sh -c %s && (cd /var/lib/tikv/5.2.bat && { tar czf - . || touch /var/lib/tikv/5.2.bat.failed; } | upload - default/tikv-0/5.2.tar.gz && test ! -e /var/lib/tikv/5.2.bat.failed;s=$?;rm -f /var/lib/tikv/5.2.bat.failed;exit $s)\n",
+		backWithMetadataCmd(TiKV, dir, "5.2")), out.String())
+
+	out.Reset()
+	_, err = co.Restore("5.2")
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("[dry-run] exec in pod tikv-0 container tikv: sh -c download default/tikv-0/5.2.tar.gz /var/lib/tikv/5.2.tar.gz && { %s; }\n",
+		TiKV.CompressedRestoreExecCmd(dir, "5.2")), out.String())
+}
+
+func TestParseArtifact(t *testing.T) {
+	downloader, err := ParseArtifact("s3://bucket/prefix/<namespace>/<pod>/<version>.tar.gz?endpoint=http://minio:9000")
+	assert.NoError(t, err)
+	assert.Equal(t, &S3Artifact{S3Uploader: S3Uploader{Bucket: "bucket", Endpoint: "http://minio:9000"}, Template: "prefix/<namespace>/<pod>/<version>.tar.gz"}, downloader)
+	key := downloader.Key(newTestPod("tikv-0", TiKV, corev1.PodRunning), "5.2")
+	assert.Equal(t, "prefix/default/tikv-0/5.2.tar.gz", key)
+	assert.Equal(t, "aws s3 cp --endpoint-url http://minio:9000 s3://bucket/prefix/default/tikv-0/5.2.tar.gz /var/lib/tikv/5.2.tar.gz", downloader.DownloadCmd(key, "/var/lib/tikv/5.2.tar.gz"))
+
+	for _, rawURL := range []string{
+		"s3://bucket/prefix/<pod>/<version>",
+		"s3://bucket/prefix/<pod>/<version>.bat",
+		"s3:///prefix/<pod>/<version>.tar.gz",
+		"gcs://bucket/prefix/<pod>/<version>.tar.gz",
+		"s3://bucket/prefix/<pod>;reboot/<version>.tar.gz",
+		"s3://bucket/prefix/<host>/<version>.tar.gz",
+	} {
+		_, err := ParseArtifact(rawURL)
+		assert.Error(t, err, rawURL)
+	}
+}
+
+func TestRestoreFromArtifact(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		newTestPod("tikv-0", TiKV, corev1.PodRunning),
+		newTestPod("tikv-1", TiKV, corev1.PodRunning),
+	)
+	co := newTestCloudOperator(context.Background(), client, nil)
+	co.Components = []component{TiKV}
+	co.DryRun = true
+	co.Download = &fakeDownloader{}
+	out := new(bytes.Buffer)
+	co.Out = out
+	dir := TiKV.BataDir(nil)
+
+	// every pod downloads its own artifact, the backup directories needn't exist in the pods.
+	_, err := co.Restore("5.2")
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf(`[dry-run] exec in pod tikv-0 container tikv: sh -c fetch artifacts/tikv-0/5.2.tar.gz /var/lib/tikv/5.2.tar.gz && { %[1]s; }
+[dry-run] exec in pod tikv-1 container tikv: sh -c fetch artifacts/tikv-1/5.2.tar.gz /var/lib/tikv/5.2.tar.gz && { %[1]s; }
+`, TiKV.CompressedRestoreExecCmd(dir, "5.2")), out.String())
+}
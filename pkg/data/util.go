@@ -14,31 +14,82 @@
 package data
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"regexp"
+	"strings"
+	"sync"
 
 	v12 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
 )
 
-// exec
-func exec(podName, container, namespace string, command []string, config *rest.Config, stdout, stderr io.Writer) error {
-	k8sCli, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return err
+// shellSafeRegexp matches the values which can be spliced into the shell commands without quoting.
+var shellSafeRegexp = regexp.MustCompile(`^[A-Za-z0-9_./:=@%+,-]*$`)
+
+// validateShellSafe checks the user-provided value has no shell meta characters,
+// the commands in pods are run by sh -c.
+func validateShellSafe(kind, value string) error {
+	if !shellSafeRegexp.MatchString(value) {
+		return fmt.Errorf("%s %q contains characters which are not allowed in shell commands", kind, value)
 	}
+	return nil
+}
 
-	req := k8sCli.CoreV1().RESTClient().Post().Namespace(namespace).
+// executor execs the command in the container of the pod.
+// The stdin is streamed to the command if it's not nil.
+// It should return once the context is done.
+type executor interface {
+	exec(ctx context.Context, podName, container, namespace string, command []string, stdin io.Reader, stdout, stderr io.Writer) error
+}
+
+// ExitError is returned if the command in the pod exited with a non-zero code,
+// it is a failure even if the command wrote some output.
+type ExitError struct {
+	Code int
+}
+
+// Error implements error interface.
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("command exited with code %d", e.Code)
+}
+
+// exitError converts the exit error of the exec stream to ExitError, the other errors are returned as is.
+func exitError(err error) error {
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) && exitErr.Exited() {
+		return &ExitError{Code: exitErr.ExitStatus()}
+	}
+	return err
+}
+
+// remoteExecutor execs the command by the pods/exec sub resource.
+type remoteExecutor struct {
+	config *rest.Config
+	// client is the clientset of the config, it's shared by all the execs.
+	client kubernetes.Interface
+}
+
+// exec
+func (e *remoteExecutor) exec(ctx context.Context, podName, container, namespace string, command []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	config := e.config
+	req := e.client.CoreV1().RESTClient().Post().Namespace(namespace).
 		Name(podName).Resource("pods").SubResource("exec")
 	option := &v12.PodExecOptions{
 		Command:   command,
 		Container: container,
-		Stdin:     false,
+		Stdin:     stdin != nil,
 		Stdout:    true,
 		Stderr:    true,
-		TTY:       true,
+		// the terminal would echo the stdin to the stdout.
+		TTY: stdin == nil,
 	}
 
 	req.VersionedParams(
@@ -49,10 +100,85 @@ func exec(podName, container, namespace string, command []string, config *rest.C
 	if err != nil {
 		return err
 	}
-	err = exec.Stream(remotecommand.StreamOptions{
-		Stdin:  nil,
-		Stdout: stdout,
-		Stderr: stderr,
-	})
-	return err
+	// the stream doesn't support context, so it waits in another goroutine.
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- exec.Stream(remotecommand.StreamOptions{
+			Stdin:  stdin,
+			Stdout: stdout,
+			Stderr: stderr,
+		})
+	}()
+	select {
+	case err := <-errCh:
+		// the non-zero exit code of the command is reported by the stream as an error.
+		return exitError(err)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// splitLines splits the output of the command in the pod into lines, the lines end with \n or \r\n
+// depending on the tty and the shell of the image, so the trailing \r of every line is trimmed.
+func splitLines(output string) []string {
+	lines := strings.Split(output, "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSuffix(lines[i], "\r")
+	}
+	return lines
+}
+
+// lineWriter calls fn with every complete line written to it.
+type lineWriter struct {
+	buf []byte
+	fn  func(line string)
+}
+
+func newLineWriter(fn func(line string)) *lineWriter {
+	return &lineWriter{fn: fn}
+}
+
+// Write implements io.Writer interface.
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		idx := bytes.IndexByte(w.buf, '\n')
+		if idx < 0 {
+			break
+		}
+		w.fn(strings.TrimSuffix(string(w.buf[:idx]), "\r"))
+		w.buf = w.buf[idx+1:]
+	}
+	return len(p), nil
+}
+
+// Flush calls fn with the remaining incomplete line.
+func (w *lineWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.fn(strings.TrimSuffix(string(w.buf), "\r"))
+		w.buf = nil
+	}
+}
+
+// parallel runs the tasks with at most n goroutines, n <= 0 means no limit.
+func parallel(n int, tasks []func()) {
+	if n <= 0 || n > len(tasks) {
+		n = len(tasks)
+	}
+	ch := make(chan func())
+	wg := &sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range ch {
+				task()
+			}
+		}()
+	}
+	for _, task := range tasks {
+		ch <- task
+	}
+	close(ch)
+	wg.Wait()
 }
@@ -0,0 +1,97 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitLines(t *testing.T) {
+	testCases := []struct {
+		output string
+		expect []string
+	}{
+		{output: "5.1.bat\n5.2.bat\n", expect: []string{"5.1.bat", "5.2.bat", ""}},
+		{output: "5.1.bat\r\n5.2.bat\r\n", expect: []string{"5.1.bat", "5.2.bat", ""}},
+		{output: "5.1.bat\r\n5.2.bat\n5.3.bat", expect: []string{"5.1.bat", "5.2.bat", "5.3.bat"}},
+		// the \r inside the line is kept.
+		{output: "a\rb\r\n", expect: []string{"a\rb", ""}},
+		{output: "", expect: []string{""}},
+	}
+	for _, ca := range testCases {
+		assert.Equal(t, ca.expect, splitLines(ca.output), ca.output)
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	var lines []string
+	w := newLineWriter(func(line string) {
+		lines = append(lines, line)
+	})
+	for _, chunk := range []string{"'db/0001.sst' -> ", "'5.2.bat/db/0001.sst'\r\n'LOCK'", " -> '5.2.bat/LOCK'\r\n", "done"} {
+		_, err := io.WriteString(w, chunk)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []string{"'db/0001.sst' -> '5.2.bat/db/0001.sst'", "'LOCK' -> '5.2.bat/LOCK'"}, lines)
+	w.Flush()
+	assert.Equal(t, "done", lines[2])
+}
+
+// streamExecutor writes the output in several chunks.
+type streamExecutor struct {
+	chunks []string
+}
+
+func (e *streamExecutor) exec(_ context.Context, _, _, _ string, _ []string, _ io.Reader, stdout, _ io.Writer) error {
+	for _, chunk := range e.chunks {
+		if _, err := io.WriteString(stdout, chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestExecStream(t *testing.T) {
+	executor := &streamExecutor{chunks: []string{"a\r\n", "b", "\r\nc"}}
+	co := newTestCloudOperator(context.Background(), nil, executor)
+	co.Stream = true
+	// it still captures the whole output while streaming.
+	out, err := co.execStream("tikv-0", TiKV.String(), []string{"ls"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "a\r\nb\r\nc", out)
+
+	co.Stream = false
+	out, err = co.execStream("tikv-0", TiKV.String(), []string{"ls"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "a\r\nb\r\nc", out)
+}
+
+func TestParallel(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 10} {
+		var count int32
+		tasks := make([]func(), 5)
+		for i := range tasks {
+			tasks[i] = func() {
+				atomic.AddInt32(&count, 1)
+			}
+		}
+		parallel(n, tasks)
+		assert.Equal(t, int32(5), count)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// VerifyResult is the checksum result of one pod.
+type VerifyResult struct {
+	Pod       string `json:"pod"`
+	Component string `json:"component"`
+	Live      string `json:"live"`
+	Backup    string `json:"backup"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Match returns true if the live data matches the backup.
+func (r *VerifyResult) Match() bool {
+	return len(r.Error) == 0 && len(r.Live) > 0 && r.Live == r.Backup
+}
+
+// ChecksumExecCmd returns the checksum of all the files in the directory except the backup directories.
+// The files are sorted by their paths, so the checksum is stable.
+func (c component) ChecksumExecCmd(dir string) string {
+	return c.checksumExecCmd(dir, backupPattern)
+}
+
+// checksumExecCmd is the same as ChecksumExecCmd, but the files matching the pattern are excluded.
+func (c component) checksumExecCmd(dir, pattern string) string {
+	return fmt.Sprintf("cd %s;find `ls -A | grep -vE '%s'` -type f -exec md5sum {} + | sort -k 2 | md5sum | cut -d' ' -f1", dir, pattern)
+}
+
+// Verify compares the checksum of the live data and the backup version in every pod.
+// The results are sorted by pod name. In dry run mode the commands are printed and the results have no checksum.
+func (c *CloudOperator) Verify(version string) ([]VerifyResult, error) {
+	if err := ValidateVersion(version); err != nil {
+		return nil, err
+	}
+	var results []VerifyResult
+	// tasks[i] checksums the pod of results[i], the results are filled after all the pods are listed.
+	var tasks []func(rst *VerifyResult)
+	for _, cp := range c.Components {
+		pods, err := c.discoverPods(cp)
+		if err != nil {
+			return nil, err
+		}
+		dir := cp.BataDir(c.DataDirs)
+		liveCommands := []string{"sh", "-c", cp.checksumExecCmd(dir, c.basePattern())}
+		backupCommands := []string{"sh", "-c", cp.checksumExecCmd(backupDir(c.backupRoot(cp), version), c.basePattern())}
+		for i := range pods.Items {
+			pod, cp := &pods.Items[i], cp
+			results = append(results, VerifyResult{Pod: c.podKey(pod), Component: cp.String()})
+			tasks = append(tasks, func(rst *VerifyResult) {
+				container, err := c.container(pod, cp)
+				if err == nil {
+					var live string
+					live, err = c.exec(rst.Pod, container, liveCommands)
+					rst.Live = strings.TrimSpace(live)
+				}
+				if err == nil {
+					var backup string
+					backup, err = c.exec(rst.Pod, container, backupCommands)
+					rst.Backup = strings.TrimSpace(backup)
+				}
+				if err != nil {
+					log.Error("checksum failed", zap.String("pod-name", rst.Pod), zap.Error(err))
+					rst.Error = err.Error()
+				}
+			})
+		}
+	}
+	// every task writes its own result, so they needn't be locked.
+	run := make([]func(), 0, len(tasks))
+	for i := range tasks {
+		i := i
+		run = append(run, func() {
+			tasks[i](&results[i])
+		})
+	}
+	parallel(c.Parallel, run)
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Pod < results[j].Pod
+	})
+	return results, nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestChecksumExecCmd(t *testing.T) {
+	assert.Equal(t, "cd /var/lib/tikv;find `ls -A | grep -vE 'bat|tar.gz|restoring|rollback|space_placeholder_file|tinker-meta.json'` -type f -exec md5sum {} + | sort -k 2 | md5sum | cut -d' ' -f1", TiKV.ChecksumExecCmd("/var/lib/tikv"))
+	assert.Equal(t, "cd /var/lib/tikv/5.2.bat;find `ls -A | grep -vE 'bat|tar.gz|restoring|rollback|space_placeholder_file|tinker-meta.json'` -type f -exec md5sum {} + | sort -k 2 | md5sum | cut -d' ' -f1", TiKV.ChecksumExecCmd(backupDir("/var/lib/tikv", "5.2")))
+}
+
+func TestVerify(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		newTestPod("tikv-0", TiKV, corev1.PodRunning),
+		newTestPod("tikv-1", TiKV, corev1.PodRunning),
+		newTestPod("pd-0", PD, corev1.PodRunning),
+	)
+	executor := newFakeExecutor(func(podName string, command []string) (string, error) {
+		// tikv-1 has different live data.
+		if podName == "tikv-1" && !strings.Contains(command[2], BackupSuffix) {
+			return "changed\r\n", nil
+		}
+		return "d41d8cd98f00b204e9800998ecf8427e\r\n", nil
+	})
+	co := newTestCloudOperator(context.Background(), client, executor)
+	results, err := co.Verify("5.2")
+	assert.NoError(t, err)
+	assert.Len(t, results, 3)
+	assert.Equal(t, "pd-0", results[0].Pod)
+	assert.True(t, results[0].Match())
+	assert.True(t, results[1].Match())
+	assert.Equal(t, "tikv-1", results[2].Pod)
+	assert.False(t, results[2].Match())
+
+	// the commands are printed in dry run mode, the pods are verified with Parallel.
+	out := new(bytes.Buffer)
+	co = newTestCloudOperator(context.Background(), client, &concurrentExecutor{})
+	co.DryRun = true
+	co.Out = out
+	results, err = co.Verify("5.2")
+	assert.NoError(t, err)
+	assert.Len(t, results, 3)
+	assert.Empty(t, results[0].Live)
+	assert.Equal(t, 6, strings.Count(out.String(), "[dry-run] exec in pod"))
+	concurrent := &concurrentExecutor{}
+	co = newTestCloudOperator(context.Background(), client, concurrent)
+	co.Parallel = 1
+	_, err = co.Verify("5.2")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, concurrent.max)
+}
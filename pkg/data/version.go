@@ -0,0 +1,183 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// versionRegexp matches the dotted numeric version with an optional v prefix and suffix, e.g. 5.2, v5.2.1, 5.2-rc.1.
+var versionRegexp = regexp.MustCompile(`^v?[0-9]+(\.[0-9]+)*(-[0-9A-Za-z][0-9A-Za-z.-]*)?$`)
+
+// ValidateVersion checks the backup version is well formed, it is used to build the backup paths.
+func ValidateVersion(version string) error {
+	if !versionRegexp.MatchString(version) {
+		return fmt.Errorf("invalid version %q, it should be dotted numbers with an optional suffix, e.g. 5.2 or 5.2-rc.1", version)
+	}
+	return nil
+}
+
+// ComponentVersions is the restore version of the components.
+// It is either a bare version of all the components or the versions of components, e.g. tikv=5.1,pd=5.2.
+type ComponentVersions struct {
+	// all is the version of all the components, it is empty if the versions of components are specified.
+	all string
+	// versions is the versions of components, they override all, e.g. the timestamped backups selected to restore.
+	versions map[component]string
+}
+
+// ParseComponentVersions parses a bare version, e.g. 5.2, or the versions of components, e.g. tikv=5.1,pd=5.2.
+func ParseComponentVersions(s string) (ComponentVersions, error) {
+	if !strings.Contains(s, "=") {
+		if err := ValidateVersion(s); err != nil {
+			return ComponentVersions{}, err
+		}
+		return ComponentVersions{all: s}, nil
+	}
+	versions, err := parseComponentValues(s)
+	if err != nil {
+		return ComponentVersions{}, err
+	}
+	for cp, version := range versions {
+		if err := ValidateVersion(version); err != nil {
+			return ComponentVersions{}, fmt.Errorf("version of %s: %w", cp, err)
+		}
+	}
+	return ComponentVersions{versions: versions}, nil
+}
+
+// Of returns the version of the component, it returns error if the version of the component is not specified.
+func (v ComponentVersions) Of(cp component) (string, error) {
+	if version, ok := v.versions[cp]; ok {
+		return version, nil
+	}
+	if len(v.all) > 0 {
+		return v.all, nil
+	}
+	return "", fmt.Errorf("version of %s is not specified", cp)
+}
+
+// with returns the versions whose components are overridden by the versions, K: component V: version.
+func (v ComponentVersions) with(versions map[component]string) ComponentVersions {
+	rst := ComponentVersions{all: v.all, versions: make(map[component]string, len(v.versions)+len(versions))}
+	for cp, version := range v.versions {
+		rst.versions[cp] = version
+	}
+	for cp, version := range versions {
+		rst.versions[cp] = version
+	}
+	return rst
+}
+
+// parseVersion parses the numeric version, e.g. 5.2.1 => [5 2 1].
+// It returns false if the version is not numeric.
+func parseVersion(version string) ([]int, bool) {
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	nums := make([]int, 0, len(parts))
+	for _, part := range parts {
+		num, err := strconv.Atoi(part)
+		if err != nil || num < 0 {
+			return nil, false
+		}
+		nums = append(nums, num)
+	}
+	return nums, true
+}
+
+// compareVersion compares two numeric versions, it returns -1 if a < b, 0 if a == b and 1 if a > b.
+func compareVersion(a, b []int) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
+
+// sortVersions returns the numeric versions from newest to oldest, the non-numeric versions are ignored.
+// The timestamped backups of the same version are sorted by their timestamps, the plain one is the oldest.
+func sortVersions(versions []string) []string {
+	type numericVersion struct {
+		version string
+		nums    []int
+		unix    int64
+	}
+	numerics := make([]numericVersion, 0, len(versions))
+	for _, version := range versions {
+		base, unix, _ := splitTimestamp(version)
+		if nums, ok := parseVersion(base); ok {
+			numerics = append(numerics, numericVersion{version: version, nums: nums, unix: unix})
+		}
+	}
+	sort.SliceStable(numerics, func(i, j int) bool {
+		if cmp := compareVersion(numerics[i].nums, numerics[j].nums); cmp != 0 {
+			return cmp > 0
+		}
+		return numerics[i].unix > numerics[j].unix
+	})
+	rst := make([]string, 0, len(numerics))
+	for _, v := range numerics {
+		rst = append(rst, v.version)
+	}
+	return rst
+}
+
+// versionsExcept returns the versions except the newest n, the non-numeric versions are never returned.
+func versionsExcept(versions []string, n int) []string {
+	sorted := sortVersions(versions)
+	if len(sorted) <= n {
+		return nil
+	}
+	return sorted[n:]
+}
+
+// isRunningVersion checks whether the backup version is the running version, e.g. 5.2 is running if the image is v5.2.1.
+// The timestamped backup is running if its version is running.
+func isRunningVersion(version, running string) bool {
+	if len(running) == 0 {
+		return false
+	}
+	version, _, _ = splitTimestamp(version)
+	version, running = strings.TrimPrefix(version, "v"), strings.TrimPrefix(running, "v")
+	return version == running || strings.HasPrefix(running, version+".")
+}
+
+// previousVersion returns the newest backup version except the version in the pod, it returns empty if there is none.
+func (c *CloudOperator) previousVersion(pod *corev1.Pod, cp component, version string) (string, error) {
+	versions, err := c.listVersions(pod, cp)
+	if err != nil {
+		return "", err
+	}
+	for _, v := range sortVersions(versions) {
+		if v != version {
+			return v, nil
+		}
+	}
+	return "", nil
+}
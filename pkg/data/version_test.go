@@ -0,0 +1,128 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortVersions(t *testing.T) {
+	versions := []string{"5.1", "nightly", "5.10", "5.2.1", "v4.0", "5.2"}
+	assert.Equal(t, []string{"5.10", "5.2.1", "5.2", "5.1", "v4.0"}, sortVersions(versions))
+	assert.Equal(t, []string{"5.1", "v4.0"}, versionsExcept(versions, 3))
+	assert.Empty(t, versionsExcept(versions, 5))
+}
+
+func TestIsRunningVersion(t *testing.T) {
+	testCases := []struct {
+		version string
+		running string
+		expect  bool
+	}{
+		{"5.2", "v5.2.1", true},
+		{"5.2.1", "v5.2.1", true},
+		{"5.2", "v5.20.0", false},
+		{"5.1", "v5.2.1", false},
+		{"5.2", "", false},
+	}
+	for _, ca := range testCases {
+		assert.Equal(t, ca.expect, isRunningVersion(ca.version, ca.running), ca)
+	}
+}
+
+func TestValidateVersion(t *testing.T) {
+	testCases := []struct {
+		version string
+		hasErr  bool
+	}{
+		{version: "5.2"},
+		{version: "5"},
+		{version: "v5.2.1"},
+		{version: "5.2-rc.1"},
+		{version: "5.2-nightly"},
+		{version: "", hasErr: true},
+		{version: "5..2", hasErr: true},
+		{version: ".5", hasErr: true},
+		{version: "5.2.", hasErr: true},
+		{version: "5.2-", hasErr: true},
+		{version: "nightly", hasErr: true},
+		{version: "5.2/../../etc", hasErr: true},
+		{version: "5.2;rm -rf /", hasErr: true},
+	}
+	for _, ca := range testCases {
+		err := ValidateVersion(ca.version)
+		if ca.hasErr {
+			assert.Error(t, err, ca.version)
+		} else {
+			assert.NoError(t, err, ca.version)
+		}
+	}
+}
+
+func TestParseComponentVersions(t *testing.T) {
+	testCases := []struct {
+		spec   string
+		expect map[component]string
+		hasErr bool
+	}{
+		{
+			// all the components use the same version.
+			spec:   "5.2",
+			expect: map[component]string{TiKV: "5.2", PD: "5.2"},
+		},
+		{
+			spec:   "tikv=5.1,pd=5.2",
+			expect: map[component]string{TiKV: "5.1", PD: "5.2"},
+		},
+		{
+			spec:   " TiKV = 5.1 ",
+			expect: map[component]string{TiKV: "5.1"},
+		},
+		{
+			spec:   "tikv=5.1,tiflash=5.2",
+			hasErr: true,
+		},
+		{
+			spec:   "tikv=5.1;rm -rf /",
+			hasErr: true,
+		},
+		{
+			spec:   "5.2,pd=5.1",
+			hasErr: true,
+		},
+		{
+			spec:   "../5.2",
+			hasErr: true,
+		},
+	}
+	for _, ca := range testCases {
+		versions, err := ParseComponentVersions(ca.spec)
+		if ca.hasErr {
+			assert.Error(t, err, ca.spec)
+			continue
+		}
+		assert.NoError(t, err, ca.spec)
+		for _, cp := range []component{TiKV, PD} {
+			version, err := versions.Of(cp)
+			if expect, ok := ca.expect[cp]; ok {
+				assert.NoError(t, err)
+				assert.Equal(t, expect, version)
+			} else {
+				assert.Error(t, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// errWaitTimeout is returned by waitFor if the condition is not met before the timeout.
+var errWaitTimeout = errors.New("wait timeout")
+
+// waitFor polls the condition until it returns true or an error, or the timeout elapses.
+// The interval doubles after every poll until MaxWaitInterval, 0 timeout means waiting forever.
+// It returns errWaitTimeout on timeout and the context error if ctx is done.
+func waitFor(ctx context.Context, condition func() (bool, error), interval, timeout time.Duration) error {
+	return backoffWait(ctx, condition, interval, timeout, time.After)
+}
+
+// backoffWait is waitFor with the injectable after, which waits for the interval between polls.
+func backoffWait(ctx context.Context, condition func() (bool, error), interval, timeout time.Duration,
+	after func(time.Duration) <-chan time.Time) error {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		deadline = time.After(timeout)
+	}
+	for {
+		done, err := condition()
+		if err != nil || done {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			return errWaitTimeout
+		case <-after(interval):
+		}
+		interval *= 2
+		if interval > MaxWaitInterval {
+			interval = MaxWaitInterval
+		}
+	}
+}
+
+// waitFor polls the condition of the operator with backoff, starting from WaitInterval.
+func (c *CloudOperator) waitFor(condition func() (bool, error), timeout time.Duration) error {
+	return backoffWait(c.ctx, condition, WaitInterval, timeout, c.after)
+}
+
+// waitReady waits until all the pods of the components are running and ready, or WaitTimeout elapses.
+// Only the pods whose name or namespace/name is in pods are waited for if it's not empty.
+// The deleted pods should be recreated, K: pod key V: UID of the deleted pod.
+func (c *CloudOperator) waitReady(components []component, pods []string, deleted map[string]types.UID) error {
+	var notReady []string
+	err := c.waitFor(func() (bool, error) {
+		var err error
+		notReady, err = c.notReadyPods(components, pods, deleted)
+		if err != nil || len(notReady) == 0 {
+			return true, err
+		}
+		log.Info("waiting for pods ready", zap.Strings("pods", notReady))
+		return false, nil
+	}, c.WaitTimeout)
+	switch {
+	case errors.Is(err, errWaitTimeout):
+		return fmt.Errorf("pods are not ready after %s: %s", c.WaitTimeout, strings.Join(notReady, ","))
+	case err != nil && c.ctx.Err() != nil:
+		return fmt.Errorf("wait pods ready is cancelled: %w", err)
+	}
+	return err
+}
+
+// notReadyPods returns the sorted keys of the pods which are not ready or not recreated yet.
+func (c *CloudOperator) notReadyPods(components []component, names []string, deleted map[string]types.UID) ([]string, error) {
+	var notReady []string
+	found := make(map[string]bool)
+	for _, cp := range components {
+		list, err := c.discoverPods(cp)
+		if err != nil {
+			return nil, err
+		}
+		pods := selectNamedPods(list.Items, names, make(map[string]bool))
+		for i := range pods {
+			pod := &pods[i]
+			key := c.podKey(pod)
+			uid, ok := deleted[key]
+			if ok && uid == pod.UID {
+				// the deleted pod is terminating.
+				continue
+			}
+			found[key] = true
+			if !isPodReady(pod) {
+				notReady = append(notReady, key)
+			}
+		}
+	}
+	for key := range deleted {
+		if !found[key] {
+			notReady = append(notReady, key)
+		}
+	}
+	sort.Strings(notReady)
+	return notReady, nil
+}
+
+// isPodReady returns true if the pod is running, ready and not being deleted.
+func isPodReady(pod *corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestWaitReady(t *testing.T) {
+	newPod := func(uid types.UID, phase corev1.PodPhase, ready corev1.ConditionStatus) corev1.Pod {
+		pod := newTestPod("tikv-0", TiKV, phase)
+		pod.UID = uid
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: ready}}
+		return *pod
+	}
+	// the pod phases transition over successive list calls.
+	steps := [][]corev1.Pod{
+		{newPod("old", corev1.PodRunning, corev1.ConditionTrue)},
+		{},
+		{newPod("new", corev1.PodPending, corev1.ConditionFalse)},
+		{newPod("new", corev1.PodRunning, corev1.ConditionFalse)},
+		{newPod("new", corev1.PodRunning, corev1.ConditionTrue)},
+	}
+	client := fake.NewSimpleClientset()
+	calls := 0
+	client.PrependReactor("list", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		step := steps[calls]
+		if calls < len(steps)-1 {
+			calls++
+		}
+		return true, &corev1.PodList{Items: step}, nil
+	})
+	co := newTestCloudOperator(context.Background(), client, nil)
+	co.WaitTimeout = time.Minute
+	co.after = func(time.Duration) <-chan time.Time {
+		ch := make(chan time.Time, 1)
+		ch <- time.Now()
+		return ch
+	}
+	deleted := map[string]types.UID{"tikv-0": "old"}
+	assert.NoError(t, co.waitReady([]component{TiKV}, nil, deleted))
+	assert.Equal(t, len(steps)-1, calls)
+
+	// it times out if the pod is never recreated.
+	calls = 0
+	steps = [][]corev1.Pod{{newPod("old", corev1.PodRunning, corev1.ConditionTrue)}}
+	co.WaitTimeout = 10 * time.Millisecond
+	co.after = time.After
+	err := co.waitReady([]component{TiKV}, nil, deleted)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "tikv-0")
+}
+
+func TestIsPodReady(t *testing.T) {
+	testCases := []struct {
+		phase      corev1.PodPhase
+		conditions []corev1.PodCondition
+		expect     bool
+	}{
+		{
+			phase:      corev1.PodRunning,
+			conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+			expect:     true,
+		},
+		{
+			phase:      corev1.PodRunning,
+			conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionFalse}},
+			expect:     false,
+		},
+		{
+			phase:  corev1.PodRunning,
+			expect: false,
+		},
+		{
+			phase:      corev1.PodPending,
+			conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+			expect:     false,
+		},
+	}
+	for _, ca := range testCases {
+		pod := newTestPod("tikv-0", TiKV, ca.phase)
+		pod.Status.Conditions = ca.conditions
+		assert.Equal(t, ca.expect, isPodReady(pod))
+	}
+}
+
+func TestWaitFor(t *testing.T) {
+	// the condition succeeds after n calls.
+	calls := 0
+	var intervals []time.Duration
+	after := func(d time.Duration) <-chan time.Time {
+		intervals = append(intervals, d)
+		ch := make(chan time.Time, 1)
+		ch <- time.Now()
+		return ch
+	}
+	err := backoffWait(context.Background(), func() (bool, error) {
+		calls++
+		return calls == 5, nil
+	}, 10*time.Second, time.Minute, after)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, calls)
+	// the interval doubles until MaxWaitInterval.
+	assert.Equal(t, []time.Duration{10 * time.Second, 20 * time.Second, MaxWaitInterval, MaxWaitInterval}, intervals)
+
+	// the error of the condition stops waiting.
+	calls = 0
+	err = waitFor(context.Background(), func() (bool, error) {
+		calls++
+		return false, errors.New("list failed")
+	}, time.Millisecond, time.Minute)
+	assert.EqualError(t, err, "list failed")
+	assert.Equal(t, 1, calls)
+
+	// the condition is never met.
+	err = waitFor(context.Background(), func() (bool, error) {
+		return false, nil
+	}, time.Millisecond, 20*time.Millisecond)
+	assert.Equal(t, errWaitTimeout, err)
+
+	// it is cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = waitFor(ctx, func() (bool, error) {
+		return false, nil
+	}, time.Minute, time.Minute)
+	assert.Equal(t, context.Canceled, err)
+}
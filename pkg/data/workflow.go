@@ -0,0 +1,212 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// BackupWorkflow stops all the components, backs up them and starts them again unless NoStart is set.
+// The components are started even if backing up failed, the error of backing up is returned.
+// It returns the result of every pod which is backed up.
+func (c *CloudOperator) BackupWorkflow(ctx context.Context, version string) ([]OperationResult, error) {
+	if err := ValidateVersion(version); err != nil {
+		return nil, err
+	}
+	if err := ValidateExcludes(c.Excludes); err != nil {
+		return nil, err
+	}
+	if err := c.validateResume(version); err != nil {
+		return nil, err
+	}
+	if err := c.backend().Validate(c); err != nil {
+		return nil, err
+	}
+	// all the pods share the timestamp of the backup.
+	version = c.backupVersion(version)
+	var results []OperationResult
+	err := c.withContext(ctx, func() error {
+		if c.PDSingle {
+			// the leader is asked before PD is stopped.
+			c.pdLeaders = c.detectPDLeaders()
+		}
+		return c.workflow("back", version, func() (err error) {
+			results, err = c.Back(version)
+			return err
+		}, nil)
+	})
+	return results, err
+}
+
+// RestoreWorkflow stops all the components, restores them and starts them again unless NoStart is set.
+// The components are started even if restoring failed, the error of restoring is returned.
+// The version is a bare version or the versions of components, e.g. tikv=5.1,pd=5.2.
+// It returns the result of every pod which is restored.
+func (c *CloudOperator) RestoreWorkflow(ctx context.Context, version string) ([]OperationResult, error) {
+	if _, err := ParseComponentVersions(version); err != nil {
+		return nil, err
+	}
+	if err := c.backend().Validate(c); err != nil {
+		return nil, err
+	}
+	var results []OperationResult
+	err := c.withContext(ctx, func() error {
+		return c.workflow("restore", version, func() (err error) {
+			results, err = c.Restore(version)
+			return err
+		}, c.postRestore)
+	})
+	return results, err
+}
+
+// postRestore runs PostRestoreHook after the restored components are up, nil hook means no check.
+func (c *CloudOperator) postRestore() error {
+	if c.PostRestoreHook == nil {
+		return nil
+	}
+	c.notify("it will check the restored cluster")
+	if err := c.PostRestoreHook.Check(c); err != nil {
+		return fmt.Errorf("post restore check failed: %w", err)
+	}
+	c.notify("post restore check success")
+	return nil
+}
+
+// withContext runs fn with ctx as the context of the operator, so the operator can't run workflows concurrently.
+// The error after ctx exceeded its deadline is CategoryTimeout, e.g. the pods failed by the cancelled execs.
+func (c *CloudOperator) withContext(ctx context.Context, fn func() error) error {
+	prev := c.ctx
+	c.ctx = ctx
+	defer func() {
+		c.ctx = prev
+	}()
+	err := fn()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return WithCategory(CategoryTimeout, err)
+	}
+	return err
+}
+
+// workflow stops all the components and waits for them stopped, then it runs the operation and starts them.
+// The permissions are checked before it, and the namespaces are locked during the workflow.
+// The check runs after the components are up if the operation succeeded, nil means no check.
+func (c *CloudOperator) workflow(operation, version string, run, check func() error) error {
+	if err := c.preflight(operation); err != nil {
+		return err
+	}
+	return c.withLock(operation, version, func() error {
+		return c.runWorkflow(operation, version, run, check)
+	})
+}
+
+// runWorkflow stops the components, runs the operation and starts them, it starts all the components
+// if it is interrupted after stopping any of them.
+// The components are left stopped after the operation if NoStart is set, unless it is interrupted.
+func (c *CloudOperator) runWorkflow(operation, version string, run, check func() error) error {
+	// nothing is stopped yet, so it needn't start the components.
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	t := time.Now()
+	c.notify("it will try to stop all component")
+	if err := c.Stop(); err != nil {
+		if c.ctx.Err() != nil {
+			c.notify("stop is interrupted: %v", err)
+			c.startAndCheck()
+			return err
+		}
+		if !c.Force {
+			return fmt.Errorf("stop cloud operator failed: %w", err)
+		}
+		c.notify("WARNING: stop failed, it will %s by force: %v", operation, err)
+	}
+	for _, cp := range c.inStopOrder(c.Components) {
+		if err := c.WaitStopped(cp); err != nil {
+			if c.Force {
+				c.notify("WARNING: wait component stopped failed, it will %s by force: %v", operation, err)
+				continue
+			}
+			c.notify("wait component stopped failed: %v", err)
+			c.startAndCheck()
+			return err
+		}
+	}
+	c.notify("it has stopped component, costs: %fs", time.Since(t).Seconds())
+	c.notify("it will %s data, it can not interrupt, please wait", operation)
+	// it should start all components even if some pods failed.
+	err := run()
+	if err != nil {
+		c.notify("%s %s failed: %v", operation, version, err)
+	} else {
+		c.notify("it finished %s component, costs: %fs", operation, time.Since(t).Seconds())
+	}
+	// the interrupted operation may leave the data incomplete, so the components are started to recover the cluster.
+	if c.ctx.Err() != nil && operation == "restore" {
+		c.notify("WARNING: %s is interrupted, the data may be half restored, please check it or run `tc restore %s` again", operation, version)
+	}
+	if c.NoStart && c.ctx.Err() == nil {
+		// the check needs the components up, so it is skipped too.
+		c.notify("WARNING: the components are left stopped, please run `tc start` to start them")
+		c.notify("it finished all")
+		return err
+	}
+	up := c.startAndCheck()
+	// the check is meaningless if the operation failed or the components are not up.
+	if err == nil && up && check != nil {
+		err = check()
+		if err != nil {
+			c.notify("%v", err)
+		}
+	}
+	c.notify("it finished all")
+	return err
+}
+
+// startAndCheck starts all the components and waits for them up, the errors are only reported.
+// It still starts them if the workflow is interrupted, it returns true if all the components are up.
+func (c *CloudOperator) startAndCheck() bool {
+	if err := c.ctx.Err(); errors.Is(err, context.DeadlineExceeded) {
+		c.notify("it exceeded the deadline, it will try to start all component")
+	} else if err != nil {
+		c.notify("it is interrupted, it will try to start all component")
+	}
+	err := c.uninterrupted(func() error {
+		if err := c.Start(); err != nil {
+			return fmt.Errorf("pods start error: %w", err)
+		}
+		return CheckError(c.WaitUp(PodFilter{}))
+	})
+	if err != nil {
+		c.notify("%v", err)
+		return false
+	}
+	c.notify("check success")
+	return true
+}
+
+// notify reports the progress of the workflows to Notify, it logs the progress if Notify is nil.
+func (c *CloudOperator) notify(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if c.Notify == nil {
+		log.Info("workflow progress", zap.String("progress", msg))
+		return
+	}
+	c.Notify(msg)
+}
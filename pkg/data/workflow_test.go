@@ -0,0 +1,254 @@
+// Copyright 2021 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package data
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWorkflow(t *testing.T) {
+	newOperator := func() (*CloudOperator, *fake.Clientset, *fakeExecutor, *[]string) {
+		client := fake.NewSimpleClientset(
+			newTestPod("tikv-0", TiKV, corev1.PodRunning),
+			newTestPod("pd-0", PD, corev1.PodRunning),
+		)
+		executor := newFakeExecutor(func(_ string, command []string) (string, error) {
+			cmd := command[len(command)-1]
+			switch {
+			case strings.Contains(cmd, "ps -ef"):
+				// PID 1 is the debug process without arguments.
+				return "UID\r\n1\r\n", nil
+			case strings.HasPrefix(cmd, "df"):
+				return "Filesystem 1024-blocks Used Available Capacity Mounted on\r\n/dev/sda1 1000 400 600 40% /var/lib\r\n", nil
+			case strings.Contains(cmd, "du -sk"):
+				return "4\tdb\r\n", nil
+			case strings.HasPrefix(cmd, "ls"):
+				return "5.2.bat\r\n", nil
+			}
+			return "", nil
+		})
+		var progress []string
+		co := newTestCloudOperator(context.Background(), client, executor)
+		co.Notify = func(msg string) {
+			progress = append(progress, msg)
+		}
+		return co, client, executor, &progress
+	}
+
+	co, client, executor, progress := newOperator()
+	_, err := co.BackupWorkflow(context.Background(), "5.2")
+	assert.NoError(t, err)
+	assert.Contains(t, executor.calls["tikv-0"], []string{"sh", "-c", TiKV.StopCmd()})
+	assert.Contains(t, executor.calls["tikv-0"], []string{"sh", "-c", backWithMetadataCmd(TiKV, TiKV.BataDir(nil), "5.2")})
+	assert.Contains(t, executor.calls["pd-0"], []string{"sh", "-c", backWithMetadataCmd(PD, PD.BataDir(nil), "5.2")})
+	assert.Contains(t, *progress, "check success")
+	assert.Equal(t, "it finished all", (*progress)[len(*progress)-1])
+	// the pods are restarted.
+	pods, err := client.CoreV1().Pods(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, pods.Items)
+
+	// the components are started even if restoring failed.
+	co, client, executor, progress = newOperator()
+	_, err = co.RestoreWorkflow(context.Background(), "5.1")
+	assert.Error(t, err)
+	for _, calls := range executor.calls {
+		for _, command := range calls {
+			assert.NotContains(t, command[len(command)-1], "restore_")
+		}
+	}
+	assert.Contains(t, *progress, "check success")
+	pods, err = client.CoreV1().Pods(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, pods.Items)
+
+	// the components are left stopped with NoStart.
+	co, client, executor, progress = newOperator()
+	co.NoStart = true
+	_, err = co.BackupWorkflow(context.Background(), "5.2")
+	assert.NoError(t, err)
+	assert.Contains(t, executor.calls["tikv-0"], []string{"sh", "-c", backWithMetadataCmd(TiKV, TiKV.BataDir(nil), "5.2")})
+	assert.Contains(t, *progress, "WARNING: the components are left stopped, please run `tc start` to start them")
+	assert.NotContains(t, *progress, "check success")
+	pods, err = client.CoreV1().Pods(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, pods.Items, 2)
+	for _, pod := range pods.Items {
+		assert.Equal(t, DebugValue, pod.Annotations[DebugLabel], pod.Name)
+	}
+
+	// it is cancelled before executing any command in the pods.
+	co, _, executor, _ = newOperator()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = co.BackupWorkflow(ctx, "5.2")
+	assert.Error(t, err)
+	assert.Empty(t, executor.calls)
+	_, err = co.RestoreWorkflow(context.Background(), "../5.2")
+	assert.Error(t, err)
+}
+
+// hangingExecutor blocks the commands matched by block until the context is done,
+// the other commands are executed by the fake executor.
+type hangingExecutor struct {
+	*fakeExecutor
+	block func(command []string) bool
+}
+
+func (e *hangingExecutor) exec(ctx context.Context, podName, container, namespace string, command []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if e.block(command) {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return e.fakeExecutor.exec(ctx, podName, container, namespace, command, stdin, stdout, stderr)
+}
+
+func TestWorkflowDeadline(t *testing.T) {
+	for _, operation := range []string{"back", "restore"} {
+		client := fake.NewSimpleClientset(
+			newTestPod("tikv-0", TiKV, corev1.PodRunning),
+			newTestPod("pd-0", PD, corev1.PodRunning),
+		)
+		executor := &hangingExecutor{
+			fakeExecutor: newFakeExecutor(func(_ string, command []string) (string, error) {
+				cmd := command[len(command)-1]
+				switch {
+				case strings.Contains(cmd, "ps -ef"):
+					return "UID\r\n1\r\n", nil
+				case strings.HasPrefix(cmd, "df"):
+					return "Filesystem 1024-blocks Used Available Capacity Mounted on\r\n/dev/sda1 1000 400 600 40% /var/lib\r\n", nil
+				case strings.Contains(cmd, "du -sk"):
+					return "4\tdb\r\n", nil
+				case strings.HasPrefix(cmd, "ls"):
+					return "5.2.bat\r\n", nil
+				}
+				return "", nil
+			}),
+			// backing up or restoring tikv hangs until the deadline.
+			block: func(command []string) bool {
+				cmd := command[len(command)-1]
+				return cmd == backWithMetadataCmd(TiKV, TiKV.BataDir(nil), "5.2") || cmd == TiKV.RestoreExecCmd(TiKV.BataDir(nil), "5.2")
+			},
+		}
+		var progress []string
+		co := newTestCloudOperator(context.Background(), client, executor)
+		co.Notify = func(msg string) {
+			progress = append(progress, msg)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		var err error
+		if operation == "back" {
+			_, err = co.BackupWorkflow(ctx, "5.2")
+		} else {
+			_, err = co.RestoreWorkflow(ctx, "5.2")
+		}
+		cancel()
+		assert.Error(t, err, operation)
+		assert.Contains(t, err.Error(), context.DeadlineExceeded.Error(), operation)
+		assert.Equal(t, CategoryTimeout, Category(err), operation)
+		// the components are still started after the deadline.
+		assert.Contains(t, progress, "it exceeded the deadline, it will try to start all component", operation)
+		assert.Contains(t, progress, "check success", operation)
+		pods, err := client.CoreV1().Pods(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+		assert.NoError(t, err)
+		assert.Empty(t, pods.Items, operation)
+	}
+}
+
+func TestWorkflowInterrupted(t *testing.T) {
+	newOperator := func(cancel context.CancelFunc) (*CloudOperator, *fake.Clientset, *fakeExecutor, *[]string) {
+		client := fake.NewSimpleClientset(
+			newTestPod("tikv-0", TiKV, corev1.PodRunning),
+			newTestPod("pd-0", PD, corev1.PodRunning),
+		)
+		executor := &hangingExecutor{
+			fakeExecutor: newFakeExecutor(func(_ string, command []string) (string, error) {
+				cmd := command[len(command)-1]
+				switch {
+				case strings.Contains(cmd, "ps -ef"):
+					return "UID\r\n1\r\n", nil
+				case strings.HasPrefix(cmd, "df"):
+					return "Filesystem 1024-blocks Used Available Capacity Mounted on\r\n/dev/sda1 1000 400 600 40% /var/lib\r\n", nil
+				case strings.Contains(cmd, "du -sk"):
+					return "4\tdb\r\n", nil
+				case strings.HasPrefix(cmd, "ls"):
+					return "5.2.bat\r\n", nil
+				}
+				return "", nil
+			}),
+			// the interrupt arrives while backing up or restoring tikv, like the signal handler cancels the context.
+			block: func(command []string) bool {
+				cmd := command[len(command)-1]
+				if cmd != backWithMetadataCmd(TiKV, TiKV.BataDir(nil), "5.2") && cmd != TiKV.RestoreExecCmd(TiKV.BataDir(nil), "5.2") {
+					return false
+				}
+				cancel()
+				return true
+			},
+		}
+		var progress []string
+		co := newTestCloudOperator(context.Background(), client, executor)
+		co.Parallel = 1
+		co.Notify = func(msg string) {
+			progress = append(progress, msg)
+		}
+		return co, client, executor.fakeExecutor, &progress
+	}
+
+	for _, noStart := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		co, client, executor, progress := newOperator(cancel)
+		co.NoStart = noStart
+		_, err := co.BackupWorkflow(ctx, "5.2")
+		cancel()
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), context.Canceled.Error())
+		assert.NotEqual(t, CategoryTimeout, Category(err))
+		// the components are stopped before the interrupt.
+		assert.Contains(t, executor.calls["tikv-0"], []string{"sh", "-c", TiKV.StopCmd()})
+		// the components are started even if NoStart is set.
+		assert.Contains(t, *progress, "it is interrupted, it will try to start all component", noStart)
+		assert.Contains(t, *progress, "check success", noStart)
+		assert.NotContains(t, *progress, "WARNING: the components are left stopped, please run `tc start` to start them", noStart)
+		pods, err := client.CoreV1().Pods(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+		assert.NoError(t, err)
+		assert.Empty(t, pods.Items, noStart)
+
+		// the interrupted restore starts the components too, the data may be half restored.
+		ctx, cancel = context.WithCancel(context.Background())
+		co, client, executor, progress = newOperator(cancel)
+		co.NoStart = noStart
+		_, err = co.RestoreWorkflow(ctx, "5.2")
+		cancel()
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), context.Canceled.Error())
+		assert.Contains(t, executor.calls["tikv-0"], []string{"sh", "-c", TiKV.StopCmd()})
+		assert.Contains(t, *progress, "WARNING: restore is interrupted, the data may be half restored, please check it or run `tc restore 5.2` again", noStart)
+		assert.Contains(t, *progress, "it is interrupted, it will try to start all component", noStart)
+		assert.Contains(t, *progress, "check success", noStart)
+		pods, err = client.CoreV1().Pods(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+		assert.NoError(t, err)
+		assert.Empty(t, pods.Items, noStart)
+	}
+}